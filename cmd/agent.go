@@ -0,0 +1,163 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	execStdin   bool
+	execTimeout time.Duration
+)
+
+var vmsExecCmd = &cobra.Command{
+	Use:   "exec <name> -- <cmd> [args...]",
+	Short: "Run a command in a VM with the QEMU guest agent",
+	Long: `Run a command inside a VM through the QEMU guest agent, without SSH. It
+works when the network or the SSH keys of the VM are broken. The command is
+not run in a shell, use sh -c to get one:
+
+  i2 vms exec web -- sh -c 'df -h | grep /data'`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+		defer cancel()
+		cluster, guest := findGuest(ctx, args[0])
+
+		var input []byte
+		if execStdin {
+			var err error
+			input, err = io.ReadAll(os.Stdin)
+			if err != nil {
+				log.Fatalf("Error reading stdin: %v", err)
+			}
+		}
+		res, err := cluster.AgentExec(ctx, guest, args[1:], input)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Fprint(os.Stdout, res.Stdout)
+		fmt.Fprint(os.Stderr, res.Stderr)
+		if res.Truncated {
+			log.Warn("The output was truncated by the guest agent")
+		}
+		os.Exit(res.ExitCode)
+	},
+}
+
+var vmsFileCmd = &cobra.Command{
+	Use:   "file",
+	Short: "Copy files to and from a VM with the QEMU guest agent",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var vmsFileGetCmd = &cobra.Command{
+	Use:   "get <name> <remote path> [local path]",
+	Short: "Copy a file from a VM, use - to print it",
+	Args:  cobra.RangeArgs(2, 3),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+		defer cancel()
+		cluster, guest := findGuest(ctx, args[0])
+
+		content, err := cluster.AgentFileRead(ctx, guest, args[1])
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		local := filepath.Base(args[1])
+		if len(args) == 3 {
+			local = args[2]
+		}
+		if local == "-" {
+			os.Stdout.Write(content)
+			return
+		}
+		if err := os.WriteFile(local, content, 0o644); err != nil {
+			log.Fatalf("Error writing %s: %v", local, err)
+		}
+		log.Infof("Copied %s:%s to %s (%d bytes)", guest.Name, args[1], local, len(content))
+	},
+}
+
+var vmsFilePutCmd = &cobra.Command{
+	Use:   "put <name> <local path> <remote path>",
+	Short: "Copy a file to a VM, use - to read it from stdin",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+		defer cancel()
+		cluster, guest := findGuest(ctx, args[0])
+
+		var content []byte
+		var err error
+		if args[1] == "-" {
+			content, err = io.ReadAll(os.Stdin)
+		} else {
+			content, err = os.ReadFile(args[1])
+		}
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", args[1], err)
+		}
+		if err := cluster.AgentFileWrite(ctx, guest, args[2], content); err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Infof("Copied %s to %s:%s (%d bytes)", args[1], guest.Name, args[2], len(content))
+	},
+}
+
+func init() {
+	vmsCmd.AddCommand(vmsExecCmd)
+	vmsCmd.AddCommand(vmsFileCmd)
+	vmsFileCmd.AddCommand(vmsFileGetCmd)
+	vmsFileCmd.AddCommand(vmsFilePutCmd)
+
+	vmsExecCmd.Flags().BoolVarP(&execStdin, "stdin", "i", false, "send stdin to the command")
+	vmsExecCmd.Flags().DurationVar(&execTimeout, "timeout", 5*time.Minute, "how long to wait for the command")
+	vmsFileCmd.PersistentFlags().DurationVar(&execTimeout, "timeout", 5*time.Minute, "how long to wait for the copy")
+}
+
+// findGuest returns a guest and its cluster, it exits when the guest can't be found
+func findGuest(ctx context.Context, name string) (*prxmx.Cluster, prxmx.Node) {
+	conf := models.NewConfig()
+	if conf == nil {
+		os.Exit(123)
+	}
+	cluster, guest, err := newClusters(conf).FindGuest(ctx, name)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	return cluster, guest
+}
@@ -22,25 +22,15 @@ THE SOFTWARE.
 package app
 
 import (
-	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
 )
 
 // appCmd represents the app command
 var AppCmd = &cobra.Command{
 	Use:   "app",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		log.Info("app called")
-	},
+	Short: "Deploy Docker Compose apps to guests",
 }
 
 func init() {
-	AppCmd.AddCommand(ReloadAppCmd)
+	AppCmd.AddCommand(DeployAppCmd)
 }
@@ -0,0 +1,181 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"i2/pkg/dckr"
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+	"i2/pkg/remote"
+	"i2/pkg/store"
+	"i2/pkg/utils"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	deployHost     string
+	deployFile     string
+	deployEnvFiles []string
+)
+
+// DeployAppCmd represents the app deploy command
+var DeployAppCmd = &cobra.Command{
+	Use:     "deploy <app> --host <vm>",
+	Aliases: []string{"reload"},
+	Short:   "Deploy a Docker Compose app to a guest",
+	Long: `Deploy a Docker Compose app to a guest, the app is the Compose project name.
+
+The images are pulled first: when a pull fails the running app is left
+untouched. The compose file and the env files are then uploaded to
+~/i2/apps/<app> on the guest and only the services that changed are
+recreated, in dependency order. Bind mounts relative to the compose file
+point to the same paths in the app directory of the guest, they aren't
+uploaded: the deploy is refused when one of them exists locally, use an
+absolute path on the guest instead.
+
+  i2 app deploy shop --host vm1
+  i2 app deploy shop --host vm1 -f deploy/compose.yaml --env-file prod.env`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		app := args[0]
+		if deployHost == "" {
+			log.Fatal("Deploy needs the guest, --host")
+		}
+		if err := dckr.ValidateAppName(app); err != nil {
+			log.Fatalf("%v", err)
+		}
+		conf := models.NewConfig()
+		if conf == nil {
+			os.Exit(123)
+		}
+		keyPath, err := conf.SSH.PrivateKeyPath()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		ctx := context.Background()
+
+		project, err := dckr.LoadComposeProject(ctx, app, deployFile, deployEnvFiles...)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		files := appFiles(deployFile, deployEnvFiles)
+		if sources := dckr.LocalBindSources(project, files); len(sources) > 0 {
+			log.Fatalf("Bind mounts of %s aren't uploaded, use paths on %s instead of: %s", app, deployHost, strings.Join(sources, ", "))
+		}
+		st, err := store.NewStore(ctx, &conf.Nats)
+		if err != nil {
+			log.Fatalf("Error creating store: %v", err)
+		}
+		defer st.Close()
+		inventory := prxmx.NewInventory(st)
+		dc, vm, err := dckr.HostClient(ctx, inventory, deployHost, conf.SSH.User)
+		if err != nil {
+			log.Fatalf("Error creating Docker client: %v", err)
+		}
+		defer dc.Close()
+
+		log.Infof("Pulling the images of %s on %s", app, vm.Name)
+		if err := dc.PullProjectImages(ctx, project); err != nil {
+			log.Fatalf("%v, %s is untouched", err, app)
+		}
+		dir, err := remote.UploadFiles(conf.SSH.User, utils.GetLocalIP(vm.IP), keyPath, dckr.AppDir(app), files)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		dckr.RelocateProject(project, dir)
+
+		results, err := dc.ComposeUp(ctx, project)
+		printDeployResults(results)
+		if err != nil {
+			log.Fatalf("Error deploying %s: %v", app, err)
+		}
+		if err := dc.RefreshContainers(ctx, inventory, *vm); err != nil {
+			log.Warnf("Error refreshing the containers of %s: %v", vm.Name, err)
+		}
+	},
+}
+
+// appFiles returns the files uploaded with a compose file: the env files, or
+// the .env next to it
+func appFiles(composeFile string, envFiles []string) []string {
+	files := append([]string{composeFile}, envFiles...)
+	if len(envFiles) == 0 {
+		dotEnv := filepath.Join(filepath.Dir(composeFile), ".env")
+		if _, err := os.Stat(dotEnv); err == nil {
+			files = append(files, dotEnv)
+		}
+	}
+	return files
+}
+
+func printDeployResults(results []dckr.ServiceResult) {
+	re := lipgloss.NewRenderer(os.Stdout)
+	baseStyle := re.NewStyle().Padding(0, 1)
+	headerStyle := baseStyle.Foreground(lipgloss.Color("252")).Bold(true)
+	rowStyle := baseStyle.Foreground(lipgloss.Color("250"))
+	changedStyle := baseStyle.Foreground(lipgloss.Color("#01BE85"))
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row == 0 {
+				return headerStyle
+			}
+			if results[row-1].Changed() {
+				return changedStyle
+			}
+			return rowStyle
+		}).
+		Headers("Service", "Created", "Recreated", "Started", "Removed", "Unchanged")
+
+	for _, r := range results {
+		t.Row(r.Service, count(r.Created), count(r.Recreated), count(r.Started), count(r.Removed), count(r.Unchanged))
+	}
+	fmt.Println(t.Render())
+}
+
+// count leaves zeros blank, the changes stand out
+func count(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+func init() {
+	DeployAppCmd.Flags().StringVar(&deployHost, "host", "", "guest to deploy to")
+	DeployAppCmd.Flags().StringVarP(&deployFile, "file", "f", "compose.yaml", "compose file")
+	DeployAppCmd.Flags().StringArrayVar(&deployEnvFiles, "env-file", nil, "env file, .env next to the compose file by default")
+	_ = DeployAppCmd.MarkFlagRequired("host")
+}
@@ -0,0 +1,174 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	applyFile   string
+	applyYes    bool
+	applyDryRun bool
+)
+
+var vmsApplyCmd = &cobra.Command{
+	Use:   "apply -f vms.yaml",
+	Short: "Create and update VMs from a YAML spec",
+	Long: `Compare the VMs described in a YAML file with Proxmox and show a plan:
+missing VMs are cloned from their template, drifted CPU, memory, tags,
+network, disks and cloud-init settings are updated. Changes that would
+destroy data (shrinking or moving disks, removing devices) are only
+reported. The plan is applied after confirmation.
+
+vms:
+  - name: web
+    node: pve1
+    template: debian-12
+    cores: 2
+    memory: 4096
+    disks:
+      - {name: scsi0, storage: local-lvm, size: 32G}
+    network:
+      - {name: net0, bridge: vmbr0, vlan: 20}
+    tags: [docker, prod]
+    cloudinit:
+      user: ivan
+      ip: dhcp
+    start: true`,
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := models.NewConfig()
+		if conf == nil {
+			os.Exit(123)
+		}
+		ctx := context.Background()
+		specs, err := prxmx.ReadSpecFile(applyFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		clusters := newClusters(conf)
+		bySelected := map[*prxmx.Cluster][]prxmx.VMSpec{}
+		for _, spec := range specs {
+			selected, err := clusters.Select(spec.Cluster)
+			if err != nil {
+				log.Fatalf("%s: %v", spec.Name, err)
+			}
+			if len(selected) > 1 {
+				log.Fatalf("%s: set the cluster of the VM or use --cluster", spec.Name)
+			}
+			bySelected[selected[0]] = append(bySelected[selected[0]], spec)
+		}
+
+		plans := map[*prxmx.Cluster][]prxmx.PlanItem{}
+		pending := 0
+		for _, cluster := range clusters {
+			if len(bySelected[cluster]) == 0 {
+				continue
+			}
+			plan, err := cluster.Plan(ctx, bySelected[cluster])
+			if err != nil {
+				log.Fatalf("Error planning %s: %v", cluster.Name, err)
+			}
+			plans[cluster] = plan
+			pending += printPlan(plan)
+		}
+
+		if pending == 0 {
+			log.Info("Nothing to do")
+			return
+		}
+		if applyDryRun || (!applyYes && !confirm(fmt.Sprintf("Apply the changes to %d VMs?", pending))) {
+			return
+		}
+		for _, cluster := range clusters {
+			if plan, ok := plans[cluster]; ok {
+				err := cluster.Apply(ctx, plan, func(step string) { log.Info(step) })
+				if err != nil {
+					log.Fatalf("%v", err)
+				}
+			}
+		}
+		log.Info("Done, run i2 vms -s to refresh the inventory")
+	},
+}
+
+func init() {
+	vmsCmd.AddCommand(vmsApplyCmd)
+
+	vmsApplyCmd.Flags().StringVarP(&applyFile, "file", "f", "vms.yaml", "VM specs")
+	vmsApplyCmd.Flags().BoolVarP(&applyYes, "yes", "y", false, "apply without asking")
+	vmsApplyCmd.Flags().BoolVar(&applyDryRun, "dry-run", false, "only show the plan")
+	vmsApplyCmd.MarkFlagFilename("file", "yaml", "yml")
+}
+
+// printPlan prints the plan and returns how many VMs would change
+func printPlan(plan []prxmx.PlanItem) int {
+	createStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#01BE85"))
+	updateStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFB86C"))
+	dangerStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F87"))
+	sleepingStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
+
+	pending := 0
+	for _, item := range plan {
+		name := fmt.Sprintf("%s/%s", item.Cluster, item.Spec.Name)
+		switch {
+		case item.Error != "":
+			fmt.Println(dangerStyle.Render("! " + name + ": " + item.Error))
+			continue
+		case item.Action == prxmx.ActionCreate:
+			pending++
+			fmt.Println(createStyle.Render(fmt.Sprintf("+ %s (clone of %s on %s)", name, item.Spec.Template, item.Node)))
+		case item.Action == prxmx.ActionUpdate:
+			pending++
+			fmt.Println(updateStyle.Render(fmt.Sprintf("~ %s (%d on %s)", name, item.VMID, item.Node)))
+		default:
+			fmt.Println(sleepingStyle.Render("= " + name))
+		}
+		for _, change := range item.Changes {
+			fmt.Println("    " + strings.ReplaceAll(change.String(), "\n", "\\n"))
+		}
+		for _, change := range item.Destructive {
+			fmt.Println(dangerStyle.Render("    ! " + change.String() + " [not applied]"))
+		}
+	}
+	return pending
+}
+
+// confirm asks a yes/no question, anything but y or yes is a no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,180 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"i2/pkg/cloudinit"
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ciData          cloudinit.Data
+	ciUserData      string
+	ciMetaData      string
+	ciNetworkConfig string
+	ciOutput        string
+	ciUpload        string
+	ciNode          string
+	ciAttach        string
+)
+
+// cloudinitCmd represents the cloudinit command
+var cloudinitCmd = &cobra.Command{
+	Use:   "cloudinit",
+	Short: "Generate cloud-init seeds",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var cloudinitBuildCmd = &cobra.Command{
+	Use:   "build <hostname>",
+	Short: "Build a NoCloud seed ISO",
+	Long: `Build a NoCloud seed ISO (labelled cidata) with user-data, meta-data and
+network-config. The files are text/template templates rendered with
+.Hostname, .InstanceID, .User, .SSHKeys, .IP, .Gateway, .Nameservers and the
+--var values as .Vars.<key>. The defaults create the SSH user of the config
+with its public keys and configure the network with DHCP, or with --ip.
+
+Use --upload to store the ISO in a Proxmox storage and --attach to insert it
+in a CD-ROM drive of a VM:
+
+  i2 cloudinit build web --ip 192.168.1.20/24 --gateway 192.168.1.1 --upload local --attach web`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := models.NewConfig()
+		if conf == nil {
+			os.Exit(123)
+		}
+		ctx := context.Background()
+		if ciAttach != "" && ciUpload == "" {
+			log.Fatal("--attach needs --upload <storage>")
+		}
+
+		data := ciData
+		data.Hostname = args[0]
+		if data.User == "" {
+			data.User = conf.SSH.User
+		}
+		keys, err := conf.SSH.AuthorizedKeys()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if len(keys) == 0 {
+			log.Warn("No SSH public key in the config, the seed won't allow SSH logins with keys")
+		}
+		data.SSHKeys = keys
+
+		templates := cloudinit.Templates{}
+		for _, t := range []struct {
+			path string
+			out  *string
+		}{
+			{ciUserData, &templates.UserData},
+			{ciMetaData, &templates.MetaData},
+			{ciNetworkConfig, &templates.NetworkConfig},
+		} {
+			if t.path == "" {
+				continue
+			}
+			b, err := os.ReadFile(t.path)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			*t.out = string(b)
+		}
+
+		seed, err := cloudinit.Render(data, templates)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		output := ciOutput
+		if output == "" {
+			output = data.Hostname + "-cidata.iso"
+		}
+		if err := seed.WriteISO(output); err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Infof("Wrote %s", output)
+		if ciUpload == "" {
+			return
+		}
+
+		var cluster *prxmx.Cluster
+		var guest prxmx.Node
+		node := ciNode
+		if ciAttach != "" {
+			cluster, guest = findGuest(ctx, ciAttach)
+			if node != "" && node != guest.Host {
+				log.Fatalf("%s runs on %s, the ISO can't be attached from --node %s", guest.Name, guest.Host, node)
+			}
+			node = guest.Host
+		} else {
+			if node == "" {
+				log.Fatal("--upload needs --node or --attach")
+			}
+			if cluster, err = newClusters(conf).ForNode(ctx, node); err != nil {
+				log.Fatalf("%v", err)
+			}
+		}
+
+		volid, err := cluster.UploadISO(ctx, node, ciUpload, output)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Infof("Uploaded %s", volid)
+		if ciAttach == "" {
+			return
+		}
+		drive, err := cluster.AttachISO(ctx, guest, volid)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Infof("Attached %s to %s as %s", volid, guest.Name, drive)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cloudinitCmd)
+	cloudinitCmd.AddCommand(cloudinitBuildCmd)
+
+	cloudinitBuildCmd.Flags().StringVar(&ciData.User, "user", "", "user to create (default is the SSH user of the config)")
+	cloudinitBuildCmd.Flags().StringVar(&ciData.InstanceID, "instance-id", "", "instance id (default is the hostname)")
+	cloudinitBuildCmd.Flags().StringVar(&ciData.IP, "ip", "", "static address in CIDR notation (default is DHCP)")
+	cloudinitBuildCmd.Flags().StringVar(&ciData.Gateway, "gateway", "", "default gateway of the static address")
+	cloudinitBuildCmd.Flags().StringSliceVar(&ciData.Nameservers, "nameserver", nil, "DNS servers of the static address")
+	cloudinitBuildCmd.Flags().StringToStringVar(&ciData.Vars, "var", nil, "template variables, key=value")
+	cloudinitBuildCmd.Flags().StringVar(&ciUserData, "user-data", "", "user-data template")
+	cloudinitBuildCmd.Flags().StringVar(&ciMetaData, "meta-data", "", "meta-data template")
+	cloudinitBuildCmd.Flags().StringVar(&ciNetworkConfig, "network-config", "", "network-config template")
+	cloudinitBuildCmd.Flags().StringVarP(&ciOutput, "output", "o", "", "ISO file (default is <hostname>-cidata.iso)")
+	cloudinitBuildCmd.Flags().StringVar(&ciUpload, "upload", "", "upload the ISO to this Proxmox storage")
+	cloudinitBuildCmd.Flags().StringVar(&ciNode, "node", "", "Proxmox node to upload to, the node of --attach when attaching")
+	cloudinitBuildCmd.Flags().StringVar(&ciAttach, "attach", "", "attach the uploaded ISO to this VM")
+}
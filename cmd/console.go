@@ -0,0 +1,156 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"i2/pkg/prxmx"
+
+	"github.com/charmbracelet/log"
+	"github.com/gorilla/websocket"
+	"github.com/moby/term"
+	"github.com/spf13/cobra"
+)
+
+// consoleDetachKeys detach from a console, like virsh and telnet
+const consoleDetachKeys = "ctrl-]"
+
+var vmsConsoleCmd = &cobra.Command{
+	Use:   "console <name>",
+	Short: "Attach the serial console of a guest to the terminal",
+	Long: `Attach the serial console of a VM or LXC container to the terminal. VMs
+need a serial port (serial0) and a getty on it. Press Ctrl-] to detach.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		cluster, guest := findGuest(ctx, args[0])
+
+		conn, _, err := cluster.DialConsole(ctx, guest, prxmx.ConsoleSerial)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(os.Stderr, "Connected to %s, press Ctrl-] to detach\r\n", guest.Name)
+		fd := os.Stdin.Fd()
+		if term.IsTerminal(fd) {
+			state, err := term.MakeRaw(fd)
+			if err != nil {
+				log.Fatalf("Error setting the terminal in raw mode: %v", err)
+			}
+			defer term.RestoreTerminal(fd, state)
+		}
+		if err := attachConsole(conn, fd); err != nil {
+			// the terminal is raw, log.Fatal would leave it broken
+			fmt.Fprintf(os.Stderr, "\r\n%v\r\n", err)
+		}
+	},
+}
+
+// attachConsole pipes the terminal to a serial console until the console is
+// closed or the detach keys are pressed
+func attachConsole(conn *websocket.Conn, fd uintptr) error {
+	detachKeys, err := term.ToBytes(consoleDetachKeys)
+	if err != nil {
+		return err
+	}
+	done := make(chan error, 2)
+	input := make(chan []byte)
+
+	go func() {
+		stdin := term.NewEscapeProxy(os.Stdin, detachKeys)
+		buf := make([]byte, 1024)
+		for {
+			n, err := stdin.Read(buf)
+			if n > 0 {
+				input <- append([]byte(nil), buf[:n]...)
+			}
+			if errors.As(err, &term.EscapeError{}) {
+				done <- nil
+				return
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					err = nil
+				}
+				done <- err
+				return
+			}
+			os.Stdout.Write(msg)
+		}
+	}()
+
+	// only this loop writes to the websocket
+	keepAlive := time.NewTicker(prxmx.ConsoleKeepAlive)
+	defer keepAlive.Stop()
+	winch := make(chan os.Signal, 1)
+	stop := notifyResize(winch)
+	defer stop()
+	var size term.Winsize
+	resized := func() []byte {
+		ws, err := term.GetWinsize(fd)
+		if err != nil || *ws == size {
+			return nil
+		}
+		size = *ws
+		return prxmx.ConsoleResize(int(size.Width), int(size.Height))
+	}
+	msg := resized()
+	for {
+		if msg != nil {
+			if err := conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+				return err
+			}
+		}
+		select {
+		case err := <-done:
+			closing := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+			_ = conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(time.Second))
+			return err
+		case data := <-input:
+			msg = prxmx.ConsoleInput(data)
+		case <-keepAlive.C:
+			msg = prxmx.ConsoleKeepAliveMessage
+		case <-winch:
+			msg = resized()
+		}
+	}
+}
+
+func init() {
+	vmsCmd.AddCommand(vmsConsoleCmd)
+}
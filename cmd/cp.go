@@ -24,102 +24,105 @@ package cmd
 import (
 	"context"
 	"fmt"
-	"i2/pkg/dckr"
-	"i2/pkg/dfiles"
-	"log"
 	"os"
-	"strings"
+	"os/signal"
+	"time"
+
+	"i2/pkg/dckr"
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+	"i2/pkg/store"
 
-	"github.com/docker/docker/api/types/container"
+	"github.com/charmbracelet/log"
+	"github.com/docker/go-units"
+	"github.com/moby/term"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
-var (
-	filePath       string
-	containerID    string
-	privateKeyPath string
-	remoteUser     string
-	remoteDir      string
-	scpHost        string
-)
+var cpArchive bool
 
 // cpCmd represents the cp command
 var cpCmd = &cobra.Command{
-	Use:   "cp",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+	Use:   "cp <src> <dst>",
+	Short: "Copy files and directories between containers and the local filesystem",
+	Long: `Copy a file or a directory from or to a container, like docker cp. Either
+side is a local path, <container>:<path> for a container of the local Docker,
+or <container>@<vm>:<path> for a container of a guest reached over SSH.
+Containers of two different hosts can be copied between.
+
+Directories are copied into the destination when it's an existing directory,
+as the destination otherwise. <src>/. copies the contents of a directory.
+Modes, times and symbolic links are kept, -a keeps the uid and gid of the
+files copied to a container, local copies keep them when run as root.
+
+  i2 cp nginx.conf web@vm1:/etc/nginx/
+  i2 cp db@vm2:/var/backups ./backups
+  i2 cp -a web@vm1:/srv/data/. web@vm3:/srv/data`,
+	Args: cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
-		if scpHost != "" {
-			copyFileRemote()
-		} else {
-			copyFileToContainer(filePath, containerID)
+		src, dst := dckr.ParseCopyPath(args[0]), dckr.ParseCopyPath(args[1])
+		if src.IsLocal() && dst.IsLocal() {
+			log.Fatal("One of the paths must be <container>:<path> or <container>@<vm>:<path>")
+		}
+		conf := models.NewConfig()
+		if conf == nil {
+			os.Exit(123)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		var inventory *prxmx.Inventory
+		if src.Host != "" || dst.Host != "" {
+			st, err := store.NewStore(ctx, &conf.Nats)
+			if err != nil {
+				log.Fatalf("Error creating store: %v", err)
+			}
+			defer st.Close()
+			inventory = prxmx.NewInventory(st)
+		}
+		clients := map[string]*dckr.DockerClient{}
+		client := func(p dckr.CopyPath) *dckr.DockerClient {
+			if p.IsLocal() {
+				return nil
+			}
+			if dc, ok := clients[p.Host]; ok {
+				return dc
+			}
+			dc, _, err := dckr.HostClient(ctx, inventory, p.Host, conf.SSH.User)
+			if err != nil {
+				log.Fatalf("Error creating Docker client: %v", err)
+			}
+			clients[p.Host] = dc
+			return dc
+		}
+		opts := dckr.CopyOptions{Source: client(src), Destination: client(dst), Archive: cpArchive}
+		for _, dc := range clients {
+			defer dc.Close()
+		}
+
+		var copied int64
+		var shown time.Time
+		progress := term.IsTerminal(os.Stderr.Fd())
+		opts.Progress = func(n int64) {
+			copied = n
+			if progress && time.Since(shown) > 100*time.Millisecond {
+				shown = time.Now()
+				fmt.Fprintf(os.Stderr, "\rCopying to %s: %s ", dst, units.HumanSize(float64(n)))
+			}
+		}
+		err := dckr.Copy(ctx, src, dst, opts)
+		if progress && !shown.IsZero() {
+			fmt.Fprintln(os.Stderr)
+		}
+		if err != nil {
+			log.Fatalf("%v", err)
 		}
+		fmt.Fprintf(os.Stderr, "Successfully copied %s to %s\n", units.HumanSize(float64(copied)), dst)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(cpCmd)
 
-	cpCmd.Flags().StringVarP(&filePath, "file", "f", "", "File to copy")
-	cpCmd.Flags().StringVarP(&containerID, "container", "c", "", "Container ID")
-	cpCmd.Flags().StringVarP(&privateKeyPath, "private-key", "p", "", "Private key path")
-	cpCmd.Flags().StringVarP(&scpHost, "scp", "n", "", "Scp remote host with the file: remote_username@HOST:/remote/directory")
-}
-
-func parseScpHost() (string, string, string) {
-	parts := strings.Split(scpHost, "@")
-	if len(parts) != 2 {
-		log.Fatalf("Invalid scp host format. Expected format: username@host")
-	}
-	username := parts[0]
-	host := parts[1]
-	return username, host, remoteDir
-}
-
-func copyFileRemote() error {
-	pkp := viper.GetString("ssh.privateKeyPath")
-	remoteUser, sshHost, remoteDir := parseScpHost()
-
-	return dfiles.SCPTransfer(filePath, remoteUser, sshHost, remoteDir, pkp)
-}
-
-func copyFileToContainer(filePath, containerID string) error {
-	// Create a new Docker client
-	dc, err := dckr.NewDockerClient()
-	if err != nil {
-		return fmt.Errorf("failed to create Docker client: %w", err)
-	}
-	defer dc.Close()
-
-	// Open the local file
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to open local file: %w", err)
-	}
-	defer file.Close()
-
-	// Get file info
-	_, err = file.Stat()
-	if err != nil {
-		return fmt.Errorf("failed to get file info: %w", err)
-	}
-
-	// Copy the file to the container
-	err = dc.CopyToContainer(context.Background(), containerID, "/", file, container.CopyToContainerOptions{
-		AllowOverwriteDirWithFile: true,
-		CopyUIDGID:                true,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to copy file to container: %w", err)
-	}
-
-	fmt.Printf("File %s copied successfully to container %s\n", filePath, containerID)
-	return nil
-
+	cpCmd.Flags().BoolVarP(&cpArchive, "archive", "a", false, "keep the uid and gid of the files copied to a container")
 }
@@ -32,6 +32,7 @@ import (
 	"i2/pkg/store"
 	"i2/pkg/utils"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -86,6 +87,9 @@ to quickly create a Cobra application.`,
 		defer st.Close()
 
 		if all {
+			if err := filter.Validate(); err != nil {
+				log.Fatalf("%v", err)
+			}
 			csInVms := doAll(st, ctx)
 			totalContainers := 0
 
@@ -132,6 +136,7 @@ func init() {
 	csCmd.Flags().BoolVarP(&all, "all", "a", false, "return containers in all nodes")
 	csCmd.Flags().BoolVarP(&print, "print", "p", false, "print containers to stdout")
 	csCmd.Flags().BoolVarP(&live, "live", "l", false, "live mode")
+	addFilterFlags(csCmd)
 }
 
 func listLocalContainers() []types.Container {
@@ -145,12 +150,10 @@ func listLocalContainers() []types.Container {
 }
 
 func getVMFromNATS(hostname string, st *store.Store, ctx context.Context) (prxmx.Node, error) {
-	jvm, err := store.GetKV(ctx, hostname, bucketVMS, st.NatsConn)
+	vm, err := prxmx.NewInventory(st).Get(ctx, hostname)
 	if err != nil {
 		log.Fatalf("Error getting VM From NATS: %v %s %s", err, hostname, bucketVMS)
 	}
-	vm := prxmx.Node{}
-	err = json.Unmarshal(jvm, &vm)
 	return vm, err
 }
 
@@ -188,18 +191,12 @@ func doAll(st *store.Store, ctx context.Context) map[string][]types.Container {
 	if !live {
 		return getCSFromNATS(ctx, st)
 	}
-	vms := []prxmx.Node{}
 	allContainers := make(map[string][]types.Container)
-	keys, _ := store.GetKeys(ctx, bucketVMS, st.NatsConn)
-	for _, key := range keys {
-		vm, err := getVMFromNATS(key, st, ctx)
-		if err != nil {
-			log.Fatalf("Error getting VM: %v", err)
-			continue
-		}
-		vms = append(vms, vm)
+	vms, err := prxmx.NewInventory(st).List(ctx)
+	if err != nil {
+		log.Fatalf("Error getting VMs: %v", err)
 	}
-	for _, vm := range vms {
+	for _, vm := range filter.Apply(vms) {
 		if vm.Running {
 			log.Info("Processing VM", vm.Name, "IP", vm.IP)
 			user := viper.GetString("ssh.user")
@@ -208,6 +205,7 @@ func doAll(st *store.Store, ctx context.Context) map[string][]types.Container {
 			containers := listRemoteContainers(st, sshHost, ctx)
 			if len(containers) > 0 {
 				saveContainers(ctx, vm, containers, st)
+				saveStats(ctx, vm, sshHost, st)
 			}
 			lip := vm.Name + "-" + lips
 			allContainers[lip] = containers
@@ -223,11 +221,13 @@ func getCSFromNATS(ctx context.Context, st *store.Store) map[string][]types.Cont
 
 	for _, key := range keys {
 		log.Info("Processing container", key)
-		vmprxmx, _ := store.GetKV(ctx, key, bucketVMS, st.NatsConn)
-		var vm prxmx.Node
-		err := json.Unmarshal(vmprxmx, &vm)
+		vm, err := prxmx.NewInventory(st).Get(ctx, key)
 		if err != nil {
-			log.Fatalf("Error unmarshalling VM: %v", err)
+			log.Errorf("Error getting VM %s: %v", key, err)
+			continue
+		}
+		if !filter.Match(vm) {
+			continue
 		}
 		vname := vm.Name + "-" + utils.GetLocalIP(vm.IP)
 
@@ -250,12 +250,26 @@ func saveContainers(ctx context.Context, vm prxmx.Node, containers []types.Conta
 	}
 	// Print container ID and name
 
-	err = store.SetKV(ctx, vm.Name, bucketContainers, bcontainers, st.NatsConn)
+	err = store.SetKV(ctx, vm.Key(), bucketContainers, bcontainers, st.NatsConn)
 	if err != nil {
 		log.Fatalf("Error storing container: %v", err)
 	}
 }
 
+// saveStats stores the stats of the running containers of a guest next to
+// its containers, a guest failing only logs a warning
+func saveStats(ctx context.Context, vm prxmx.Node, sshHost string, st *store.Store) {
+	dc, err := dckr.NewDockerClientWithSSH(sshHost)
+	if err != nil {
+		log.Warnf("Error creating Docker client for %s: %v", vm.Name, err)
+		return
+	}
+	defer dc.Close()
+	if err := dc.RefreshStats(ctx, prxmx.NewInventory(st), vm); err != nil {
+		log.Warnf("Error storing the stats of %s: %v", vm.Name, err)
+	}
+}
+
 func printContainers(hostname, ip string, containers []types.Container) {
 
 	re := lipgloss.NewRenderer(os.Stdout)
@@ -300,3 +314,66 @@ func printContainers(hostname, ip string, containers []types.Container) {
 	fmt.Println(group)
 
 }
+
+// dockerHost is a Docker host of the containers commands, vm is nil for the
+// local Docker
+type dockerHost struct {
+	name string
+	vm   *prxmx.Node
+	dc   *dckr.DockerClient
+}
+
+// dockerHosts returns every running guest of the containers bucket matching
+// the filter with all, sorted by name, the guest given, or the local Docker.
+// Guests are reached over SSH as user, the inventory is only read for guests.
+func dockerHosts(ctx context.Context, inventory *prxmx.Inventory, user string, all bool, args []string) ([]dockerHost, error) {
+	if !all {
+		host := ""
+		if len(args) == 1 {
+			host = args[0]
+		}
+		dc, vm, err := dckr.HostClient(ctx, inventory, host, user)
+		if err != nil {
+			return nil, fmt.Errorf("error creating Docker client: %w", err)
+		}
+		name := "localhost"
+		if vm != nil {
+			name = vm.Name
+		}
+		return []dockerHost{{name: name, vm: vm, dc: dc}}, nil
+	}
+
+	stored, err := inventory.Containers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the containers bucket: %w", err)
+	}
+	hosts := []dockerHost{}
+	for key := range stored {
+		vm, err := inventory.GetByKey(ctx, key)
+		if err != nil {
+			log.Warnf("Error getting VM %s: %v", key, err)
+			continue
+		}
+		ip := utils.GetLocalIP(vm.IP)
+		if !vm.Running || ip == "" || !filter.Match(vm) {
+			continue
+		}
+		dc, err := dckr.NewDockerClientWithSSH(dckr.SSHAddress(user, ip))
+		if err != nil {
+			log.Warnf("Error creating Docker client for %s: %v", vm.Name, err)
+			continue
+		}
+		hosts = append(hosts, dockerHost{name: vm.Name, vm: &vm, dc: dc})
+	}
+	slices.SortFunc(hosts, func(a, b dockerHost) int { return strings.Compare(a.name, b.name) })
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no running guest with containers, refresh them with i2 containers --all --live")
+	}
+	return hosts, nil
+}
+
+func closeDockerHosts(hosts []dockerHost) {
+	for _, h := range hosts {
+		h.dc.Close()
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"i2/pkg/dckr"
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+	"i2/pkg/store"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	csHost    string
+	csTimeout int
+	csSignal  string
+	csForce   bool
+	csVolumes bool
+)
+
+var csStartCmd = &cobra.Command{
+	Use:   "start <name>",
+	Short: "Start a container",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runContainerAction(cmd, dckr.ActionStart, args[0], dckr.ActionOptions{})
+	},
+}
+
+var csStopCmd = &cobra.Command{
+	Use:   "stop <name>",
+	Short: "Stop a container",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runContainerAction(cmd, dckr.ActionStop, args[0], dckr.ActionOptions{Timeout: stopTimeout(cmd)})
+	},
+}
+
+var csRestartCmd = &cobra.Command{
+	Use:   "restart <name>",
+	Short: "Restart a container",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runContainerAction(cmd, dckr.ActionRestart, args[0], dckr.ActionOptions{Timeout: stopTimeout(cmd)})
+	},
+}
+
+var csKillCmd = &cobra.Command{
+	Use:   "kill <name>",
+	Short: "Send a signal to a container",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runContainerAction(cmd, dckr.ActionKill, args[0], dckr.ActionOptions{Signal: csSignal})
+	},
+}
+
+var csRmCmd = &cobra.Command{
+	Use:   "rm <name>",
+	Short: "Remove a container",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runContainerAction(cmd, dckr.ActionRemove, args[0], dckr.ActionOptions{Force: csForce, Volumes: csVolumes})
+	},
+}
+
+var csRenameCmd = &cobra.Command{
+	Use:   "rename <name> <new name>",
+	Short: "Rename a container",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runContainerAction(cmd, dckr.ActionRename, args[0], dckr.ActionOptions{NewName: args[1]})
+	},
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{csStartCmd, csStopCmd, csRestartCmd, csKillCmd, csRmCmd, csRenameCmd} {
+		csCmd.AddCommand(cmd)
+		cmd.Flags().StringVar(&csHost, "host", "", "guest running the container, reached over SSH (default is the local Docker)")
+	}
+	for _, cmd := range []*cobra.Command{csStopCmd, csRestartCmd} {
+		cmd.Flags().IntVarP(&csTimeout, "time", "t", 0, "seconds to wait before killing the container (default is the stop timeout of the container)")
+	}
+	csKillCmd.Flags().StringVarP(&csSignal, "signal", "s", "", "signal to send (default is SIGKILL)")
+	csRmCmd.Flags().BoolVarP(&csForce, "force", "f", false, "remove a running container")
+	csRmCmd.Flags().BoolVarP(&csVolumes, "volumes", "v", false, "remove the anonymous volumes of the container")
+}
+
+// stopTimeout returns --time, nil when it isn't set so Docker uses the stop
+// timeout of the container
+func stopTimeout(cmd *cobra.Command) *int {
+	if !cmd.Flags().Changed("time") {
+		return nil
+	}
+	return &csTimeout
+}
+
+// runContainerAction runs an action on a container of --host, or of the local
+// Docker, and refreshes the containers of the guest in NATS
+func runContainerAction(cmd *cobra.Command, action, name string, opts dckr.ActionOptions) {
+	conf := models.NewConfig()
+	if conf == nil {
+		os.Exit(123)
+	}
+	ctx := context.Background()
+
+	var inventory *prxmx.Inventory
+	if csHost != "" {
+		st, err := store.NewStore(ctx, &conf.Nats)
+		if err != nil {
+			log.Fatalf("Error creating store: %v", err)
+		}
+		defer st.Close()
+		inventory = prxmx.NewInventory(st)
+	}
+	dc, vm, err := dckr.HostClient(ctx, inventory, csHost, conf.SSH.User)
+	if err != nil {
+		log.Fatalf("Error creating Docker client: %v", err)
+	}
+	defer dc.Close()
+
+	if err := dc.ContainerAction(ctx, action, name, opts); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if vm == nil {
+		log.Infof("%s: %s", cmd.Name(), name)
+		return
+	}
+	log.Infof("%s: %s on %s", cmd.Name(), name, vm.Name)
+	if err := dc.RefreshContainers(ctx, inventory, *vm); err != nil {
+		log.Warnf("Error refreshing the containers of %s: %v", vm.Name, err)
+	}
+}
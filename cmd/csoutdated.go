@@ -0,0 +1,153 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"i2/pkg/dckr"
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+	"i2/pkg/store"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/charmbracelet/log"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/spf13/cobra"
+)
+
+var (
+	outdatedAll  bool
+	outdatedPush bool
+)
+
+var csOutdatedCmd = &cobra.Command{
+	Use:   "outdated",
+	Short: "List the containers running outdated images",
+	Long: `Compare the image of every running container in the containers bucket with
+the image its tag points to in its registry. A container is outdated when the
+tag was pushed again since the image was pulled. Registries are read with the
+credentials of docker login, multi-platform images are compared with the
+manifest of the platform of the local image.
+
+The containers bucket is refreshed by i2 containers --all --live, --push sends
+the i2_container_image_outdated gauge to the push gateway of the config.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := models.NewConfig()
+		if conf == nil {
+			os.Exit(123)
+		}
+		ctx := context.Background()
+		st, err := store.NewStore(ctx, &conf.Nats)
+		if err != nil {
+			log.Fatalf("Error creating store: %v", err)
+		}
+		defer st.Close()
+
+		statuses, err := dckr.OutdatedContainers(ctx, prxmx.NewInventory(st), dckr.NewRegistry())
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		printImageStatuses(statuses, outdatedAll)
+
+		if outdatedPush {
+			if conf.PushGateway.URL == "" {
+				log.Fatalf("push_gateway.url is not set")
+			}
+			dckr.SetOutdatedGauge(statuses)
+			if err := push.New(conf.PushGateway.URL, "i2").Collector(dckr.OutdatedGauge).Push(); err != nil {
+				log.Fatalf("Error pushing to %s: %v", conf.PushGateway.URL, err)
+			}
+		}
+	},
+}
+
+// printImageStatuses prints the outdated containers and the ones whose
+// registry couldn't be read, all of them with all
+func printImageStatuses(statuses []dckr.ImageStatus, all bool) {
+	re := lipgloss.NewRenderer(os.Stdout)
+	baseStyle := re.NewStyle().Padding(0, 1)
+	headerStyle := baseStyle.Foreground(lipgloss.Color("252")).Bold(true)
+	rowStyle := baseStyle.Foreground(lipgloss.Color("250"))
+	outdatedStyle := baseStyle.Foreground(lipgloss.Color("214"))
+	errorStyle := baseStyle.Foreground(lipgloss.Color("203"))
+
+	rows := []dckr.ImageStatus{}
+	outdated := 0
+	for _, s := range statuses {
+		if s.Outdated {
+			outdated++
+		}
+		if all || s.Outdated || s.Error != "" {
+			rows = append(rows, s)
+		}
+	}
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			switch {
+			case row == 0:
+				return headerStyle
+			case rows[row-1].Error != "":
+				return errorStyle
+			case rows[row-1].Outdated:
+				return outdatedStyle
+			}
+			return rowStyle
+		}).
+		Headers("VM", "Container", "Image", "Running", "Status")
+
+	for _, s := range rows {
+		status := "up to date"
+		switch {
+		case s.Error != "":
+			status = s.Error
+		case s.Outdated:
+			status = "outdated"
+		}
+		t.Row(s.VM, s.Container, s.Image, shortDigest(s.Local), status)
+	}
+	fmt.Println(t.Render())
+	log.Infof("Checked containers: %d Outdated: %d", len(statuses), outdated)
+}
+
+// shortDigest returns the first 12 hex characters of a digest, like docker
+func shortDigest(d string) string {
+	_, hex, _ := strings.Cut(d, ":")
+	if len(hex) > 12 {
+		return hex[:12]
+	}
+	return hex
+}
+
+func init() {
+	csCmd.AddCommand(csOutdatedCmd)
+
+	csOutdatedCmd.Flags().BoolVarP(&outdatedAll, "all", "a", false, "list the containers that are up to date too")
+	csOutdatedCmd.Flags().BoolVar(&outdatedPush, "push", false, "push the outdated gauge to the push gateway")
+}
@@ -0,0 +1,320 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"i2/pkg/dckr"
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+	"i2/pkg/store"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/charmbracelet/log"
+	"github.com/docker/go-units"
+	"github.com/moby/term"
+	"github.com/spf13/cobra"
+)
+
+// Columns the top command sorts by
+const (
+	topSortCPU    = "cpu"
+	topSortMemory = "mem"
+	topSortName   = "name"
+	topSortVM     = "vm"
+	topSortNet    = "net"
+	topSortIO     = "io"
+)
+
+var (
+	topAll      bool
+	topOnce     bool
+	topInterval time.Duration
+	topSort     string
+)
+
+var csTopCmd = &cobra.Command{
+	Use:   "top [vm]",
+	Short: "Show the resources used by containers",
+	Long: `Show the CPU, memory, network and block IO used by the running containers
+of the local Docker, of a guest, or with --all of every running guest in the
+containers bucket, reached over SSH.
+
+The view refreshes every --interval and is sorted with the keys: c CPU,
+m memory, n name, v VM, i network IO, b block IO. --once, or an output that
+isn't a terminal, prints a single sample.
+
+  i2 containers top --all
+  i2 containers top --all --tag prod --sort mem
+  i2 containers top vm1 --once`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := models.NewConfig()
+		if conf == nil {
+			os.Exit(123)
+		}
+		if !slices.Contains([]string{topSortCPU, topSortMemory, topSortName, topSortVM, topSortNet, topSortIO}, topSort) {
+			log.Fatalf("invalid sort %q, use cpu, mem, name, vm, net or io", topSort)
+		}
+		if err := filter.Validate(); err != nil {
+			log.Fatalf("%v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var inventory *prxmx.Inventory
+		if topAll || len(args) == 1 {
+			st, err := store.NewStore(ctx, &conf.Nats)
+			if err != nil {
+				log.Fatalf("Error creating store: %v", err)
+			}
+			defer st.Close()
+			inventory = prxmx.NewInventory(st)
+		}
+		hosts, err := dockerHosts(ctx, inventory, conf.SSH.User, topAll, args)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer closeDockerHosts(hosts)
+
+		if topOnce || !term.IsTerminal(os.Stdout.Fd()) {
+			rows, errs := sampleHosts(ctx, hosts)
+			sortTopRows(rows, topSort)
+			fmt.Println(renderTopTable(rows, topSort))
+			for _, h := range hosts {
+				if errs[h.name] != nil {
+					log.Warnf("%s: %v", h.name, errs[h.name])
+				}
+			}
+			return
+		}
+		m := topModel{ctx: ctx, hosts: hosts, interval: topInterval, sortBy: topSort}
+		if _, err := tea.NewProgram(m, tea.WithAltScreen()).Run(); err != nil {
+			log.Fatalf("Error running program: %v", err)
+		}
+	},
+}
+
+// topRow is a container of the top command
+type topRow struct {
+	vm string
+	dckr.ContainerStats
+}
+
+// sampleHosts samples the running containers of every host concurrently,
+// the hosts failing are returned with their error
+func sampleHosts(ctx context.Context, hosts []dockerHost) ([]topRow, map[string]error) {
+	type sample struct {
+		host  string
+		stats []dckr.ContainerStats
+		err   error
+	}
+	samples := make(chan sample, len(hosts))
+	for _, h := range hosts {
+		go func() {
+			stats, err := h.dc.HostStats(ctx)
+			samples <- sample{host: h.name, stats: stats, err: err}
+		}()
+	}
+	rows := []topRow{}
+	errs := map[string]error{}
+	for range hosts {
+		s := <-samples
+		if s.err != nil {
+			errs[s.host] = s.err
+			continue
+		}
+		for _, stats := range s.stats {
+			rows = append(rows, topRow{vm: s.host, ContainerStats: stats})
+		}
+	}
+	return rows, errs
+}
+
+// sortTopRows sorts the containers by a column, the biggest usage first
+func sortTopRows(rows []topRow, by string) {
+	key := func(r topRow) float64 {
+		switch by {
+		case topSortCPU:
+			return r.CPUPercent
+		case topSortMemory:
+			return float64(r.MemoryUsage)
+		case topSortNet:
+			return float64(r.NetworkRx + r.NetworkTx)
+		case topSortIO:
+			return float64(r.BlockRead + r.BlockWrite)
+		}
+		return 0
+	}
+	slices.SortStableFunc(rows, func(a, b topRow) int {
+		switch by {
+		case topSortName:
+			return strings.Compare(a.Name+"\x00"+a.vm, b.Name+"\x00"+b.vm)
+		case topSortVM:
+			return strings.Compare(a.vm+"\x00"+a.Name, b.vm+"\x00"+b.Name)
+		}
+		ka, kb := key(a), key(b)
+		switch {
+		case ka > kb:
+			return -1
+		case ka < kb:
+			return 1
+		}
+		return strings.Compare(a.vm+"\x00"+a.Name, b.vm+"\x00"+b.Name)
+	})
+}
+
+// renderTopTable renders the containers, the sorted column is marked
+func renderTopTable(rows []topRow, sortBy string) string {
+	re := lipgloss.NewRenderer(os.Stdout)
+	baseStyle := re.NewStyle().Padding(0, 1)
+	headerStyle := baseStyle.Foreground(lipgloss.Color("252")).Bold(true)
+	rowStyle := baseStyle.Foreground(lipgloss.Color("250"))
+	hotStyle := baseStyle.Foreground(lipgloss.Color("203"))
+
+	headers := []string{"VM", "Container", "CPU %", "Memory", "Mem %", "Net I/O", "Block I/O", "PIDs"}
+	for i, column := range []string{topSortVM, topSortName, topSortCPU, topSortMemory, "", topSortNet, topSortIO, ""} {
+		if column != "" && column == sortBy {
+			headers[i] += " ▼"
+		}
+	}
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			switch {
+			case row == 0:
+				return headerStyle
+			case (col == 2 && rows[row-1].CPUPercent >= 80) || (col == 4 && rows[row-1].MemoryPercent >= 80):
+				return hotStyle
+			}
+			return rowStyle
+		}).
+		Headers(headers...)
+
+	for _, r := range rows {
+		t.Row(
+			r.vm,
+			r.Name,
+			fmt.Sprintf("%.2f", r.CPUPercent),
+			units.BytesSize(float64(r.MemoryUsage))+" / "+units.BytesSize(float64(r.MemoryLimit)),
+			fmt.Sprintf("%.2f", r.MemoryPercent),
+			units.HumanSizeWithPrecision(float64(r.NetworkRx), 3)+" / "+units.HumanSizeWithPrecision(float64(r.NetworkTx), 3),
+			units.HumanSizeWithPrecision(float64(r.BlockRead), 3)+" / "+units.HumanSizeWithPrecision(float64(r.BlockWrite), 3),
+			fmt.Sprint(r.PIDs),
+		)
+	}
+	return t.Render()
+}
+
+type topSampleMsg struct {
+	rows []topRow
+	errs map[string]error
+}
+
+type topTickMsg struct{}
+
+// topModel is the live view of the top command, sampling the hosts again
+// an interval after the previous sample
+type topModel struct {
+	ctx      context.Context
+	hosts    []dockerHost
+	interval time.Duration
+	sortBy   string
+	rows     []topRow
+	errs     map[string]error
+	updated  time.Time
+}
+
+func (m topModel) sample() tea.Msg {
+	rows, errs := sampleHosts(m.ctx, m.hosts)
+	return topSampleMsg{rows: rows, errs: errs}
+}
+
+func (m topModel) Init() tea.Cmd {
+	return m.sample
+}
+
+func (m topModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "esc", "ctrl+c":
+			return m, tea.Quit
+		case "c":
+			m.sortBy = topSortCPU
+		case "m":
+			m.sortBy = topSortMemory
+		case "n":
+			m.sortBy = topSortName
+		case "v":
+			m.sortBy = topSortVM
+		case "i":
+			m.sortBy = topSortNet
+		case "b":
+			m.sortBy = topSortIO
+		}
+		sortTopRows(m.rows, m.sortBy)
+	case topSampleMsg:
+		m.rows, m.errs, m.updated = msg.rows, msg.errs, time.Now()
+		sortTopRows(m.rows, m.sortBy)
+		return m, tea.Tick(m.interval, func(time.Time) tea.Msg { return topTickMsg{} })
+	case topTickMsg:
+		return m, m.sample
+	}
+	return m, nil
+}
+
+func (m topModel) View() string {
+	if m.updated.IsZero() {
+		return fmt.Sprintf("\n   Sampling the containers of %d hosts...press q to quit\n", len(m.hosts))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d containers on %d hosts, %s\n", len(m.rows), len(m.hosts), m.updated.Format(time.TimeOnly))
+	b.WriteString(renderTopTable(m.rows, m.sortBy))
+	b.WriteString("\n")
+	for _, h := range m.hosts {
+		if err := m.errs[h.name]; err != nil {
+			b.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("203")).Render(h.name+": "+err.Error()) + "\n")
+		}
+	}
+	b.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("241")).Render("c cpu • m memory • n name • v vm • i net • b block io • q quit"))
+	return b.String()
+}
+
+func init() {
+	csCmd.AddCommand(csTopCmd)
+
+	csTopCmd.Flags().BoolVarP(&topAll, "all", "a", false, "containers of every running guest in the containers bucket")
+	csTopCmd.Flags().BoolVar(&topOnce, "once", false, "print a single sample")
+	csTopCmd.Flags().DurationVar(&topInterval, "interval", 5*time.Second, "time between samples")
+	csTopCmd.Flags().StringVar(&topSort, "sort", topSortCPU, "sort by cpu, mem, name, vm, net or io")
+	addFilterFlags(csTopCmd)
+}
@@ -0,0 +1,180 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"i2/pkg/dckr"
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+	"i2/pkg/store"
+	"i2/pkg/utils"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+var watchAll bool
+
+// watchRescan is how often watch --all lists the guests again, watchRefresh
+// how often it stores the containers and stats of a guest, less than the
+// TTL of the buckets
+const (
+	watchRescan  = 5 * time.Minute
+	watchRefresh = 10 * time.Minute
+)
+
+var csWatchCmd = &cobra.Command{
+	Use:   "watch [vm]",
+	Short: "Publish the container events of Docker hosts to NATS",
+	Long: `Watch the Docker events of the local Docker, of a guest, or with --all of
+every running guest in the vms bucket, reached over SSH. Containers
+starting, dying, running out of memory or changing health are published on
+i2.events.<vm>.container.<action> and updated in the containers bucket as
+they happen. The containers and stats of the guests are stored again every
+10 minutes, before the buckets expire them. i2 keeps running until it's
+interrupted, reconnecting to the hosts that fail. With --all the guests are
+listed again every 5 minutes, new guests are watched and the guests stopped
+are no longer watched.
+
+  i2 containers watch --all
+  nats sub 'i2.events.*.container.die'`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := models.NewConfig()
+		if conf == nil {
+			os.Exit(123)
+		}
+		if err := filter.Validate(); err != nil {
+			log.Fatalf("%v", err)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		st, err := store.NewStore(ctx, &conf.Nats)
+		if err != nil {
+			log.Fatalf("Error creating store: %v", err)
+		}
+		defer st.Close()
+		inventory := prxmx.NewInventory(st)
+
+		publish := func(subject string, data []byte) error {
+			log.Info("Event", "subject", subject)
+			return store.Publish(subject, data, st.NatsConn)
+		}
+		if watchAll {
+			watchGuests(ctx, inventory, conf.SSH.User, publish)
+		} else {
+			hosts, err := dockerHosts(ctx, inventory, conf.SSH.User, false, args)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			defer closeDockerHosts(hosts)
+			log.Infof("Watching the events of %s", hosts[0].name)
+			w := &dckr.EventWatcher{Client: hosts[0].dc, VM: hosts[0].vm, Inventory: inventory, Publish: publish, Refresh: watchRefresh}
+			w.Run(ctx)
+		}
+		if err := st.NatsConn.Flush(); err != nil {
+			log.Warnf("Error flushing the events: %v", err)
+		}
+	},
+}
+
+func init() {
+	csCmd.AddCommand(csWatchCmd)
+
+	csWatchCmd.Flags().BoolVarP(&watchAll, "all", "a", false, "watch every running guest in the vms bucket")
+	addFilterFlags(csWatchCmd)
+}
+
+// watchGuests watches the running guests of the vms bucket matching the
+// filter until the context is cancelled. The guests are listed again every
+// watchRescan, a bucket that can't be read or is empty keeps the guests
+// watched.
+func watchGuests(ctx context.Context, inventory *prxmx.Inventory, user string, publish func(string, []byte) error) {
+	type watcher struct {
+		name   string
+		cancel context.CancelFunc
+		done   chan struct{}
+	}
+	watching := map[string]watcher{}
+	ticker := time.NewTicker(watchRescan)
+	defer ticker.Stop()
+	for {
+		vms, err := inventory.List(ctx)
+		switch {
+		case err != nil:
+			log.Warnf("Error listing the guests: %v", err)
+		case len(vms) == 0:
+			log.Warnf("No guest in the vms bucket, sync them with i2 vms -s")
+		default:
+			running := map[string]prxmx.Node{}
+			for _, vm := range filter.Apply(vms) {
+				if vm.Running && utils.GetLocalIP(vm.IP) != "" {
+					running[vm.Key()] = vm
+				}
+			}
+			for key, w := range watching {
+				if _, ok := running[key]; !ok {
+					log.Infof("No longer watching the events of %s", w.name)
+					w.cancel()
+					<-w.done
+					delete(watching, key)
+				}
+			}
+			for key, vm := range running {
+				if _, ok := watching[key]; ok {
+					continue
+				}
+				dc, err := dckr.NewDockerClientWithSSH(dckr.SSHAddress(user, utils.GetLocalIP(vm.IP)))
+				if err != nil {
+					log.Warnf("Error creating Docker client for %s: %v", vm.Name, err)
+					continue
+				}
+				log.Infof("Watching the events of %s", vm.Name)
+				wctx, cancel := context.WithCancel(ctx)
+				w := watcher{name: vm.Name, cancel: cancel, done: make(chan struct{})}
+				ew := &dckr.EventWatcher{Client: dc, VM: &vm, Inventory: inventory, Publish: publish, Refresh: watchRefresh}
+				go func() {
+					defer close(w.done)
+					defer dc.Close()
+					ew.Run(wctx)
+				}()
+				watching[key] = w
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, w := range watching {
+				<-w.done
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
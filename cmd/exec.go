@@ -0,0 +1,177 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"i2/pkg/dckr"
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+	"i2/pkg/store"
+
+	"github.com/charmbracelet/log"
+	"github.com/moby/term"
+	"github.com/spf13/cobra"
+)
+
+var (
+	execInteractive bool
+	execNoTTY       bool
+	execUser        string
+	execWorkDir     string
+	execEnv         []string
+)
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:   "exec <container>[@vm] [-- <command>...]",
+	Short: "Run a command in a container",
+	Long: `Run a command in a running container of the local Docker, or of a guest
+reached over SSH with <container>@<vm>, sh when no command is given.
+
+When the terminal is interactive the command gets a TTY: the terminal is put
+in raw mode, resizing it resizes the TTY and the command exits i2 with its
+exit code. -T runs the command without a TTY, to pipe its output.
+
+  i2 exec web@vm1
+  i2 exec db@vm2 -u postgres -- psql
+  i2 exec web -T -- cat /etc/os-release`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := models.NewConfig()
+		if conf == nil {
+			os.Exit(123)
+		}
+		ctx := context.Background()
+
+		name, host, _ := strings.Cut(args[0], "@")
+		var inventory *prxmx.Inventory
+		if host != "" {
+			st, err := store.NewStore(ctx, &conf.Nats)
+			if err != nil {
+				log.Fatalf("Error creating store: %v", err)
+			}
+			defer st.Close()
+			inventory = prxmx.NewInventory(st)
+		}
+		dc, _, err := dckr.HostClient(ctx, inventory, host, conf.SSH.User)
+		if err != nil {
+			log.Fatalf("Error creating Docker client: %v", err)
+		}
+		defer dc.Close()
+
+		command := args[1:]
+		if len(command) == 0 {
+			command = []string{"sh"}
+		}
+		code, err := execContainer(ctx, dc, name, dckr.ExecOptions{
+			Cmd:     command,
+			TTY:     !execNoTTY && term.IsTerminal(os.Stdin.Fd()) && term.IsTerminal(os.Stdout.Fd()),
+			Stdin:   execInteractive,
+			User:    execUser,
+			WorkDir: execWorkDir,
+			Env:     execEnv,
+		})
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+// execContainer runs a command in a container attached to the terminal and
+// returns its exit code. The terminal is restored before returning, os.Exit
+// skips deferred calls.
+func execContainer(ctx context.Context, dc *dckr.DockerClient, name string, opts dckr.ExecOptions) (int, error) {
+	fd := os.Stdin.Fd()
+	if opts.TTY {
+		if ws, err := term.GetWinsize(fd); err == nil {
+			opts.Size = &[2]uint{uint(ws.Height), uint(ws.Width)}
+		}
+	}
+	session, err := dc.Exec(ctx, name, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer session.Close()
+
+	var stdin *os.File
+	if opts.Stdin {
+		stdin = os.Stdin
+	}
+	if !opts.TTY {
+		if err := session.Stream(stdin, os.Stdout, os.Stderr); err != nil {
+			return 0, err
+		}
+		return session.ExitCode(ctx)
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return 0, fmt.Errorf("error setting the terminal in raw mode: %w", err)
+	}
+	done := make(chan struct{})
+	go resizeExec(ctx, session, fd, done)
+	err = session.Stream(stdin, os.Stdout, os.Stderr)
+	close(done)
+	term.RestoreTerminal(fd, state)
+	if err != nil {
+		return 0, err
+	}
+	return session.ExitCode(ctx)
+}
+
+// resizeExec resizes the TTY of a command to the terminal until done, on
+// every SIGWINCH
+func resizeExec(ctx context.Context, session *dckr.ExecSession, fd uintptr, done <-chan struct{}) {
+	winch := make(chan os.Signal, 1)
+	stop := notifyResize(winch)
+	defer stop()
+	var size term.Winsize
+	for {
+		if ws, err := term.GetWinsize(fd); err == nil && *ws != size {
+			size = *ws
+			_ = session.Resize(ctx, uint(size.Height), uint(size.Width))
+		}
+		select {
+		case <-done:
+			return
+		case <-winch:
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+
+	execCmd.Flags().BoolVarP(&execInteractive, "interactive", "i", true, "attach the standard input to the command")
+	execCmd.Flags().BoolVarP(&execNoTTY, "no-tty", "T", false, "don't allocate a TTY, even on a terminal")
+	execCmd.Flags().StringVarP(&execUser, "user", "u", "", "user running the command, name or uid[:gid]")
+	execCmd.Flags().StringVarP(&execWorkDir, "workdir", "w", "", "working directory of the command")
+	execCmd.Flags().StringArrayVarP(&execEnv, "env", "e", nil, "environment variable, KEY=value")
+}
@@ -0,0 +1,354 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fwNode     string
+	fwVM       string
+	fwIPSets   bool
+	fwGroups   bool
+	fwRule     prxmx.FirewallRule
+	fwFile     string
+	fwYes      bool
+	fwDryRun   bool
+	fwDisabled bool
+)
+
+// firewallCmd represents the firewall command
+var firewallCmd = &cobra.Command{
+	Use:   "firewall",
+	Short: "Manage the Proxmox firewall",
+	Long: `Manage the firewall rules of the cluster, of a node (--node) or of a guest
+(--vm), and the cluster IP sets and security groups.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var firewallListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the firewall rules",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		cluster, scope := firewallTarget(ctx)
+		switch {
+		case fwIPSets:
+			ipsets, err := cluster.IPSets(ctx)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			printIPSetsTable(ipsets)
+		case fwGroups:
+			groups, err := cluster.SecurityGroups(ctx)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			for _, group := range groups {
+				fmt.Println(lipgloss.NewStyle().Bold(true).Render(group.Group + " " + group.Comment))
+				printRulesTable(group.Rules)
+			}
+		default:
+			rules, err := cluster.FirewallRules(ctx, scope)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			printRulesTable(rules)
+		}
+	},
+}
+
+var firewallAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a firewall rule",
+	Long: `Add a firewall rule, on top of the list unless --pos is set. Reference IP
+sets with +name and security groups with --type group --action <group>:
+
+  i2 firewall add --vm web --type in --action ACCEPT --proto tcp --dport 443 --source +office`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		cluster, scope := firewallTarget(ctx)
+		rule := fwRule
+		rule.Enable = 1
+		if fwDisabled {
+			rule.Enable = 0
+		}
+		if err := cluster.AddFirewallRule(ctx, scope, rule); err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Infof("Added %s to %s", rule, scope)
+	},
+}
+
+var firewallDeleteCmd = &cobra.Command{
+	Use:   "delete <pos>",
+	Short: "Delete the firewall rule at a position",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pos, err := strconv.Atoi(args[0])
+		if err != nil || pos < 0 {
+			log.Fatalf("Invalid rule position %q", args[0])
+		}
+		ctx := context.Background()
+		cluster, scope := firewallTarget(ctx)
+		if err := cluster.DeleteFirewallRule(ctx, scope, pos); err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Infof("Deleted rule %d of %s", pos, scope)
+	},
+}
+
+var firewallApplyCmd = &cobra.Command{
+	Use:   "apply -f firewall.yaml",
+	Short: "Reconcile the firewall with a YAML ruleset",
+	Long: `Compare a YAML ruleset with the firewall and show a plan. Only the IP sets,
+security groups and scopes in the file are managed, an empty list of rules
+removes every rule of its scope. Tagged IP sets hold the address of every
+guest with all the tags.
+
+cluster: main
+ipsets:
+  - name: web
+    tags: [web, prod]
+  - name: office
+    cidrs: [192.168.10.0/24]
+groups:
+  - name: webserver
+    rules:
+      - {type: in, action: ACCEPT, macro: HTTPS}
+rules:
+  - {type: in, action: ACCEPT, source: +office, macro: SSH}
+nodes:
+  pve1: []
+vms:
+  web:
+    - {type: group, action: webserver}
+    - {type: in, action: ACCEPT, source: +web, proto: tcp, dport: "8080"}`,
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := models.NewConfig()
+		if conf == nil {
+			os.Exit(123)
+		}
+		ctx := context.Background()
+		spec, err := prxmx.ReadFirewallFile(fwFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		cluster, err := newClusters(conf).One(cmp.Or(clusterName, spec.Cluster))
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		plan, err := cluster.FirewallPlan(ctx, spec)
+		if err != nil {
+			log.Fatalf("Error planning %s: %v", cluster.Name, err)
+		}
+		for _, warning := range plan.Warnings {
+			log.Warn(warning)
+		}
+		if plan.Empty() {
+			log.Info("Nothing to do")
+			return
+		}
+		printFirewallPlan(plan)
+		if fwDryRun || (!fwYes && !confirm(fmt.Sprintf("Apply the firewall changes to %s?", cluster.Name))) {
+			return
+		}
+		if err := cluster.FirewallApply(ctx, plan, func(step string) { log.Info(step) }); err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Info("Done")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(firewallCmd)
+	firewallCmd.AddCommand(firewallListCmd)
+	firewallCmd.AddCommand(firewallAddCmd)
+	firewallCmd.AddCommand(firewallDeleteCmd)
+	firewallCmd.AddCommand(firewallApplyCmd)
+
+	for _, cmd := range []*cobra.Command{firewallListCmd, firewallAddCmd, firewallDeleteCmd} {
+		cmd.Flags().StringVar(&fwNode, "node", "", "rules of a Proxmox node")
+		cmd.Flags().StringVar(&fwVM, "vm", "", "rules of a guest")
+	}
+	firewallListCmd.Flags().BoolVar(&fwIPSets, "ipsets", false, "list the IP sets")
+	firewallListCmd.Flags().BoolVar(&fwGroups, "groups", false, "list the security groups")
+
+	firewallAddCmd.Flags().IntVar(&fwRule.Pos, "pos", 0, "position of the rule, 0 is the top")
+	firewallAddCmd.Flags().StringVar(&fwRule.Type, "type", "in", "in, out or group")
+	firewallAddCmd.Flags().StringVar(&fwRule.Action, "action", "", "ACCEPT, DROP, REJECT or the security group")
+	firewallAddCmd.Flags().StringVar(&fwRule.Macro, "macro", "", "macro, SSH, HTTPS...")
+	firewallAddCmd.Flags().StringVar(&fwRule.Source, "source", "", "source address, CIDR or +ipset")
+	firewallAddCmd.Flags().StringVar(&fwRule.Dest, "dest", "", "destination address, CIDR or +ipset")
+	firewallAddCmd.Flags().StringVar(&fwRule.Proto, "proto", "", "protocol, tcp, udp, icmp...")
+	firewallAddCmd.Flags().StringVar(&fwRule.DPort, "dport", "", "destination ports, 80,443 or 8000:8100")
+	firewallAddCmd.Flags().StringVar(&fwRule.SPort, "sport", "", "source ports")
+	firewallAddCmd.Flags().StringVar(&fwRule.Iface, "iface", "", "network interface, net0")
+	firewallAddCmd.Flags().StringVar(&fwRule.Log, "log", "", "log level, nolog, info...")
+	firewallAddCmd.Flags().StringVar(&fwRule.Comment, "comment", "", "comment")
+	firewallAddCmd.Flags().BoolVar(&fwDisabled, "disabled", false, "add the rule disabled")
+	firewallAddCmd.MarkFlagRequired("action")
+
+	firewallApplyCmd.Flags().StringVarP(&fwFile, "file", "f", "firewall.yaml", "firewall ruleset")
+	firewallApplyCmd.Flags().BoolVarP(&fwYes, "yes", "y", false, "apply without asking")
+	firewallApplyCmd.Flags().BoolVar(&fwDryRun, "dry-run", false, "only show the plan")
+	firewallApplyCmd.MarkFlagFilename("file", "yaml", "yml")
+}
+
+// firewallTarget returns the cluster and the scope chosen with --cluster,
+// --node and --vm
+func firewallTarget(ctx context.Context) (*prxmx.Cluster, prxmx.FirewallScope) {
+	if fwVM != "" {
+		cluster, guest := findGuest(ctx, fwVM)
+		return cluster, prxmx.FirewallScope{Guest: &guest}
+	}
+	conf := models.NewConfig()
+	if conf == nil {
+		os.Exit(123)
+	}
+	clusters := newClusters(conf)
+	if fwNode != "" {
+		cluster, err := clusters.ForNode(ctx, fwNode)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		return cluster, prxmx.FirewallScope{Node: fwNode}
+	}
+	cluster, err := clusters.One("")
+	if err != nil {
+		log.Fatalf("%v, use --cluster", err)
+	}
+	return cluster, prxmx.FirewallScope{}
+}
+
+func printRulesTable(rules []prxmx.FirewallRule) {
+	re := lipgloss.NewRenderer(os.Stdout)
+	baseStyle := re.NewStyle().Padding(0, 1)
+	headerStyle := baseStyle.Foreground(lipgloss.Color("252")).Bold(true)
+	rowStyle := baseStyle.Foreground(lipgloss.Color("250"))
+	disabledStyle := baseStyle.Foreground(lipgloss.Color("240"))
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row == 0 {
+				return headerStyle
+			}
+			if rules[row-1].Enable == 0 {
+				return disabledStyle
+			}
+			return rowStyle
+		}).
+		Headers("Pos", "Type", "Action", "Macro", "Source", "Dest", "Proto", "DPort", "Iface", "Comment")
+
+	for _, r := range rules {
+		t.Row(strconv.Itoa(r.Pos), r.Type, r.Action, r.Macro, r.Source, r.Dest, r.Proto, r.DPort, r.Iface, r.Comment)
+	}
+	fmt.Println(t.Render())
+}
+
+func printIPSetsTable(ipsets []prxmx.IPSet) {
+	re := lipgloss.NewRenderer(os.Stdout)
+	baseStyle := re.NewStyle().Padding(0, 1)
+	headerStyle := baseStyle.Foreground(lipgloss.Color("252")).Bold(true)
+	rowStyle := baseStyle.Foreground(lipgloss.Color("250"))
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row == 0 {
+				return headerStyle
+			}
+			return rowStyle
+		}).
+		Headers("IP set", "Entries", "Comment")
+
+	for _, ipset := range ipsets {
+		entries := make([]string, 0, len(ipset.Entries))
+		for _, e := range ipset.Entries {
+			if e.NoMatch {
+				entries = append(entries, "!"+e.CIDR)
+				continue
+			}
+			entries = append(entries, e.CIDR)
+		}
+		t.Row("+"+ipset.Name, strings.Join(entries, "\n"), ipset.Comment)
+	}
+	fmt.Println(t.Render())
+}
+
+// printFirewallPlan prints the IP set entries and the rules that change
+func printFirewallPlan(plan prxmx.FirewallPlan) {
+	createStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#01BE85"))
+	updateStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFB86C"))
+	dangerStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F87"))
+
+	for _, change := range plan.IPSets {
+		if change.Create {
+			fmt.Println(createStyle.Render("+ ipset " + change.Name))
+		} else {
+			fmt.Println(updateStyle.Render("~ ipset " + change.Name))
+		}
+		for _, cidr := range change.Add {
+			fmt.Println(createStyle.Render("    + " + cidr))
+		}
+		for _, cidr := range change.Delete {
+			fmt.Println(dangerStyle.Render("    - " + cidr))
+		}
+	}
+	for _, change := range plan.Rules {
+		if change.CreateGroup {
+			fmt.Println(createStyle.Render(fmt.Sprintf("+ %s", change.Scope)))
+		} else {
+			fmt.Println(updateStyle.Render(fmt.Sprintf("~ %s", change.Scope)))
+		}
+		removed, added := prxmx.RuleDiff(change.From, change.To)
+		if len(removed) == 0 && len(added) == 0 {
+			fmt.Println("    reordered")
+		}
+		for _, r := range removed {
+			fmt.Println(dangerStyle.Render("    - " + r.String()))
+		}
+		for _, r := range added {
+			fmt.Println(createStyle.Render("    + " + r.String()))
+		}
+	}
+}
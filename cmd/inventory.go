@@ -0,0 +1,99 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+	"i2/pkg/store"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ansibleList bool
+	ansibleHost string
+)
+
+// inventoryCmd represents the inventory command
+var inventoryCmd = &cobra.Command{
+	Use:   "inventory",
+	Short: "Export the inventory of your guests",
+}
+
+var inventoryAnsibleCmd = &cobra.Command{
+	Use:   "ansible",
+	Short: "Ansible dynamic inventory built from NATS",
+	Long: `Print the guests stored in NATS as an Ansible dynamic inventory. Guests
+are grouped by Proxmox node (node_<name>), by tag (tag_<tag>) and in docker
+when they run containers. Use it from a script in your inventory directory:
+
+  #!/bin/sh
+  exec i2 inventory ansible "$@"`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !ansibleList && ansibleHost == "" {
+			log.Fatal("Use --list or --host <name>")
+		}
+		conf := models.NewConfig()
+		if conf == nil {
+			os.Exit(123)
+		}
+		ctx := context.Background()
+		st, err := store.NewStore(ctx, &conf.Nats)
+		if err != nil || st == nil {
+			log.Fatalf("Error creating store: %v", err)
+		}
+		defer st.Close()
+
+		inventory, err := prxmx.NewInventory(st).AnsibleInventory(ctx, conf.SSH.User)
+		if err != nil {
+			log.Fatalf("Error reading the inventory: %v", err)
+		}
+		var out any = inventory
+		if ansibleHost != "" {
+			vars, ok := inventory.HostVars(ansibleHost)
+			if !ok {
+				// Ansible expects an empty object for unknown hosts
+				vars = map[string]any{}
+			}
+			out = vars
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(out); err != nil {
+			log.Fatalf("Error encoding the inventory: %v", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(inventoryCmd)
+	inventoryCmd.AddCommand(inventoryAnsibleCmd)
+
+	inventoryAnsibleCmd.Flags().BoolVar(&ansibleList, "list", false, "Print every group and host")
+	inventoryAnsibleCmd.Flags().StringVar(&ansibleHost, "host", "", "Print the variables of a host")
+}
@@ -0,0 +1,163 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"i2/pkg/dckr"
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+	"i2/pkg/store"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logsApp  string
+	logsOpts dckr.LogOptions
+)
+
+// logColors are the prefix colours of the streams of logs --app
+var logColors = []lipgloss.Color{"#01BE85", "#FFB86C", "#8BE9FD", "#FF79C6", "#BD93F9", "#F1FA8C", "#FF5F87", "#50FA7B"}
+
+// logsCmd represents the logs command
+var logsCmd = &cobra.Command{
+	Use:   "logs <container>[@vm] | --app <project>",
+	Short: "Show the logs of containers",
+	Long: `Show the logs of a container of the local Docker, or of a guest reached
+over SSH with <container>@<vm>.
+
+With --app the logs of every container of a Docker Compose project are
+interleaved, on every guest running it, each line prefixed with the guest
+and the container. The guests are found in the containers stored in NATS,
+refresh them with i2 containers --all --live.
+
+  i2 logs web@vm1 -f --tail 100
+  i2 logs --app shop --since 10m -f`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if (len(args) == 0) == (logsApp == "") {
+			log.Fatal("Use either a container or --app")
+		}
+		conf := models.NewConfig()
+		if conf == nil {
+			os.Exit(123)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		name, host, _ := strings.Cut(cmd.Flags().Arg(0), "@")
+		var inventory *prxmx.Inventory
+		if logsApp != "" || host != "" {
+			st, err := store.NewStore(ctx, &conf.Nats)
+			if err != nil {
+				log.Fatalf("Error creating store: %v", err)
+			}
+			defer st.Close()
+			inventory = prxmx.NewInventory(st)
+		}
+
+		if logsApp != "" {
+			if err := appLogs(ctx, inventory, conf.SSH.User, logsApp); err != nil {
+				log.Fatalf("%v", err)
+			}
+			return
+		}
+		dc, _, err := dckr.HostClient(ctx, inventory, host, conf.SSH.User)
+		if err != nil {
+			log.Fatalf("Error creating Docker client: %v", err)
+		}
+		defer dc.Close()
+		if err := dc.StreamLogs(ctx, name, logsOpts, os.Stdout, os.Stderr); err != nil {
+			log.Fatalf("%v", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+
+	logsCmd.Flags().StringVar(&logsApp, "app", "", "Docker Compose project, the logs of all its containers")
+	logsCmd.Flags().BoolVarP(&logsOpts.Follow, "follow", "f", false, "follow the logs")
+	logsCmd.Flags().StringVar(&logsOpts.Since, "since", "", "logs since a timestamp or a duration, 10m")
+	logsCmd.Flags().StringVarP(&logsOpts.Tail, "tail", "n", "all", "number of lines from the end of the logs")
+	logsCmd.Flags().BoolVarP(&logsOpts.Timestamps, "timestamps", "t", false, "show timestamps")
+}
+
+// appLogs interleaves the logs of the containers of a project on every guest
+// running it. A guest or a container failing doesn't stop the others.
+func appLogs(ctx context.Context, inventory *prxmx.Inventory, sshUser, project string) error {
+	vms, err := inventory.ContainerHosts(ctx, dckr.ComposeProjectLabel, project)
+	if err != nil {
+		return err
+	}
+	if len(vms) == 0 {
+		return fmt.Errorf("no guest runs %s, refresh the containers with i2 containers --all --live", project)
+	}
+
+	type stream struct {
+		dc            *dckr.DockerClient
+		vm, container string
+	}
+	streams := []stream{}
+	for _, vm := range vms {
+		dc, _, err := dckr.HostClient(ctx, inventory, vm.Key(), sshUser)
+		if err != nil {
+			log.Errorf("%s: %v", vm.Name, err)
+			continue
+		}
+		defer dc.Close()
+		containers, err := dc.ProjectContainers(ctx, project)
+		if err != nil {
+			log.Errorf("%s: %v", vm.Name, err)
+			continue
+		}
+		for _, c := range containers {
+			streams = append(streams, stream{dc: dc, vm: vm.Name, container: dckr.GetContainerName(c.Names)})
+		}
+	}
+	if len(streams) == 0 {
+		return fmt.Errorf("no container of %s found", project)
+	}
+
+	width := 0
+	for _, s := range streams {
+		width = max(width, len(s.vm)+1+len(s.container))
+	}
+	sources := make([]dckr.LogSource, 0, len(streams))
+	for i, s := range streams {
+		label := fmt.Sprintf("%-*s", width, s.vm+"/"+s.container)
+		prefix := lipgloss.NewStyle().Foreground(logColors[i%len(logColors)]).Render(label) + " | "
+		sources = append(sources, dckr.LogSource{Client: s.dc, Container: s.container, Prefix: prefix})
+	}
+	dckr.InterleaveLogs(ctx, sources, logsOpts, os.Stdout, os.Stderr, func(source dckr.LogSource, err error) {
+		log.Errorf("%s%v", source.Prefix, err)
+	})
+	return nil
+}
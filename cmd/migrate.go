@@ -0,0 +1,129 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateOnline bool
+	migrateTarget string
+	drainDryRun   bool
+)
+
+var vmsMigrateCmd = &cobra.Command{
+	Use:   "migrate <name> --to <node>",
+	Short: "Migrate a VM or LXC container to another Proxmox node",
+	Long: `Migrate a guest to another node and follow the migration task until it
+finishes. The target node must have enough free memory and the guest disks
+must be on shared storage. Running guests need --online: VMs are migrated
+live and LXC containers are restarted on the target.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := models.NewConfig()
+		if conf == nil {
+			os.Exit(123)
+		}
+		ctx := context.Background()
+		cluster, guest, err := newClusters(conf).FindGuest(ctx, args[0])
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if err := migrateGuest(ctx, conf, cluster, guest, migrateTarget, migrateOnline); err != nil {
+			log.Fatalf("%v", err)
+		}
+	},
+}
+
+var vmsDrainCmd = &cobra.Command{
+	Use:   "drain <node>",
+	Short: "Migrate every running guest off a Proxmox node",
+	Long: `Move every running VM and LXC container off a node before maintenance.
+Each guest goes to the node with the most free memory that passes the
+migration checks. Guests are migrated one at a time, VMs live and LXC
+containers with a restart.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := models.NewConfig()
+		if conf == nil {
+			os.Exit(123)
+		}
+		ctx := context.Background()
+		cluster, err := newClusters(conf).ForNode(ctx, args[0])
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		plan, err := cluster.DrainPlan(ctx, args[0])
+		for _, m := range plan {
+			log.Infof("%s (%s) -> %s", m.Guest.Name, m.Guest.Key(), m.Target)
+		}
+		if err != nil {
+			log.Errorf("These guests can't be migrated: %v", err)
+		}
+		if drainDryRun || len(plan) == 0 {
+			return
+		}
+
+		failed := 0
+		for _, m := range plan {
+			if err := migrateGuest(ctx, conf, cluster, m.Guest, m.Target, true); err != nil {
+				log.Errorf("%v", err)
+				failed++
+			}
+		}
+		if failed > 0 || err != nil {
+			log.Fatalf("Node %s still has running guests", args[0])
+		}
+		log.Infof("Node %s drained", args[0])
+	},
+}
+
+func init() {
+	vmsCmd.AddCommand(vmsMigrateCmd)
+	vmsCmd.AddCommand(vmsDrainCmd)
+
+	vmsMigrateCmd.Flags().StringVar(&migrateTarget, "to", "", "target node")
+	vmsMigrateCmd.Flags().BoolVar(&migrateOnline, "online", false, "migrate a running guest")
+	vmsMigrateCmd.MarkFlagRequired("to")
+	vmsDrainCmd.Flags().BoolVar(&drainDryRun, "dry-run", false, "only show where each guest would go")
+}
+
+// migrateGuest migrates a guest, follows the task and updates its host in NATS
+func migrateGuest(ctx context.Context, conf *models.Config, cluster *prxmx.Cluster, guest prxmx.Node, target string, online bool) error {
+	log.Infof("Migrating %s from %s to %s", guest.Name, guest.Host, target)
+	upid, err := cluster.Migrate(ctx, guest, target, online)
+	if err != nil {
+		return err
+	}
+	return printTask(func(lines chan<- string) (prxmx.TaskInfo, error) {
+		return cluster.FinishMigration(ctx, &conf.Nats, guest, target, upid, lines)
+	})
+}
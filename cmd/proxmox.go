@@ -0,0 +1,317 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pveUser        prxmx.UserSpec
+	pveToken       prxmx.TokenSpec
+	pveACLs        []string
+	pveExpire      time.Duration
+	pveOnePass     string
+	pveYes         bool
+	pveNoPropagate bool
+)
+
+// proxmoxCmd represents the proxmox command
+var proxmoxCmd = &cobra.Command{
+	Use:   "proxmox",
+	Short: "Manage Proxmox users and API tokens",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var proxmoxUsersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "List the Proxmox users and their tokens",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		users, err := accessCluster().Users(ctx)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		printUsersTable(users)
+	},
+}
+
+var proxmoxUsersCreateCmd = &cobra.Command{
+	Use:   "create <user@realm>",
+	Short: "Create a Proxmox user",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		spec := pveUser
+		spec.UserID = args[0]
+		if err := accessCluster().CreateUser(context.Background(), spec); err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Infof("Created %s", spec.UserID)
+	},
+}
+
+var proxmoxUsersDeleteCmd = &cobra.Command{
+	Use:   "delete <user@realm>",
+	Short: "Delete a Proxmox user and its tokens",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if !pveYes && !confirm(fmt.Sprintf("Delete %s and its API tokens?", args[0])) {
+			return
+		}
+		if err := accessCluster().DeleteUser(context.Background(), args[0]); err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Infof("Deleted %s", args[0])
+	},
+}
+
+var proxmoxTokensCmd = &cobra.Command{
+	Use:   "tokens <user@realm>",
+	Short: "List the API tokens of a user",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		cluster := accessCluster()
+		tokens, err := cluster.Tokens(ctx, args[0])
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		acls := map[string][]prxmx.ACL{}
+		for _, token := range tokens {
+			if token.Privsep {
+				if acls[token.TokenID], err = cluster.TokenACLs(ctx, args[0]+"!"+token.TokenID); err != nil {
+					log.Fatalf("%v", err)
+				}
+			}
+		}
+		printTokensTable(tokens, acls)
+	},
+}
+
+var proxmoxTokensCreateCmd = &cobra.Command{
+	Use:   "create <user@realm> <token>",
+	Short: "Issue an API token",
+	Long: `Issue an API token. Tokens use privilege separation: they only get the
+roles granted with --acl <path>=<role>, not the permissions of the user.
+
+  i2 proxmox tokens create ci@pve runner --acl /vms=PVEVMUser --acl /storage/local=PVEDatastoreUser --expire 2160h
+
+The secret is only shown once, use --1password <vault> to store it in
+1Password (with OP_SERVICE_ACCOUNT_TOKEN) instead of printing it.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		spec := pveToken
+		spec.ID = args[1]
+		if pveExpire > 0 {
+			spec.Expire = time.Now().Add(pveExpire).Unix()
+		}
+		for _, value := range pveACLs {
+			path, role, found := strings.Cut(value, "=")
+			if !found {
+				log.Fatalf("Invalid ACL %q, use <path>=<role>", value)
+			}
+			spec.ACLs = append(spec.ACLs, prxmx.ACL{Path: path, Role: role, Propagate: !pveNoPropagate})
+		}
+		secret, err := accessCluster().CreateToken(context.Background(), args[0], spec)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		printTokenSecret(secret)
+	},
+}
+
+var proxmoxTokensRotateCmd = &cobra.Command{
+	Use:   "rotate <user@realm> <token>",
+	Short: "Replace the secret of an API token",
+	Long: `Revoke an API token and issue it again with the same comment, expiry,
+privilege separation and ACLs. A temporary <token>-rotate token is issued and
+revoked first, the token is left untouched when it can't be issued. The old
+secret stops working immediately.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		secret, err := accessCluster().RotateToken(context.Background(), args[0], args[1])
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		printTokenSecret(secret)
+	},
+}
+
+var proxmoxTokensRevokeCmd = &cobra.Command{
+	Use:   "revoke <user@realm> <token>",
+	Short: "Revoke an API token",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if !pveYes && !confirm(fmt.Sprintf("Revoke %s!%s?", args[0], args[1])) {
+			return
+		}
+		if err := accessCluster().RevokeToken(context.Background(), args[0], args[1]); err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Infof("Revoked %s!%s", args[0], args[1])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(proxmoxCmd)
+	proxmoxCmd.AddCommand(proxmoxUsersCmd)
+	proxmoxCmd.AddCommand(proxmoxTokensCmd)
+	proxmoxUsersCmd.AddCommand(proxmoxUsersCreateCmd)
+	proxmoxUsersCmd.AddCommand(proxmoxUsersDeleteCmd)
+	proxmoxTokensCmd.AddCommand(proxmoxTokensCreateCmd)
+	proxmoxTokensCmd.AddCommand(proxmoxTokensRotateCmd)
+	proxmoxTokensCmd.AddCommand(proxmoxTokensRevokeCmd)
+
+	proxmoxUsersCreateCmd.Flags().StringVar(&pveUser.Password, "password", "", "password, only for the pve realm")
+	proxmoxUsersCreateCmd.Flags().StringVar(&pveUser.Email, "email", "", "email")
+	proxmoxUsersCreateCmd.Flags().StringVar(&pveUser.Comment, "comment", "", "comment")
+	proxmoxUsersCreateCmd.Flags().StringSliceVar(&pveUser.Groups, "group", nil, "groups of the user")
+
+	proxmoxTokensCreateCmd.Flags().StringVar(&pveToken.Comment, "comment", "", "comment")
+	proxmoxTokensCreateCmd.Flags().BoolVar(&pveToken.Privsep, "privsep", true, "privilege separation, --privsep=false gives the token the permissions of the user")
+	proxmoxTokensCreateCmd.Flags().StringSliceVar(&pveACLs, "acl", nil, "role granted to the token, <path>=<role>")
+	proxmoxTokensCreateCmd.Flags().BoolVar(&pveNoPropagate, "no-propagate", false, "don't propagate the ACLs to the children of their path")
+	proxmoxTokensCreateCmd.Flags().DurationVar(&pveExpire, "expire", 0, "lifetime of the token, 2160h (default never expires)")
+
+	for _, cmd := range []*cobra.Command{proxmoxTokensCreateCmd, proxmoxTokensRotateCmd} {
+		cmd.Flags().StringVar(&pveOnePass, "1password", "", "store the secret in this 1Password vault instead of printing it")
+	}
+	for _, cmd := range []*cobra.Command{proxmoxUsersDeleteCmd, proxmoxTokensRevokeCmd} {
+		cmd.Flags().BoolVarP(&pveYes, "yes", "y", false, "don't ask for confirmation")
+	}
+}
+
+// accessCluster returns the cluster chosen with --cluster, users and tokens
+// belong to one cluster
+func accessCluster() *prxmx.Cluster {
+	conf := models.NewConfig()
+	if conf == nil {
+		os.Exit(123)
+	}
+	cluster, err := newClusters(conf).One("")
+	if err != nil {
+		log.Fatalf("%v, use --cluster", err)
+	}
+	return cluster
+}
+
+// printTokenSecret prints a new secret or stores it in the 1Password vault
+// of --1password
+func printTokenSecret(secret prxmx.TokenSecret) {
+	if pveOnePass != "" {
+		user, _, _ := strings.Cut(secret.FullTokenID, "!")
+		reference, err := models.WriteCredentialTo1Password(context.Background(), pveOnePass,
+			"Proxmox "+secret.FullTokenID, user, secret.Value)
+		if err != nil {
+			// the secret can't be read again, don't lose it
+			log.Errorf("Error writing to 1Password: %v", err)
+		} else {
+			log.Infof("Stored the secret of %s in %s", secret.FullTokenID, reference)
+			return
+		}
+	}
+	log.Warn("The secret is only shown once")
+	fmt.Printf("%s=%s\n", secret.FullTokenID, secret.Value)
+}
+
+func printUsersTable(users []prxmx.User) {
+	re := lipgloss.NewRenderer(os.Stdout)
+	baseStyle := re.NewStyle().Padding(0, 1)
+	headerStyle := baseStyle.Foreground(lipgloss.Color("252")).Bold(true)
+	rowStyle := baseStyle.Foreground(lipgloss.Color("250"))
+	disabledStyle := baseStyle.Foreground(lipgloss.Color("240"))
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row == 0 {
+				return headerStyle
+			}
+			if !users[row-1].Enable {
+				return disabledStyle
+			}
+			return rowStyle
+		}).
+		Headers("User", "Email", "Groups", "Tokens", "Expires", "Comment")
+
+	for _, u := range users {
+		tokens := make([]string, 0, len(u.Tokens))
+		for _, token := range u.Tokens {
+			tokens = append(tokens, token.TokenID)
+		}
+		t.Row(u.UserID, u.Email, strings.Join(u.Groups, ","), strings.Join(tokens, "\n"), expiry(u.Expire), u.Comment)
+	}
+	fmt.Println(t.Render())
+}
+
+func printTokensTable(tokens []prxmx.APIToken, acls map[string][]prxmx.ACL) {
+	re := lipgloss.NewRenderer(os.Stdout)
+	baseStyle := re.NewStyle().Padding(0, 1)
+	headerStyle := baseStyle.Foreground(lipgloss.Color("252")).Bold(true)
+	rowStyle := baseStyle.Foreground(lipgloss.Color("250"))
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row == 0 {
+				return headerStyle
+			}
+			return rowStyle
+		}).
+		Headers("Token", "Privsep", "ACLs", "Expires", "Comment")
+
+	for _, token := range tokens {
+		granted := "user permissions"
+		if token.Privsep {
+			lines := make([]string, 0, len(acls[token.TokenID]))
+			for _, acl := range acls[token.TokenID] {
+				lines = append(lines, acl.Path+"="+acl.Role)
+			}
+			granted = strings.Join(lines, "\n")
+		}
+		t.Row(token.TokenID, fmt.Sprint(token.Privsep), granted, expiry(token.Expire), token.Comment)
+	}
+	fmt.Println(t.Render())
+}
+
+func expiry(unix int64) string {
+	if unix == 0 {
+		return "never"
+	}
+	return time.Unix(unix, 0).Format(time.DateOnly)
+}
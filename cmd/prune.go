@@ -0,0 +1,269 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"i2/pkg/dckr"
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+	"i2/pkg/store"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/charmbracelet/log"
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pruneAllHosts      bool
+	pruneDryRun        bool
+	pruneYes           bool
+	pruneImageDays     int
+	pruneContainerDays int
+	pruneVolumes       bool
+	pruneProtected     []string
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune [vm]",
+	Short: "Remove unused Docker images, containers, volumes and networks",
+	Long: `Show the space used by dangling images, unused images, stopped containers
+and orphaned volumes of the local Docker, of a guest, or with --all-hosts of
+every running guest in the containers bucket, then remove them according to
+the prune policy of the config, after confirmation:
+
+prune:
+  image_days: 30       # unused images created 30 days ago
+  container_days: 7    # containers stopped 7 days ago
+  volumes: false       # orphaned volumes are only listed
+  protected_labels:    # resources with these labels are kept, key or key=value
+    - com.example.backup
+    - env=prod
+
+Dangling images and unused networks are always removed, resources labelled
+i2.protected are always kept. The flags override the policy.
+
+  i2 prune --all-hosts --dry-run
+  i2 prune vm1 --image-days 7 --volumes`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := models.NewConfig()
+		if conf == nil {
+			os.Exit(123)
+		}
+		if err := filter.Validate(); err != nil {
+			log.Fatalf("%v", err)
+		}
+		policy := conf.Prune
+		if cmd.Flags().Changed("image-days") {
+			policy.ImageDays = pruneImageDays
+		}
+		if cmd.Flags().Changed("container-days") {
+			policy.ContainerDays = pruneContainerDays
+		}
+		if cmd.Flags().Changed("volumes") {
+			policy.Volumes = pruneVolumes
+		}
+		policy.ProtectedLabels = append(policy.ProtectedLabels, pruneProtected...)
+		ctx := context.Background()
+
+		var inventory *prxmx.Inventory
+		if pruneAllHosts || len(args) == 1 {
+			st, err := store.NewStore(ctx, &conf.Nats)
+			if err != nil {
+				log.Fatalf("Error creating store: %v", err)
+			}
+			defer st.Close()
+			inventory = prxmx.NewInventory(st)
+		}
+		hosts, err := dockerHosts(ctx, inventory, conf.SSH.User, pruneAllHosts, args)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer closeDockerHosts(hosts)
+
+		plans := planHosts(ctx, hosts, policy)
+		pending := 0
+		var reclaimable int64
+		for i, h := range hosts {
+			if p := plans[i]; p != nil {
+				printPrunePlan(h.name, *p)
+				pending += len(p.Pending())
+				for _, size := range p.Reclaimable() {
+					reclaimable += size
+				}
+			}
+		}
+		printPruneSummary(hosts, plans)
+
+		if pending == 0 {
+			log.Info("Nothing to prune")
+			return
+		}
+		question := fmt.Sprintf("Remove %d resources, reclaiming %s?", pending, units.HumanSize(float64(reclaimable)))
+		if pruneDryRun || (!pruneYes && !confirm(question)) {
+			return
+		}
+		var reclaimed int64
+		for i, h := range hosts {
+			p := plans[i]
+			if p == nil || len(p.Pending()) == 0 {
+				continue
+			}
+			reclaimed += h.dc.Prune(ctx, *p, func(item dckr.PruneItem, err error) {
+				if err != nil {
+					log.Warnf("%s: error removing %s %s: %v", h.name, item.Kind, item.Name, err)
+					return
+				}
+				log.Infof("%s: removed %s %s", h.name, item.Kind, item.Name)
+			})
+			if h.vm != nil {
+				if err := h.dc.RefreshContainers(ctx, inventory, *h.vm); err != nil {
+					log.Warnf("Error refreshing the containers of %s: %v", h.name, err)
+				}
+			}
+		}
+		log.Infof("Reclaimed %s", units.HumanSize(float64(reclaimed)))
+	},
+}
+
+// planHosts plans the prune of every host concurrently, the plans are in the
+// order of the hosts. The hosts failing are logged and have no plan.
+func planHosts(ctx context.Context, hosts []dockerHost, policy models.Prune) []*dckr.PrunePlan {
+	type result struct {
+		i    int
+		plan dckr.PrunePlan
+		err  error
+	}
+	results := make(chan result, len(hosts))
+	now := time.Now()
+	for i, h := range hosts {
+		go func() {
+			plan, err := h.dc.PrunePlan(ctx, policy, now)
+			results <- result{i: i, plan: plan, err: err}
+		}()
+	}
+	plans := make([]*dckr.PrunePlan, len(hosts))
+	for range hosts {
+		r := <-results
+		if r.err != nil {
+			log.Errorf("Error planning the prune of %s: %v", hosts[r.i].name, r.err)
+			continue
+		}
+		plans[r.i] = &r.plan
+	}
+	return plans
+}
+
+// printPrunePlan prints the resources of a host the policy removes, and the
+// ones it keeps greyed out
+func printPrunePlan(host string, plan dckr.PrunePlan) {
+	removeStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F87"))
+	sleepingStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
+
+	if len(plan.Items) == 0 {
+		return
+	}
+	fmt.Println(lipgloss.NewStyle().Bold(true).Render(host))
+	for _, item := range plan.Items {
+		line := fmt.Sprintf("%s %s (%s", item.Kind, item.Name, item.Reason)
+		if item.Kind != dckr.PruneNetwork {
+			line += ", " + pruneSize(item.Size)
+		}
+		line += ")"
+		if item.Keep != "" {
+			fmt.Println(sleepingStyle.Render("  = " + line + ", " + item.Keep))
+			continue
+		}
+		fmt.Println(removeStyle.Render("  - " + line))
+	}
+}
+
+// printPruneSummary prints the space reclaimed on every host by kind
+func printPruneSummary(hosts []dockerHost, plans []*dckr.PrunePlan) {
+	re := lipgloss.NewRenderer(os.Stdout)
+	baseStyle := re.NewStyle().Padding(0, 1)
+	headerStyle := baseStyle.Foreground(lipgloss.Color("252")).Bold(true)
+	rowStyle := baseStyle.Foreground(lipgloss.Color("250"))
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row == 0 {
+				return headerStyle
+			}
+			return rowStyle
+		}).
+		Headers("VM", "Containers", "Images", "Volumes", "Networks", "Reclaimable")
+
+	for i, h := range hosts {
+		plan := plans[i]
+		if plan == nil {
+			continue
+		}
+		counts := map[string]int{}
+		for _, item := range plan.Pending() {
+			counts[item.Kind]++
+		}
+		sizes := plan.Reclaimable()
+		var total int64
+		row := []string{h.name}
+		for _, kind := range []string{dckr.PruneContainer, dckr.PruneImage, dckr.PruneVolume, dckr.PruneNetwork} {
+			total += sizes[kind]
+			cell := fmt.Sprint(counts[kind])
+			if kind != dckr.PruneNetwork && counts[kind] > 0 {
+				cell += " (" + units.HumanSize(float64(sizes[kind])) + ")"
+			}
+			row = append(row, cell)
+		}
+		t.Row(append(row, units.HumanSize(float64(total)))...)
+	}
+	fmt.Println(t.Render())
+}
+
+// pruneSize returns a size for humans, Docker reports -1 when it's unknown
+func pruneSize(size int64) string {
+	if size < 0 {
+		return "size unknown"
+	}
+	return units.HumanSize(float64(size))
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+
+	pruneCmd.Flags().BoolVar(&pruneAllHosts, "all-hosts", false, "prune every running guest in the containers bucket")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "only show what would be removed")
+	pruneCmd.Flags().BoolVarP(&pruneYes, "yes", "y", false, "prune without asking")
+	pruneCmd.Flags().IntVar(&pruneImageDays, "image-days", models.DefaultPruneImageDays, "remove the unused images created this many days ago")
+	pruneCmd.Flags().IntVar(&pruneContainerDays, "container-days", models.DefaultPruneContainerDays, "remove the containers stopped this many days ago")
+	pruneCmd.Flags().BoolVar(&pruneVolumes, "volumes", false, "remove the orphaned volumes")
+	pruneCmd.Flags().StringArrayVar(&pruneProtected, "protect", nil, "keep the resources with this label, key or key=value")
+	addFilterFlags(pruneCmd)
+}
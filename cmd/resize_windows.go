@@ -19,38 +19,31 @@ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
 OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 THE SOFTWARE.
 */
-package app
+package cmd
 
 import (
-	"i2/pkg/remote"
-
-	"github.com/charmbracelet/log"
-	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
+	"os"
+	"time"
 )
 
-// appCmd represents the app command
-var ReloadAppCmd = &cobra.Command{
-	Use:   "reload",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		log.Info("reload called")
-		user := viper.GetString("ssh.user")
-
-		res, err := remote.ExecuteSSHCommand(user, args[0], "docker compose pull && docker compose down && docker compose up -d", viper.GetString("ssh.private_key_file"))
-		if err != nil {
-			log.Error("Error executing command:", err)
-			return
+// notifyResize sends to ch every second, Windows has no SIGWINCH so the
+// size of the terminal is polled
+func notifyResize(ch chan<- os.Signal) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				select {
+				case ch <- nil:
+				default:
+				}
+			}
 		}
-		log.Info("Command output:", res)
-	},
-}
-
-func init() {
+	}()
+	return func() { close(done) }
 }
@@ -31,7 +31,10 @@ import (
 	"github.com/spf13/viper"
 )
 
-var cfgFile string
+var (
+	cfgFile     string
+	clusterName string
+)
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -68,6 +71,7 @@ func init() {
 	// will be global for your application.
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.i2.yaml)")
+	rootCmd.PersistentFlags().StringVar(&clusterName, "cluster", "", "only use this Proxmox cluster (default is every cluster)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
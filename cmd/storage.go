@@ -0,0 +1,193 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+	"i2/pkg/store"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/charmbracelet/log"
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+)
+
+var (
+	storageContent bool
+	storageSync    bool
+)
+
+// storageCmd represents the storage command
+var storageCmd = &cobra.Command{
+	Use:   "storage",
+	Short: "List the storage of your Proxmox nodes",
+	Long: `List every storage of every Proxmox node with its type, content types
+and usage. Storages above 85% are highlighted. Use --content to also list
+the ISOs, container templates and backups stored in them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := models.NewConfig()
+		if conf == nil {
+			os.Exit(123)
+		}
+		ctx := context.Background()
+		clusters := newClusters(conf)
+
+		st, err := store.NewStore(ctx, &conf.Nats)
+		if err != nil || st == nil {
+			log.Errorf("Error creating store: %v", err)
+			return
+		}
+		defer st.Close()
+		inventory := prxmx.NewInventory(st)
+
+		var storages []prxmx.Storage
+		if !storageSync {
+			storages, _ = inventory.ListStorage(ctx)
+			storages = slices.DeleteFunc(storages, func(s prxmx.Storage) bool {
+				return !slices.Contains(clusters.Names(), s.Cluster)
+			})
+		}
+		if len(storages) == 0 {
+			log.Info("Fetching storage from Proxmox")
+			storages, err = clusters.GetStorage()
+			if err != nil {
+				log.Warnf("Error getting storage, results may be partial: %v", err)
+			}
+			warnNearlyFull(storages)
+			if storageSync || conf.Sync.Enabled {
+				log.Info("Syncing storage with NATS", inventory.StorageBucket)
+				if err := inventory.SaveStorage(ctx, storages); err != nil {
+					log.Errorf("Error syncing storage: %v", err)
+				}
+			}
+			if storageSync {
+				return
+			}
+		} else {
+			log.Infof("Reading %d storages from NATS", len(storages))
+		}
+
+		printStorageTable(storages)
+		if storageContent {
+			printVolumesTable(storages)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(storageCmd)
+
+	storageCmd.Flags().BoolVarP(&storageSync, "sync", "s", false, "Sync storage with NATS")
+	storageCmd.Flags().BoolVarP(&storageContent, "content", "c", false, "List the ISOs, templates and backups")
+}
+
+func warnNearlyFull(storages []prxmx.Storage) {
+	for _, s := range storages {
+		if s.NearlyFull() {
+			log.Warnf("Storage %s on %s is %.0f%% full (%s free)", s.Name, s.Node, s.UsedFraction()*100, units.BytesSize(float64(s.Avail)))
+		}
+	}
+}
+
+func printStorageTable(storages []prxmx.Storage) {
+	re := lipgloss.NewRenderer(os.Stdout)
+	baseStyle := re.NewStyle().Padding(0, 1)
+	headerStyle := baseStyle.Foreground(lipgloss.Color("252")).Bold(true)
+	rowStyle := baseStyle.Foreground(lipgloss.Color("250"))
+	fullStyle := baseStyle.Foreground(lipgloss.Color("#FF5F87")).Bold(true)
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row == 0 {
+				return headerStyle
+			}
+			if storages[row-1].NearlyFull() {
+				return fullStyle
+			}
+			return rowStyle
+		}).
+		Headers("Cluster", "Node", "Storage", "Type", "Content", "Used", "Total", "Avail", "Usage")
+
+	for _, s := range storages {
+		usage := fmt.Sprintf("%.0f%%", s.UsedFraction()*100)
+		if !s.Active {
+			usage = "inactive"
+		}
+		t.Row(s.Cluster, s.Node, s.Name, s.Type, strings.Join(s.Content, ","),
+			units.BytesSize(float64(s.Used)), units.BytesSize(float64(s.Total)), units.BytesSize(float64(s.Avail)), usage)
+	}
+	fmt.Println(t.Render())
+}
+
+func printVolumesTable(storages []prxmx.Storage) {
+	re := lipgloss.NewRenderer(os.Stdout)
+	baseStyle := re.NewStyle().Padding(0, 1)
+	headerStyle := baseStyle.Foreground(lipgloss.Color("252")).Bold(true)
+	rowStyle := baseStyle.Foreground(lipgloss.Color("250"))
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row == 0 {
+				return headerStyle
+			}
+			return rowStyle
+		}).
+		Headers("Cluster", "Node", "Storage", "Content", "Name", "Size", "Created")
+
+	// shared storages are listed once per node, show their volumes once
+	seen := map[string]bool{}
+	for _, s := range storages {
+		if s.Shared {
+			if seen[s.Cluster+"/"+s.Name] {
+				continue
+			}
+			seen[s.Cluster+"/"+s.Name] = true
+		}
+		groups := []struct {
+			content string
+			volumes []prxmx.Volume
+		}{{"iso", s.ISOs}, {"vztmpl", s.Templates}, {"backup", s.Backups}}
+		for _, group := range groups {
+			for _, v := range group.volumes {
+				created := ""
+				if !v.Created.IsZero() {
+					created = v.Created.Format(time.DateTime)
+				}
+				t.Row(s.Cluster, s.Node, s.Name, group.content, v.Name, units.BytesSize(float64(v.Size)), created)
+			}
+		}
+	}
+	fmt.Println(t.Render())
+}
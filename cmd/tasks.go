@@ -0,0 +1,170 @@
+/*
+Copyright © 2024 Ivan Pedrazas <ipedrazas@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+var runningTasks bool
+
+// tasksCmd represents the tasks command
+var tasksCmd = &cobra.Command{
+	Use:   "tasks",
+	Short: "List and follow Proxmox tasks",
+	Long: `Every mutating Proxmox call (start, migrate, clone...) runs as a task
+identified by a UPID. Use list to see the recent tasks of the clusters and
+watch to follow the log of a task until it finishes.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var tasksListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the recent Proxmox tasks",
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := models.NewConfig()
+		if conf == nil {
+			os.Exit(123)
+		}
+		tasks, err := newClusters(conf).Tasks(context.Background())
+		if err != nil && len(tasks) == 0 {
+			log.Fatalf("Error getting tasks: %v", err)
+		}
+		if err != nil {
+			log.Warnf("Error getting tasks, results may be partial: %v", err)
+		}
+		if runningTasks {
+			running := []prxmx.TaskInfo{}
+			for _, task := range tasks {
+				if task.Running {
+					running = append(running, task)
+				}
+			}
+			tasks = running
+		}
+		printTasks(tasks)
+	},
+}
+
+var tasksWatchCmd = &cobra.Command{
+	Use:   "watch <upid>",
+	Short: "Follow the log of a Proxmox task until it finishes",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := models.NewConfig()
+		if conf == nil {
+			os.Exit(123)
+		}
+		ctx := context.Background()
+		cluster, err := newClusters(conf).ForTask(ctx, args[0])
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if err := watchTask(ctx, cluster, args[0]); err != nil {
+			log.Fatalf("%v", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tasksCmd)
+	tasksCmd.AddCommand(tasksListCmd)
+	tasksCmd.AddCommand(tasksWatchCmd)
+
+	tasksListCmd.Flags().BoolVarP(&runningTasks, "running", "r", false, "only show running tasks")
+}
+
+// watchTask prints the log of a task until it finishes
+func watchTask(ctx context.Context, cluster *prxmx.Cluster, upid string) error {
+	return printTask(func(lines chan<- string) (prxmx.TaskInfo, error) {
+		info, err := cluster.WatchTask(ctx, upid, lines)
+		if err != nil && !errors.Is(err, prxmx.ErrTaskFailed) {
+			err = fmt.Errorf("error watching task: %w", err)
+		}
+		return info, err
+	})
+}
+
+// printTask prints the log lines follow sends until the task stops
+func printTask(follow func(lines chan<- string) (prxmx.TaskInfo, error)) error {
+	lines := make(chan string)
+	done := make(chan struct{})
+	go func() {
+		for line := range lines {
+			fmt.Println(line)
+		}
+		close(done)
+	}()
+	info, err := follow(lines)
+	close(lines)
+	<-done
+
+	if errors.Is(err, prxmx.ErrTaskFailed) {
+		return fmt.Errorf("task %s on %s failed: %s", info.Type, info.Node, info.ExitStatus)
+	}
+	if err != nil {
+		return err
+	}
+	log.Infof("Task %s on %s finished: %s", info.Type, info.Node, info.ExitStatus)
+	return nil
+}
+
+func printTasks(tasks []prxmx.TaskInfo) {
+	re := lipgloss.NewRenderer(os.Stdout)
+	baseStyle := re.NewStyle().Padding(0, 1)
+	headerStyle := baseStyle.Foreground(lipgloss.Color("252")).Bold(true)
+	rowStyle := baseStyle.Foreground(lipgloss.Color("250"))
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row == 0 {
+				return headerStyle
+			}
+			return rowStyle
+		}).
+		Headers("Started", "Cluster", "Node", "Type", "ID", "User", "Status", "UPID")
+
+	for _, task := range tasks {
+		status := task.ExitStatus
+		if task.Running {
+			status = "running"
+		}
+		t.Row(task.StartTime.Format(time.DateTime), task.Cluster, task.Node, task.Type, task.ID, task.User, status, task.UPID)
+	}
+	fmt.Println(t.Render())
+}
@@ -23,7 +23,6 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 
 	"i2/pkg/models"
@@ -32,6 +31,7 @@ import (
 	"i2/pkg/utils"
 
 	"os"
+	"slices"
 	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -42,13 +42,13 @@ import (
 	"github.com/charmbracelet/lipgloss/table"
 	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 	"golang.design/x/clipboard"
 )
 
 var (
 	asTable  bool
-	cluster  *prxmx.Cluster
+	clusters prxmx.Clusters
+	filter   prxmx.Filter
 	selected string
 	sync     bool
 	vms      []prxmx.Node
@@ -113,7 +113,7 @@ func (m spinnerModel) View() string {
 // vmsCmd represents the vms command
 var vmsCmd = &cobra.Command{
 	Use:   "vms",
-	Short: "List all your ProxMox VMs",
+	Short: "List all your ProxMox VMs and LXC containers",
 	Long: `A longer description that spans multiple lines and likely contains examples
 and usage of using your command. For example:
 
@@ -128,7 +128,10 @@ to quickly create a Cobra application.`,
 		bucketVMS = conf.Nats.Bucket + "-vms"
 		bucketContainers = conf.Nats.Bucket + "-containers"
 
-		cluster = prxmx.NewCluster(conf.Proxmox.URL, conf.Proxmox.User, conf.Proxmox.Pass)
+		clusters = newClusters(conf)
+		if err := filter.Validate(); err != nil {
+			log.Fatalf("%v", err)
+		}
 		ctx := context.Background()
 
 		st, err := store.NewStore(ctx, &conf.Nats)
@@ -137,27 +140,29 @@ to quickly create a Cobra application.`,
 			return
 		}
 		defer st.Close()
+		inventory := prxmx.NewInventory(st)
 
 		if sync {
 			log.Info("Fetching VMs from Proxmox")
-			vms, err = cluster.GetVMs()
+			vms, err = clusters.GetVMs()
 			if err != nil {
-				log.Errorf("Error getting VMs: %v", err)
+				log.Warnf("Error getting VMs, results may be partial: %v", err)
 			}
-			err = saveVMSToNATS(vms, conf)
+			err = saveVMSToNATS(ctx, vms, inventory)
 			if err != nil {
 				log.Errorf("Error syncing VMs: %v", err)
 			}
 			return
 		}
-		keys, _ := store.GetKeys(ctx, bucketVMS, st.NatsConn)
+		cached, _ := inventory.List(ctx)
+		cached = inClusters(cached, clusters)
 
-		if len(keys) == 0 {
+		if len(cached) == 0 {
 			log.Info("Fetching VMs from Proxmox")
 			go func() {
-				vms, err = cluster.GetVMs()
+				vms, err = clusters.GetVMs()
 				if err != nil {
-					log.Errorf("Error getting VMs: %v", err)
+					log.Warnf("Error getting VMs, results may be partial: %v", err)
 				}
 			}()
 
@@ -167,27 +172,17 @@ to quickly create a Cobra application.`,
 			}
 
 			if conf.Sync.Enabled {
-				err = saveVMSToNATS(vms, conf)
+				err = saveVMSToNATS(ctx, vms, inventory)
 				if err != nil {
 					log.Errorf("Error syncing VMs: %v", err)
 				}
 			}
 		} else {
-			log.Infof("Reading from NATS: %v", keys)
-			for _, key := range keys {
-				jvm, err := store.GetKV(ctx, key, bucketVMS, st.NatsConn)
-				if err != nil {
-					log.Errorf("Error getting VM: %v", err)
-				}
-				vm := prxmx.Node{}
-				err = json.Unmarshal(jvm, &vm)
-				if err != nil {
-					log.Errorf("Error unmarshalling VM: %v", err)
-				}
-				vms = append(vms, vm)
-			}
+			log.Infof("Reading %d guests from NATS", len(cached))
+			vms = cached
 		}
 
+		vms = filter.Apply(vms)
 		if asTable {
 			printVMsTable(vms)
 		} else {
@@ -212,24 +207,49 @@ func init() {
 
 	vmsCmd.Flags().BoolVarP(&asTable, "table", "t", false, "Return a table")
 	vmsCmd.Flags().BoolVarP(&sync, "sync", "s", false, "Sync VMs with NATS")
+	addFilterFlags(vmsCmd)
 }
 
-func saveVMSToNATS(vms []prxmx.Node, conf *models.Config) error {
-	log.Info("Syncing VMs with NATS", bucketVMS)
-
-	ctx := context.Background()
-	st, err := store.NewStore(ctx, &conf.Nats)
+// newClusters returns the Proxmox clusters from the config, only the one
+// chosen with --cluster when it's set
+func newClusters(conf *models.Config) prxmx.Clusters {
+	all, err := prxmx.NewClusters(conf)
 	if err != nil {
-		return err
+		log.Fatalf("Error reading the Proxmox clusters: %v", err)
+	}
+	selected, err := all.Select(clusterName)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	return selected
+}
+
+// addFilterFlags adds the guest filter flags to a command
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&filter.Tags, "tag", nil, "only guests with this tag (repeat to require several)")
+	cmd.Flags().StringVar(&filter.Node, "node", "", "only guests on this Proxmox node")
+	cmd.Flags().StringVar(&filter.Status, "status", "", "only running or stopped guests")
+	cmd.Flags().StringVar(&filter.Name, "name", "", "only guests matching this glob, web-*")
+}
+
+// inClusters returns the guests belonging to the clusters. Legacy entries
+// have no cluster and are only kept when every cluster is selected.
+func inClusters(vms []prxmx.Node, clusters prxmx.Clusters) []prxmx.Node {
+	if clusterName == "" {
+		return vms
 	}
-	defer st.Close()
+	selected := []prxmx.Node{}
 	for _, vm := range vms {
-		err = store.SetKV(ctx, vm.Name, bucketVMS, vm.ToBytes(), st.NatsConn)
-		if err != nil {
-			return err
+		if slices.Contains(clusters.Names(), vm.Cluster) {
+			selected = append(selected, vm)
 		}
 	}
-	return nil
+	return selected
+}
+
+func saveVMSToNATS(ctx context.Context, vms []prxmx.Node, inventory *prxmx.Inventory) error {
+	log.Info("Syncing VMs with NATS", inventory.Bucket)
+	return inventory.Save(ctx, vms)
 }
 
 func runTeaVMsList(vms []prxmx.Node) {
@@ -239,7 +259,7 @@ func runTeaVMsList(vms []prxmx.Node) {
 	for _, vm := range vms {
 		if vm.Running {
 			running++
-			items = append(items, item{title: "🖥️    " + vm.Name + "   " + utils.GetLocalIP(vm.IP), description: "     " + vm.Uptime.ToStringShort()})
+			items = append(items, item{title: guestIcon(vm) + "    " + vm.Name + "   " + utils.GetLocalIP(vm.IP), description: "     " + vm.Cluster + " - " + guestType(vm) + " - " + vm.Uptime.ToStringShort()})
 		}
 	}
 	m := model{list: list.New(items, list.NewDefaultDelegate(), 0, 0)}
@@ -324,14 +344,14 @@ func printVMsTable(vms []prxmx.Node) {
 				return oddRowStyle
 			}
 		}).
-		Headers("Name", "IP", "Uptime")
+		Headers("Name", "Cluster", "VMID", "Type", "Host", "IP", "Uptime")
 
 	for _, vm := range vms {
 		if vm.Running {
 			running++
-			t.Row("🖥️  "+vm.Name, " "+utils.GetLocalIP(vm.IP), vm.Uptime.ToStringShort())
+			t.Row(guestIcon(vm)+"  "+vm.Name, vm.Cluster, guestID(vm), guestType(vm), vm.Host, " "+utils.GetLocalIP(vm.IP), vm.Uptime.ToStringShort())
 		} else {
-			t.Row("💤  "+sleepingStyle.Render(vm.Name), " ", vm.Uptime.ToStringShort())
+			t.Row("💤  "+sleepingStyle.Render(vm.Name), vm.Cluster, guestID(vm), guestType(vm), vm.Host, " ", vm.Uptime.ToStringShort())
 		}
 
 	}
@@ -367,12 +387,12 @@ func printVMsTable(vms []prxmx.Node) {
 	w := lipgloss.Width
 
 	statusKey := statusStyle.Render("ProxMox")
-	total := encodingStyle.Render(fmt.Sprintf("Total VMs: %d", len(vms)))
-	totalRunning := fishCakeStyle.Render(fmt.Sprintf("Running VMs: %d", running))
+	total := encodingStyle.Render(fmt.Sprintf("Total Guests: %d", len(vms)))
+	totalRunning := fishCakeStyle.Render(fmt.Sprintf("Running: %d", running))
 
 	statusVal := statusText.
 		Width(width - w(statusKey) - w(total) - w(totalRunning)).
-		Render("💻  - " + viper.GetString("proxmox.url"))
+		Render("💻  - " + strings.Join(clusters.Names(), ", "))
 
 	bar := lipgloss.JoinHorizontal(lipgloss.Top,
 		statusKey,
@@ -387,3 +407,24 @@ func printVMsTable(vms []prxmx.Node) {
 	fmt.Println(docStyle.Render(doc.String()))
 
 }
+
+func guestIcon(vm prxmx.Node) string {
+	if vm.IsContainer() {
+		return "📦"
+	}
+	return "🖥️"
+}
+
+func guestID(vm prxmx.Node) string {
+	if vm.VMID == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d", vm.VMID)
+}
+
+func guestType(vm prxmx.Node) string {
+	if vm.IsContainer() {
+		return "lxc"
+	}
+	return "vm"
+}
@@ -44,6 +44,9 @@ func RunServer(conf *models.Config) {
 	}
 
 	log.Infof("Starting server on %s", addr)
+	if conf.Api.Token == "" {
+		log.Warn("api.token isn't set, the routes changing something are disabled")
+	}
 
 	go func() {
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// TicketTTL is how long a websocket ticket can be used to open a connection
+const TicketTTL = 30 * time.Second
+
+// Ticket is a short-lived credential for the websockets, browsers can't set
+// an Authorization header on them
+type Ticket struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Authenticate refuses the requests changing something and the websockets,
+// like the consoles, without the API token in an Authorization: Bearer
+// header. Websockets also take a ticket from /auth/tickets in ?ticket=.
+// Without a token in the config these routes are disabled, reading routes
+// stay open.
+func Authenticate(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		upgrade := websocket.IsWebSocketUpgrade(c.Request)
+		if !upgrade && !mutating(c.Request) {
+			c.Next()
+			return
+		}
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "api.token isn't set, the routes changing something are disabled"})
+			return
+		}
+		bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1 {
+			c.Next()
+			return
+		}
+		if upgrade && c.Query("ticket") != "" && validTicket(token, c.Query("ticket"), time.Now()) {
+			c.Next()
+			return
+		}
+		c.Header("WWW-Authenticate", "Bearer")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or missing API token"})
+	}
+}
+
+// IssueTicket godoc
+// @Summary Issue a websocket ticket
+// @Description Return a ticket opening websockets, such as the consoles, for
+// @Description 30 seconds with ?ticket=<ticket>. It is signed with the API
+// @Description token, which browsers can't send on a websocket.
+// @Tags auth
+// @Produce json
+// @Success 201 {object} Ticket
+// @Failure 401 {object} interface{}
+// @Router /auth/tickets [post]
+func IssueTicket(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		expires := time.Now().Add(TicketTTL).Truncate(time.Second)
+		c.JSON(http.StatusCreated, Ticket{Ticket: signTicket(token, expires), ExpiresAt: expires})
+	}
+}
+
+// signTicket returns <unix expiry>.<HMAC-SHA256 of the expiry>
+func signTicket(token string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(exp))
+	return exp + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validTicket checks the signature of a ticket and that it hasn't expired
+func validTicket(token, ticket string, now time.Time) bool {
+	exp, _, ok := strings.Cut(ticket, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.After(time.Unix(unix, 0)) {
+		return false
+	}
+	return hmac.Equal([]byte(ticket), []byte(signTicket(token, time.Unix(unix, 0))))
+}
+
+// mutating returns whether a request can change something
+func mutating(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
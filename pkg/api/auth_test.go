@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := func(token string) *gin.Engine {
+		r := gin.New()
+		api := r.Group("/api/v1", Authenticate(token))
+		api.GET("/vms", func(c *gin.Context) { c.Status(http.StatusOK) })
+		api.DELETE("/vms/:name", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+		api.GET("/vms/:name/console", func(c *gin.Context) { c.Status(http.StatusSwitchingProtocols) })
+		return r
+	}
+	for _, tc := range []struct {
+		name, token, method, path, auth string
+		want                            int
+	}{
+		{"reads are open", "secret", http.MethodGet, "/api/v1/vms", "", http.StatusOK},
+		{"writes need the token", "secret", http.MethodDelete, "/api/v1/vms/web", "", http.StatusUnauthorized},
+		{"wrong token", "secret", http.MethodDelete, "/api/v1/vms/web", "Bearer nope", http.StatusUnauthorized},
+		{"not a bearer token", "secret", http.MethodDelete, "/api/v1/vms/web", "secret", http.StatusUnauthorized},
+		{"token", "secret", http.MethodDelete, "/api/v1/vms/web", "Bearer secret", http.StatusNoContent},
+		{"no token in the config", "", http.MethodDelete, "/api/v1/vms/web", "Bearer ", http.StatusForbidden},
+		{"websockets need the token", "secret", http.MethodGet, "/api/v1/vms/web/console", "", http.StatusUnauthorized},
+		{"websocket with the token", "secret", http.MethodGet, "/api/v1/vms/web/console", "Bearer secret", http.StatusSwitchingProtocols},
+		{"websocket with a ticket", "secret", http.MethodGet, "/api/v1/vms/web/console?ticket=" + signTicket("secret", time.Now().Add(TicketTTL)), "", http.StatusSwitchingProtocols},
+		{"expired ticket", "secret", http.MethodGet, "/api/v1/vms/web/console?ticket=" + signTicket("secret", time.Now().Add(-time.Second)), "", http.StatusUnauthorized},
+		{"ticket of another token", "secret", http.MethodGet, "/api/v1/vms/web/console?ticket=" + signTicket("other", time.Now().Add(TicketTTL)), "", http.StatusUnauthorized},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		if strings.HasSuffix(r.URL.Path, "/console") {
+			r.Header.Set("Connection", "Upgrade")
+			r.Header.Set("Upgrade", "websocket")
+		}
+		if tc.auth != "" {
+			r.Header.Set("Authorization", tc.auth)
+		}
+		router(tc.token).ServeHTTP(w, r)
+		assert.Equal(t, tc.want, w.Code, tc.name)
+	}
+}
+
+func TestIssueTicket(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/auth/tickets", IssueTicket("secret"))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/tickets", nil))
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var ticket Ticket
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &ticket))
+	assert.True(t, validTicket("secret", ticket.Ticket, time.Now()))
+	assert.False(t, validTicket("secret", ticket.Ticket, ticket.ExpiresAt.Add(time.Second)))
+	assert.False(t, validTicket("secret", "1.0", time.Unix(0, 0)))
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"i2/pkg/dckr"
 	"i2/pkg/dns"
 	"i2/pkg/models"
 	"i2/pkg/prxmx"
@@ -20,8 +21,10 @@ func AddRoutes(router *gin.Engine, config *models.Config) {
 
 	// Define routes
 
-	api := router.Group("/api/v1")
+	api := router.Group("/api/v1", Authenticate(config.Api.Token))
 	api.GET("/", info)
+	api.POST("/auth/tickets", IssueTicket(config.Api.Token))
 	dns.AddRoutes(api, config)
 	prxmx.AddRoutes(api, config)
+	dckr.AddRoutes(api, config)
 }
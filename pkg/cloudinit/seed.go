@@ -0,0 +1,175 @@
+package cloudinit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+)
+
+// VolumeLabel is the label cloud-init looks for to find a NoCloud seed
+const VolumeLabel = "cidata"
+
+// Data is what the templates are rendered with. An empty IP configures every
+// ethernet interface with DHCP.
+type Data struct {
+	Hostname    string
+	InstanceID  string
+	User        string
+	SSHKeys     []string
+	IP          string
+	Gateway     string
+	Nameservers []string
+	Vars        map[string]string
+}
+
+// Templates are the text/template sources of the seed files, the defaults
+// are used for the empty ones
+type Templates struct {
+	UserData      string
+	MetaData      string
+	NetworkConfig string
+}
+
+// Seed is the content of a NoCloud seed
+type Seed struct {
+	UserData      []byte
+	MetaData      []byte
+	NetworkConfig []byte
+}
+
+// DefaultUserData creates the user with sudo and the SSH keys
+const DefaultUserData = `#cloud-config
+hostname: {{ .Hostname }}
+{{- if .User }}
+users:
+  - name: {{ .User }}
+    groups: [sudo]
+    shell: /bin/bash
+    sudo: "ALL=(ALL) NOPASSWD:ALL"
+    {{- if .SSHKeys }}
+    ssh_authorized_keys:
+    {{- range .SSHKeys }}
+      - {{ quote . }}
+    {{- end }}
+    {{- end }}
+{{- else if .SSHKeys }}
+ssh_authorized_keys:
+{{- range .SSHKeys }}
+  - {{ quote . }}
+{{- end }}
+{{- end }}
+`
+
+// DefaultMetaData sets the instance id and the hostname
+const DefaultMetaData = `instance-id: {{ .InstanceID }}
+local-hostname: {{ .Hostname }}
+`
+
+// DefaultNetworkConfig is a netplan v2 config, DHCP unless an IP is set
+const DefaultNetworkConfig = `version: 2
+ethernets:
+  default:
+    match:
+      name: "e*"
+    {{- if .IP }}
+    addresses: [{{ .IP }}]
+    {{- if .Gateway }}
+    routes:
+      - to: default
+        via: {{ .Gateway }}
+    {{- end }}
+    {{- if .Nameservers }}
+    nameservers:
+      addresses: [{{ join .Nameservers ", " }}]
+    {{- end }}
+    {{- else }}
+    dhcp4: true
+    {{- end }}
+`
+
+var funcs = template.FuncMap{
+	"quote": strconv.Quote,
+	"join":  strings.Join,
+}
+
+// Render renders the seed files. The instance id defaults to the hostname.
+func Render(data Data, templates Templates) (Seed, error) {
+	if data.Hostname == "" {
+		return Seed{}, errors.New("the seed needs a hostname")
+	}
+	if data.InstanceID == "" {
+		data.InstanceID = data.Hostname
+	}
+	var seed Seed
+	var err error
+	for _, file := range []struct {
+		name, source, fallback string
+		out                    *[]byte
+	}{
+		{"user-data", templates.UserData, DefaultUserData, &seed.UserData},
+		{"meta-data", templates.MetaData, DefaultMetaData, &seed.MetaData},
+		{"network-config", templates.NetworkConfig, DefaultNetworkConfig, &seed.NetworkConfig},
+	} {
+		source := file.source
+		if source == "" {
+			source = file.fallback
+		}
+		if *file.out, err = render(file.name, source, data); err != nil {
+			return seed, err
+		}
+	}
+	return seed, nil
+}
+
+func render(name, source string, data Data) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the %s template: %w", name, err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("error rendering %s: %w", name, err)
+	}
+	return b.Bytes(), nil
+}
+
+// WriteISO writes the seed to an ISO 9660 image labelled cidata, replacing
+// the file if it exists
+func (s Seed) WriteISO(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fs, err := iso9660.Create(f, 0, 0, 2048, "")
+	if err != nil {
+		return fmt.Errorf("error creating the ISO: %w", err)
+	}
+	for name, content := range map[string][]byte{
+		"/user-data":      s.UserData,
+		"/meta-data":      s.MetaData,
+		"/network-config": s.NetworkConfig,
+	} {
+		file, err := fs.OpenFile(name, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			return fmt.Errorf("error adding %s to the ISO: %w", name, err)
+		}
+		if _, err := file.Write(content); err != nil {
+			return fmt.Errorf("error adding %s to the ISO: %w", name, err)
+		}
+	}
+	if err := fs.Finalize(iso9660.FinalizeOptions{RockRidge: true, VolumeIdentifier: VolumeLabel}); err != nil {
+		return fmt.Errorf("error writing the ISO: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,113 @@
+package cloudinit
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRenderDefaults(t *testing.T) {
+	seed, err := Render(Data{
+		Hostname: "web",
+		User:     "ops",
+		SSHKeys:  []string{"ssh-ed25519 AAAA ops@laptop"},
+	}, Templates{})
+	require.NoError(t, err)
+
+	assert.Contains(t, string(seed.UserData), "#cloud-config\n")
+	var userData struct {
+		Hostname string
+		Users    []struct {
+			Name              string
+			SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys"`
+		}
+	}
+	require.NoError(t, yaml.Unmarshal(seed.UserData, &userData))
+	assert.Equal(t, "web", userData.Hostname)
+	require.Len(t, userData.Users, 1)
+	assert.Equal(t, "ops", userData.Users[0].Name)
+	assert.Equal(t, []string{"ssh-ed25519 AAAA ops@laptop"}, userData.Users[0].SSHAuthorizedKeys)
+
+	assert.Equal(t, "instance-id: web\nlocal-hostname: web\n", string(seed.MetaData))
+
+	var network map[string]any
+	require.NoError(t, yaml.Unmarshal(seed.NetworkConfig, &network))
+	assert.Equal(t, true, network["ethernets"].(map[string]any)["default"].(map[string]any)["dhcp4"])
+}
+
+func TestRenderStaticNetwork(t *testing.T) {
+	seed, err := Render(Data{
+		Hostname:    "db",
+		IP:          "192.168.1.20/24",
+		Gateway:     "192.168.1.1",
+		Nameservers: []string{"1.1.1.1", "9.9.9.9"},
+	}, Templates{})
+	require.NoError(t, err)
+
+	var network struct {
+		Ethernets map[string]struct {
+			Addresses   []string
+			Routes      []map[string]string
+			Nameservers struct{ Addresses []string }
+			DHCP4       *bool `yaml:"dhcp4"`
+		}
+	}
+	require.NoError(t, yaml.Unmarshal(seed.NetworkConfig, &network))
+	eth := network.Ethernets["default"]
+	assert.Equal(t, []string{"192.168.1.20/24"}, eth.Addresses)
+	assert.Equal(t, []map[string]string{{"to": "default", "via": "192.168.1.1"}}, eth.Routes)
+	assert.Equal(t, []string{"1.1.1.1", "9.9.9.9"}, eth.Nameservers.Addresses)
+	assert.Nil(t, eth.DHCP4)
+}
+
+func TestRenderTemplates(t *testing.T) {
+	seed, err := Render(Data{Hostname: "web", Vars: map[string]string{"role": "proxy"}},
+		Templates{UserData: "#cloud-config\nruncmd:\n  - echo {{ .Vars.role }}\n"})
+	require.NoError(t, err)
+	assert.Equal(t, "#cloud-config\nruncmd:\n  - echo proxy\n", string(seed.UserData))
+
+	_, err = Render(Data{Hostname: "web"}, Templates{UserData: "{{ .Vars.missing }}"})
+	assert.ErrorContains(t, err, "user-data")
+
+	_, err = Render(Data{}, Templates{})
+	assert.Error(t, err)
+}
+
+func TestWriteISO(t *testing.T) {
+	seed := Seed{UserData: []byte("#cloud-config\n"), MetaData: []byte("instance-id: web\n"), NetworkConfig: []byte("version: 2\n")}
+	path := filepath.Join(t.TempDir(), "web-cidata.iso")
+	require.NoError(t, seed.WriteISO(path))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	info, err := f.Stat()
+	require.NoError(t, err)
+	fs, err := iso9660.Read(f, info.Size(), 0, 2048)
+	require.NoError(t, err)
+	// the identifier is padded with NULs, blkid reads it as a C string
+	assert.Equal(t, VolumeLabel, strings.TrimRight(fs.Label(), "\x00 "))
+
+	entries, err := fs.ReadDir("/")
+	require.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"meta-data", "network-config", "user-data"}, names)
+
+	file, err := fs.OpenFile("/meta-data", os.O_RDONLY)
+	require.NoError(t, err)
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, "instance-id: web\n", string(content))
+}
@@ -0,0 +1,90 @@
+package dckr
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/compose-spec/compose-go/types"
+)
+
+// AppsDir is where i2 app deploy uploads the apps, in the home directory of
+// the SSH user of the host
+const AppsDir = "i2/apps"
+
+// appNameRe is the format of Compose project names
+var appNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ValidateAppName checks an app name is a valid Compose project name, it is
+// used in paths and labels
+func ValidateAppName(app string) error {
+	if !appNameRe.MatchString(app) {
+		return fmt.Errorf("invalid app name %q: lowercase letters, digits, - and _ only", app)
+	}
+	return nil
+}
+
+// AppDir returns the directory of an app on a host, relative to the home
+// directory of the SSH user
+func AppDir(app string) string {
+	return path.Join(AppsDir, app)
+}
+
+// LocalBindSources returns the sources of the bind mounts under the
+// directory of the compose file which exist locally but aren't one of the
+// uploaded files, those land next to the compose file. Relocated, they would
+// be empty directories created by Docker on the host.
+func LocalBindSources(project *types.Project, uploaded []string) []string {
+	local := project.WorkingDir
+	sent := map[string]bool{}
+	for _, f := range uploaded {
+		if abs, err := filepath.Abs(f); err == nil && filepath.Dir(abs) == local {
+			sent[abs] = true
+		}
+	}
+	var sources []string
+	for _, svc := range project.Services {
+		for _, v := range svc.Volumes {
+			if v.Type != types.VolumeTypeBind || sent[v.Source] {
+				continue
+			}
+			rel, err := filepath.Rel(local, v.Source)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				continue
+			}
+			if _, err := os.Stat(v.Source); err == nil {
+				sources = append(sources, v.Source)
+			}
+		}
+	}
+	return sources
+}
+
+// RelocateProject points a project loaded locally to dir, the directory its
+// files are uploaded to on the Docker host. Bind mounts under the directory
+// of the compose file move with it, so the labels of the containers and their
+// config hash are those of the host directory.
+func RelocateProject(project *types.Project, dir string) {
+	local := project.WorkingDir
+	relocate := func(p string) string {
+		rel, err := filepath.Rel(local, p)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return p
+		}
+		return path.Join(dir, filepath.ToSlash(rel))
+	}
+	for _, svc := range project.Services {
+		for i, v := range svc.Volumes {
+			if v.Type == types.VolumeTypeBind {
+				svc.Volumes[i].Source = relocate(v.Source)
+			}
+		}
+	}
+	for i, f := range project.ComposeFiles {
+		project.ComposeFiles[i] = relocate(f)
+	}
+	project.WorkingDir = dir
+}
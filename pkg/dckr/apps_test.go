@@ -0,0 +1,62 @@
+package dckr
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAppName(t *testing.T) {
+	assert.NoError(t, ValidateAppName("shop"))
+	assert.NoError(t, ValidateAppName("shop_v2-eu"))
+	assert.Error(t, ValidateAppName("Shop"))
+	assert.Error(t, ValidateAppName("-shop"))
+	assert.Error(t, ValidateAppName("shop; rm -rf ~"))
+	assert.Equal(t, "i2/apps/shop", AppDir("shop"))
+}
+
+func TestRelocateProject(t *testing.T) {
+	project := loadTestProject(t, strings.Replace(testComposeFile, "services:\n", `services:
+  static:
+    image: nginx:1.27
+    volumes:
+      - /srv/static:/usr/share/nginx/html
+`, 1))
+	local := project.WorkingDir
+	RelocateProject(project, "/home/ops/i2/apps/shop")
+
+	assert.Equal(t, "/home/ops/i2/apps/shop", project.WorkingDir)
+	assert.Equal(t, []string{"/home/ops/i2/apps/shop/compose.yaml"}, project.ComposeFiles)
+	web, err := project.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, "/home/ops/i2/apps/shop/html", web.Volumes[0].Source)
+	static, err := project.GetService("static")
+	require.NoError(t, err)
+	assert.Equal(t, "/srv/static", static.Volumes[0].Source)
+	db, err := project.GetService("db")
+	require.NoError(t, err)
+	assert.Equal(t, "data", db.Volumes[0].Source)
+	assert.NotEqual(t, filepath.Join(local, "html"), web.Volumes[0].Source)
+}
+
+func TestLocalBindSources(t *testing.T) {
+	project := loadTestProject(t, strings.Replace(testComposeFile, "services:\n", `services:
+  static:
+    image: nginx:1.27
+    volumes:
+      - /srv/static:/usr/share/nginx/html
+      - ./.env:/etc/static.env:ro
+`, 1))
+	dir := project.WorkingDir
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), nil, 0o644))
+	files := []string{filepath.Join(dir, "compose.yaml"), filepath.Join(dir, ".env")}
+	assert.Empty(t, LocalBindSources(project, files), "html doesn't exist, .env is uploaded")
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "html"), 0o755))
+	assert.Equal(t, []string{filepath.Join(dir, "html")}, LocalBindSources(project, files))
+	assert.ElementsMatch(t, []string{filepath.Join(dir, "html"), filepath.Join(dir, ".env")}, LocalBindSources(project, files[:1]))
+}
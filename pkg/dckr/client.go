@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/docker/cli/cli/config"
+	configtypes "github.com/docker/cli/cli/config/types"
 	"github.com/docker/cli/cli/connhelper"
 	"github.com/docker/cli/cli/context/store"
 	"github.com/docker/docker/api/types/container"
@@ -16,6 +17,9 @@ import (
 type DockerClient struct {
 	cli               client.CommonAPIClient
 	containerListArgs container.ListOptions
+	// credentials returns the credentials of the registries images are
+	// pulled from, DockerConfigCredentials when nil
+	credentials func(host string) (configtypes.AuthConfig, error)
 }
 
 func (dc *DockerClient) Close() error {
@@ -2,86 +2,815 @@ package dckr
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/charmbracelet/log"
 	"github.com/compose-spec/compose-go/cli"
 	"github.com/compose-spec/compose-go/types"
-	"github.com/docker/docker/client"
-
+	dockertypes "github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+)
+
+// Labels Docker Compose sets on the resources of a project, the containers
+// i2 creates are managed by docker compose as well
+const (
+	ComposeProjectLabel     = "com.docker.compose.project"
+	ComposeServiceLabel     = "com.docker.compose.service"
+	ComposeNumberLabel      = "com.docker.compose.container-number"
+	ComposeOneoffLabel      = "com.docker.compose.oneoff"
+	ComposeNetworkLabel     = "com.docker.compose.network"
+	ComposeVolumeLabel      = "com.docker.compose.volume"
+	ComposeWorkingDirLabel  = "com.docker.compose.project.working_dir"
+	ComposeConfigFilesLabel = "com.docker.compose.project.config_files"
 )
 
-func StartComposeServices(ctx context.Context, name, composeFile string) error {
-	project, err := loadComposeFile(composeFile)
+// ConfigHashLabel is the config hash of the containers i2 creates. It isn't
+// the com.docker.compose.config-hash of docker compose, whose hash i2 can't
+// compute, containers created by docker compose are recreated once by i2.
+const ConfigHashLabel = "i2.config-hash"
+
+// composePollInterval is how often the dependencies of a service are checked
+var composePollInterval = time.Second
+
+// LoadComposeProject loads a compose file with the .env next to it, or with
+// envFiles when given. name overrides the project name when not empty.
+func LoadComposeProject(ctx context.Context, name, composeFile string, envFiles ...string) (*types.Project, error) {
+	opts := []cli.ProjectOptionsFn{
+		cli.WithContext(ctx),
+		cli.WithWorkingDirectory(filepath.Dir(composeFile)),
+		cli.WithOsEnv,
+		cli.WithEnvFiles(envFiles...),
+		cli.WithDotEnv,
+	}
+	if name != "" {
+		opts = append(opts, cli.WithName(name))
+	}
+	options, err := cli.NewProjectOptions([]string{composeFile}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", composeFile, err)
+	}
+	project, err := cli.ProjectFromOptions(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", composeFile, err)
+	}
+	return project, nil
+}
+
+// StartComposeServices loads a compose file and brings its project up, see
+// ComposeUp
+func (dc *DockerClient) StartComposeServices(ctx context.Context, name, composeFile string) error {
+	project, err := LoadComposeProject(ctx, name, composeFile)
 	if err != nil {
 		return err
 	}
+	_, err = dc.ComposeUp(ctx, project)
+	return err
+}
 
-	// Create Docker client
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+// PullComposeImages pulls the images of the services of a compose file
+func (dc *DockerClient) PullComposeImages(ctx context.Context, name, composeFile string) error {
+	project, err := LoadComposeProject(ctx, name, composeFile)
 	if err != nil {
 		return err
 	}
+	return dc.PullProjectImages(ctx, project)
+}
 
-	// Run containers in detached mode
+// PullProjectImages pulls the images of the services of a project, but those
+// with pull_policy never. Pulling before ComposeUp leaves the running
+// containers untouched when a pull fails.
+func (dc *DockerClient) PullProjectImages(ctx context.Context, project *types.Project) error {
 	for _, svc := range project.Services {
-		fmt.Printf("Starting container: %s in detached mode\n", svc.Name)
-		resp, err := cli.ContainerCreate(context.Background(), &container.Config{
-			Image: svc.Image,
-		}, nil, nil, nil, svc.Name)
-		if err != nil {
+		if svc.Image == "" || svc.PullPolicy == types.PullPolicyNever {
+			continue
+		}
+		if err := dc.pullImage(ctx, svc.Image); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		err = cli.ContainerStart(context.Background(), resp.ID, container.StartOptions{})
-		if err != nil {
+// ServiceResult counts what ComposeUp did to the containers of a service
+type ServiceResult struct {
+	Service   string `json:"service"`
+	Created   int    `json:"created"`
+	Recreated int    `json:"recreated"`
+	Started   int    `json:"started"`
+	Removed   int    `json:"removed"`
+	Unchanged int    `json:"unchanged"`
+}
+
+// Changed tells whether ComposeUp changed any container of the service
+func (r ServiceResult) Changed() bool {
+	return r.Created+r.Recreated+r.Started+r.Removed > 0
+}
+
+// ComposeUp reconciles the Docker host with a compose project, like docker
+// compose up -d. It creates the networks and volumes of the project, then
+// starts the services in dependency order, waiting for the conditions of
+// depends_on. Containers whose service changed are recreated, the others are
+// only started when stopped, so running it twice changes nothing. Services
+// must have an image, builds aren't supported. The results are those of the
+// services reconciled before an error.
+func (dc *DockerClient) ComposeUp(ctx context.Context, project *types.Project) ([]ServiceResult, error) {
+	if err := dc.ensureNetworks(ctx, project); err != nil {
+		return nil, err
+	}
+	if err := dc.ensureVolumes(ctx, project); err != nil {
+		return nil, err
+	}
+	results := []ServiceResult{}
+	err := project.WithServices(nil, func(svc types.ServiceConfig) error {
+		if err := dc.waitDependencies(ctx, project, svc); err != nil {
 			return err
 		}
+		result, err := dc.reconcileService(ctx, project, svc)
+		results = append(results, result)
+		return err
+	})
+	return results, err
+}
+
+// ensureNetworks creates the networks used by the services, external
+// networks must exist
+func (dc *DockerClient) ensureNetworks(ctx context.Context, project *types.Project) error {
+	used := map[string]bool{}
+	for _, svc := range project.Services {
+		for key := range svc.Networks {
+			used[key] = true
+		}
+	}
+	for _, key := range project.NetworkNames() {
+		n := project.Networks[key]
+		if !used[key] {
+			continue
+		}
+		_, err := dc.cli.NetworkInspect(ctx, n.Name, network.InspectOptions{})
+		if err == nil {
+			continue
+		}
+		if !errdefs.IsNotFound(err) {
+			return fmt.Errorf("failed to inspect network %s: %w", n.Name, err)
+		}
+		if n.External.External {
+			return fmt.Errorf("external network %s not found", n.Name)
+		}
+		opts := network.CreateOptions{
+			Driver:     n.Driver,
+			Options:    n.DriverOpts,
+			Internal:   n.Internal,
+			Attachable: n.Attachable,
+			Labels:     projectLabels(project, n.Labels, ComposeNetworkLabel, key),
+		}
+		if n.EnableIPv6 {
+			opts.EnableIPv6 = &n.EnableIPv6
+		}
+		if n.Ipam.Driver != "" || len(n.Ipam.Config) > 0 {
+			opts.IPAM = &network.IPAM{Driver: n.Ipam.Driver}
+			for _, pool := range n.Ipam.Config {
+				opts.IPAM.Config = append(opts.IPAM.Config, network.IPAMConfig{
+					Subnet:     pool.Subnet,
+					Gateway:    pool.Gateway,
+					IPRange:    pool.IPRange,
+					AuxAddress: pool.AuxiliaryAddresses,
+				})
+			}
+		}
+		if _, err := dc.cli.NetworkCreate(ctx, n.Name, opts); err != nil {
+			return fmt.Errorf("failed to create network %s: %w", n.Name, err)
+		}
+		log.Infof("Network %s created", n.Name)
 	}
+	return nil
+}
 
+// ensureVolumes creates the named volumes of the project, external volumes
+// must exist
+func (dc *DockerClient) ensureVolumes(ctx context.Context, project *types.Project) error {
+	for _, key := range project.VolumeNames() {
+		v := project.Volumes[key]
+		_, err := dc.cli.VolumeInspect(ctx, v.Name)
+		if err == nil {
+			continue
+		}
+		if !errdefs.IsNotFound(err) {
+			return fmt.Errorf("failed to inspect volume %s: %w", v.Name, err)
+		}
+		if v.External.External {
+			return fmt.Errorf("external volume %s not found", v.Name)
+		}
+		_, err = dc.cli.VolumeCreate(ctx, volume.CreateOptions{
+			Name:       v.Name,
+			Driver:     v.Driver,
+			DriverOpts: v.DriverOpts,
+			Labels:     projectLabels(project, v.Labels, ComposeVolumeLabel, key),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create volume %s: %w", v.Name, err)
+		}
+		log.Infof("Volume %s created", v.Name)
+	}
 	return nil
 }
 
-// Load the compose file
-func loadComposeFile(filename string) (*types.Project, error) {
-	fmt.Println(filename)
-	var opts *cli.ProjectOptions
-	opts, err := cli.NewProjectOptions([]string{filename})
-	if err != nil {
-		fmt.Println("Load Option Errors", err)
-		return nil, err
+// projectLabels returns the labels of a network or a volume of a project
+func projectLabels(project *types.Project, labels types.Labels, keyLabel, key string) map[string]string {
+	out := map[string]string{}
+	for k, v := range labels {
+		out[k] = v
 	}
-	fmt.Println("Load Options", opts)
+	out[ComposeProjectLabel] = project.Name
+	out[keyLabel] = key
+	return out
+}
 
-	project, err := cli.ProjectFromOptions(opts)
+// serviceContainers returns the containers of a service, stopped ones
+// included
+func (dc *DockerClient) serviceContainers(ctx context.Context, project, service string) ([]dockertypes.Container, error) {
+	containers, err := dc.cli.ContainerList(ctx, container.ListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg("label", ComposeProjectLabel+"="+project),
+			filters.Arg("label", ComposeServiceLabel+"="+service),
+		),
+	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to list the containers of %s: %w", service, err)
 	}
-	fmt.Println("Load Project", project)
-	return project, nil
+	return containers, nil
 }
 
-func PullComposeImages(ctx context.Context, name, composeFile string) error {
-	project, err := loadComposeFile(composeFile)
+// reconcileService creates, recreates, starts or removes the containers of a
+// service to match its configuration and its scale
+func (dc *DockerClient) reconcileService(ctx context.Context, project *types.Project, svc types.ServiceConfig) (ServiceResult, error) {
+	result := ServiceResult{Service: svc.Name}
+	if svc.Image == "" {
+		return result, fmt.Errorf("service %s has no image, builds aren't supported", svc.Name)
+	}
+	scale := serviceScale(svc)
+	if svc.ContainerName != "" && scale > 1 {
+		return result, fmt.Errorf("service %s sets container_name and can't scale to %d", svc.Name, scale)
+	}
+	if err := dc.ensureImage(ctx, svc); err != nil {
+		return result, err
+	}
+	hash, err := ServiceHash(svc)
+	if err != nil {
+		return result, err
+	}
+	containers, err := dc.serviceContainers(ctx, project.Name, svc.Name)
 	if err != nil {
+		return result, err
+	}
+
+	existing := map[int]bool{}
+	for _, c := range containers {
+		number, _ := strconv.Atoi(c.Labels[ComposeNumberLabel])
+		switch {
+		case number < 1 || number > scale || existing[number]:
+			if err := dc.RemoveContainer(ctx, c.ID, true, false); err != nil {
+				return result, err
+			}
+			result.Removed++
+		case c.Labels[ConfigHashLabel] != hash:
+			if err := dc.recreateContainer(ctx, project, svc, c, number, hash); err != nil {
+				return result, err
+			}
+			existing[number] = true
+			result.Recreated++
+		default:
+			existing[number] = true
+			if c.State == "running" {
+				result.Unchanged++
+				continue
+			}
+			if err := dc.StartContainer(ctx, c.ID); err != nil {
+				return result, err
+			}
+			result.Started++
+		}
+	}
+	for number := 1; number <= scale; number++ {
+		if existing[number] {
+			continue
+		}
+		if err := dc.createServiceContainer(ctx, project, svc, number, hash); err != nil {
+			return result, err
+		}
+		result.Created++
+	}
+	return result, nil
+}
+
+// recreateContainer replaces a container of a service like docker compose:
+// the old container is stopped and renamed out of the way, the new one is
+// created and started, then the old one is removed. When the new container
+// can't be started it's removed and the old one is restored.
+func (dc *DockerClient) recreateContainer(ctx context.Context, project *types.Project, svc types.ServiceConfig, old dockertypes.Container, number int, hash string) error {
+	name := GetContainerName(old.Names)
+	running := old.State == "running"
+	if running {
+		if err := dc.StopContainer(ctx, old.ID, nil); err != nil {
+			return err
+		}
+	}
+	restore := func(err error) error {
+		if running {
+			if startErr := dc.StartContainer(ctx, old.ID); startErr != nil {
+				return fmt.Errorf("%w, %w", err, startErr)
+			}
+		}
 		return err
 	}
+	if err := dc.RenameContainer(ctx, old.ID, shortID(old.ID)+"_"+name); err != nil {
+		return restore(err)
+	}
+	if err := dc.createServiceContainer(ctx, project, svc, number, hash); err != nil {
+		// the new container may have been created before failing
+		spec := containerName(project, svc, number)
+		if rmErr := dc.cli.ContainerRemove(ctx, spec, container.RemoveOptions{Force: true}); rmErr != nil && !errdefs.IsNotFound(rmErr) {
+			return fmt.Errorf("%w, %w", err, rmErr)
+		}
+		if renameErr := dc.RenameContainer(ctx, old.ID, name); renameErr != nil {
+			return fmt.Errorf("%w, %w", err, renameErr)
+		}
+		return restore(fmt.Errorf("%w, %s was kept", err, name))
+	}
+	return dc.RemoveContainer(ctx, old.ID, true, false)
+}
+
+// shortID returns the 12 characters ID of a container
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
 
-	// Create Docker client
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+// createServiceContainer creates and starts a container of a service,
+// connected to all its networks
+func (dc *DockerClient) createServiceContainer(ctx context.Context, project *types.Project, svc types.ServiceConfig, number int, hash string) error {
+	spec, err := serviceSpec(project, svc, number, hash)
 	if err != nil {
 		return err
 	}
+	var netConfig *network.NetworkingConfig
+	if len(spec.networks) > 0 {
+		first := spec.networks[0]
+		netConfig = &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{first.name: first.settings}}
+	}
+	created, err := dc.cli.ContainerCreate(ctx, spec.config, spec.host, netConfig, nil, spec.name)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", spec.name, err)
+	}
+	for _, endpoint := range spec.networks[min(1, len(spec.networks)):] {
+		if err := dc.cli.NetworkConnect(ctx, endpoint.name, created.ID, endpoint.settings); err != nil {
+			return fmt.Errorf("failed to connect %s to %s: %w", spec.name, endpoint.name, err)
+		}
+	}
+	return dc.StartContainer(ctx, spec.name)
+}
 
-	// Pull images
-	options := image.PullOptions{}
-	for _, svc := range project.Services {
-		fmt.Printf("Pulling image: %s\n", svc.Image)
-		_, err := cli.ImagePull(context.Background(), svc.Image, options)
+// ensureImage pulls the image of a service following its pull_policy,
+// missing by default
+func (dc *DockerClient) ensureImage(ctx context.Context, svc types.ServiceConfig) error {
+	switch svc.PullPolicy {
+	case types.PullPolicyAlways:
+		return dc.pullImage(ctx, svc.Image)
+	case types.PullPolicyNever:
+		return nil
+	case types.PullPolicyBuild:
+		return fmt.Errorf("service %s has pull_policy build, builds aren't supported", svc.Name)
+	}
+	_, _, err := dc.cli.ImageInspectWithRaw(ctx, svc.Image)
+	if err == nil {
+		return nil
+	}
+	if !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to inspect image %s: %w", svc.Image, err)
+	}
+	return dc.pullImage(ctx, svc.Image)
+}
+
+// pullImage pulls an image, the pull is done once its progress is read
+func (dc *DockerClient) pullImage(ctx context.Context, ref string) error {
+	auth, err := registryAuth(ref, dc.credentials)
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %w", ref, err)
+	}
+	log.Infof("Pulling %s", ref)
+	progress, err := dc.cli.ImagePull(ctx, ref, image.PullOptions{RegistryAuth: auth})
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %w", ref, err)
+	}
+	defer progress.Close()
+	decoder := json.NewDecoder(progress)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := decoder.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to pull %s: %w", ref, err)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("failed to pull %s: %s", ref, msg.Error)
+		}
+	}
+}
+
+// waitDependencies waits for the conditions of the depends_on of a service:
+// healthy containers for service_healthy, containers exited with 0 for
+// service_completed_successfully. Dependencies are started before the
+// service, service_started needs no wait.
+func (dc *DockerClient) waitDependencies(ctx context.Context, project *types.Project, svc types.ServiceConfig) error {
+	names := make([]string, 0, len(svc.DependsOn))
+	for name := range svc.DependsOn {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		dep := svc.DependsOn[name]
+		if dep.Condition != types.ServiceConditionHealthy && dep.Condition != types.ServiceConditionCompletedSuccessfully {
+			continue
+		}
+		if _, err := project.GetService(name); err != nil {
+			if dep.Required {
+				return fmt.Errorf("%s depends on %s: %w", svc.Name, name, err)
+			}
+			continue
+		}
+		if err := dc.waitService(ctx, project.Name, name, dep.Condition); err != nil {
+			return fmt.Errorf("%s depends on %s: %w", svc.Name, name, err)
+		}
+	}
+	return nil
+}
+
+// waitService polls the containers of a service until all of them meet a
+// depends_on condition
+func (dc *DockerClient) waitService(ctx context.Context, project, service, condition string) error {
+	for {
+		containers, err := dc.serviceContainers(ctx, project, service)
 		if err != nil {
 			return err
 		}
+		met := true
+		for _, c := range containers {
+			info, err := dc.cli.ContainerInspect(ctx, c.ID)
+			if err != nil {
+				return fmt.Errorf("failed to inspect %s: %w", c.ID, err)
+			}
+			ok, err := conditionMet(info.State, condition)
+			if err != nil {
+				return fmt.Errorf("%s %w", strings.TrimPrefix(info.Name, "/"), err)
+			}
+			met = met && ok
+		}
+		if met {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(composePollInterval):
+		}
 	}
-	return nil
+}
+
+// conditionMet tells whether a container state meets a depends_on condition,
+// an error when it never will
+func conditionMet(state *dockertypes.ContainerState, condition string) (bool, error) {
+	if state == nil {
+		return false, nil
+	}
+	switch condition {
+	case types.ServiceConditionHealthy:
+		if state.Health == nil {
+			return false, fmt.Errorf("has no healthcheck")
+		}
+		switch state.Health.Status {
+		case dockertypes.Healthy:
+			return true, nil
+		case dockertypes.Unhealthy:
+			return false, fmt.Errorf("is unhealthy")
+		}
+		if !state.Running && !state.Restarting {
+			return false, fmt.Errorf("exited with %d", state.ExitCode)
+		}
+		return false, nil
+	case types.ServiceConditionCompletedSuccessfully:
+		if state.Status != "exited" {
+			return false, nil
+		}
+		if state.ExitCode != 0 {
+			return false, fmt.Errorf("exited with %d", state.ExitCode)
+		}
+		return true, nil
+	}
+	return true, nil
+}
+
+// serviceScale returns the number of containers of a service, 1 by default
+func serviceScale(svc types.ServiceConfig) int {
+	if svc.Deploy != nil && svc.Deploy.Replicas != nil {
+		return int(*svc.Deploy.Replicas)
+	}
+	if svc.Scale > 0 {
+		return svc.Scale
+	}
+	return 1
+}
+
+// ServiceHash returns the config hash of a service, a container whose
+// i2.config-hash differs is recreated. Scaling a service
+// doesn't change its hash.
+func ServiceHash(svc types.ServiceConfig) (string, error) {
+	svc.Scale = 0
+	if svc.Deploy != nil {
+		deploy := *svc.Deploy
+		deploy.Replicas = nil
+		svc.Deploy = &deploy
+	}
+	data, err := json.Marshal(svc)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash service %s: %w", svc.Name, err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// containerName returns the name of a container of a service, project-service-n
+// unless the service sets container_name
+func containerName(project *types.Project, svc types.ServiceConfig, number int) string {
+	if svc.ContainerName != "" {
+		return svc.ContainerName
+	}
+	return fmt.Sprintf("%s-%s-%d", project.Name, svc.Name, number)
+}
+
+// endpoint is a network a container is connected to
+type endpoint struct {
+	name     string
+	settings *network.EndpointSettings
+}
+
+// containerSpec is what ContainerCreate needs to create a container of a
+// service, the container is created on the first network and connected to
+// the others
+type containerSpec struct {
+	name     string
+	config   *container.Config
+	host     *container.HostConfig
+	networks []endpoint
+}
+
+// serviceSpec converts a service to the configuration of its container number
+func serviceSpec(project *types.Project, svc types.ServiceConfig, number int, hash string) (containerSpec, error) {
+	labels := map[string]string{}
+	for k, v := range svc.Labels {
+		labels[k] = v
+	}
+	labels[ComposeProjectLabel] = project.Name
+	labels[ComposeServiceLabel] = svc.Name
+	labels[ComposeNumberLabel] = strconv.Itoa(number)
+	labels[ConfigHashLabel] = hash
+	labels[ComposeOneoffLabel] = "False"
+	labels[ComposeWorkingDirLabel] = project.WorkingDir
+	labels[ComposeConfigFilesLabel] = strings.Join(project.ComposeFiles, ",")
+
+	var env []string
+	for k, v := range svc.Environment {
+		if v != nil {
+			env = append(env, k+"="+*v)
+		}
+	}
+	sort.Strings(env)
+
+	exposed := nat.PortSet{}
+	bindings := nat.PortMap{}
+	for _, expose := range svc.Expose {
+		port, err := nat.NewPort(nat.SplitProtoPort(expose))
+		if err != nil {
+			return containerSpec{}, fmt.Errorf("service %s: invalid expose %s: %w", svc.Name, expose, err)
+		}
+		exposed[port] = struct{}{}
+	}
+	for _, p := range svc.Ports {
+		protocol := p.Protocol
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		port, err := nat.NewPort(protocol, strconv.Itoa(int(p.Target)))
+		if err != nil {
+			return containerSpec{}, fmt.Errorf("service %s: invalid port %d: %w", svc.Name, p.Target, err)
+		}
+		exposed[port] = struct{}{}
+		if p.Published != "" {
+			bindings[port] = append(bindings[port], nat.PortBinding{HostIP: p.HostIP, HostPort: p.Published})
+		}
+	}
+
+	mounts, err := serviceMounts(project, svc)
+	if err != nil {
+		return containerSpec{}, err
+	}
+	restart, err := restartPolicy(svc.Restart)
+	if err != nil {
+		return containerSpec{}, fmt.Errorf("service %s: %w", svc.Name, err)
+	}
+
+	config := &container.Config{
+		Hostname:     svc.Hostname,
+		Domainname:   svc.DomainName,
+		User:         svc.User,
+		ExposedPorts: exposed,
+		Tty:          svc.Tty,
+		OpenStdin:    svc.StdinOpen,
+		Env:          env,
+		Cmd:          strslice.StrSlice(svc.Command),
+		Entrypoint:   strslice.StrSlice(svc.Entrypoint),
+		Image:        svc.Image,
+		WorkingDir:   svc.WorkingDir,
+		Labels:       labels,
+		StopSignal:   svc.StopSignal,
+	}
+	if svc.StopGracePeriod != nil {
+		timeout := int(time.Duration(*svc.StopGracePeriod).Seconds())
+		config.StopTimeout = &timeout
+	}
+	if hc := svc.HealthCheck; hc != nil {
+		config.Healthcheck = &container.HealthConfig{Test: hc.Test}
+		if hc.Disable {
+			config.Healthcheck.Test = []string{"NONE"}
+		}
+		if hc.Interval != nil {
+			config.Healthcheck.Interval = time.Duration(*hc.Interval)
+		}
+		if hc.Timeout != nil {
+			config.Healthcheck.Timeout = time.Duration(*hc.Timeout)
+		}
+		if hc.StartPeriod != nil {
+			config.Healthcheck.StartPeriod = time.Duration(*hc.StartPeriod)
+		}
+		if hc.StartInterval != nil {
+			config.Healthcheck.StartInterval = time.Duration(*hc.StartInterval)
+		}
+		if hc.Retries != nil {
+			config.Healthcheck.Retries = int(*hc.Retries)
+		}
+	}
+
+	host := &container.HostConfig{
+		PortBindings:   bindings,
+		RestartPolicy:  restart,
+		Mounts:         mounts,
+		CapAdd:         svc.CapAdd,
+		CapDrop:        svc.CapDrop,
+		DNS:            svc.DNS,
+		DNSOptions:     svc.DNSOpts,
+		DNSSearch:      svc.DNSSearch,
+		ExtraHosts:     svc.ExtraHosts.AsList(),
+		Privileged:     svc.Privileged,
+		ReadonlyRootfs: svc.ReadOnly,
+		SecurityOpt:    svc.SecurityOpt,
+		Sysctls:        svc.Sysctls,
+		ShmSize:        int64(svc.ShmSize),
+		Init:           svc.Init,
+		Resources: container.Resources{
+			Memory:   int64(svc.MemLimit),
+			NanoCPUs: int64(svc.CPUS * 1e9),
+		},
+	}
+	sort.Strings(host.ExtraHosts)
+	if svc.Logging != nil {
+		host.LogConfig = container.LogConfig{Type: svc.Logging.Driver, Config: svc.Logging.Options}
+	}
+
+	spec := containerSpec{name: containerName(project, svc, number), config: config, host: host}
+	if svc.NetworkMode != "" {
+		host.NetworkMode = container.NetworkMode(svc.NetworkMode)
+		return spec, nil
+	}
+	keys := make([]string, 0, len(svc.Networks))
+	for key := range svc.Networks {
+		keys = append(keys, key)
+	}
+	// by priority then name, like docker compose
+	sort.Slice(keys, func(i, j int) bool {
+		pi, pj := networkPriority(svc.Networks[keys[i]]), networkPriority(svc.Networks[keys[j]])
+		if pi != pj {
+			return pi > pj
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys {
+		n, ok := project.Networks[key]
+		if !ok {
+			return containerSpec{}, fmt.Errorf("service %s uses undefined network %s", svc.Name, key)
+		}
+		settings := &network.EndpointSettings{Aliases: []string{svc.Name}}
+		if cfg := svc.Networks[key]; cfg != nil {
+			settings.Aliases = append(settings.Aliases, cfg.Aliases...)
+			if cfg.Ipv4Address != "" || cfg.Ipv6Address != "" {
+				settings.IPAMConfig = &network.EndpointIPAMConfig{IPv4Address: cfg.Ipv4Address, IPv6Address: cfg.Ipv6Address}
+			}
+		}
+		spec.networks = append(spec.networks, endpoint{name: n.Name, settings: settings})
+	}
+	if len(spec.networks) > 0 {
+		host.NetworkMode = container.NetworkMode(spec.networks[0].name)
+	}
+	return spec, nil
+}
+
+func networkPriority(cfg *types.ServiceNetworkConfig) int {
+	if cfg == nil {
+		return 0
+	}
+	return cfg.Priority
+}
+
+// serviceMounts converts the volumes of a service to mounts. Named volumes
+// are the volumes of the project, bind mounts are paths of the Docker host.
+func serviceMounts(project *types.Project, svc types.ServiceConfig) ([]mount.Mount, error) {
+	var mounts []mount.Mount
+	for _, v := range svc.Volumes {
+		m := mount.Mount{Target: v.Target, ReadOnly: v.ReadOnly}
+		switch v.Type {
+		case types.VolumeTypeVolume:
+			m.Type = mount.TypeVolume
+			m.Source = v.Source
+			if declared, ok := project.Volumes[v.Source]; ok {
+				m.Source = declared.Name
+			}
+			if v.Volume != nil && v.Volume.NoCopy {
+				m.VolumeOptions = &mount.VolumeOptions{NoCopy: true}
+			}
+		case types.VolumeTypeBind:
+			m.Type = mount.TypeBind
+			m.Source = v.Source
+			if v.Bind != nil {
+				m.BindOptions = &mount.BindOptions{
+					Propagation:      mount.Propagation(v.Bind.Propagation),
+					CreateMountpoint: v.Bind.CreateHostPath,
+				}
+			}
+		case types.VolumeTypeTmpfs:
+			m.Type = mount.TypeTmpfs
+			if v.Tmpfs != nil {
+				m.TmpfsOptions = &mount.TmpfsOptions{SizeBytes: int64(v.Tmpfs.Size)}
+			}
+		default:
+			return nil, fmt.Errorf("service %s: volumes of type %s aren't supported", svc.Name, v.Type)
+		}
+		mounts = append(mounts, m)
+	}
+	for _, tmpfs := range svc.Tmpfs {
+		mounts = append(mounts, mount.Mount{Type: mount.TypeTmpfs, Target: tmpfs})
+	}
+	return mounts, nil
+}
+
+// restartPolicy parses the restart of a service: no, always, unless-stopped
+// or on-failure[:max-retries]
+func restartPolicy(restart string) (container.RestartPolicy, error) {
+	mode, retries, _ := strings.Cut(restart, ":")
+	policy := container.RestartPolicy{Name: container.RestartPolicyMode(mode)}
+	switch policy.Name {
+	case "", container.RestartPolicyDisabled, container.RestartPolicyAlways, container.RestartPolicyUnlessStopped:
+		if retries != "" {
+			return policy, fmt.Errorf("invalid restart policy %s", restart)
+		}
+	case container.RestartPolicyOnFailure:
+		if retries != "" {
+			n, err := strconv.Atoi(retries)
+			if err != nil {
+				return policy, fmt.Errorf("invalid restart policy %s", restart)
+			}
+			policy.MaximumRetryCount = n
+		}
+	default:
+		return policy, fmt.Errorf("invalid restart policy %s", restart)
+	}
+	return policy, nil
 }
@@ -0,0 +1,396 @@
+package dckr
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/compose-spec/compose-go/types"
+	configtypes "github.com/docker/cli/cli/config/types"
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testComposeFile = `
+services:
+  db:
+    image: postgres:16
+    environment:
+      POSTGRES_PASSWORD: secret
+    volumes:
+      - data:/var/lib/postgresql/data
+    networks: [back]
+    healthcheck:
+      test: ["CMD", "pg_isready"]
+      interval: 5s
+      retries: 3
+  web:
+    image: nginx:1.27
+    restart: on-failure:3
+    ports:
+      - "8080:80"
+    environment:
+      B: two
+      A: one
+    labels:
+      tier: front
+    volumes:
+      - ./html:/usr/share/nginx/html:ro
+    networks:
+      front:
+      back:
+        aliases: [www]
+    depends_on:
+      db:
+        condition: service_healthy
+volumes:
+  data:
+networks:
+  front:
+  back:
+`
+
+// loadTestProject loads a compose file written to a temporary directory
+func loadTestProject(t *testing.T, content string) *types.Project {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "compose.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	project, err := LoadComposeProject(context.Background(), "shop", path)
+	require.NoError(t, err)
+	return project
+}
+
+func TestServiceSpec(t *testing.T) {
+	project := loadTestProject(t, testComposeFile)
+	web, err := project.GetService("web")
+	require.NoError(t, err)
+
+	spec, err := serviceSpec(project, web, 1, "hash")
+	require.NoError(t, err)
+	assert.Equal(t, "shop-web-1", spec.name)
+	assert.Equal(t, "nginx:1.27", spec.config.Image)
+	assert.Equal(t, []string{"A=one", "B=two"}, spec.config.Env)
+	assert.Equal(t, "front", spec.config.Labels["tier"])
+	assert.Equal(t, "shop", spec.config.Labels[ComposeProjectLabel])
+	assert.Equal(t, "web", spec.config.Labels[ComposeServiceLabel])
+	assert.Equal(t, "1", spec.config.Labels[ComposeNumberLabel])
+	assert.Equal(t, "hash", spec.config.Labels[ConfigHashLabel])
+	assert.Equal(t, "False", spec.config.Labels[ComposeOneoffLabel])
+	assert.Equal(t, nat.PortMap{"80/tcp": {{HostPort: "8080"}}}, spec.host.PortBindings)
+	assert.Equal(t, container.RestartPolicy{Name: container.RestartPolicyOnFailure, MaximumRetryCount: 3}, spec.host.RestartPolicy)
+	require.Len(t, spec.host.Mounts, 1)
+	assert.Equal(t, mount.TypeBind, spec.host.Mounts[0].Type)
+	assert.Equal(t, filepath.Join(project.WorkingDir, "html"), spec.host.Mounts[0].Source)
+	assert.True(t, spec.host.Mounts[0].ReadOnly)
+	require.Len(t, spec.networks, 2)
+	assert.Equal(t, "shop_back", spec.networks[0].name)
+	assert.Equal(t, []string{"web", "www"}, spec.networks[0].settings.Aliases)
+	assert.Equal(t, "shop_front", spec.networks[1].name)
+	assert.Equal(t, container.NetworkMode("shop_back"), spec.host.NetworkMode)
+
+	db, err := project.GetService("db")
+	require.NoError(t, err)
+	spec, err = serviceSpec(project, db, 1, "hash")
+	require.NoError(t, err)
+	assert.Equal(t, mount.Mount{Type: mount.TypeVolume, Source: "shop_data", Target: "/var/lib/postgresql/data"}, spec.host.Mounts[0])
+	assert.Equal(t, &container.HealthConfig{Test: []string{"CMD", "pg_isready"}, Interval: 5 * time.Second, Retries: 3}, spec.config.Healthcheck)
+}
+
+func TestServiceHash(t *testing.T) {
+	project := loadTestProject(t, testComposeFile)
+	web, err := project.GetService("web")
+	require.NoError(t, err)
+	hash, err := ServiceHash(web)
+	require.NoError(t, err)
+
+	scaled := web
+	scaled.Scale = 3
+	scaledHash, err := ServiceHash(scaled)
+	require.NoError(t, err)
+	assert.Equal(t, hash, scaledHash)
+
+	changed := web
+	changed.Image = "nginx:1.28"
+	changedHash, err := ServiceHash(changed)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, changedHash)
+}
+
+func TestRestartPolicy(t *testing.T) {
+	for restart, want := range map[string]container.RestartPolicy{
+		"":               {},
+		"always":         {Name: container.RestartPolicyAlways},
+		"unless-stopped": {Name: container.RestartPolicyUnlessStopped},
+		"on-failure":     {Name: container.RestartPolicyOnFailure},
+		"on-failure:5":   {Name: container.RestartPolicyOnFailure, MaximumRetryCount: 5},
+	} {
+		policy, err := restartPolicy(restart)
+		require.NoError(t, err, restart)
+		assert.Equal(t, want, policy, restart)
+	}
+	for _, restart := range []string{"sometimes", "always:3", "on-failure:x"} {
+		_, err := restartPolicy(restart)
+		assert.Error(t, err, restart)
+	}
+}
+
+// fakeCompose is the state of the Docker API stand-in of TestComposeUp: the
+// containers it creates, creating the container failCreate fails. Images are
+// missing when pull is set, auth is the X-Registry-Auth of their pulls.
+type fakeCompose struct {
+	mu         sync.Mutex
+	calls      []string
+	containers map[string]dockertypes.Container
+	health     string
+	failCreate string
+	pull       bool
+	auth       []string
+	created    int
+}
+
+// lookup returns the name of a container by name or ID
+func (f *fakeCompose) lookup(ref string) string {
+	for name, c := range f.containers {
+		if name == ref || c.ID == ref {
+			return name
+		}
+	}
+	return ref
+}
+
+// takeCalls returns the calls recorded since the last take
+func (f *fakeCompose) takeCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func TestComposeUp(t *testing.T) {
+	fake := &fakeCompose{}
+	dc := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		path := strings.TrimPrefix(r.URL.Path, "/v1.45")
+		if r.Method != http.MethodGet {
+			fake.calls = append(fake.calls, r.Method+" "+path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		notFound := func() {
+			dockerError(w, http.StatusNotFound, "not found")
+		}
+		switch {
+		case r.Method == http.MethodGet && (strings.HasPrefix(path, "/networks/") || strings.HasPrefix(path, "/volumes/")):
+			notFound()
+		case path == "/networks/create" || path == "/volumes/create":
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"Id": "created"}`))
+		case path == "/images/create":
+			fake.auth = append(fake.auth, r.Header.Get("X-Registry-Auth"))
+			_, _ = w.Write([]byte(`{"status": "Downloaded"}`))
+		case strings.HasPrefix(path, "/images/") && fake.pull:
+			notFound()
+		case strings.HasPrefix(path, "/images/"):
+			_, _ = w.Write([]byte(`{"Id": "sha256:1"}`))
+		case path == "/containers/json":
+			args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+			assert.NoError(t, err)
+			list := []dockertypes.Container{}
+			for _, c := range fake.containers {
+				if args.MatchKVList("label", c.Labels) {
+					list = append(list, c)
+				}
+			}
+			assert.NoError(t, json.NewEncoder(w).Encode(list))
+		case path == "/containers/create":
+			var config container.Config
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&config))
+			name := r.URL.Query().Get("name")
+			if name == fake.failCreate {
+				dockerError(w, http.StatusInternalServerError, "no space left on device")
+				return
+			}
+			fake.created++
+			id := fmt.Sprintf("%064x", fake.created)
+			fake.containers[name] = dockertypes.Container{ID: id, Names: []string{"/" + name}, Labels: config.Labels, State: "created"}
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"Id": "` + id + `"}`))
+		case strings.HasSuffix(path, "/start"), strings.HasSuffix(path, "/stop"):
+			ref, action, _ := strings.Cut(strings.TrimPrefix(path, "/containers/"), "/")
+			name := fake.lookup(ref)
+			c := fake.containers[name]
+			c.State = map[string]string{"start": "running", "stop": "exited"}[action]
+			fake.containers[name] = c
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasSuffix(path, "/rename"):
+			name := fake.lookup(strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/rename"))
+			newName := r.URL.Query().Get("name")
+			c := fake.containers[name]
+			c.Names = []string{"/" + newName}
+			delete(fake.containers, name)
+			fake.containers[newName] = c
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodDelete:
+			name := fake.lookup(strings.TrimPrefix(path, "/containers/"))
+			if _, ok := fake.containers[name]; !ok {
+				notFound()
+				return
+			}
+			delete(fake.containers, name)
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasSuffix(path, "/json"):
+			name := fake.lookup(strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/json"))
+			info := dockertypes.ContainerJSON{ContainerJSONBase: &dockertypes.ContainerJSONBase{
+				ID: fake.containers[name].ID, Name: "/" + name,
+				State: &dockertypes.ContainerState{Status: "running", Running: true, Health: &dockertypes.Health{Status: fake.health}},
+			}}
+			assert.NoError(t, json.NewEncoder(w).Encode(info))
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+	for _, tc := range []struct {
+		name string
+		test func(t *testing.T)
+	}{
+		{"up", func(t *testing.T) {
+			ctx := context.Background()
+			project := loadTestProject(t, testComposeFile)
+
+			results, err := dc.ComposeUp(ctx, project)
+			require.NoError(t, err)
+			assert.Equal(t, []ServiceResult{{Service: "db", Created: 1}, {Service: "web", Created: 1}}, results)
+			assert.Equal(t, []string{
+				"POST /networks/create",
+				"POST /networks/create",
+				"POST /volumes/create",
+				"POST /containers/create",
+				"POST /containers/shop-db-1/start",
+				"POST /containers/create",
+				"POST /networks/shop_front/connect",
+				"POST /containers/shop-web-1/start",
+			}, fake.takeCalls())
+
+			// running it again only checks the networks and the volumes exist
+			results, err = dc.ComposeUp(ctx, project)
+			require.NoError(t, err)
+			assert.Equal(t, []ServiceResult{{Service: "db", Unchanged: 1}, {Service: "web", Unchanged: 1}}, results)
+			assert.False(t, results[0].Changed())
+			for _, call := range fake.takeCalls() {
+				assert.Contains(t, []string{"POST /networks/create", "POST /volumes/create"}, call)
+			}
+
+			// a changed service is recreated before the old container is removed, a
+			// scaled down one loses containers
+			changed := loadTestProject(t, strings.Replace(testComposeFile, "nginx:1.27", "nginx:1.28", 1))
+			fake.containers["shop-db-2"] = dockertypes.Container{ID: "id-shop-db-2", Names: []string{"/shop-db-2"}, Labels: map[string]string{
+				ComposeProjectLabel: "shop", ComposeServiceLabel: "db", ComposeNumberLabel: "2",
+			}}
+			old := fake.containers["shop-web-1"].ID
+			results, err = dc.ComposeUp(ctx, changed)
+			require.NoError(t, err)
+			assert.Equal(t, []ServiceResult{{Service: "db", Removed: 1, Unchanged: 1}, {Service: "web", Recreated: 1}}, results)
+			calls := fake.takeCalls()
+			assert.Contains(t, calls, "DELETE /containers/id-shop-db-2")
+			web := calls[len(calls)-6:]
+			assert.Equal(t, []string{
+				"POST /containers/" + old + "/stop",
+				"POST /containers/" + old + "/rename",
+				"POST /containers/create",
+				"POST /networks/shop_front/connect",
+				"POST /containers/shop-web-1/start",
+				"DELETE /containers/" + old,
+			}, web)
+			assert.NotContains(t, calls, "POST /containers/shop-db-1/start")
+			assert.Len(t, fake.containers, 2)
+			assert.Equal(t, "running", fake.containers["shop-web-1"].State)
+		}},
+		{"pull credentials", func(t *testing.T) {
+			fake.pull = true
+			var hosts []string
+			dc.credentials = func(host string) (configtypes.AuthConfig, error) {
+				hosts = append(hosts, host)
+				return configtypes.AuthConfig{Username: "ci", Password: "secret"}, nil
+			}
+			_, err := dc.ComposeUp(context.Background(), loadTestProject(t, testComposeFile))
+			require.NoError(t, err)
+			assert.Equal(t, []string{"docker.io", "docker.io"}, hosts)
+			require.Len(t, fake.auth, 2)
+			b, err := base64.URLEncoding.DecodeString(fake.auth[0])
+			require.NoError(t, err)
+			var auth registry.AuthConfig
+			require.NoError(t, json.Unmarshal(b, &auth))
+			assert.Equal(t, registry.AuthConfig{Username: "ci", Password: "secret"}, auth)
+		}},
+		{"recreate failure", func(t *testing.T) {
+			ctx := context.Background()
+			_, err := dc.ComposeUp(ctx, loadTestProject(t, testComposeFile))
+			require.NoError(t, err)
+			old := fake.containers["shop-web-1"]
+
+			// the old container is renamed back and started when the new one fails
+			fake.failCreate = "shop-web-1"
+			changed := loadTestProject(t, strings.Replace(testComposeFile, "nginx:1.27", "nginx:1.28", 1))
+			_, err = dc.ComposeUp(ctx, changed)
+			assert.ErrorContains(t, err, "no space left on device, shop-web-1 was kept")
+			assert.Len(t, fake.containers, 2)
+			web := fake.containers["shop-web-1"]
+			assert.Equal(t, old.ID, web.ID)
+			assert.Equal(t, "running", web.State)
+		}},
+		{"unhealthy dependency", func(t *testing.T) {
+			fake.health = dockertypes.Unhealthy
+			project := loadTestProject(t, testComposeFile)
+
+			results, err := dc.ComposeUp(context.Background(), project)
+			assert.ErrorContains(t, err, "web depends on db: shop-db-1 is unhealthy")
+			assert.Equal(t, []ServiceResult{{Service: "db", Created: 1}}, results)
+			assert.NotContains(t, fake.containers, "shop-web-1")
+		}},
+	} {
+		fake.containers, fake.health, fake.failCreate, fake.pull = map[string]dockertypes.Container{}, dockertypes.Healthy, "", false
+		fake.calls, fake.auth, fake.created = nil, nil, 0
+		dc.credentials = nil
+		t.Run(tc.name, tc.test)
+	}
+}
+
+func TestConditionMet(t *testing.T) {
+	running := &dockertypes.ContainerState{Status: "running", Running: true}
+	ok, err := conditionMet(running, types.ServiceConditionHealthy)
+	assert.False(t, ok)
+	assert.ErrorContains(t, err, "no healthcheck")
+
+	starting := &dockertypes.ContainerState{Status: "running", Running: true, Health: &dockertypes.Health{Status: dockertypes.Starting}}
+	ok, err = conditionMet(starting, types.ServiceConditionHealthy)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	ok, err = conditionMet(running, types.ServiceConditionCompletedSuccessfully)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+	ok, err = conditionMet(&dockertypes.ContainerState{Status: "exited"}, types.ServiceConditionCompletedSuccessfully)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	_, err = conditionMet(&dockertypes.ContainerState{Status: "exited", ExitCode: 2}, types.ServiceConditionCompletedSuccessfully)
+	assert.ErrorContains(t, err, "exited with 2")
+
+	ok, err = conditionMet(running, types.ServiceConditionStarted)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+}
@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"path"
 	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
 )
 
 func (dc *DockerClient) ListContainers() ([]types.Container, error) {
@@ -21,6 +24,31 @@ func (dc *DockerClient) ListContainers() ([]types.Container, error) {
 	return containers, nil
 }
 
+// HostContainer is a container as stored in the containers bucket, with the
+// platform of its image, os/arch[/variant]
+type HostContainer struct {
+	types.Container
+	Platform string `json:",omitempty"`
+}
+
+// hostContainers adds the platform of their local image to containers, it
+// is empty when the image can't be inspected
+func (dc *DockerClient) hostContainers(ctx context.Context, containers []types.Container) []HostContainer {
+	platforms := map[string]string{}
+	hosted := make([]HostContainer, 0, len(containers))
+	for _, c := range containers {
+		platform, ok := platforms[c.ImageID]
+		if !ok {
+			if img, _, err := dc.cli.ImageInspectWithRaw(ctx, c.ImageID); err == nil && img.Os != "" {
+				platform = path.Join(img.Os, img.Architecture, img.Variant)
+			}
+			platforms[c.ImageID] = platform
+		}
+		hosted = append(hosted, HostContainer{Container: c, Platform: platform})
+	}
+	return hosted
+}
+
 func (dc *DockerClient) CopyToContainer(ctx context.Context, containerID, dstPath string, content io.Reader, options types.CopyToContainerOptions) error {
 	return dc.cli.CopyToContainer(ctx, containerID, dstPath, content, options)
 }
@@ -43,8 +71,31 @@ func (dc *DockerClient) ListImages() ([]image.Summary, error) {
 	return images, nil
 }
 
+// ListVolumes returns the volumes of the Docker host with their size and the
+// number of containers using them, as docker system df reports them
+func (dc *DockerClient) ListVolumes(ctx context.Context) ([]*volume.Volume, error) {
+	usage, err := dc.cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.VolumeObject}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+	return usage.Volumes, nil
+}
+
+// ListNetworks returns the networks of the Docker host
+func (dc *DockerClient) ListNetworks(ctx context.Context) ([]network.Summary, error) {
+	networks, err := dc.cli.NetworkList(ctx, network.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+	return networks, nil
+}
+
 func (dc *DockerClient) PullImage(img string) (io.ReadCloser, error) {
 	ctx := context.Background()
-	options := image.PullOptions{}
+	auth, err := registryAuth(img, dc.credentials)
+	if err != nil {
+		return nil, err
+	}
+	options := image.PullOptions{RegistryAuth: auth}
 	return dc.cli.ImagePull(ctx, img, options)
 }
@@ -2,8 +2,11 @@ package dckr
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
 	"testing"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -27,3 +30,38 @@ func TestListContainers(t *testing.T) {
 	assert.NotEmpty(t, containers, "ListContainers should return at least one container")
 
 }
+
+func TestHostContainers(t *testing.T) {
+	inspected := 0
+	dc := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1.45/images/sha256:arm/json":
+			inspected++
+			_ = json.NewEncoder(w).Encode(types.ImageInspect{ID: "sha256:arm", Os: "linux", Architecture: "arm64", Variant: "v8"})
+		case "/v1.45/images/sha256:amd/json":
+			inspected++
+			_ = json.NewEncoder(w).Encode(types.ImageInspect{ID: "sha256:amd", Os: "linux", Architecture: "amd64"})
+		default:
+			dockerError(w, http.StatusNotFound, "No such image")
+		}
+	})
+	hosted := dc.hostContainers(context.Background(), []types.Container{
+		{ID: "a", ImageID: "sha256:arm"},
+		{ID: "b", ImageID: "sha256:amd"},
+		{ID: "c", ImageID: "sha256:arm"},
+		{ID: "d", ImageID: "sha256:gone"},
+	})
+
+	require.Len(t, hosted, 4)
+	assert.Equal(t, "linux/arm64/v8", hosted[0].Platform)
+	assert.Equal(t, "linux/amd64", hosted[1].Platform)
+	assert.Equal(t, "linux/arm64/v8", hosted[2].Platform)
+	assert.Empty(t, hosted[3].Platform)
+	assert.Equal(t, 2, inspected, "images are inspected once")
+
+	b, err := json.Marshal(hosted[:1])
+	require.NoError(t, err)
+	var stored []types.Container
+	require.NoError(t, json.Unmarshal(b, &stored))
+	assert.Equal(t, "a", stored[0].ID, "the bucket still holds Docker containers")
+}
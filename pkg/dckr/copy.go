@@ -0,0 +1,468 @@
+package dckr
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+)
+
+// CopyPath is a side of i2 cp, a local path when Container is empty.
+// Otherwise Path is in a container of the local Docker, or of the guest Host.
+type CopyPath struct {
+	Container string
+	Host      string
+	Path      string
+}
+
+// ParseCopyPath parses container:path, container@vm:path or a local path.
+// Absolute paths and paths with a / before the first : are local, ./a:b
+// names a local file.
+func ParseCopyPath(arg string) CopyPath {
+	if filepath.IsAbs(arg) || strings.HasPrefix(arg, ".") {
+		return CopyPath{Path: arg}
+	}
+	ref, p, ok := strings.Cut(arg, ":")
+	if !ok || ref == "" || strings.Contains(ref, "/") {
+		return CopyPath{Path: arg}
+	}
+	name, host, _ := strings.Cut(ref, "@")
+	return CopyPath{Container: name, Host: host, Path: p}
+}
+
+// IsLocal reports whether the path is on the local filesystem
+func (p CopyPath) IsLocal() bool {
+	return p.Container == ""
+}
+
+func (p CopyPath) String() string {
+	switch {
+	case p.IsLocal():
+		return p.Path
+	case p.Host != "":
+		return p.Container + "@" + p.Host + ":" + p.Path
+	}
+	return p.Container + ":" + p.Path
+}
+
+// CopyOptions are the clients of the container sides of a copy. Archive
+// keeps the uid and gid of the files copied to a container, local copies
+// keep them when i2 runs as root. Progress is called with the bytes of the
+// archive copied so far.
+type CopyOptions struct {
+	Source      *DockerClient
+	Destination *DockerClient
+	Archive     bool
+	Progress    func(int64)
+}
+
+// Copy copies a file or a directory like docker cp, from or to a container,
+// or between two containers of any hosts. Directories are copied into an
+// existing destination directory, or as the destination when it doesn't
+// exist, src/. copies the contents of src. Modes, times and symbolic links
+// are kept.
+func Copy(ctx context.Context, src, dst CopyPath, opts CopyOptions) error {
+	if src.IsLocal() && dst.IsLocal() {
+		return errors.New("one of the paths must be in a container")
+	}
+	var source *copySource
+	var err error
+	if src.IsLocal() {
+		source, err = localSource(src.Path)
+	} else {
+		source, err = opts.Source.containerSource(ctx, src.Container, containerPath(src.Path))
+	}
+	if err != nil {
+		return err
+	}
+	defer source.content.Close()
+	if opts.Progress != nil {
+		source.content = &progressReader{ReadCloser: source.content, progress: opts.Progress}
+	}
+
+	if dst.IsLocal() {
+		return extractLocal(source, dst.Path, os.Geteuid() == 0)
+	}
+	return opts.Destination.extractToContainer(ctx, dst.Container, containerPath(dst.Path), source, opts.Archive)
+}
+
+// copySource is the tar archive of a path, as the archive endpoints of
+// Docker send it: its top-level entry is name, . for the contents of a
+// directory
+type copySource struct {
+	name    string
+	isDir   bool
+	content io.ReadCloser
+}
+
+// containerPath returns a path of a container, relative paths are relative
+// to its root
+func containerPath(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		return "/" + p
+	}
+	return p
+}
+
+// copiesContents reports whether a path names the contents of a directory
+func copiesContents(p string) bool {
+	return p == "." || strings.HasSuffix(p, "/.")
+}
+
+// containerSource reads the archive of a path of a container
+func (dc *DockerClient) containerSource(ctx context.Context, id, p string) (*copySource, error) {
+	content, stat, err := dc.cli.CopyFromContainer(ctx, id, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy %s from %s: %w", p, id, err)
+	}
+	name := stat.Name
+	if copiesContents(p) {
+		name = "."
+	}
+	return &copySource{name: name, isDir: stat.Mode.IsDir(), content: content}, nil
+}
+
+// localSource archives a local path. Symbolic links are archived as links,
+// unless the path ends with a / and is a link to a directory.
+func localSource(p string) (*copySource, error) {
+	root := filepath.Clean(p)
+	name := filepath.Base(root)
+	if copiesContents(p) {
+		name = "."
+	}
+	info, err := os.Lstat(root)
+	if err == nil && info.Mode()&os.ModeSymlink != 0 && (strings.HasSuffix(p, "/") || name == ".") {
+		if root, err = filepath.EvalSymlinks(root); err == nil {
+			info, err = os.Lstat(root)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(writeTar(w, root, name))
+	}()
+	return &copySource{name: name, isDir: info.IsDir(), content: r}, nil
+}
+
+// writeTar archives root as name. Sockets can't be archived, they're
+// skipped.
+func writeTar(w io.Writer, root, name string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSocket != 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if rel != "." {
+			hdr.Name += "/" + filepath.ToSlash(rel)
+		}
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// copyTarget returns the directory the archive of a source is extracted in
+// and the name its top-level entry takes there, following the rules of
+// docker cp. exists and isDir describe the destination.
+func copyTarget(source *copySource, dst string, exists, isDir bool) (string, string, error) {
+	switch {
+	case exists && isDir:
+		return dst, source.name, nil
+	case exists && source.isDir:
+		return "", "", fmt.Errorf("cannot copy a directory to the file %s", dst)
+	case !exists && !source.isDir && strings.HasSuffix(dst, "/"):
+		return "", "", fmt.Errorf("directory %s doesn't exist", dst)
+	}
+	dst = path.Clean(dst)
+	return path.Dir(dst), path.Base(dst), nil
+}
+
+// extractToContainer extracts the archive of a source to a path of a
+// container. A destination that is a symbolic link is followed.
+func (dc *DockerClient) extractToContainer(ctx context.Context, id, dst string, source *copySource, copyUIDGID bool) error {
+	stat, err := dc.cli.ContainerStatPath(ctx, id, dst)
+	if err == nil && stat.Mode&os.ModeSymlink != 0 {
+		target := stat.LinkTarget
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(path.Clean(dst)), target)
+		}
+		dst = target
+		stat, err = dc.cli.ContainerStatPath(ctx, id, dst)
+	}
+	if err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to stat %s in %s: %w", dst, id, err)
+	}
+	dir, name, err := copyTarget(source, dst, err == nil, stat.Mode.IsDir())
+	if err != nil {
+		return err
+	}
+	content := io.Reader(source.content)
+	if name != source.name {
+		content = rebaseTar(content, source.name, name)
+	}
+	err = dc.cli.CopyToContainer(ctx, id, dir, content, container.CopyToContainerOptions{CopyUIDGID: copyUIDGID})
+	if err != nil {
+		return fmt.Errorf("failed to copy to %s in %s: %w", dir, id, err)
+	}
+	return nil
+}
+
+// extractLocal extracts the archive of a source to a local path, chown
+// keeps the uid and gid of the files
+func extractLocal(source *copySource, dst string, chown bool) error {
+	info, err := os.Stat(dst)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	dir, name, err := copyTarget(source, filepath.ToSlash(dst), err == nil, err == nil && info.IsDir())
+	if err != nil {
+		return err
+	}
+	dir = filepath.FromSlash(dir)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("directory %s doesn't exist", dir)
+	}
+	content := io.Reader(source.content)
+	if name != source.name {
+		content = rebaseTar(content, source.name, name)
+	}
+	return extractTar(content, dir, chown)
+}
+
+// rebaseTar renames the top-level entry of an archive from old to name
+func rebaseTar(r io.Reader, old, name string) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		tr := tar.NewReader(r)
+		tw := tar.NewWriter(pw)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				pw.CloseWithError(tw.Close())
+				return
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			hdr.Name = rebaseName(hdr.Name, old, name)
+			if hdr.Typeflag == tar.TypeLink {
+				hdr.Linkname = rebaseName(hdr.Linkname, old, name)
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return pr
+}
+
+// rebaseName replaces the first element of an archive entry name
+func rebaseName(entry, old, name string) string {
+	if entry == old || entry == old+"/" || strings.HasPrefix(entry, old+"/") {
+		return name + entry[len(old):]
+	}
+	return entry
+}
+
+// extractTar extracts an archive in dir. Entries outside dir, or under a
+// symbolic link the archive could write through, are refused, devices and
+// fifos are skipped. Directories get their mode and times once
+// their files are written.
+func extractTar(r io.Reader, dir string, chown bool) error {
+	tr := tar.NewReader(r)
+	dirs := []*tar.Header{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the archive: %w", err)
+		}
+		target, err := extractPath(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if info, err := os.Lstat(target); err == nil && !info.IsDir() && target != dir {
+				if err := os.Remove(target); err != nil {
+					return err
+				}
+			}
+			if err := os.MkdirAll(target, 0o700); err != nil {
+				return err
+			}
+			dirs = append(dirs, hdr)
+		case tar.TypeReg:
+			if err := writeFile(target, tr, hdr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			_ = os.Remove(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			source, err := extractPath(dir, hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			_ = os.Remove(target)
+			if err := os.Link(source, target); err != nil {
+				return err
+			}
+			continue
+		default:
+			continue
+		}
+		if chown {
+			if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+				return err
+			}
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if err := setModeAndTimes(target, hdr); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		// a later entry may have replaced the directory
+		target, err := extractPath(dir, dirs[i].Name)
+		if err != nil {
+			return err
+		}
+		if info, err := os.Lstat(target); err != nil || !info.IsDir() {
+			continue
+		}
+		if err := setModeAndTimes(target, dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractPath returns where an archive entry is extracted in dir. The
+// missing parent directories of the entry are created, a parent that is a
+// symbolic link is refused: x -> /etc followed by x/cron.d/job would write
+// outside dir.
+func extractPath(dir, name string) (string, error) {
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(dir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside %s", name, dir)
+	}
+	parent := dir
+	elems := strings.Split(rel, string(filepath.Separator))
+	for _, elem := range elems[:len(elems)-1] {
+		parent = filepath.Join(parent, elem)
+		info, err := os.Lstat(parent)
+		if errors.Is(err, fs.ErrNotExist) {
+			return target, os.MkdirAll(filepath.Dir(target), 0o755)
+		}
+		if err != nil {
+			return "", err
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("%s is outside %s, %s isn't a directory", name, dir, parent)
+		}
+	}
+	return target, nil
+}
+
+func writeFile(target string, r io.Reader, hdr *tar.Header) error {
+	_ = os.Remove(target)
+	// O_EXCL doesn't follow a symbolic link created meanwhile
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// setModeAndTimes sets the mode of an archive entry, setuid, setgid and
+// sticky bits included, and its modification time. It follows chown, which
+// clears setuid.
+func setModeAndTimes(target string, hdr *tar.Header) error {
+	mode := hdr.FileInfo().Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	if err := os.Chmod(target, mode); err != nil {
+		return err
+	}
+	return os.Chtimes(target, time.Now(), hdr.ModTime)
+}
+
+// progressReader reports the bytes read so far
+type progressReader struct {
+	io.ReadCloser
+	read     int64
+	progress func(int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	r.progress(r.read)
+	return n, err
+}
@@ -0,0 +1,211 @@
+package dckr
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCopyPath(t *testing.T) {
+	assert.Equal(t, CopyPath{Container: "web", Path: "/etc/nginx"}, ParseCopyPath("web:/etc/nginx"))
+	assert.Equal(t, CopyPath{Container: "web", Host: "vm1", Path: "/srv"}, ParseCopyPath("web@vm1:/srv"))
+	assert.Equal(t, CopyPath{Path: "/tmp/a:b"}, ParseCopyPath("/tmp/a:b"))
+	assert.Equal(t, CopyPath{Path: "./a:b"}, ParseCopyPath("./a:b"))
+	assert.Equal(t, CopyPath{Path: "dir/a:b"}, ParseCopyPath("dir/a:b"))
+	assert.Equal(t, CopyPath{Path: "notes.txt"}, ParseCopyPath("notes.txt"))
+	assert.Equal(t, "web@vm1:/srv", ParseCopyPath("web@vm1:/srv").String())
+	assert.Equal(t, "/", containerPath(ParseCopyPath("web:").Path))
+}
+
+func TestCopyTarget(t *testing.T) {
+	file := &copySource{name: "app.conf"}
+	dir := &copySource{name: "data", isDir: true}
+
+	target := func(source *copySource, dst string, exists, isDir bool) []string {
+		d, name, err := copyTarget(source, dst, exists, isDir)
+		if err != nil {
+			return []string{err.Error()}
+		}
+		return []string{d, name}
+	}
+	assert.Equal(t, []string{"/etc", "app.conf"}, target(file, "/etc", true, true))
+	assert.Equal(t, []string{"/etc", "nginx.conf"}, target(file, "/etc/nginx.conf", true, false))
+	assert.Equal(t, []string{"/etc", "new.conf"}, target(file, "/etc/new.conf", false, false))
+	assert.Equal(t, []string{"directory /etc/new/ doesn't exist"}, target(file, "/etc/new/", false, false))
+	assert.Equal(t, []string{"/srv", "data"}, target(dir, "/srv", true, true))
+	assert.Equal(t, []string{"/srv", "backup"}, target(dir, "/srv/backup/", false, false))
+	assert.Equal(t, []string{"cannot copy a directory to the file /srv/file"}, target(dir, "/srv/file", true, false))
+}
+
+func TestRebaseName(t *testing.T) {
+	assert.Equal(t, "backup/", rebaseName("data/", "data", "backup"))
+	assert.Equal(t, "backup/a/b", rebaseName("data/a/b", "data", "backup"))
+	assert.Equal(t, "database/x", rebaseName("database/x", "data", "backup"))
+	assert.Equal(t, "backup/x", rebaseName("./x", ".", "backup"))
+}
+
+// sourceTree creates a directory data with a script, a private directory
+// and a symbolic link
+func sourceTree(t *testing.T) string {
+	t.Helper()
+	root := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "private"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "run.sh"), []byte("#!/bin/sh\n"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "private", "key"), []byte("secret"), 0o600))
+	require.NoError(t, os.Chmod(filepath.Join(root, "private"), 0o700))
+	require.NoError(t, os.Symlink("run.sh", filepath.Join(root, "start")))
+	return root
+}
+
+func assertTree(t *testing.T, root string) {
+	t.Helper()
+	info, err := os.Stat(filepath.Join(root, "run.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o755), info.Mode().Perm())
+	info, err = os.Stat(filepath.Join(root, "private"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+	key, err := os.ReadFile(filepath.Join(root, "private", "key"))
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(key))
+	link, err := os.Readlink(filepath.Join(root, "start"))
+	require.NoError(t, err)
+	assert.Equal(t, "run.sh", link)
+}
+
+func TestLocalCopy(t *testing.T) {
+	src := sourceTree(t)
+	dst := t.TempDir()
+
+	// into an existing directory
+	source, err := localSource(src)
+	require.NoError(t, err)
+	require.NoError(t, extractLocal(source, dst, false))
+	assertTree(t, filepath.Join(dst, "data"))
+
+	// as a new directory
+	source, err = localSource(src)
+	require.NoError(t, err)
+	require.NoError(t, extractLocal(source, filepath.Join(dst, "backup"), false))
+	assertTree(t, filepath.Join(dst, "backup"))
+
+	// the contents of a directory
+	source, err = localSource(src + "/.")
+	require.NoError(t, err)
+	require.NoError(t, extractLocal(source, filepath.Join(dst, "backup"), false))
+	assertTree(t, filepath.Join(dst, "backup"))
+	_, err = os.Stat(filepath.Join(dst, "backup", "data"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	source, err = localSource(src)
+	require.NoError(t, err)
+	assert.ErrorContains(t, extractLocal(source, filepath.Join(dst, "missing", "backup"), false), "doesn't exist")
+}
+
+func TestExtractTarOutside(t *testing.T) {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../escape", Mode: 0o644, Typeflag: tar.TypeReg}))
+	require.NoError(t, tw.Close())
+	assert.ErrorContains(t, extractTar(&b, t.TempDir(), false), "is outside")
+}
+
+func TestExtractTarThroughSymlink(t *testing.T) {
+	outside := t.TempDir()
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "x", Linkname: outside, Typeflag: tar.TypeSymlink}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "x/evil", Mode: 0o644, Size: 4, Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte("evil"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	assert.ErrorContains(t, extractTar(&b, t.TempDir(), false), "is outside")
+	_, err = os.Stat(filepath.Join(outside, "evil"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCopyContainer(t *testing.T) {
+	// web has /etc, a directory, and /srv/app.conf. Archives put are recorded
+	// by path with the names of their entries.
+	stats := map[string]container.PathStat{
+		"/etc":          {Name: "etc", Mode: os.ModeDir | 0o755},
+		"/srv/app.conf": {Name: "app.conf", Mode: 0o644, Size: 5},
+	}
+	put := map[string][]string{}
+	dc := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1.45/containers/web/archive", r.URL.Path)
+		p := r.URL.Query().Get("path")
+		if r.Method == http.MethodPut {
+			tr := tar.NewReader(r.Body)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if !assert.NoError(t, err) {
+					return
+				}
+				put[p] = append(put[p], hdr.Name)
+			}
+			return
+		}
+		stat, ok := stats[p]
+		if !ok {
+			dockerError(w, http.StatusNotFound, "Could not find the file")
+			return
+		}
+		b, err := json.Marshal(stat)
+		assert.NoError(t, err)
+		w.Header().Set("X-Docker-Container-Path-Stat", base64.StdEncoding.EncodeToString(b))
+		if r.Method == http.MethodGet {
+			tw := tar.NewWriter(w)
+			assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "app.conf", Mode: 0o640, Size: 5, Typeflag: tar.TypeReg}))
+			_, err := tw.Write([]byte("a=b\n\n"))
+			assert.NoError(t, err)
+			assert.NoError(t, tw.Close())
+		}
+	})
+	ctx := context.Background()
+	src := sourceTree(t)
+	dst := t.TempDir()
+
+	var copied int64
+	to := CopyOptions{Destination: dc, Progress: func(n int64) { copied = n }}
+	require.NoError(t, Copy(ctx, CopyPath{Path: src}, ParseCopyPath("web:/etc"), to))
+	assert.Equal(t, []string{"data/", "data/private/", "data/private/key", "data/run.sh", "data/start"}, put["/etc"])
+	assert.Positive(t, copied)
+
+	require.NoError(t, Copy(ctx, CopyPath{Path: filepath.Join(src, "run.sh")}, ParseCopyPath("web:etc/run"), to))
+	assert.Equal(t, []string{"run"}, put["/etc"][5:])
+
+	from := CopyOptions{Source: dc}
+	require.NoError(t, Copy(ctx, ParseCopyPath("web:/srv/app.conf"), CopyPath{Path: filepath.Join(dst, "local.conf")}, from))
+	info, err := os.Stat(filepath.Join(dst, "local.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+	assert.Equal(t, int64(5), info.Size())
+
+	for _, tc := range []struct {
+		src, dst CopyPath
+		opts     CopyOptions
+		err      string
+	}{
+		{CopyPath{Path: src}, ParseCopyPath("web:/srv/app.conf"), to, "cannot copy a directory to the file"},
+		{CopyPath{Path: src}, CopyPath{Path: "/tmp"}, to, "must be in a container"},
+		{ParseCopyPath("web:/missing"), CopyPath{Path: dst}, from, "Could not find the file"},
+	} {
+		assert.ErrorContains(t, Copy(ctx, tc.src, tc.dst, tc.opts), tc.err)
+	}
+}
@@ -0,0 +1,233 @@
+package dckr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"i2/pkg/prxmx"
+
+	"github.com/charmbracelet/log"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// EventsSubject is the root of the NATS subjects of the container events,
+// i2.events.<vm>.container.<action>
+const EventsSubject = "i2.events"
+
+// WatchedActions are the container events published by EventWatcher
+var WatchedActions = []events.Action{events.ActionStart, events.ActionDie, events.ActionOOM, events.ActionHealthStatus}
+
+// Delays between two connections of an EventWatcher to its Docker, doubled
+// after every failure
+var (
+	eventsRetryMin = 5 * time.Second
+	eventsRetryMax = time.Minute
+)
+
+// ContainerEvent is the message published for a container event. Health is
+// the status of health_status events, Attributes the labels of the container
+// with its name, image and, for die, its exitCode.
+type ContainerEvent struct {
+	VM         string            `json:"vm"`
+	Action     string            `json:"action"`
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Image      string            `json:"image"`
+	Health     string            `json:"health,omitempty"`
+	Attributes map[string]string `json:"attributes"`
+	Time       time.Time         `json:"time"`
+}
+
+// NewContainerEvent translates a Docker event of a container on a host
+func NewContainerEvent(vm string, msg events.Message) ContainerEvent {
+	action, health, _ := strings.Cut(string(msg.Action), ":")
+	return ContainerEvent{
+		VM:         vm,
+		Action:     action,
+		ID:         msg.Actor.ID,
+		Name:       msg.Actor.Attributes["name"],
+		Image:      msg.Actor.Attributes["image"],
+		Health:     strings.TrimSpace(health),
+		Attributes: msg.Actor.Attributes,
+		Time:       time.Unix(0, msg.TimeNano),
+	}
+}
+
+// Subject returns the NATS subject of the event
+func (e ContainerEvent) Subject() string {
+	return EventSubject(e.VM, e.Action)
+}
+
+// EventSubject returns the subject of the container events of a host, the
+// characters NATS doesn't allow in a token are replaced by _
+func EventSubject(vm, action string) string {
+	token := strings.NewReplacer(".", "_", " ", "_", "\t", "_", "*", "_", ">", "_").Replace
+	return EventsSubject + "." + token(vm) + ".container." + token(action)
+}
+
+// WatchEvents calls fn with the watched container events until the events
+// stream fails, or the context is cancelled, nil is returned then
+func (dc *DockerClient) WatchEvents(ctx context.Context, fn func(events.Message) error) error {
+	args := filters.NewArgs(filters.Arg("type", string(events.ContainerEventType)))
+	for _, action := range WatchedActions {
+		args.Add("event", string(action))
+	}
+	messages, errs := dc.cli.Events(ctx, events.ListOptions{Filters: args})
+	for {
+		select {
+		case msg := <-messages:
+			if err := fn(msg); err != nil {
+				return err
+			}
+		case err := <-errs:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read the events: %w", err)
+		}
+	}
+}
+
+// EventWatcher publishes the container events of a host and keeps its
+// containers up to date in the containers bucket. VM is nil for the local
+// Docker, whose containers aren't stored. The containers and stats buckets
+// expire their entries, the containers and stats of the guest are stored
+// again every Refresh, 0 only stores them when connecting.
+type EventWatcher struct {
+	Client    *DockerClient
+	VM        *prxmx.Node
+	Inventory *prxmx.Inventory
+	Publish   func(subject string, data []byte) error
+	Refresh   time.Duration
+
+	// mu serializes the writes of the containers of the guest, the Refresh
+	// ticker stores them next to the events
+	mu sync.Mutex
+}
+
+// Name returns the host of the watcher, localhost for the local Docker
+func (w *EventWatcher) Name() string {
+	if w.VM == nil {
+		return "localhost"
+	}
+	return w.VM.Name
+}
+
+// Run watches the events until the context is cancelled, reconnecting when
+// the events stream fails. The containers of the host are stored again on
+// every connection, events may have been missed meanwhile, and every
+// Refresh.
+func (w *EventWatcher) Run(ctx context.Context) {
+	if w.VM != nil && w.Refresh > 0 {
+		go func() {
+			ticker := time.NewTicker(w.Refresh)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					w.refresh(ctx)
+				}
+			}
+		}()
+	}
+	retry := eventsRetryMin
+	for ctx.Err() == nil {
+		if w.VM != nil {
+			w.refresh(ctx)
+		}
+		start := time.Now()
+		err := w.Client.WatchEvents(ctx, func(msg events.Message) error {
+			return w.handle(ctx, msg)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > eventsRetryMax {
+			retry = eventsRetryMin
+		}
+		log.Warnf("Watching the events of %s: %v, reconnecting in %s", w.Name(), err, retry)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+		retry = min(retry*2, eventsRetryMax)
+	}
+}
+
+// refresh stores the containers and the stats of the guest
+func (w *EventWatcher) refresh(ctx context.Context) {
+	w.mu.Lock()
+	err := w.Client.RefreshContainers(ctx, w.Inventory, *w.VM)
+	w.mu.Unlock()
+	if err != nil {
+		log.Warnf("Error refreshing the containers of %s: %v", w.Name(), err)
+	}
+	if err := w.Client.RefreshStats(ctx, w.Inventory, *w.VM); err != nil {
+		log.Warnf("Error refreshing the stats of %s: %v", w.Name(), err)
+	}
+}
+
+// handle publishes an event and updates its container in the containers
+// bucket. Failures are logged, they don't stop the watcher.
+func (w *EventWatcher) handle(ctx context.Context, msg events.Message) error {
+	event := NewContainerEvent(w.Name(), msg)
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := w.Publish(event.Subject(), data); err != nil {
+		log.Warnf("Error publishing %s: %v", event.Subject(), err)
+	}
+	if w.VM != nil {
+		if err := w.updateContainer(ctx, event.ID); err != nil {
+			log.Warnf("Error updating %s of %s: %v", event.Name, w.Name(), err)
+		}
+	}
+	return nil
+}
+
+// updateContainer replaces a container in the containers stored for the
+// host, or removes it when it no longer runs. All the containers are stored
+// again when the stored ones can't be read.
+func (w *EventWatcher) updateContainer(ctx context.Context, id string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b, err := w.Inventory.HostContainers(ctx, *w.VM)
+	var stored []HostContainer
+	if err == nil {
+		err = json.Unmarshal(b, &stored)
+	}
+	if err != nil {
+		return w.Client.RefreshContainers(ctx, w.Inventory, *w.VM)
+	}
+	opts := w.Client.containerListArgs
+	opts.Filters = filters.NewArgs(filters.Arg("id", id))
+	current, err := w.Client.cli.ContainerList(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", id, err)
+	}
+	return w.Inventory.SaveContainers(ctx, *w.VM, upsertContainer(stored, id, w.Client.hostContainers(ctx, current)))
+}
+
+// upsertContainer replaces the container id in containers by current, or
+// removes it when current is empty. New containers are added at the end.
+func upsertContainer(containers []HostContainer, id string, current []HostContainer) []HostContainer {
+	updated := make([]HostContainer, 0, len(containers)+1)
+	for _, c := range containers {
+		if c.ID != id {
+			updated = append(updated, c)
+			continue
+		}
+		updated = append(updated, current...)
+		current = nil
+	}
+	return append(updated, current...)
+}
@@ -0,0 +1,99 @@
+package dckr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func healthEvent() events.Message {
+	return events.Message{
+		Type:     events.ContainerEventType,
+		Action:   "health_status: unhealthy",
+		Actor:    events.Actor{ID: "abc", Attributes: map[string]string{"name": "web", "image": "nginx:1.27", "app": "shop"}},
+		TimeNano: 1_700_000_000_000_000_000,
+	}
+}
+
+func TestNewContainerEvent(t *testing.T) {
+	event := NewContainerEvent("web.1", healthEvent())
+	assert.Equal(t, "health_status", event.Action)
+	assert.Equal(t, "unhealthy", event.Health)
+	assert.Equal(t, "web", event.Name)
+	assert.Equal(t, "nginx:1.27", event.Image)
+	assert.Equal(t, "shop", event.Attributes["app"])
+	assert.Equal(t, int64(1_700_000_000), event.Time.Unix())
+	assert.Equal(t, "i2.events.web_1.container.health_status", event.Subject())
+
+	die := NewContainerEvent("vm1", events.Message{Action: events.ActionDie, Actor: events.Actor{ID: "abc", Attributes: map[string]string{"exitCode": "137"}}})
+	assert.Equal(t, "i2.events.vm1.container.die", die.Subject())
+	assert.Empty(t, die.Health)
+	assert.Equal(t, "i2.events.my_vm__.container.oom", EventSubject("my vm*>", "oom"))
+}
+
+func TestUpsertContainer(t *testing.T) {
+	c := func(id, status string) HostContainer {
+		return HostContainer{Container: types.Container{ID: id, Status: status}, Platform: "linux/amd64"}
+	}
+	stored := []HostContainer{c("a", ""), c("b", "Up 1 minute"), c("c", "")}
+
+	updated := upsertContainer(stored, "b", []HostContainer{c("b", "Up 1 minute (unhealthy)")})
+	assert.Equal(t, []HostContainer{c("a", ""), c("b", "Up 1 minute (unhealthy)"), c("c", "")}, updated)
+	assert.Equal(t, []HostContainer{c("a", ""), c("c", "")}, upsertContainer(stored, "b", nil))
+	assert.Equal(t, []HostContainer{c("a", ""), c("b", "Up 1 minute"), c("c", ""), c("d", "")}, upsertContainer(stored, "d", []HostContainer{c("d", "")}))
+	assert.Len(t, stored, 3)
+}
+
+func TestWatchEvents(t *testing.T) {
+	// a start and a health_status event before the stream closes
+	var requested filters.Args
+	dc := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1.45/events", r.URL.Path)
+		var err error
+		requested, err = filters.FromJSON(r.URL.Query().Get("filters"))
+		assert.NoError(t, err)
+		w.Header().Set("Content-Type", "application/json")
+		start := events.Message{Type: events.ContainerEventType, Action: events.ActionStart, Actor: events.Actor{ID: "abc", Attributes: map[string]string{"name": "web"}}}
+		assert.NoError(t, json.NewEncoder(w).Encode(start))
+		assert.NoError(t, json.NewEncoder(w).Encode(healthEvent()))
+	})
+
+	actions := []events.Action{}
+	err := dc.WatchEvents(context.Background(), func(msg events.Message) error {
+		actions = append(actions, msg.Action)
+		return nil
+	})
+	assert.ErrorContains(t, err, "failed to read the events")
+	assert.Equal(t, []events.Action{events.ActionStart, "health_status: unhealthy"}, actions)
+	assert.True(t, requested.ExactMatch("type", "container"))
+	assert.ElementsMatch(t, []string{"start", "die", "oom", "health_status"}, requested.Get("event"))
+
+	published := map[string]ContainerEvent{}
+	w := &EventWatcher{Client: dc, Publish: func(subject string, data []byte) error {
+		var event ContainerEvent
+		assert.NoError(t, json.Unmarshal(data, &event))
+		published[subject] = event
+		return nil
+	}}
+	assert.Equal(t, "localhost", w.Name())
+	_ = dc.WatchEvents(context.Background(), func(msg events.Message) error {
+		return w.handle(context.Background(), msg)
+	})
+	require.Len(t, published, 2)
+	for _, tc := range []struct {
+		subject, name, health string
+	}{
+		{"i2.events.localhost.container.start", "web", ""},
+		{"i2.events.localhost.container.health_status", "web", "unhealthy"},
+	} {
+		assert.Equal(t, tc.name, published[tc.subject].Name, tc.subject)
+		assert.Equal(t, tc.health, published[tc.subject].Health, tc.subject)
+	}
+}
@@ -0,0 +1,110 @@
+package dckr
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// ExecOptions describe a command run in a container. Size is the initial
+// [height, width] of the TTY.
+type ExecOptions struct {
+	Cmd     []string
+	TTY     bool
+	Stdin   bool
+	User    string
+	WorkDir string
+	Env     []string
+	Size    *[2]uint
+}
+
+// ExecSession is a command running in a container, attached to its streams
+// through the connection Docker hijacks from the exec start request
+type ExecSession struct {
+	ID   string
+	TTY  bool
+	dc   *DockerClient
+	resp types.HijackedResponse
+}
+
+// Exec creates and starts a command in a running container. The session
+// must be closed once streamed.
+func (dc *DockerClient) Exec(ctx context.Context, name string, opts ExecOptions) (*ExecSession, error) {
+	if len(opts.Cmd) == 0 {
+		return nil, fmt.Errorf("no command to run in %s", name)
+	}
+	created, err := dc.cli.ContainerExecCreate(ctx, name, container.ExecOptions{
+		User:         opts.User,
+		Tty:          opts.TTY,
+		ConsoleSize:  opts.Size,
+		AttachStdin:  opts.Stdin,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          opts.Env,
+		WorkingDir:   opts.WorkDir,
+		Cmd:          opts.Cmd,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the exec in %s: %w", name, err)
+	}
+	resp, err := dc.cli.ContainerExecAttach(ctx, created.ID, container.ExecAttachOptions{
+		Tty:         opts.TTY,
+		ConsoleSize: opts.Size,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach to the exec in %s: %w", name, err)
+	}
+	return &ExecSession{ID: created.ID, TTY: opts.TTY, dc: dc, resp: resp}, nil
+}
+
+// Stream copies stdin to the command and its output to stdout and stderr
+// until the command exits. Without a TTY Docker multiplexes both outputs in
+// one stream. stdin may be nil when the command doesn't read it.
+func (s *ExecSession) Stream(stdin io.Reader, stdout, stderr io.Writer) error {
+	if stdin != nil {
+		go func() {
+			_, _ = io.Copy(s.resp.Conn, stdin)
+			_ = s.resp.CloseWrite()
+		}()
+	}
+	var err error
+	if s.TTY {
+		_, err = io.Copy(stdout, s.resp.Reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, s.resp.Reader)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stream the exec: %w", err)
+	}
+	return nil
+}
+
+// Resize sets the size of the TTY of the command
+func (s *ExecSession) Resize(ctx context.Context, height, width uint) error {
+	err := s.dc.cli.ContainerExecResize(ctx, s.ID, container.ResizeOptions{Height: height, Width: width})
+	if err != nil {
+		return fmt.Errorf("failed to resize the exec: %w", err)
+	}
+	return nil
+}
+
+// ExitCode returns the exit code of the command once it has exited
+func (s *ExecSession) ExitCode(ctx context.Context) (int, error) {
+	info, err := s.dc.cli.ContainerExecInspect(ctx, s.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect the exec: %w", err)
+	}
+	if info.Running {
+		return 0, fmt.Errorf("exec %s is still running", s.ID)
+	}
+	return info.ExitCode, nil
+}
+
+// Close closes the connection to the command
+func (s *ExecSession) Close() {
+	s.resp.Close()
+}
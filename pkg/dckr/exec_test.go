@@ -0,0 +1,101 @@
+package dckr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExec(t *testing.T) {
+	// the exec echoes its input in upper case, on stdout and on stderr
+	// without a TTY, and exits with 3
+	var mu sync.Mutex
+	var created container.ExecOptions
+	calls := []string{}
+	dc := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1.45/containers/missing/exec":
+			dockerError(w, http.StatusNotFound, "No such container: missing")
+		case "/v1.45/containers/web/exec":
+			created = container.ExecOptions{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"Id": "abc"}`))
+		case "/v1.45/exec/abc/start":
+			var start container.ExecStartOptions
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&start))
+			assert.Equal(t, created.Tty, start.Tty)
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer conn.Close()
+			_, _ = buf.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+			assert.NoError(t, buf.Flush())
+			input, err := io.ReadAll(buf)
+			assert.NoError(t, err)
+			output := bytes.ToUpper(input)
+			if created.Tty {
+				_, _ = conn.Write(output)
+				return
+			}
+			_, _ = stdcopy.NewStdWriter(conn, stdcopy.Stdout).Write(output)
+			_, _ = stdcopy.NewStdWriter(conn, stdcopy.Stderr).Write(output)
+		case "/v1.45/exec/abc/json":
+			_, _ = w.Write([]byte(`{"ID": "abc", "Running": false, "ExitCode": 3}`))
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name           string
+		opts           ExecOptions
+		input          string
+		stdout, stderr string
+	}{
+		{"tty", ExecOptions{Cmd: []string{"sh"}, TTY: true, Stdin: true, User: "app", Size: &[2]uint{24, 80}}, "echo hi\n", "ECHO HI\n", ""},
+		{"without tty", ExecOptions{Cmd: []string{"cat"}, Stdin: true}, "hello", "HELLO", "HELLO"},
+	} {
+		session, err := dc.Exec(ctx, "web", tc.opts)
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, tc.opts.Cmd, created.Cmd, tc.name)
+		assert.Equal(t, tc.opts.User, created.User, tc.name)
+		assert.Equal(t, tc.opts.Stdin, created.AttachStdin, tc.name)
+		assert.Equal(t, tc.opts.Size, created.ConsoleSize, tc.name)
+		if tc.opts.TTY {
+			require.NoError(t, session.Resize(ctx, 40, 120))
+		}
+		var stdout, stderr bytes.Buffer
+		require.NoError(t, session.Stream(strings.NewReader(tc.input), &stdout, &stderr), tc.name)
+		assert.Equal(t, tc.stdout, stdout.String(), tc.name)
+		assert.Equal(t, tc.stderr, stderr.String(), tc.name)
+		code, err := session.ExitCode(ctx)
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, 3, code, tc.name)
+		session.Close()
+	}
+	mu.Lock()
+	assert.Contains(t, calls, "POST /v1.45/exec/abc/resize?h=40&w=120")
+	mu.Unlock()
+
+	_, err := dc.Exec(ctx, "missing", ExecOptions{Cmd: []string{"sh"}})
+	assert.ErrorContains(t, err, "No such container")
+	_, err = dc.Exec(ctx, "web", ExecOptions{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,29 @@
+package dckr
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeDocker starts an HTTP server answering the Docker API with handler
+// and returns a client pointed at it, request paths start with /v1.45
+func newFakeDocker(t *testing.T, handler http.HandlerFunc) *DockerClient {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.45"))
+	require.NoError(t, err)
+	return &DockerClient{cli: cli}
+}
+
+// dockerError replies with a Docker API error
+func dockerError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
@@ -0,0 +1,182 @@
+package dckr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"i2/pkg/models"
+	"i2/pkg/prxmx"
+	"i2/pkg/store"
+
+	"github.com/charmbracelet/log"
+	"github.com/docker/docker/errdefs"
+	"github.com/gin-gonic/gin"
+)
+
+type containerService struct {
+	config *models.Config
+}
+
+// ActionResult is the reply of a container action. Warning is set when the
+// action worked but the containers of the host couldn't be stored in NATS.
+type ActionResult struct {
+	Host      string `json:"host"`
+	Container string `json:"container"`
+	Action    string `json:"action"`
+	Warning   string `json:"warning,omitempty"`
+}
+
+// ContainerAction godoc
+// @Summary Run a container action
+// @Description Start, stop, restart, kill or rename a container of the local
+// @Description Docker or of a guest reached over SSH. The containers of the
+// @Description guest are then refreshed in the containers bucket.
+// @Tags containers
+// @Accept json
+// @Produce json
+// @Param name path string true "Container name or ID"
+// @Param action path string true "start, stop, restart, kill or rename"
+// @Param host query string false "Guest name or key, the local Docker when empty"
+// @Param timeout query int false "Seconds to wait before killing the container (stop, restart)"
+// @Param signal query string false "Signal to send (kill), SIGKILL by default"
+// @Param to query string false "New name (rename)"
+// @Success 200 {object} ActionResult
+// @Failure 400 {object} interface{}
+// @Failure 404 {object} interface{}
+// @Failure 409 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /containers/{name}/{action} [post]
+func (s *containerService) handlerContainerAction(c *gin.Context) {
+	action := c.Param("action")
+	opts := ActionOptions{Signal: c.Query("signal"), NewName: c.Query("to")}
+	switch action {
+	case ActionStart, ActionStop, ActionRestart, ActionKill:
+	case ActionRename:
+		if opts.NewName == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "rename needs the new name in ?to="})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown container action %q, use start, stop, restart, kill or rename", action)})
+		return
+	}
+	if value := c.Query("timeout"); value != "" {
+		timeout, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid timeout %q", value)})
+			return
+		}
+		opts.Timeout = &timeout
+	}
+	s.run(c, action, opts)
+}
+
+// RemoveContainer godoc
+// @Summary Remove a container
+// @Description Remove a container of the local Docker or of a guest reached
+// @Description over SSH. Running containers are only removed with force.
+// @Tags containers
+// @Accept json
+// @Produce json
+// @Param name path string true "Container name or ID"
+// @Param host query string false "Guest name or key, the local Docker when empty"
+// @Param force query bool false "Remove a running container"
+// @Param volumes query bool false "Remove the anonymous volumes of the container"
+// @Success 200 {object} ActionResult
+// @Failure 404 {object} interface{}
+// @Failure 409 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /containers/{name} [delete]
+func (s *containerService) handlerRemoveContainer(c *gin.Context) {
+	s.run(c, ActionRemove, ActionOptions{Force: c.Query("force") == "true", Volumes: c.Query("volumes") == "true"})
+}
+
+func (s *containerService) run(c *gin.Context, action string, opts ActionOptions) {
+	ctx := c.Request.Context()
+	host := c.Query("host")
+	name := c.Param("name")
+
+	var inventory *prxmx.Inventory
+	if host != "" {
+		st, err := store.NewStore(ctx, &s.config.Nats)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer st.Close()
+		inventory = prxmx.NewInventory(st)
+	}
+
+	dc, vm, err := HostClient(ctx, inventory, host, s.config.SSH.User)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer dc.Close()
+
+	if err := dc.ContainerAction(ctx, action, name, opts); err != nil {
+		c.JSON(actionStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	result := ActionResult{Host: "localhost", Container: name, Action: action}
+	if vm != nil {
+		result.Host = vm.Name
+		if err := dc.RefreshContainers(context.WithoutCancel(ctx), inventory, *vm); err != nil {
+			log.Warnf("Error refreshing the containers of %s: %v", vm.Name, err)
+			result.Warning = err.Error()
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// actionStatus maps the errors of the Docker API to HTTP statuses
+func actionStatus(err error) int {
+	switch {
+	case errdefs.IsNotFound(err):
+		return http.StatusNotFound
+	case errdefs.IsConflict(err):
+		return http.StatusConflict
+	case errdefs.IsInvalidParameter(err):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// OutdatedContainers godoc
+// @Summary List the containers running outdated images
+// @Description Compare the images of the running containers of every guest in
+// @Description the containers bucket with the images their tags point to in
+// @Description their registries, with the credentials of the Docker config.
+// @Description Multi-platform images are compared with the manifest of the
+// @Description platform of the local image. The i2_container_image_outdated
+// @Description gauge is updated.
+// @Tags containers
+// @Produce json
+// @Param all query bool false "Include the containers that are up to date"
+// @Success 200 {array} ImageStatus
+// @Failure 500 {object} interface{}
+// @Router /containers/outdated [get]
+func (s *containerService) handlerOutdatedContainers(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	st, err := store.NewStore(ctx, &s.config.Nats)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer st.Close()
+
+	statuses, err := OutdatedContainers(ctx, prxmx.NewInventory(st), NewRegistry())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	SetOutdatedGauge(statuses)
+	if c.Query("all") != "true" {
+		statuses = slices.DeleteFunc(statuses, func(s ImageStatus) bool { return !s.Outdated && s.Error == "" })
+	}
+	c.JSON(http.StatusOK, statuses)
+}
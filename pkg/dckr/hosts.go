@@ -0,0 +1,50 @@
+package dckr
+
+import (
+	"context"
+	"fmt"
+
+	"i2/pkg/prxmx"
+	"i2/pkg/utils"
+)
+
+// SSHAddress returns the ssh:// address of the Docker of a host
+func SSHAddress(user, ip string) string {
+	if user == "" {
+		return "ssh://" + ip
+	}
+	return "ssh://" + user + "@" + ip
+}
+
+// HostClient returns a client for the Docker of a guest of the inventory,
+// reached over SSH as user, or for the local Docker when host is empty. The
+// guest is nil for the local Docker.
+func HostClient(ctx context.Context, inventory *prxmx.Inventory, host, user string) (*DockerClient, *prxmx.Node, error) {
+	if host == "" {
+		dc, err := NewDockerClient()
+		return dc, nil, err
+	}
+	vm, err := inventory.Get(ctx, host)
+	if err != nil {
+		return nil, nil, err
+	}
+	ip := utils.GetLocalIP(vm.IP)
+	if ip == "" {
+		return nil, nil, fmt.Errorf("%s has no address", vm.Name)
+	}
+	dc, err := NewDockerClientWithSSH(SSHAddress(user, ip))
+	if err != nil {
+		return nil, nil, err
+	}
+	return dc, &vm, nil
+}
+
+// RefreshContainers stores the running containers of a guest in the
+// containers bucket, the same entry containers --all --live writes
+func (dc *DockerClient) RefreshContainers(ctx context.Context, inventory *prxmx.Inventory, vm prxmx.Node) error {
+	containers, err := dc.ListContainers()
+	if err != nil {
+		return fmt.Errorf("failed to list the containers of %s: %w", vm.Name, err)
+	}
+	return inventory.SaveContainers(ctx, vm, dc.hostContainers(ctx, containers))
+}
@@ -0,0 +1,103 @@
+package dckr
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Container actions, the names used by the CLI and the API
+const (
+	ActionStart   = "start"
+	ActionStop    = "stop"
+	ActionRestart = "restart"
+	ActionKill    = "kill"
+	ActionRemove  = "rm"
+	ActionRename  = "rename"
+)
+
+// ActionOptions are the options of ContainerAction, each action only reads
+// its own: Timeout for stop and restart, Signal for kill, Force and Volumes
+// for rm and NewName for rename
+type ActionOptions struct {
+	Timeout *int
+	Signal  string
+	Force   bool
+	Volumes bool
+	NewName string
+}
+
+// ContainerAction runs one of the Action* actions on a container
+func (dc *DockerClient) ContainerAction(ctx context.Context, action, name string, opts ActionOptions) error {
+	switch action {
+	case ActionStart:
+		return dc.StartContainer(ctx, name)
+	case ActionStop:
+		return dc.StopContainer(ctx, name, opts.Timeout)
+	case ActionRestart:
+		return dc.RestartContainer(ctx, name, opts.Timeout)
+	case ActionKill:
+		return dc.KillContainer(ctx, name, opts.Signal)
+	case ActionRemove:
+		return dc.RemoveContainer(ctx, name, opts.Force, opts.Volumes)
+	case ActionRename:
+		if opts.NewName == "" {
+			return fmt.Errorf("rename needs the new name of %s", name)
+		}
+		return dc.RenameContainer(ctx, name, opts.NewName)
+	}
+	return fmt.Errorf("unknown container action %q", action)
+}
+
+// StartContainer starts a container by name or ID
+func (dc *DockerClient) StartContainer(ctx context.Context, name string) error {
+	if err := dc.cli.ContainerStart(ctx, name, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start %s: %w", name, err)
+	}
+	return nil
+}
+
+// StopContainer stops a container, it is killed after timeout seconds. A nil
+// timeout uses the stop timeout of the container, 10 seconds by default.
+func (dc *DockerClient) StopContainer(ctx context.Context, name string, timeout *int) error {
+	if err := dc.cli.ContainerStop(ctx, name, container.StopOptions{Timeout: timeout}); err != nil {
+		return fmt.Errorf("failed to stop %s: %w", name, err)
+	}
+	return nil
+}
+
+// RestartContainer stops and starts a container, the timeout is the one of
+// StopContainer
+func (dc *DockerClient) RestartContainer(ctx context.Context, name string, timeout *int) error {
+	if err := dc.cli.ContainerRestart(ctx, name, container.StopOptions{Timeout: timeout}); err != nil {
+		return fmt.Errorf("failed to restart %s: %w", name, err)
+	}
+	return nil
+}
+
+// KillContainer sends a signal to a container, SIGKILL when signal is empty
+func (dc *DockerClient) KillContainer(ctx context.Context, name, signal string) error {
+	if err := dc.cli.ContainerKill(ctx, name, signal); err != nil {
+		return fmt.Errorf("failed to kill %s: %w", name, err)
+	}
+	return nil
+}
+
+// RemoveContainer removes a container. Running containers are only removed
+// with force, anonymous volumes only with volumes.
+func (dc *DockerClient) RemoveContainer(ctx context.Context, name string, force, volumes bool) error {
+	err := dc.cli.ContainerRemove(ctx, name, container.RemoveOptions{Force: force, RemoveVolumes: volumes})
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %w", name, err)
+	}
+	return nil
+}
+
+// RenameContainer renames a container
+func (dc *DockerClient) RenameContainer(ctx context.Context, name, newName string) error {
+	if err := dc.cli.ContainerRename(ctx, name, newName); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", name, newName, err)
+	}
+	return nil
+}
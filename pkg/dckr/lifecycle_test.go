@@ -0,0 +1,54 @@
+package dckr
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContainerAction(t *testing.T) {
+	calls := []string{}
+	dc := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+		if r.URL.Path == "/v1.45/containers/missing/start" {
+			dockerError(w, http.StatusNotFound, "No such container: missing")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	ctx := context.Background()
+	timeout := 5
+
+	for _, tc := range []struct {
+		action string
+		opts   ActionOptions
+		call   string
+	}{
+		{ActionStart, ActionOptions{}, "POST /v1.45/containers/web/start?"},
+		{ActionStop, ActionOptions{}, "POST /v1.45/containers/web/stop?"},
+		{ActionStop, ActionOptions{Timeout: &timeout}, "POST /v1.45/containers/web/stop?t=5"},
+		{ActionRestart, ActionOptions{Timeout: &timeout}, "POST /v1.45/containers/web/restart?t=5"},
+		{ActionKill, ActionOptions{Signal: "SIGHUP"}, "POST /v1.45/containers/web/kill?signal=SIGHUP"},
+		{ActionRemove, ActionOptions{Force: true, Volumes: true}, "DELETE /v1.45/containers/web?force=1&v=1"},
+		{ActionRename, ActionOptions{NewName: "web-old"}, "POST /v1.45/containers/web/rename?name=web-old"},
+	} {
+		calls = nil
+		require.NoError(t, dc.ContainerAction(ctx, tc.action, "web", tc.opts), tc.action)
+		assert.Equal(t, []string{tc.call}, calls, tc.action)
+	}
+
+	assert.Error(t, dc.ContainerAction(ctx, ActionRename, "web", ActionOptions{}))
+	assert.Error(t, dc.ContainerAction(ctx, "pause", "web", ActionOptions{}))
+
+	err := dc.ContainerAction(ctx, ActionStart, "missing", ActionOptions{})
+	require.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, actionStatus(err))
+}
+
+func TestSSHAddress(t *testing.T) {
+	assert.Equal(t, "ssh://ops@192.168.1.20", SSHAddress("ops", "192.168.1.20"))
+	assert.Equal(t, "ssh://192.168.1.20", SSHAddress("", "192.168.1.20"))
+}
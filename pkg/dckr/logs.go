@@ -0,0 +1,144 @@
+package dckr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// LogOptions select the logs of a container. Since is a timestamp or a
+// duration (10m), Tail the number of lines from the end or all.
+type LogOptions struct {
+	Follow     bool
+	Since      string
+	Tail       string
+	Timestamps bool
+}
+
+// StreamLogs copies the logs of a container to stdout and stderr until the
+// logs end, or the context is cancelled when following. Docker multiplexes
+// both streams in one unless the container has a TTY.
+func (dc *DockerClient) StreamLogs(ctx context.Context, name string, opts LogOptions, stdout, stderr io.Writer) error {
+	info, err := dc.cli.ContainerInspect(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s: %w", name, err)
+	}
+	logs, err := dc.cli.ContainerLogs(ctx, name, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     opts.Follow,
+		Since:      opts.Since,
+		Tail:       opts.Tail,
+		Timestamps: opts.Timestamps,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read the logs of %s: %w", name, err)
+	}
+	defer logs.Close()
+
+	if info.Config != nil && info.Config.Tty {
+		_, err = io.Copy(stdout, logs)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, logs)
+	}
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to read the logs of %s: %w", name, err)
+	}
+	return nil
+}
+
+// ProjectContainers returns the containers of a Compose project, stopped
+// containers included
+func (dc *DockerClient) ProjectContainers(ctx context.Context, project string) ([]types.Container, error) {
+	containers, err := dc.cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", ComposeProjectLabel+"="+project)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the containers of %s: %w", project, err)
+	}
+	return containers, nil
+}
+
+// PrefixWriter writes every line with a prefix. Writers sharing a mutex
+// write whole lines, so the logs of several containers interleave line by
+// line. Close writes the last line when it has no newline.
+type PrefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+// NewPrefixWriter returns a PrefixWriter, mu is shared by the writers of the
+// same output
+func NewPrefixWriter(w io.Writer, prefix string, mu *sync.Mutex) *PrefixWriter {
+	return &PrefixWriter{w: w, prefix: []byte(prefix), mu: mu}
+}
+
+func (pw *PrefixWriter) Write(p []byte) (int, error) {
+	pw.buf = append(pw.buf, p...)
+	var out []byte
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		out = append(out, pw.prefix...)
+		out = append(out, pw.buf[:i+1]...)
+		pw.buf = pw.buf[i+1:]
+	}
+	if len(out) > 0 {
+		pw.mu.Lock()
+		defer pw.mu.Unlock()
+		if _, err := pw.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close writes the pending line
+func (pw *PrefixWriter) Close() error {
+	if len(pw.buf) == 0 {
+		return nil
+	}
+	_, err := pw.Write([]byte("\n"))
+	return err
+}
+
+// LogSource is a container whose logs are interleaved, Prefix starts each of
+// its lines
+type LogSource struct {
+	Client    *DockerClient
+	Container string
+	Prefix    string
+}
+
+// InterleaveLogs streams the logs of every source concurrently, line by line.
+// A source failing is passed to onError and doesn't stop the others.
+func InterleaveLogs(ctx context.Context, sources []LogSource, opts LogOptions, stdout, stderr io.Writer, onError func(LogSource, error)) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		out := NewPrefixWriter(stdout, source.Prefix, &mu)
+		errOut := NewPrefixWriter(stderr, source.Prefix, &mu)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := source.Client.StreamLogs(ctx, source.Container, opts, out, errOut); err != nil {
+				onError(source, err)
+			}
+			out.Close()
+			errOut.Close()
+		}()
+	}
+	wg.Wait()
+}
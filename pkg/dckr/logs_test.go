@@ -0,0 +1,88 @@
+package dckr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogs(t *testing.T) {
+	// web is multiplexed, tty is raw
+	dc := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		tty := strings.Contains(r.URL.Path, "/containers/tty/")
+		switch {
+		case strings.Contains(r.URL.Path, "/containers/missing/"):
+			dockerError(w, http.StatusNotFound, "No such container: missing")
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"Id": "abc", "Config": map[string]any{"Tty": tty}})
+		case strings.HasSuffix(r.URL.Path, "/logs"):
+			assert.Equal(t, "1", r.URL.Query().Get("stdout"))
+			assert.Equal(t, "1", r.URL.Query().Get("stderr"))
+			assert.Equal(t, "10", r.URL.Query().Get("tail"))
+			if tty {
+				_, _ = w.Write([]byte("raw line\n"))
+				return
+			}
+			_, _ = stdcopy.NewStdWriter(w, stdcopy.Stdout).Write([]byte("listening on :8080\n"))
+			_, _ = stdcopy.NewStdWriter(w, stdcopy.Stderr).Write([]byte("warning: no cache\n"))
+			_, _ = stdcopy.NewStdWriter(w, stdcopy.Stdout).Write([]byte("GET /"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		container, stdout, stderr string
+		err                       bool
+	}{
+		{"web", "listening on :8080\nGET /", "warning: no cache\n", false},
+		{"tty", "raw line\n", "", false},
+		{"missing", "", "", true},
+	} {
+		var stdout, stderr bytes.Buffer
+		err := dc.StreamLogs(ctx, tc.container, LogOptions{Tail: "10"}, &stdout, &stderr)
+		assert.Equal(t, tc.err, err != nil, tc.container)
+		assert.Equal(t, tc.stdout, stdout.String(), tc.container)
+		assert.Equal(t, tc.stderr, stderr.String(), tc.container)
+	}
+
+	var stdout, stderr bytes.Buffer
+	var mu sync.Mutex
+	failed := []string{}
+	InterleaveLogs(ctx, []LogSource{
+		{Client: dc, Container: "web", Prefix: "vm1/web | "},
+		{Client: dc, Container: "tty", Prefix: "vm2/tty | "},
+		{Client: dc, Container: "missing", Prefix: "vm2/missing | "},
+	}, LogOptions{Tail: "10"}, &stdout, &stderr, func(source LogSource, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, source.Container)
+	})
+
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	assert.ElementsMatch(t, []string{"vm1/web | listening on :8080", "vm1/web | GET /", "vm2/tty | raw line"}, lines)
+	assert.Equal(t, "vm1/web | warning: no cache\n", stderr.String())
+	assert.Equal(t, []string{"missing"}, failed)
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	w := NewPrefixWriter(&out, "> ", &mu)
+	_, _ = w.Write([]byte("first li"))
+	assert.Empty(t, out.String(), "partial lines are kept")
+	_, _ = w.Write([]byte("ne\nsecond\nthi"))
+	assert.Equal(t, "> first line\n> second\n", out.String())
+	require.NoError(t, w.Close())
+	assert.Equal(t, "> first line\n> second\n> thi\n", out.String())
+}
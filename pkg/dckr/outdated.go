@@ -0,0 +1,114 @@
+package dckr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"i2/pkg/prxmx"
+
+	"github.com/distribution/reference"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OutdatedGauge is 1 for every running container whose tag points to another
+// image in its registry, set by SetOutdatedGauge
+var OutdatedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "i2_container_image_outdated",
+	Help: "Running container whose image tag was updated in its registry",
+}, []string{"vm", "container", "image"})
+
+func init() {
+	prometheus.MustRegister(OutdatedGauge)
+}
+
+// ImageStatus compares the image of a running container with the image its
+// tag points to in the registry. Error is set when the registry couldn't be
+// read, Outdated is false then.
+type ImageStatus struct {
+	VM        string   `json:"vm"`
+	Container string   `json:"container"`
+	Image     string   `json:"image"`
+	Local     string   `json:"local"`
+	Published []string `json:"published,omitempty"`
+	Outdated  bool     `json:"outdated"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// OutdatedContainers checks the images of the running containers of every
+// guest in the containers bucket against their registries
+func OutdatedContainers(ctx context.Context, inventory *prxmx.Inventory, registry *Registry) ([]ImageStatus, error) {
+	stored, err := inventory.Containers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the containers bucket: %w", err)
+	}
+	keys := make([]string, 0, len(stored))
+	for key := range stored {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	statuses := []ImageStatus{}
+	for _, key := range keys {
+		var containers []HostContainer
+		if err := json.Unmarshal(stored[key], &containers); err != nil {
+			return nil, fmt.Errorf("invalid containers of %s: %w", key, err)
+		}
+		vm := key
+		if node, err := inventory.GetByKey(ctx, key); err == nil {
+			vm = node.Name
+		}
+		statuses = append(statuses, CheckImages(ctx, registry, vm, containers)...)
+	}
+	return statuses, nil
+}
+
+// CheckImages compares the images of the running containers of a guest with
+// their registries. Containers created from an image ID or a digest are
+// skipped, their image can't change. Multi-platform images are compared
+// with the manifest of the platform of the local image.
+func CheckImages(ctx context.Context, registry *Registry, vm string, containers []HostContainer) []ImageStatus {
+	statuses := []ImageStatus{}
+	for _, c := range containers {
+		if c.State != "running" || !isTagged(c.Image) {
+			continue
+		}
+		status := ImageStatus{VM: vm, Container: GetContainerName(c.Names), Image: c.Image, Local: c.ImageID}
+		published, err := registry.PublishedDigests(ctx, c.Image, c.Platform)
+		if err != nil {
+			status.Error = err.Error()
+		} else {
+			status.Published = published
+			status.Outdated = !slices.Contains(published, c.ImageID)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// SetOutdatedGauge sets OutdatedGauge to the outdated containers of statuses,
+// dropping the containers of a previous check
+func SetOutdatedGauge(statuses []ImageStatus) {
+	OutdatedGauge.Reset()
+	for _, s := range statuses {
+		if s.Outdated {
+			OutdatedGauge.WithLabelValues(s.VM, s.Container, s.Image).Set(1)
+		}
+	}
+}
+
+// isTagged reports whether an image reference names a tag, implicitly latest
+func isTagged(image string) bool {
+	if strings.HasPrefix(image, "sha256:") {
+		return false
+	}
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return false
+	}
+	_, digested := named.(reference.Digested)
+	return !digested
+}
@@ -0,0 +1,270 @@
+package dckr
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"i2/pkg/models"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+)
+
+// PruneProtectedLabel protects any resource from i2 prune, on top of the
+// protected labels of the policy
+const PruneProtectedLabel = "i2.protected"
+
+// Kinds of the resources removed by i2 prune, in the order they're removed
+const (
+	PruneContainer = "container"
+	PruneImage     = "image"
+	PruneVolume    = "volume"
+	PruneNetwork   = "network"
+)
+
+// PruneItem is a resource of a Docker host that can be removed. Size is the
+// space it takes, without the layers an image shares with other images like
+// docker system df. Keep is why the policy keeps it, it's removed when empty.
+type PruneItem struct {
+	Kind   string `json:"kind"`
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"`
+	Keep   string `json:"keep,omitempty"`
+}
+
+// PrunePlan is what i2 prune would remove from a Docker host
+type PrunePlan struct {
+	Items []PruneItem `json:"items"`
+}
+
+// Pending returns the items the policy removes
+func (p PrunePlan) Pending() []PruneItem {
+	pending := []PruneItem{}
+	for _, item := range p.Items {
+		if item.Keep == "" {
+			pending = append(pending, item)
+		}
+	}
+	return pending
+}
+
+// Reclaimable returns the space of the items the policy removes by kind
+func (p PrunePlan) Reclaimable() map[string]int64 {
+	sizes := map[string]int64{}
+	for _, item := range p.Pending() {
+		sizes[item.Kind] += max(item.Size, 0)
+	}
+	return sizes
+}
+
+// PrunePlan lists the stopped containers, unused images, orphaned volumes and
+// unused networks of the Docker host and plans their removal with a policy
+func (dc *DockerClient) PrunePlan(ctx context.Context, policy models.Prune, now time.Time) (PrunePlan, error) {
+	containers, err := dc.cli.ContainerList(ctx, container.ListOptions{All: true, Size: true})
+	if err != nil {
+		return PrunePlan{}, fmt.Errorf("failed to list containers: %w", err)
+	}
+	stopped := map[string]time.Time{}
+	for _, c := range containers {
+		if isStopped(c) {
+			stopped[c.ID] = dc.stoppedAt(ctx, c)
+		}
+	}
+	images, err := dc.cli.ImageList(ctx, image.ListOptions{All: true, SharedSize: true})
+	if err != nil {
+		return PrunePlan{}, fmt.Errorf("failed to list images: %w", err)
+	}
+	volumes, err := dc.ListVolumes(ctx)
+	if err != nil {
+		return PrunePlan{}, err
+	}
+	networks, err := dc.ListNetworks(ctx)
+	if err != nil {
+		return PrunePlan{}, err
+	}
+	return planPrune(policy.WithDefaults(), now, containers, stopped, images, volumes, networks), nil
+}
+
+// Prune removes the items of a plan the policy doesn't keep, containers
+// first so their images, volumes and networks are no longer used. Every
+// item is reported to progress with its error, the space reclaimed is
+// returned.
+func (dc *DockerClient) Prune(ctx context.Context, plan PrunePlan, progress func(PruneItem, error)) int64 {
+	var reclaimed int64
+	for _, item := range plan.Pending() {
+		var err error
+		switch item.Kind {
+		case PruneContainer:
+			err = dc.cli.ContainerRemove(ctx, item.ID, container.RemoveOptions{})
+		case PruneImage:
+			// unused images tagged in several repositories need force
+			_, err = dc.cli.ImageRemove(ctx, item.ID, image.RemoveOptions{Force: true, PruneChildren: true})
+		case PruneVolume:
+			err = dc.cli.VolumeRemove(ctx, item.ID, false)
+		case PruneNetwork:
+			err = dc.cli.NetworkRemove(ctx, item.ID)
+		default:
+			err = fmt.Errorf("unknown kind %q", item.Kind)
+		}
+		if err == nil {
+			reclaimed += max(item.Size, 0)
+		}
+		progress(item, err)
+	}
+	return reclaimed
+}
+
+// planPrune plans the removal of the resources of a host, stopped holds when
+// the stopped containers stopped
+func planPrune(policy models.Prune, now time.Time, containers []types.Container, stopped map[string]time.Time, images []image.Summary, volumes []*volume.Volume, networks []network.Summary) PrunePlan {
+	protected := append([]string{PruneProtectedLabel}, policy.ProtectedLabels...)
+	plan := PrunePlan{Items: []PruneItem{}}
+
+	// resources used by the containers that stay
+	usedImages := map[string]bool{}
+	usedVolumes := map[string]bool{}
+	usedNetworks := map[string]bool{}
+	for _, c := range containers {
+		item := PruneItem{Kind: PruneContainer, ID: c.ID, Name: GetContainerName(c.Names), Size: c.SizeRw}
+		if at, ok := stopped[c.ID]; ok && now.Sub(at) >= days(policy.ContainerDays) {
+			item.Reason = "stopped " + ago(now, at)
+			item.Keep = protectedBy(c.Labels, protected)
+			plan.Items = append(plan.Items, item)
+			if item.Keep == "" {
+				continue
+			}
+		}
+		usedImages[c.ImageID] = true
+		for _, m := range c.Mounts {
+			if m.Name != "" {
+				usedVolumes[m.Name] = true
+			}
+		}
+		if c.NetworkSettings != nil {
+			for name, settings := range c.NetworkSettings.Networks {
+				usedNetworks[name] = true
+				if settings != nil {
+					usedNetworks[settings.NetworkID] = true
+				}
+			}
+		}
+	}
+
+	parents := map[string]bool{}
+	for _, img := range images {
+		parents[img.ParentID] = true
+	}
+	for _, img := range images {
+		if usedImages[img.ID] || parents[img.ID] {
+			continue
+		}
+		// SharedSize is -1 when Docker didn't compute it
+		item := PruneItem{Kind: PruneImage, ID: img.ID, Name: imageName(img), Size: img.Size - max(img.SharedSize, 0)}
+		created := time.Unix(img.Created, 0)
+		switch {
+		case isDangling(img):
+			item.Reason = "dangling"
+		case now.Sub(created) >= days(policy.ImageDays):
+			item.Reason = "unused, created " + ago(now, created)
+		default:
+			continue
+		}
+		item.Keep = protectedBy(img.Labels, protected)
+		plan.Items = append(plan.Items, item)
+	}
+
+	for _, v := range volumes {
+		if usedVolumes[v.Name] {
+			continue
+		}
+		item := PruneItem{Kind: PruneVolume, ID: v.Name, Name: v.Name, Size: -1, Reason: "orphaned"}
+		if v.UsageData != nil {
+			item.Size = v.UsageData.Size
+		}
+		item.Keep = protectedBy(v.Labels, protected)
+		if item.Keep == "" && !policy.Volumes {
+			item.Keep = "volumes aren't pruned by the policy"
+		}
+		plan.Items = append(plan.Items, item)
+	}
+
+	for _, n := range networks {
+		if usedNetworks[n.ID] || usedNetworks[n.Name] || slices.Contains([]string{"bridge", "host", "none"}, n.Name) || n.Scope == "swarm" {
+			continue
+		}
+		item := PruneItem{Kind: PruneNetwork, ID: n.ID, Name: n.Name, Reason: "unused"}
+		item.Keep = protectedBy(n.Labels, protected)
+		plan.Items = append(plan.Items, item)
+	}
+	return plan
+}
+
+// stoppedAt returns when a stopped container finished, or was created when
+// it never ran
+func (dc *DockerClient) stoppedAt(ctx context.Context, c types.Container) time.Time {
+	created := time.Unix(c.Created, 0)
+	info, err := dc.cli.ContainerInspect(ctx, c.ID)
+	if err != nil || info.State == nil {
+		return created
+	}
+	finished, err := time.Parse(time.RFC3339Nano, info.State.FinishedAt)
+	if err != nil || finished.Before(created) {
+		return created
+	}
+	return finished
+}
+
+// isStopped reports whether a container isn't running, paused or restarting
+func isStopped(c types.Container) bool {
+	return c.State == "exited" || c.State == "created" || c.State == "dead"
+}
+
+// isDangling reports whether an image has no tag
+func isDangling(img image.Summary) bool {
+	return !slices.ContainsFunc(img.RepoTags, func(tag string) bool { return tag != "<none>:<none>" })
+}
+
+// imageName returns the tags of an image, its short ID when it has none
+func imageName(img image.Summary) string {
+	if !isDangling(img) {
+		return strings.Join(img.RepoTags, ", ")
+	}
+	_, id, _ := strings.Cut(img.ID, ":")
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return "<none>@" + id
+}
+
+// protectedBy returns why resource labels are protected, labels are key or
+// key=value
+func protectedBy(labels map[string]string, protected []string) string {
+	for _, label := range protected {
+		key, value, hasValue := strings.Cut(label, "=")
+		if v, ok := labels[key]; ok && (!hasValue || v == value) {
+			return "protected by " + label
+		}
+	}
+	return ""
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
+
+// ago returns how long ago a time was in days, or hours within a day
+func ago(now, t time.Time) string {
+	d := now.Sub(t)
+	if d < 24*time.Hour {
+		return fmt.Sprintf("%d hours ago", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d days ago", int(d.Hours()/24))
+}
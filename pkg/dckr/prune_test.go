@@ -0,0 +1,126 @@
+package dckr
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"i2/pkg/models"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanPrune(t *testing.T) {
+	now := time.Date(2024, 9, 30, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	policy := models.Prune{ProtectedLabels: []string{"env=prod"}}.WithDefaults()
+
+	containers := []types.Container{
+		{ID: "web", Names: []string{"/web"}, State: "running", ImageID: "sha256:nginx",
+			Mounts:          []types.MountPoint{{Type: "volume", Name: "web-data"}},
+			NetworkSettings: &types.SummaryNetworkSettings{Networks: map[string]*network.EndpointSettings{"shop_default": {NetworkID: "n-shop"}}}},
+		{ID: "old", Names: []string{"/old"}, State: "exited", ImageID: "sha256:old", SizeRw: 100,
+			Mounts:          []types.MountPoint{{Type: "volume", Name: "old-data"}},
+			NetworkSettings: &types.SummaryNetworkSettings{Networks: map[string]*network.EndpointSettings{"old_default": {NetworkID: "n-old"}}}},
+		{ID: "recent", Names: []string{"/recent"}, State: "exited", ImageID: "sha256:recent"},
+		{ID: "backup", Names: []string{"/backup"}, State: "exited", ImageID: "sha256:backup", Labels: map[string]string{"env": "prod"}},
+	}
+	stopped := map[string]time.Time{"old": now.Add(-10 * day), "recent": now.Add(-2 * day), "backup": now.Add(-90 * day)}
+	images := []image.Summary{
+		{ID: "sha256:nginx", RepoTags: []string{"nginx:1.27"}, Created: now.Add(-400 * day).Unix(), Size: 190},
+		{ID: "sha256:old", RepoTags: []string{"old:1"}, Created: now.Add(-60 * day).Unix(), Size: 500, SharedSize: 300},
+		{ID: "sha256:recent", RepoTags: []string{"recent:1"}, Created: now.Add(-60 * day).Unix(), Size: 600},
+		{ID: "sha256:backup", RepoTags: []string{"backup:1"}, Created: now.Add(-60 * day).Unix(), Size: 700},
+		{ID: "sha256:new", RepoTags: []string{"new:1"}, Created: now.Add(-3 * day).Unix(), Size: 800},
+		{ID: "sha256:0123456789abcdef", RepoTags: []string{"<none>:<none>"}, Created: now.Add(-time.Hour).Unix(), Size: 50, SharedSize: -1},
+		{ID: "sha256:parent", Created: now.Add(-60 * day).Unix(), Size: 10},
+		{ID: "sha256:child", ParentID: "sha256:parent", RepoTags: []string{"child:1"}, Created: now.Add(-60 * day).Unix(), Size: 20, Labels: map[string]string{PruneProtectedLabel: "true"}},
+	}
+	volumes := []*volume.Volume{
+		{Name: "web-data", UsageData: &volume.UsageData{Size: 1000}},
+		{Name: "old-data", UsageData: &volume.UsageData{Size: 2000}},
+		{Name: "gone", UsageData: &volume.UsageData{Size: -1}},
+	}
+	networks := []network.Summary{
+		{ID: "n-bridge", Name: "bridge"},
+		{ID: "n-shop", Name: "shop_default"},
+		{ID: "n-old", Name: "old_default"},
+	}
+
+	plan := planPrune(policy, now, containers, stopped, images, volumes, networks)
+	summary := map[string]string{}
+	for _, item := range plan.Items {
+		summary[item.Kind+" "+item.Name] = item.Reason + "|" + item.Keep
+	}
+	assert.Equal(t, map[string]string{
+		"container old":             "stopped 10 days ago|",
+		"container backup":          "stopped 90 days ago|protected by env=prod",
+		"image old:1":               "unused, created 60 days ago|",
+		"image <none>@0123456789ab": "dangling|",
+		"image child:1":             "unused, created 60 days ago|protected by " + PruneProtectedLabel,
+		"volume old-data":           "orphaned|volumes aren't pruned by the policy",
+		"volume gone":               "orphaned|volumes aren't pruned by the policy",
+		"network old_default":       "unused|",
+	}, summary)
+	assert.Equal(t, map[string]int64{PruneContainer: 100, PruneImage: 250, PruneNetwork: 0}, plan.Reclaimable(), "shared layers aren't reclaimed")
+
+	policy.Volumes = true
+	plan = planPrune(policy, now, containers, stopped, images, volumes, networks)
+	assert.Equal(t, int64(2000), plan.Reclaimable()[PruneVolume])
+	assert.Len(t, plan.Pending(), 6)
+}
+
+func TestProtectedBy(t *testing.T) {
+	protected := []string{PruneProtectedLabel, "env=prod"}
+	assert.Equal(t, "protected by i2.protected", protectedBy(map[string]string{PruneProtectedLabel: ""}, protected))
+	assert.Equal(t, "protected by env=prod", protectedBy(map[string]string{"env": "prod"}, protected))
+	assert.Empty(t, protectedBy(map[string]string{"env": "dev"}, protected))
+	assert.Empty(t, protectedBy(nil, protected))
+}
+
+func TestPrune(t *testing.T) {
+	var mu sync.Mutex
+	calls := []string{}
+	dc := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1.45/images/sha256:old":
+			_, _ = w.Write([]byte(`[{"Deleted": "sha256:old"}]`))
+		case "/v1.45/volumes/busy":
+			dockerError(w, http.StatusConflict, "volume is in use")
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	plan := PrunePlan{Items: []PruneItem{
+		{Kind: PruneContainer, ID: "old", Size: 100},
+		{Kind: PruneContainer, ID: "backup", Size: 100, Keep: "protected by env=prod"},
+		{Kind: PruneImage, ID: "sha256:old", Size: 500},
+		{Kind: PruneVolume, ID: "busy", Size: 2000},
+		{Kind: PruneNetwork, ID: "n-old"},
+	}}
+	failed := []string{}
+	reclaimed := dc.Prune(context.Background(), plan, func(item PruneItem, err error) {
+		if err != nil {
+			failed = append(failed, item.ID)
+		}
+	})
+	assert.Equal(t, int64(600), reclaimed)
+	assert.Equal(t, []string{"busy"}, failed)
+	assert.Equal(t, []string{
+		"DELETE /v1.45/containers/old",
+		"DELETE /v1.45/images/sha256:old",
+		"DELETE /v1.45/volumes/busy",
+		"DELETE /v1.45/networks/n-old",
+	}, calls)
+}
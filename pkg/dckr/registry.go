@@ -0,0 +1,344 @@
+package dckr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/distribution/reference"
+	"github.com/docker/cli/cli/config"
+	configtypes "github.com/docker/cli/cli/config/types"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Media types of the manifests asked to registries, the Docker ones for
+// registries without OCI support
+const (
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// dockerHubAuthKey is the key of the Docker Hub credentials in the Docker
+// config
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// Registry reads the manifests published in image registries. Registries
+// on loopback addresses are reached over plain HTTP, like Docker does.
+type Registry struct {
+	HTTP *http.Client
+	// Credentials returns the credentials of a registry host, the Docker
+	// config ones by default
+	Credentials func(host string) (configtypes.AuthConfig, error)
+
+	mu      sync.Mutex
+	digests map[string][]string
+}
+
+// NewRegistry returns a Registry using the credentials of the Docker config
+func NewRegistry() *Registry {
+	return &Registry{HTTP: http.DefaultClient, Credentials: DockerConfigCredentials}
+}
+
+// DockerConfigCredentials returns the credentials docker login stored for a
+// registry host, in ~/.docker/config.json or its credential helper
+func DockerConfigCredentials(host string) (configtypes.AuthConfig, error) {
+	key := host
+	if host == "docker.io" {
+		key = dockerHubAuthKey
+	}
+	return config.LoadDefaultConfigFile(io.Discard).GetAuthConfig(key)
+}
+
+// registryAuth returns the X-Registry-Auth header of a pull of an image,
+// the encoded credentials of its registry
+func registryAuth(ref string, credentials func(host string) (configtypes.AuthConfig, error)) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid image %s: %w", ref, err)
+	}
+	if credentials == nil {
+		credentials = DockerConfigCredentials
+	}
+	host := reference.Domain(named)
+	auth, err := credentials(host)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the credentials of %s: %w", host, err)
+	}
+	return registry.EncodeAuthConfig(registry.AuthConfig{
+		Username:      auth.Username,
+		Password:      auth.Password,
+		Auth:          auth.Auth,
+		ServerAddress: auth.ServerAddress,
+		IdentityToken: auth.IdentityToken,
+		RegistryToken: auth.RegistryToken,
+	})
+}
+
+// PublishedDigests returns the digests of what a tag points to in its
+// registry: the tag itself, the manifest of platform, os/arch[/variant], when
+// the tag is a multi-platform index, and the image config. Depending on its
+// image store, Docker identifies an image with one of them. Results are
+// cached.
+func (r *Registry) PublishedDigests(ctx context.Context, ref, platform string) ([]string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image %s: %w", ref, err)
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return nil, fmt.Errorf("image %s is pinned to a digest", ref)
+	}
+	tagged := reference.TagNameOnly(named).(reference.NamedTagged)
+
+	key := tagged.String() + " " + platform
+	r.mu.Lock()
+	cached, ok := r.digests[key]
+	r.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	repo := &repository{registry: r, host: reference.Domain(tagged), path: reference.Path(tagged), platform: platform}
+	digests, err := repo.resolve(ctx, tagged.Tag())
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	if r.digests == nil {
+		r.digests = map[string][]string{}
+	}
+	r.digests[key] = digests
+	r.mu.Unlock()
+	return digests, nil
+}
+
+// repository is an image repository of a registry, token holds the bearer
+// token once the registry asked for one. platform picks the manifest of
+// multi-platform images.
+type repository struct {
+	registry *Registry
+	host     string
+	path     string
+	platform string
+	token    string
+}
+
+// baseURL returns the URL of the registry API of the repository
+func (repo *repository) baseURL() string {
+	host := repo.host
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	scheme := "https"
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	if ip := net.ParseIP(hostname); hostname == "localhost" || (ip != nil && ip.IsLoopback()) {
+		scheme = "http"
+	}
+	return scheme + "://" + host + "/v2/" + repo.path
+}
+
+// resolve returns the digests of a tag, see PublishedDigests
+func (repo *repository) resolve(ctx context.Context, tag string) ([]string, error) {
+	body, tagDigest, err := repo.manifest(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	digests := []string{tagDigest}
+	var m struct {
+		Manifests []ocispec.Descriptor `json:"manifests"`
+		Config    ocispec.Descriptor   `json:"config"`
+	}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest of %s:%s: %w", repo.path, tag, err)
+	}
+	if len(m.Manifests) > 0 {
+		platform, err := repo.pick(m.Manifests)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%s: %w", repo.path, tag, err)
+		}
+		body, _, err = repo.manifest(ctx, platform.Digest.String())
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, platform.Digest.String())
+		m.Config = ocispec.Descriptor{}
+		if err := json.Unmarshal(body, &m); err != nil {
+			return nil, fmt.Errorf("invalid manifest of %s@%s: %w", repo.path, platform.Digest, err)
+		}
+	}
+	if m.Config.Digest != "" {
+		digests = append(digests, m.Config.Digest.String())
+	}
+	return digests, nil
+}
+
+// pick returns the manifest of the platform of the repository in an index
+func (repo *repository) pick(manifests []ocispec.Descriptor) (ocispec.Descriptor, error) {
+	if repo.platform == "" {
+		return ocispec.Descriptor{}, fmt.Errorf("unknown platform of the local image, refresh the containers")
+	}
+	want := strings.Split(repo.platform, "/")
+	for _, m := range manifests {
+		p := m.Platform
+		if p == nil || p.OS != want[0] || len(want) < 2 || p.Architecture != want[1] {
+			continue
+		}
+		if len(want) > 2 && p.Variant != want[2] {
+			continue
+		}
+		return m, nil
+	}
+	return ocispec.Descriptor{}, fmt.Errorf("no manifest for %s", repo.platform)
+}
+
+// manifest returns a manifest by tag or digest and its digest
+func (repo *repository) manifest(ctx context.Context, ref string) ([]byte, string, error) {
+	resp, err := repo.get(ctx, repo.baseURL()+"/manifests/"+ref, strings.Join([]string{
+		ocispec.MediaTypeImageIndex,
+		ocispec.MediaTypeImageManifest,
+		mediaTypeDockerManifestList,
+		mediaTypeDockerManifest,
+	}, ", "))
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to get the manifest of %s:%s: %s", repo.path, ref, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read the manifest of %s:%s: %w", repo.path, ref, err)
+	}
+	d := resp.Header.Get("Docker-Content-Digest")
+	if d == "" {
+		d = digest.FromBytes(body).String()
+	}
+	return body, d, nil
+}
+
+// get sends a request to the registry, answering its authentication
+// challenge once: basic auth, or a bearer token from its token server
+func (repo *repository) get(ctx context.Context, rawURL, accept string) (*http.Response, error) {
+	do := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", accept)
+		if repo.token != "" {
+			req.Header.Set("Authorization", "Bearer "+repo.token)
+		}
+		resp, err := repo.registry.HTTP.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reach %s: %w", repo.host, err)
+		}
+		return resp, nil
+	}
+	resp, err := do()
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+
+	creds, err := repo.registry.Credentials(repo.host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the credentials of %s: %w", repo.host, err)
+	}
+	scheme, params := parseChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if creds.Username == "" {
+			return nil, fmt.Errorf("%s needs credentials, docker login %s", repo.host, repo.host)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", accept)
+		req.SetBasicAuth(creds.Username, creds.Password)
+		return repo.registry.HTTP.Do(req)
+	case "bearer":
+		if repo.token, err = repo.fetchToken(ctx, params, creds); err != nil {
+			return nil, err
+		}
+		return do()
+	}
+	return nil, fmt.Errorf("unsupported authentication %q of %s", challenge, repo.host)
+}
+
+// fetchToken gets a pull token of the repository from the token server of a
+// bearer challenge, anonymously when there are no credentials
+func (repo *repository) fetchToken(ctx context.Context, params map[string]string, creds configtypes.AuthConfig) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid token realm %q of %s", params["realm"], repo.host)
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", "repository:"+repo.path+":pull")
+	realm.RawQuery = query.Encode()
+
+	if creds.RegistryToken != "" {
+		return creds.RegistryToken, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case creds.IdentityToken != "":
+		req.SetBasicAuth("<token>", creds.IdentityToken)
+	case creds.Username != "":
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	resp, err := repo.registry.HTTP.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get a token for %s: %w", repo.path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get a token for %s: %s", repo.path, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid token for %s: %w", repo.path, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return token.Token, nil
+}
+
+// parseChallenge parses a WWW-Authenticate header, Bearer realm="...",service="..."
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := map[string]string{}
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, ", "), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return strings.ToLower(scheme), params
+}
@@ -0,0 +1,213 @@
+package dckr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	configtypes "github.com/docker/cli/cli/config/types"
+	"github.com/docker/docker/api/types"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRegistry stands in for registry:2 behind a token server. app:1.0 is a
+// multi-platform index, web:latest a single manifest. Tokens are only given
+// to ci:secret.
+type fakeRegistry struct {
+	host    string
+	mu      sync.Mutex
+	calls   []string
+	index   digest.Digest
+	amd64   digest.Digest
+	arm64   digest.Digest
+	config  digest.Digest
+	web     digest.Digest
+	webConf digest.Digest
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	t.Helper()
+	fr := &fakeRegistry{}
+	manifests := map[string][]byte{}
+	add := func(body any) digest.Digest {
+		b, err := json.Marshal(body)
+		require.NoError(t, err)
+		d := digest.FromBytes(b)
+		manifests[d.String()] = b
+		return d
+	}
+	image := func(config string) (digest.Digest, digest.Digest) {
+		conf := digest.FromString(config)
+		return add(ocispec.Manifest{MediaType: ocispec.MediaTypeImageManifest, Config: ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: conf}}), conf
+	}
+	fr.amd64, fr.config = image("app amd64")
+	fr.arm64, _ = image("app arm64")
+	fr.index = add(ocispec.Index{MediaType: ocispec.MediaTypeImageIndex, Manifests: []ocispec.Descriptor{
+		{MediaType: ocispec.MediaTypeImageManifest, Digest: fr.arm64, Platform: &ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+		{MediaType: ocispec.MediaTypeImageManifest, Digest: fr.amd64, Platform: &ocispec.Platform{OS: "linux", Architecture: "amd64"}},
+	}})
+	fr.web, fr.webConf = image("web")
+	tags := map[string]digest.Digest{"/v2/team/app/manifests/1.0": fr.index, "/v2/web/manifests/latest": fr.web}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fr.mu.Lock()
+		fr.calls = append(fr.calls, r.URL.Path)
+		fr.mu.Unlock()
+		if r.URL.Path == "/token" {
+			user, password, _ := r.BasicAuth()
+			if user != "ci" || password != "secret" || r.URL.Query().Get("service") != "fake" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"token": "t-" + r.URL.Query().Get("scope")})
+			return
+		}
+		repo, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v2/"), "/manifests/")
+		if r.Header.Get("Authorization") != "Bearer t-repository:"+repo+":pull" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="fake",scope="repository:`+repo+`:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		d, ok := tags[r.URL.Path]
+		if !ok {
+			_, ref, _ := strings.Cut(r.URL.Path, "/manifests/")
+			d = digest.Digest(ref)
+		}
+		body, ok := manifests[d.String()]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", d.String())
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	fr.host = server.Listener.Addr().String()
+	return fr
+}
+
+func (fr *fakeRegistry) registry() *Registry {
+	return &Registry{HTTP: http.DefaultClient, Credentials: func(host string) (configtypes.AuthConfig, error) {
+		if host != fr.host {
+			return configtypes.AuthConfig{}, nil
+		}
+		return configtypes.AuthConfig{Username: "ci", Password: "secret"}, nil
+	}}
+}
+
+func (fr *fakeRegistry) requests() int {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return len(fr.calls)
+}
+
+func TestPublishedDigests(t *testing.T) {
+	fr := newFakeRegistry(t)
+	ctx := context.Background()
+
+	reg := fr.registry()
+	digests, err := reg.PublishedDigests(ctx, fr.host+"/team/app:1.0", "linux/amd64")
+	require.NoError(t, err)
+	assert.Equal(t, []string{fr.index.String(), fr.amd64.String(), fr.config.String()}, digests)
+
+	calls := fr.requests()
+	_, err = reg.PublishedDigests(ctx, fr.host+"/team/app:1.0", "linux/amd64")
+	require.NoError(t, err)
+	assert.Equal(t, calls, fr.requests(), "digests are cached")
+
+	digests, err = reg.PublishedDigests(ctx, fr.host+"/team/app:1.0", "linux/arm64/v8")
+	require.NoError(t, err)
+	assert.Equal(t, fr.arm64.String(), digests[1])
+
+	digests, err = reg.PublishedDigests(ctx, fr.host+"/web", "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{fr.web.String(), fr.webConf.String()}, digests)
+
+	_, err = reg.PublishedDigests(ctx, fr.host+"/team/app:1.0", "windows/amd64")
+	assert.ErrorContains(t, err, "no manifest for windows/amd64")
+	_, err = reg.PublishedDigests(ctx, fr.host+"/team/app:1.0", "")
+	assert.ErrorContains(t, err, "unknown platform")
+	_, err = reg.PublishedDigests(ctx, fr.host+"/team/app:2.0", "linux/amd64")
+	assert.ErrorContains(t, err, "404")
+	_, err = reg.PublishedDigests(ctx, fr.host+"/web@"+fr.web.String(), "linux/amd64")
+	assert.ErrorContains(t, err, "pinned")
+
+	anonymous := &Registry{HTTP: http.DefaultClient, Credentials: func(string) (configtypes.AuthConfig, error) {
+		return configtypes.AuthConfig{}, nil
+	}}
+	_, err = anonymous.PublishedDigests(ctx, fr.host+"/web", "linux/amd64")
+	assert.ErrorContains(t, err, "failed to get a token")
+}
+
+func TestCheckImages(t *testing.T) {
+	fr := newFakeRegistry(t)
+	ctx := context.Background()
+
+	container := func(name, image, id, state, platform string) HostContainer {
+		return HostContainer{Container: types.Container{Names: []string{"/" + name}, Image: image, ImageID: id, State: state}, Platform: platform}
+	}
+	containers := []HostContainer{
+		container("app", fr.host+"/team/app:1.0", fr.config.String(), "running", "linux/amd64"),
+		container("app-snapshotter", fr.host+"/team/app:1.0", fr.index.String(), "running", "linux/amd64"),
+		container("app-arm", fr.host+"/team/app:1.0", fr.config.String(), "running", "linux/arm64/v8"),
+		container("web", fr.host+"/web", digest.FromString("old web").String(), "running", "linux/amd64"),
+		container("stopped", fr.host+"/web", digest.FromString("old web").String(), "exited", "linux/amd64"),
+		container("pinned", fr.host+"/web@"+fr.web.String(), fr.webConf.String(), "running", "linux/amd64"),
+		{Container: types.Container{ID: "0123456789abcdef", Image: fr.webConf.String(), ImageID: fr.webConf.String(), State: "running"}},
+		container("gone", fr.host+"/gone:1", digest.FromString("gone").String(), "running", "linux/amd64"),
+	}
+	statuses := CheckImages(ctx, fr.registry(), "vm1", containers)
+	require.Len(t, statuses, 5)
+	assert.Equal(t, "app", statuses[0].Container)
+	assert.False(t, statuses[0].Outdated)
+	assert.False(t, statuses[1].Outdated, "the containerd image store identifies images by their index")
+	assert.True(t, statuses[2].Outdated, "the arm64 manifest is another image")
+	assert.Contains(t, statuses[2].Published, fr.arm64.String())
+	assert.True(t, statuses[3].Outdated)
+	assert.Equal(t, "vm1", statuses[3].VM)
+	assert.Equal(t, "gone", statuses[4].Container)
+	assert.False(t, statuses[4].Outdated)
+	assert.Contains(t, statuses[4].Error, "404")
+
+	SetOutdatedGauge(statuses)
+	t.Cleanup(OutdatedGauge.Reset)
+	assert.Equal(t, 2, testutil.CollectAndCount(OutdatedGauge))
+	assert.Equal(t, 1.0, testutil.ToFloat64(OutdatedGauge.WithLabelValues("vm1", "web", fr.host+"/web")))
+	SetOutdatedGauge(nil)
+	assert.Equal(t, 0, testutil.CollectAndCount(OutdatedGauge))
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"`)
+	assert.Equal(t, "bearer", scheme)
+	assert.Equal(t, map[string]string{
+		"realm":   "https://auth.docker.io/token",
+		"service": "registry.docker.io",
+		"scope":   "repository:library/nginx:pull",
+	}, params)
+
+	scheme, params = parseChallenge(`Basic realm="Registry Realm"`)
+	assert.Equal(t, "basic", scheme)
+	assert.Equal(t, "Registry Realm", params["realm"])
+}
+
+func TestRepositoryBaseURL(t *testing.T) {
+	for ref, want := range map[string]string{
+		"docker.io/library/nginx": "https://registry-1.docker.io/v2/library/nginx",
+		"ghcr.io/owner/app":       "https://ghcr.io/v2/owner/app",
+		"localhost:5000/app":      "http://localhost:5000/v2/app",
+		"127.0.0.1:5000/app":      "http://127.0.0.1:5000/v2/app",
+	} {
+		host, path, _ := strings.Cut(ref, "/")
+		assert.Equal(t, want, (&repository{host: host, path: path}).baseURL(), ref)
+	}
+}
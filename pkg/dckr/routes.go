@@ -0,0 +1,14 @@
+package dckr
+
+import (
+	"i2/pkg/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func AddRoutes(api *gin.RouterGroup, config *models.Config) {
+	service := &containerService{config: config}
+	api.POST("/containers/:name/:action", service.handlerContainerAction)
+	api.DELETE("/containers/:name", service.handlerRemoveContainer)
+	api.GET("/containers/outdated", service.handlerOutdatedContainers)
+}
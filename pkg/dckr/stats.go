@@ -0,0 +1,172 @@
+package dckr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"i2/pkg/prxmx"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// ContainerStats is a sample of the resources used by a container, computed
+// like docker stats. MemoryUsage excludes the page cache, network and block
+// IO are totals since the container started.
+type ContainerStats struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	CPUPercent    float64   `json:"cpu_percent"`
+	MemoryUsage   uint64    `json:"memory_usage"`
+	MemoryLimit   uint64    `json:"memory_limit"`
+	MemoryPercent float64   `json:"memory_percent"`
+	NetworkRx     uint64    `json:"network_rx"`
+	NetworkTx     uint64    `json:"network_tx"`
+	BlockRead     uint64    `json:"block_read"`
+	BlockWrite    uint64    `json:"block_write"`
+	PIDs          uint64    `json:"pids"`
+	Read          time.Time `json:"read"`
+}
+
+// ContainerStats returns a sample of the resources used by a container.
+// Docker waits for a second sample to compute the CPU usage.
+func (dc *DockerClient) ContainerStats(ctx context.Context, name string) (ContainerStats, error) {
+	resp, err := dc.cli.ContainerStats(ctx, name, false)
+	if err != nil {
+		return ContainerStats{}, fmt.Errorf("failed to get the stats of %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	var s container.StatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return ContainerStats{}, fmt.Errorf("invalid stats of %s: %w", name, err)
+	}
+	return statsOf(s), nil
+}
+
+// StreamStats calls fn with a sample of the resources used by a container
+// every second, until the container stops or the context is cancelled
+func (dc *DockerClient) StreamStats(ctx context.Context, name string, fn func(ContainerStats)) error {
+	resp, err := dc.cli.ContainerStats(ctx, name, true)
+	if err != nil {
+		return fmt.Errorf("failed to get the stats of %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var s container.StatsResponse
+		if err := decoder.Decode(&s); err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("invalid stats of %s: %w", name, err)
+		}
+		fn(statsOf(s))
+	}
+}
+
+// HostStats returns a sample of the resources used by every running
+// container, sampled concurrently. Containers stopping meanwhile are left out.
+func (dc *DockerClient) HostStats(ctx context.Context) ([]ContainerStats, error) {
+	containers, err := dc.cli.ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the containers: %w", err)
+	}
+	samples := make([]*ContainerStats, len(containers))
+	errs := make([]error, len(containers))
+	var wg sync.WaitGroup
+	for i, c := range containers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := dc.ContainerStats(ctx, c.ID)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			samples[i] = &s
+		}()
+	}
+	wg.Wait()
+
+	stats := []ContainerStats{}
+	for i, s := range samples {
+		if s != nil {
+			stats = append(stats, *s)
+		} else if ctx.Err() != nil {
+			return nil, errs[i]
+		}
+	}
+	if len(stats) == 0 && len(containers) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return stats, nil
+}
+
+// RefreshStats stores a sample of the resources used by the running
+// containers of a guest in the stats bucket
+func (dc *DockerClient) RefreshStats(ctx context.Context, inventory *prxmx.Inventory, vm prxmx.Node) error {
+	stats, err := dc.HostStats(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get the stats of %s: %w", vm.Name, err)
+	}
+	return inventory.SaveStats(ctx, vm, stats)
+}
+
+// statsOf computes the stats of a container from a Docker sample
+func statsOf(s container.StatsResponse) ContainerStats {
+	stats := ContainerStats{
+		ID:          s.ID,
+		Name:        strings.TrimPrefix(s.Name, "/"),
+		CPUPercent:  cpuPercent(s.CPUStats, s.PreCPUStats),
+		MemoryUsage: memoryUsage(s.MemoryStats),
+		MemoryLimit: s.MemoryStats.Limit,
+		PIDs:        s.PidsStats.Current,
+		Read:        s.Read,
+	}
+	if stats.MemoryLimit > 0 {
+		stats.MemoryPercent = float64(stats.MemoryUsage) / float64(stats.MemoryLimit) * 100
+	}
+	for _, n := range s.Networks {
+		stats.NetworkRx += n.RxBytes
+		stats.NetworkTx += n.TxBytes
+	}
+	for _, entry := range s.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			stats.BlockRead += entry.Value
+		case "write":
+			stats.BlockWrite += entry.Value
+		}
+	}
+	return stats
+}
+
+// cpuPercent returns the CPU used between two samples, 100% per CPU
+func cpuPercent(cpu, previous container.CPUStats) float64 {
+	cpuDelta := float64(cpu.CPUUsage.TotalUsage) - float64(previous.CPUUsage.TotalUsage)
+	systemDelta := float64(cpu.SystemUsage) - float64(previous.SystemUsage)
+	cpus := float64(cpu.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(cpu.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+	return cpuDelta / systemDelta * cpus * 100
+}
+
+// memoryUsage returns the memory used without the inactive page cache, the
+// key is total_inactive_file with cgroup v1 and inactive_file with v2
+func memoryUsage(m container.MemoryStats) uint64 {
+	for _, key := range []string{"total_inactive_file", "inactive_file"} {
+		if v, ok := m.Stats[key]; ok && v < m.Usage {
+			return m.Usage - v
+		}
+	}
+	return m.Usage
+}
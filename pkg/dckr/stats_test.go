@@ -0,0 +1,111 @@
+package dckr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sampleStats is a Docker sample of a container using half a CPU of 4
+// between two samples and 300MiB of memory, 100MiB of it inactive cache
+func sampleStats(name string) container.StatsResponse {
+	var s container.StatsResponse
+	s.Name = "/" + name
+	s.ID = name + "-id"
+	s.PreCPUStats.CPUUsage.TotalUsage = 1_000_000
+	s.PreCPUStats.SystemUsage = 10_000_000
+	s.CPUStats.CPUUsage.TotalUsage = 1_500_000
+	s.CPUStats.SystemUsage = 14_000_000
+	s.CPUStats.OnlineCPUs = 4
+	s.MemoryStats.Usage = 300 << 20
+	s.MemoryStats.Limit = 1 << 30
+	s.MemoryStats.Stats = map[string]uint64{"inactive_file": 100 << 20}
+	s.PidsStats.Current = 7
+	s.Networks = map[string]container.NetworkStats{
+		"eth0": {RxBytes: 1000, TxBytes: 200},
+		"eth1": {RxBytes: 24, TxBytes: 56},
+	}
+	s.BlkioStats.IoServiceBytesRecursive = []container.BlkioStatEntry{
+		{Op: "read", Value: 4096},
+		{Op: "write", Value: 512},
+		{Op: "Read", Value: 4096},
+	}
+	return s
+}
+
+func TestStatsOf(t *testing.T) {
+	stats := statsOf(sampleStats("web"))
+	assert.Equal(t, "web", stats.Name)
+	assert.InDelta(t, 50.0, stats.CPUPercent, 0.001)
+	assert.Equal(t, uint64(200<<20), stats.MemoryUsage)
+	assert.InDelta(t, 19.53, stats.MemoryPercent, 0.01)
+	assert.Equal(t, uint64(1024), stats.NetworkRx)
+	assert.Equal(t, uint64(256), stats.NetworkTx)
+	assert.Equal(t, uint64(8192), stats.BlockRead)
+	assert.Equal(t, uint64(512), stats.BlockWrite)
+	assert.Equal(t, uint64(7), stats.PIDs)
+
+	first := sampleStats("web")
+	first.PreCPUStats = container.CPUStats{}
+	first.PreCPUStats.SystemUsage = first.CPUStats.SystemUsage
+	assert.Zero(t, statsOf(first).CPUPercent)
+
+	v1 := sampleStats("web")
+	v1.MemoryStats.Stats = map[string]uint64{"total_inactive_file": 50 << 20}
+	assert.Equal(t, uint64(250<<20), statsOf(v1).MemoryUsage)
+}
+
+func TestContainerStats(t *testing.T) {
+	// web and db run, db stops before its stats are read. Streams send three
+	// samples.
+	dc := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1.45/containers/json":
+			_, _ = w.Write([]byte(`[{"Id": "web-id", "Names": ["/web"]}, {"Id": "db-id", "Names": ["/db"]}]`))
+		case "/v1.45/containers/web-id/stats", "/v1.45/containers/web/stats":
+			samples := 1
+			if r.URL.Query().Get("stream") == "1" {
+				samples = 3
+			}
+			for i := 0; i < samples; i++ {
+				assert.NoError(t, json.NewEncoder(w).Encode(sampleStats("web")))
+			}
+		default:
+			dockerError(w, http.StatusNotFound, "No such container")
+		}
+	})
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name, err string
+	}{
+		{"web", ""},
+		{"missing", "No such container"},
+	} {
+		stats, err := dc.ContainerStats(ctx, tc.name)
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, tc.name, stats.Name)
+	}
+
+	samples := 0
+	require.NoError(t, dc.StreamStats(ctx, "web", func(s ContainerStats) {
+		samples++
+		assert.Equal(t, "web-id", s.ID)
+	}))
+	assert.Equal(t, 3, samples)
+
+	host, err := dc.HostStats(ctx)
+	require.NoError(t, err)
+	require.Len(t, host, 1, "db stopped")
+	assert.Equal(t, "web", host[0].Name)
+}
@@ -23,6 +23,224 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/auth/tickets": {
+            "post": {
+                "description": "Return a ticket opening websockets, such as the consoles, for\n30 seconds with ?ticket=\u003cticket\u003e. It is signed with the API\ntoken, which browsers can't send on a websocket.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Issue a websocket ticket",
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/api.Ticket"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/containers/outdated": {
+            "get": {
+                "description": "Compare the images of the running containers of every guest in\nthe containers bucket with the images their tags point to in\ntheir registries, with the credentials of the Docker config.\nMulti-platform images are compared with the manifest of the\nplatform of the local image. The i2_container_image_outdated\ngauge is updated.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "containers"
+                ],
+                "summary": "List the containers running outdated images",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Include the containers that are up to date",
+                        "name": "all",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/dckr.ImageStatus"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/containers/{name}": {
+            "delete": {
+                "description": "Remove a container of the local Docker or of a guest reached\nover SSH. Running containers are only removed with force.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "containers"
+                ],
+                "summary": "Remove a container",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Container name or ID",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Guest name or key, the local Docker when empty",
+                        "name": "host",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Remove a running container",
+                        "name": "force",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Remove the anonymous volumes of the container",
+                        "name": "volumes",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dckr.ActionResult"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/containers/{name}/{action}": {
+            "post": {
+                "description": "Start, stop, restart, kill or rename a container of the local\nDocker or of a guest reached over SSH. The containers of the\nguest are then refreshed in the containers bucket.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "containers"
+                ],
+                "summary": "Run a container action",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Container name or ID",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "start, stop, restart, kill or rename",
+                        "name": "action",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Guest name or key, the local Docker when empty",
+                        "name": "host",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Seconds to wait before killing the container (stop, restart)",
+                        "name": "timeout",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Signal to send (kill), SIGKILL by default",
+                        "name": "signal",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "New name (rename)",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dckr.ActionResult"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
         "/dns/:zone/entries": {
             "get": {
                 "consumes": [
@@ -218,9 +436,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/proxmox/nodes": {
+        "/inventory/ansible": {
             "get": {
-                "description": "Get cluster nodes",
+                "description": "Get the guests stored in NATS as an Ansible dynamic inventory,\ngrouped by node (node_\u003cname\u003e), tag (tag_\u003ctag\u003e) and docker. With\nhost only the variables of that host are returned.",
                 "consumes": [
                     "application/json"
                 ],
@@ -228,9 +446,17 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "prxmx"
+                    "inventory"
+                ],
+                "summary": "Ansible dynamic inventory",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Host name, the output of --host",
+                        "name": "host",
+                        "in": "query"
+                    }
                 ],
-                "summary": "Get cluster nodes",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -238,6 +464,12 @@ const docTemplate = `{
                             "type": "object"
                         }
                     },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -247,9 +479,67 @@ const docTemplate = `{
                 }
             }
         },
-        "/proxmox/vms": {
+        "/proxmox/firewall/apply": {
+            "post": {
+                "description": "Reconcile the IP sets, security groups and rules of a\nfirewall.yaml (YAML or JSON) and return the plan. With dry_run\nthe plan is only returned.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "firewall"
+                ],
+                "summary": "Apply a firewall spec",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cluster name, overrides the cluster of the spec",
+                        "name": "cluster",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only return the plan",
+                        "name": "dry_run",
+                        "in": "query"
+                    },
+                    {
+                        "description": "Firewall spec",
+                        "name": "spec",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/prxmx.FirewallSpec"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/prxmx.FirewallPlan"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/proxmox/firewall/groups": {
             "get": {
-                "description": "Get virtual machines",
+                "description": "Get the security groups with their rules",
                 "consumes": [
                     "application/json"
                 ],
@@ -257,22 +547,33 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "prxmx"
+                    "firewall"
+                ],
+                "summary": "Get security groups",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cluster name, required with several clusters",
+                        "name": "cluster",
+                        "in": "query"
+                    }
                 ],
-                "summary": "Get virtual machines",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "type": "array",
-                                "items": {
-                                    "$ref": "#/definitions/prxmx.Node"
-                                }
+                                "$ref": "#/definitions/prxmx.SecurityGroup"
                             }
                         }
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -281,51 +582,1415 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "dns.DNSEntry": {
-            "type": "object",
-            "properties": {
-                "content": {
-                    "type": "string"
-                },
-                "domain": {
-                    "type": "string"
-                },
-                "id": {
-                    "type": "string"
-                },
-                "name": {
-                    "type": "string"
-                },
-                "provider": {
-                    "type": "string"
-                },
-                "ttl": {
-                    "type": "integer"
-                },
-                "type": {
-                    "type": "string"
-                }
-            }
         },
-        "dns.DNSRecord": {
-            "type": "object",
-            "properties": {
-                "content": {
-                    "type": "string"
-                },
-                "name": {
+        "/proxmox/firewall/ipsets": {
+            "get": {
+                "description": "Get the cluster IP sets with their entries",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "firewall"
+                ],
+                "summary": "Get IP sets",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cluster name, required with several clusters",
+                        "name": "cluster",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/prxmx.IPSet"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/proxmox/firewall/rules": {
+            "get": {
+                "description": "Get the firewall rules of the cluster, of a node or of a guest",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "firewall"
+                ],
+                "summary": "Get firewall rules",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cluster name, required with several clusters",
+                        "name": "cluster",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Node name",
+                        "name": "node",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Guest name, VMID or \u003ccluster\u003e.\u003cvmid\u003e",
+                        "name": "vm",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/prxmx.FirewallRule"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Insert a rule at pos, 0 is the top. Rules are enabled unless\nenable is 0.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "firewall"
+                ],
+                "summary": "Add a firewall rule",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cluster name, required with several clusters",
+                        "name": "cluster",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Node name",
+                        "name": "node",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Guest name, VMID or \u003ccluster\u003e.\u003cvmid\u003e",
+                        "name": "vm",
+                        "in": "query"
+                    },
+                    {
+                        "description": "Rule",
+                        "name": "rule",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/prxmx.FirewallRule"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/prxmx.FirewallRule"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/proxmox/firewall/rules/{pos}": {
+            "delete": {
+                "description": "Delete the rule at pos, the rules below move up",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "firewall"
+                ],
+                "summary": "Delete a firewall rule",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Rule position",
+                        "name": "pos",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cluster name, required with several clusters",
+                        "name": "cluster",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Node name",
+                        "name": "node",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Guest name, VMID or \u003ccluster\u003e.\u003cvmid\u003e",
+                        "name": "vm",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/proxmox/nodes": {
+            "get": {
+                "description": "Get the nodes of every cluster, tagged with their cluster name",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "proxmox"
+                ],
+                "summary": "Get cluster nodes",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cluster name, every cluster by default",
+                        "name": "cluster",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "object"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/proxmox/storage": {
+            "get": {
+                "description": "Get every storage of every node with its usage and the ISOs,\ncontainer templates and backups it contains. Storages that\ncould not be inspected are returned with their error set.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "proxmox"
+                ],
+                "summary": "Get storage",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cluster name, every cluster by default",
+                        "name": "cluster",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/prxmx.Storage"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/proxmox/tasks": {
+            "get": {
+                "description": "Get the recent tasks of the clusters, newest first",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "proxmox"
+                ],
+                "summary": "Get cluster tasks",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cluster name, every cluster by default",
+                        "name": "cluster",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/prxmx.TaskInfo"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/proxmox/tasks/{upid}": {
+            "get": {
+                "description": "Get the status of a task",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "proxmox"
+                ],
+                "summary": "Get a task",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task UPID",
+                        "name": "upid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cluster name, needed when its node name is in several clusters",
+                        "name": "cluster",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/prxmx.TaskInfo"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/proxmox/tasks/{upid}/log": {
+            "get": {
+                "description": "Stream the log lines of a task as Server-Sent Events until it finishes.\nEvery line is a \"log\" event, the last event is \"status\" with the\nfinal TaskInfo or \"error\" when the task failed or could not be followed.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "proxmox"
+                ],
+                "summary": "Stream a task log",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task UPID",
+                        "name": "upid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cluster name, needed when its node name is in several clusters",
+                        "name": "cluster",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/proxmox/users": {
+            "get": {
+                "description": "Get the Proxmox users with their API tokens",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "access"
+                ],
+                "summary": "Get users",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cluster name, required with several clusters",
+                        "name": "cluster",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/prxmx.User"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Create an enabled user, the password is only used by the pve realm",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "access"
+                ],
+                "summary": "Create a user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cluster name, required with several clusters",
+                        "name": "cluster",
+                        "in": "query"
+                    },
+                    {
+                        "description": "User",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/prxmx.UserSpec"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/proxmox/users/{userid}": {
+            "delete": {
+                "description": "Delete a user and its API tokens",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "access"
+                ],
+                "summary": "Delete a user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User, \u003cname\u003e@\u003crealm\u003e",
+                        "name": "userid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cluster name, required with several clusters",
+                        "name": "cluster",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/proxmox/users/{userid}/tokens": {
+            "get": {
+                "description": "Get the API tokens of a user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "access"
+                ],
+                "summary": "Get API tokens",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User, \u003cname\u003e@\u003crealm\u003e",
+                        "name": "userid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cluster name, required with several clusters",
+                        "name": "cluster",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/prxmx.APIToken"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Issue an API token and grant its ACLs. Tokens use privilege\nseparation unless privsep is false. The secret is only\nreturned once.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "access"
+                ],
+                "summary": "Issue an API token",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User, \u003cname\u003e@\u003crealm\u003e",
+                        "name": "userid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cluster name, required with several clusters",
+                        "name": "cluster",
+                        "in": "query"
+                    },
+                    {
+                        "description": "Token",
+                        "name": "token",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/prxmx.TokenSpec"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/prxmx.TokenSecret"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/proxmox/users/{userid}/tokens/{tokenid}": {
+            "delete": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "access"
+                ],
+                "summary": "Revoke an API token",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User, \u003cname\u003e@\u003crealm\u003e",
+                        "name": "userid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Token id",
+                        "name": "tokenid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cluster name, required with several clusters",
+                        "name": "cluster",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/proxmox/users/{userid}/tokens/{tokenid}/rotate": {
+            "post": {
+                "description": "Revoke a token and issue it again with the same settings and\nACLs, a temporary \u003ctokenid\u003e-rotate token checks the token can be\nissued first. The old secret stops working immediately.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "access"
+                ],
+                "summary": "Rotate an API token",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User, \u003cname\u003e@\u003crealm\u003e",
+                        "name": "userid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Token id",
+                        "name": "tokenid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cluster name, required with several clusters",
+                        "name": "cluster",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/prxmx.TokenSecret"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/proxmox/vms": {
+            "get": {
+                "description": "Get virtual machines and LXC containers. Guests that could not\nbe fully inspected are returned with their error set.\nEvery guest is tagged with its cluster name.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "proxmox"
+                ],
+                "summary": "Get virtual machines",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cluster name, every cluster by default",
+                        "name": "cluster",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only guests with every tag",
+                        "name": "tag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only guests on this Proxmox node",
+                        "name": "node",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "running or stopped",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Name glob, web-*",
+                        "name": "name",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "array",
+                                "items": {
+                                    "$ref": "#/definitions/prxmx.Node"
+                                }
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/proxmox/vms/{name}/console": {
+            "get": {
+                "description": "Proxy the serial or VNC console of a running guest over a\nwebsocket, without exposing the Proxmox UI nor its credentials.\nSerial consoles are logged in, send termproxy messages:\n0:\u003clen\u003e:\u003cdata\u003e for input, 1:\u003ccols\u003e:\u003crows\u003e: to resize and 2 to\nkeep it open. VNC consoles are raw RFB without authentication,\ni2 logs in with the one time password. It needs the API token\nor a ticket from /auth/tickets in ?ticket=, and an Origin of\nthe public URL of the API or its own host.",
+                "tags": [
+                    "proxmox"
+                ],
+                "summary": "Open a guest console",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Guest name, VMID or \u003ccluster\u003e.\u003cvmid\u003e",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cluster name, every cluster by default",
+                        "name": "cluster",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "serial (default) or vnc",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Ticket from /auth/tickets",
+                        "name": "ticket",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "101": {
+                        "description": "Switching Protocols",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        },
+        "/proxmox/vms/{name}/migrate": {
+            "post": {
+                "description": "Migrate a VM or LXC container to another node. The target node\nmust have enough free memory and the guest disks must be on\nshared storage. The API follows the migration and records the\nnew host of the guest in NATS once it's done, task is the route\nof the migration task.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "proxmox"
+                ],
+                "summary": "Migrate a guest",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Guest name, VMID or \u003ccluster\u003e.\u003cvmid\u003e",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cluster name, every cluster by default",
+                        "name": "cluster",
+                        "in": "query"
+                    },
+                    {
+                        "description": "Migration",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/prxmx.MigrateRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "api.Ticket": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "ticket": {
+                    "type": "string"
+                }
+            }
+        },
+        "dckr.ActionResult": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "container": {
+                    "type": "string"
+                },
+                "host": {
+                    "type": "string"
+                },
+                "warning": {
+                    "type": "string"
+                }
+            }
+        },
+        "dckr.ImageStatus": {
+            "type": "object",
+            "properties": {
+                "container": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "image": {
+                    "type": "string"
+                },
+                "local": {
+                    "type": "string"
+                },
+                "outdated": {
+                    "type": "boolean"
+                },
+                "published": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "vm": {
+                    "type": "string"
+                }
+            }
+        },
+        "dns.DNSEntry": {
+            "type": "object",
+            "properties": {
+                "content": {
+                    "type": "string"
+                },
+                "domain": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "provider": {
+                    "type": "string"
+                },
+                "ttl": {
+                    "type": "integer"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "dns.DNSRecord": {
+            "type": "object",
+            "properties": {
+                "content": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "provider": {
+                    "type": "string"
+                },
+                "ttl": {
+                    "type": "integer"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "prxmx.ACL": {
+            "type": "object",
+            "properties": {
+                "path": {
+                    "type": "string"
+                },
+                "propagate": {
+                    "type": "boolean"
+                },
+                "role": {
+                    "type": "string"
+                }
+            }
+        },
+        "prxmx.APIToken": {
+            "type": "object",
+            "properties": {
+                "comment": {
+                    "type": "string"
+                },
+                "expire": {
+                    "type": "integer"
+                },
+                "privsep": {
+                    "type": "boolean"
+                },
+                "tokenid": {
+                    "type": "string"
+                }
+            }
+        },
+        "prxmx.FirewallPlan": {
+            "type": "object",
+            "properties": {
+                "cluster": {
+                    "type": "string"
+                },
+                "ipsets": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/prxmx.IPSetChange"
+                    }
+                },
+                "rules": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/prxmx.RulesChange"
+                    }
+                },
+                "warnings": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "prxmx.FirewallRule": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "comment": {
+                    "type": "string"
+                },
+                "dest": {
+                    "type": "string"
+                },
+                "dport": {
+                    "type": "string"
+                },
+                "enable": {
+                    "type": "integer"
+                },
+                "iface": {
+                    "type": "string"
+                },
+                "log": {
+                    "type": "string"
+                },
+                "macro": {
+                    "type": "string"
+                },
+                "pos": {
+                    "type": "integer"
+                },
+                "proto": {
+                    "type": "string"
+                },
+                "source": {
+                    "type": "string"
+                },
+                "sport": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "prxmx.FirewallScope": {
+            "type": "object",
+            "properties": {
+                "group": {
+                    "type": "string"
+                },
+                "guest": {
+                    "$ref": "#/definitions/prxmx.Node"
+                },
+                "node": {
+                    "type": "string"
+                }
+            }
+        },
+        "prxmx.FirewallSpec": {
+            "type": "object",
+            "properties": {
+                "cluster": {
+                    "type": "string"
+                },
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/prxmx.SecurityGroupSpec"
+                    }
+                },
+                "ipsets": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/prxmx.IPSetSpec"
+                    }
+                },
+                "nodes": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "array",
+                        "items": {
+                            "$ref": "#/definitions/prxmx.FirewallRule"
+                        }
+                    }
+                },
+                "rules": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/prxmx.FirewallRule"
+                    }
+                },
+                "vms": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "array",
+                        "items": {
+                            "$ref": "#/definitions/prxmx.FirewallRule"
+                        }
+                    }
+                }
+            }
+        },
+        "prxmx.IPSet": {
+            "type": "object",
+            "properties": {
+                "comment": {
+                    "type": "string"
+                },
+                "entries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/prxmx.IPSetEntry"
+                    }
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "prxmx.IPSetChange": {
+            "type": "object",
+            "properties": {
+                "add": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "comment": {
+                    "type": "string"
+                },
+                "create": {
+                    "type": "boolean"
+                },
+                "delete": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "prxmx.IPSetEntry": {
+            "type": "object",
+            "properties": {
+                "cidr": {
+                    "type": "string"
+                },
+                "comment": {
+                    "type": "string"
+                },
+                "nomatch": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "prxmx.IPSetSpec": {
+            "type": "object",
+            "properties": {
+                "cidrs": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "comment": {
                     "type": "string"
                 },
-                "provider": {
+                "name": {
                     "type": "string"
                 },
-                "ttl": {
-                    "type": "integer"
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "prxmx.MigrateRequest": {
+            "type": "object",
+            "required": [
+                "target"
+            ],
+            "properties": {
+                "online": {
+                    "type": "boolean"
                 },
-                "type": {
+                "target": {
                     "type": "string"
                 }
             }
@@ -333,20 +1998,265 @@ const docTemplate = `{
         "prxmx.Node": {
             "type": "object",
             "properties": {
+                "cluster": {
+                    "type": "string"
+                },
+                "cpus": {
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "host": {
+                    "type": "string"
+                },
                 "ip": {
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
                 },
+                "ipv6": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "macs": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "maxDisk": {
+                    "type": "integer"
+                },
+                "maxMem": {
+                    "type": "integer"
+                },
                 "name": {
                     "type": "string"
                 },
+                "os": {
+                    "type": "string"
+                },
                 "running": {
                     "type": "boolean"
                 },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "template": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                },
                 "uptime": {
                     "$ref": "#/definitions/prxmx.Uptime"
+                },
+                "version": {
+                    "type": "integer"
+                },
+                "vmid": {
+                    "type": "integer"
+                }
+            }
+        },
+        "prxmx.RulesChange": {
+            "type": "object",
+            "properties": {
+                "comment": {
+                    "type": "string"
+                },
+                "createGroup": {
+                    "type": "boolean"
+                },
+                "from": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/prxmx.FirewallRule"
+                    }
+                },
+                "scope": {
+                    "$ref": "#/definitions/prxmx.FirewallScope"
+                },
+                "to": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/prxmx.FirewallRule"
+                    }
+                }
+            }
+        },
+        "prxmx.SecurityGroup": {
+            "type": "object",
+            "properties": {
+                "comment": {
+                    "type": "string"
+                },
+                "group": {
+                    "type": "string"
+                },
+                "rules": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/prxmx.FirewallRule"
+                    }
+                }
+            }
+        },
+        "prxmx.SecurityGroupSpec": {
+            "type": "object",
+            "properties": {
+                "comment": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "rules": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/prxmx.FirewallRule"
+                    }
+                }
+            }
+        },
+        "prxmx.Storage": {
+            "type": "object",
+            "properties": {
+                "active": {
+                    "type": "boolean"
+                },
+                "avail": {
+                    "type": "integer"
+                },
+                "backups": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/prxmx.Volume"
+                    }
+                },
+                "cluster": {
+                    "type": "string"
+                },
+                "content": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "enabled": {
+                    "type": "boolean"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "isos": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/prxmx.Volume"
+                    }
+                },
+                "name": {
+                    "type": "string"
+                },
+                "node": {
+                    "type": "string"
+                },
+                "shared": {
+                    "type": "boolean"
+                },
+                "templates": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/prxmx.Volume"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                },
+                "type": {
+                    "type": "string"
+                },
+                "used": {
+                    "type": "integer"
+                }
+            }
+        },
+        "prxmx.TaskInfo": {
+            "type": "object",
+            "properties": {
+                "cluster": {
+                    "type": "string"
+                },
+                "endTime": {
+                    "type": "string"
+                },
+                "exitStatus": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "node": {
+                    "type": "string"
+                },
+                "running": {
+                    "type": "boolean"
+                },
+                "startTime": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                },
+                "upid": {
+                    "type": "string"
+                },
+                "user": {
+                    "type": "string"
+                }
+            }
+        },
+        "prxmx.TokenSecret": {
+            "type": "object",
+            "properties": {
+                "full-tokenid": {
+                    "type": "string"
+                },
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "prxmx.TokenSpec": {
+            "type": "object",
+            "properties": {
+                "acls": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/prxmx.ACL"
+                    }
+                },
+                "comment": {
+                    "type": "string"
+                },
+                "expire": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "privsep": {
+                    "type": "boolean"
                 }
             }
         },
@@ -369,6 +2279,90 @@ const docTemplate = `{
                     "type": "integer"
                 }
             }
+        },
+        "prxmx.User": {
+            "type": "object",
+            "properties": {
+                "comment": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "enable": {
+                    "type": "boolean"
+                },
+                "expire": {
+                    "type": "integer"
+                },
+                "firstname": {
+                    "type": "string"
+                },
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "lastname": {
+                    "type": "string"
+                },
+                "tokens": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/prxmx.APIToken"
+                    }
+                },
+                "userid": {
+                    "type": "string"
+                }
+            }
+        },
+        "prxmx.UserSpec": {
+            "type": "object",
+            "properties": {
+                "comment": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "password": {
+                    "type": "string"
+                },
+                "userid": {
+                    "type": "string"
+                }
+            }
+        },
+        "prxmx.Volume": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "string"
+                },
+                "format": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "vmid": {
+                    "type": "integer"
+                },
+                "volID": {
+                    "type": "string"
+                }
+            }
         }
     }
 }`
@@ -3,8 +3,11 @@ package models
 import (
 	"context"
 	"errors"
-
+	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/1password/onepassword-sdk-go"
@@ -28,6 +31,7 @@ type Config struct {
 	CloudFlare  CloudFlare  `mapstructure:"cloudflare"`
 	GCP         GCP         `mapstructure:"gcp"`
 	OnePassword OnePassword `mapstructure:"1password"`
+	Prune       Prune       `mapstructure:"prune"`
 }
 
 type SSHConfig struct {
@@ -42,6 +46,7 @@ type SSHConfig struct {
 type Sync struct {
 	Interval   time.Duration `mapstructure:"interval"`
 	Timeout    time.Duration `mapstructure:"timeout"`
+	Workers    int           `mapstructure:"workers"`
 	VMS        bool          `mapstructure:"vms"`
 	Containers bool          `mapstructure:"containers"`
 	Enabled    bool          `mapstructure:"enabled"`
@@ -53,6 +58,8 @@ type Api struct {
 	PublicUrl string `mapstructure:"public_url"`
 	Scheme    string `mapstructure:"scheme"`
 	Version   string `mapstructure:"version"`
+	// Token is the bearer token of the routes changing something
+	Token string `mapstructure:"token"`
 }
 
 type Nats struct {
@@ -66,9 +73,70 @@ type Nats struct {
 }
 
 type Proxmox struct {
+	Name string `mapstructure:"name"`
 	URL  string `mapstructure:"url"`
 	User string `mapstructure:"user"`
 	Pass string `mapstructure:"pass"`
+	// Clusters are the other Proxmox endpoints managed by i2
+	Clusters []Proxmox `mapstructure:"clusters"`
+}
+
+// Endpoints returns every configured Proxmox endpoint: the main one, when it
+// has a URL, followed by the extra clusters
+func (p Proxmox) Endpoints() []Proxmox {
+	endpoints := []Proxmox{}
+	if p.URL != "" {
+		endpoints = append(endpoints, Proxmox{Name: p.Name, URL: p.URL, User: p.User, Pass: p.Pass})
+	}
+	return append(endpoints, p.Clusters...)
+}
+
+// AuthorizedKeys returns the public keys of public_key and of the lines of
+// public_key_file, a leading ~ is the home directory
+func (s SSHConfig) AuthorizedKeys() ([]string, error) {
+	keys := []string{}
+	if key := strings.TrimSpace(s.PublicKey); key != "" {
+		keys = append(keys, key)
+	}
+	if s.PublicKeyFile == "" {
+		return keys, nil
+	}
+	path, err := expandHome(s.PublicKeyFile)
+	if err != nil {
+		return keys, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return keys, fmt.Errorf("error reading the public key file: %w", err)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") && !slices.Contains(keys, line) {
+			keys = append(keys, line)
+		}
+	}
+	return keys, nil
+}
+
+// PrivateKeyPath returns the path of private_key_file, a leading ~ is the
+// home directory
+func (s SSHConfig) PrivateKeyPath() (string, error) {
+	if s.PrivateKeyFile == "" {
+		return "", fmt.Errorf("ssh.private_key_file is not set")
+	}
+	return expandHome(s.PrivateKeyFile)
+}
+
+// expandHome replaces a leading ~ of a path with the home directory
+func expandHome(path string) (string, error) {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, rest), nil
 }
 
 type PushGateway struct {
@@ -76,6 +144,35 @@ type PushGateway struct {
 	PushInterval time.Duration `mapstructure:"push_interval"`
 }
 
+// Prune is the policy of i2 prune. Dangling images and unused networks are
+// always removed, unused images created ImageDays ago, containers stopped
+// ContainerDays ago, and orphaned volumes only with Volumes. Resources with one
+// of ProtectedLabels, key or key=value, are kept.
+type Prune struct {
+	ImageDays       int      `mapstructure:"image_days"`
+	ContainerDays   int      `mapstructure:"container_days"`
+	Volumes         bool     `mapstructure:"volumes"`
+	ProtectedLabels []string `mapstructure:"protected_labels"`
+}
+
+// Days the policy keeps unused images and stopped containers when they aren't
+// configured
+const (
+	DefaultPruneImageDays     = 30
+	DefaultPruneContainerDays = 7
+)
+
+// WithDefaults returns the policy with the default ages where they're unset
+func (p Prune) WithDefaults() Prune {
+	if p.ImageDays <= 0 {
+		p.ImageDays = DefaultPruneImageDays
+	}
+	if p.ContainerDays <= 0 {
+		p.ContainerDays = DefaultPruneContainerDays
+	}
+	return p
+}
+
 type CloudFlare struct {
 	ApiToken  string `mapstructure:"api_token"`
 	IsDefault bool   `mapstructure:"is_default"`
@@ -127,6 +224,28 @@ func WithOnePassword(ctx context.Context) func(*Config) {
 		}
 		conf.Proxmox.Pass = proxmoxToken
 
+		if strings.HasPrefix(conf.Api.Token, "op://") {
+			apiToken, err := ReadSecretFrom1Password(ctx, conf.Api.Token)
+			if err != nil {
+				log.Errorf("unable to read the API token, %v", err)
+				panic("1Password token or key not found")
+			}
+			conf.Api.Token = apiToken
+		}
+
+		// the extra clusters keep their op:// references in pass
+		for i, cluster := range conf.Proxmox.Clusters {
+			if !strings.HasPrefix(cluster.Pass, "op://") {
+				continue
+			}
+			pass, err := ReadSecretFrom1Password(ctx, cluster.Pass)
+			if err != nil {
+				log.Errorf("unable to read the proxmox token of %s, %v", cluster.Name, err)
+				panic("1Password token or key not found")
+			}
+			conf.Proxmox.Clusters[i].Pass = pass
+		}
+
 		key = conf.OnePassword.NatsToken
 		natsToken, err := ReadSecretFrom1Password(ctx, key)
 		if err != nil {
@@ -138,15 +257,94 @@ func WithOnePassword(ctx context.Context) func(*Config) {
 }
 
 func ReadSecretFrom1Password(ctx context.Context, secretKey string) (string, error) {
-	token := os.Getenv("OP_SERVICE_ACCOUNT_TOKEN")
+	client, err := newOnePasswordClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	return client.Secrets.Resolve(ctx, secretKey)
+}
+
+// WriteCredentialTo1Password stores an API credential in a vault, given by
+// name or ID, as an item titled title. An existing item with the same title
+// is updated. It returns the secret reference of the credential, the value
+// for the 1password keys of the config.
+func WriteCredentialTo1Password(ctx context.Context, vault, title, username, credential string) (string, error) {
+	client, err := newOnePasswordClient(ctx)
+	if err != nil {
+		return "", err
+	}
 
-	client, err := onepassword.NewClient(
+	vaults, err := client.Vaults.ListAll(ctx)
+	if err != nil {
+		return "", err
+	}
+	var found *onepassword.VaultOverview
+	for {
+		v, err := vaults.Next()
+		if errors.Is(err, onepassword.ErrorIteratorDone) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if v.ID == vault || v.Title == vault {
+			found = v
+			break
+		}
+	}
+	if found == nil {
+		return "", fmt.Errorf("1Password vault %s not found", vault)
+	}
+	reference := fmt.Sprintf("op://%s/%s/credential", found.Title, title)
+
+	fields := []onepassword.ItemField{
+		{ID: "username", Title: "username", FieldType: onepassword.ItemFieldTypeText, Value: username},
+		{ID: "credential", Title: "credential", FieldType: onepassword.ItemFieldTypeConcealed, Value: credential},
+	}
+	items, err := client.Items.ListAll(ctx, found.ID)
+	if err != nil {
+		return "", err
+	}
+	for {
+		overview, err := items.Next()
+		if errors.Is(err, onepassword.ErrorIteratorDone) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if overview.Title != title {
+			continue
+		}
+		item, err := client.Items.Get(ctx, found.ID, overview.ID)
+		if err != nil {
+			return "", err
+		}
+		// keep the other fields of the item
+		for _, field := range fields {
+			if i := slices.IndexFunc(item.Fields, func(f onepassword.ItemField) bool { return f.ID == field.ID }); i >= 0 {
+				item.Fields[i].Value = field.Value
+			} else {
+				item.Fields = append(item.Fields, field)
+			}
+		}
+		_, err = client.Items.Put(ctx, item)
+		return reference, err
+	}
+
+	_, err = client.Items.Create(ctx, onepassword.ItemCreateParams{
+		Category: onepassword.ItemCategoryAPICredentials,
+		VaultID:  found.ID,
+		Title:    title,
+		Fields:   fields,
+		Tags:     []string{"i2"},
+	})
+	return reference, err
+}
+
+func newOnePasswordClient(ctx context.Context) (*onepassword.Client, error) {
+	token := os.Getenv("OP_SERVICE_ACCOUNT_TOKEN")
+	return onepassword.NewClient(
 		ctx,
 		onepassword.WithServiceAccountToken(token),
 		onepassword.WithIntegrationInfo("My 1Password i2 Integration", "v0.1.0"),
 	)
-	if err != nil {
-		return "", err
-	}
-	return client.Secrets.Resolve(ctx, secretKey)
 }
@@ -0,0 +1,181 @@
+package prxmx
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// AgentPollInterval is how often the status of an agent command is polled
+var AgentPollInterval = 500 * time.Millisecond
+
+// AgentWriteLimit is the largest content the agent file-write call accepts,
+// bigger files are written in parts
+const AgentWriteLimit = 60 * 1024
+
+// ErrNoAgent is returned when a guest can't be reached with the QEMU guest agent
+var ErrNoAgent = errors.New("the QEMU guest agent is only available in running VMs")
+
+// ExecResult is the outcome of a command run with the guest agent
+type ExecResult struct {
+	ExitCode  int
+	Stdout    string
+	Stderr    string
+	Signal    bool
+	Truncated bool
+}
+
+// agentExecStatus is the exec-status reply, go-proxmox decodes out-truncated
+// as a string while the API returns a boolean
+type agentExecStatus struct {
+	Exited       int    `json:"exited"`
+	ExitCode     int    `json:"exitcode"`
+	OutData      string `json:"out-data"`
+	ErrData      string `json:"err-data"`
+	OutTruncated bool   `json:"out-truncated"`
+	ErrTruncated bool   `json:"err-truncated"`
+	Signal       bool   `json:"signal"`
+}
+
+type agentFileRead struct {
+	Content   string `json:"content"`
+	Truncated bool   `json:"truncated"`
+}
+
+func agentPath(guest Node, command string) string {
+	return fmt.Sprintf("/nodes/%s/qemu/%d/agent/%s", guest.Host, guest.VMID, command)
+}
+
+func checkAgent(guest Node) error {
+	if guest.IsContainer() || !guest.Running {
+		return fmt.Errorf("%s: %w", guest.Name, ErrNoAgent)
+	}
+	return nil
+}
+
+// AgentExec runs a command inside a VM with the guest agent and waits until
+// it exits or ctx is done. input is sent to the command stdin. It doesn't
+// need SSH nor a network connection to the VM.
+func (c *Cluster) AgentExec(ctx context.Context, guest Node, command []string, input []byte) (ExecResult, error) {
+	if err := checkAgent(guest); err != nil {
+		return ExecResult{}, err
+	}
+	if len(command) == 0 {
+		return ExecResult{}, errors.New("no command to run")
+	}
+
+	params := map[string]any{"command": command}
+	if len(input) > 0 {
+		params["input-data"] = string(input)
+	}
+	var started struct {
+		PID int `json:"pid"`
+	}
+	cctx, cancel := c.callContext(ctx)
+	err := c.Client.Post(cctx, agentPath(guest, "exec"), params, &started)
+	cancel()
+	if err != nil {
+		return ExecResult{}, fmt.Errorf("error running %s in %s: %w", command[0], guest.Name, err)
+	}
+
+	for {
+		var status agentExecStatus
+		cctx, cancel := c.callContext(ctx)
+		err := c.Client.Get(cctx, fmt.Sprintf("%s?pid=%d", agentPath(guest, "exec-status"), started.PID), &status)
+		cancel()
+		if err != nil {
+			return ExecResult{}, fmt.Errorf("error reading the status of %s in %s: %w", command[0], guest.Name, err)
+		}
+		if status.Exited != 0 {
+			return ExecResult{
+				ExitCode:  status.ExitCode,
+				Stdout:    status.OutData,
+				Stderr:    status.ErrData,
+				Signal:    status.Signal,
+				Truncated: status.OutTruncated || status.ErrTruncated,
+			}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ExecResult{}, ctx.Err()
+		case <-time.After(AgentPollInterval):
+		}
+	}
+}
+
+// AgentFileRead returns the content of a file inside a VM. The agent reads
+// up to 16MiB, bigger files fail instead of being returned truncated.
+func (c *Cluster) AgentFileRead(ctx context.Context, guest Node, file string) ([]byte, error) {
+	if err := checkAgent(guest); err != nil {
+		return nil, err
+	}
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+
+	var read agentFileRead
+	query := url.Values{"file": {file}}.Encode()
+	if err := c.Client.Get(cctx, agentPath(guest, "file-read")+"?"+query, &read); err != nil {
+		return nil, fmt.Errorf("error reading %s from %s: %w", file, guest.Name, err)
+	}
+	if read.Truncated {
+		return nil, fmt.Errorf("%s in %s is too big to be read with the guest agent", file, guest.Name)
+	}
+	return []byte(read.Content), nil
+}
+
+// AgentFileWrite writes a file inside a VM. Files bigger than AgentWriteLimit
+// are written in parts next to the file and joined with cat, which needs a
+// POSIX shell in the guest.
+func (c *Cluster) AgentFileWrite(ctx context.Context, guest Node, file string, content []byte) error {
+	if err := checkAgent(guest); err != nil {
+		return err
+	}
+	// the content is sent base64 encoded so binary files survive the JSON body
+	limit := base64.StdEncoding.DecodedLen(AgentWriteLimit)
+	if len(content) <= limit {
+		return c.agentWrite(ctx, guest, file, content)
+	}
+
+	parts := []string{}
+	for i := 0; i*limit < len(content); i++ {
+		part := fmt.Sprintf("%s.i2part.%d", file, i)
+		if err := c.agentWrite(ctx, guest, part, content[i*limit:min((i+1)*limit, len(content))]); err != nil {
+			return err
+		}
+		parts = append(parts, shellQuote(part))
+	}
+	script := fmt.Sprintf("cat %s > %s && rm -f %s", strings.Join(parts, " "), shellQuote(file), strings.Join(parts, " "))
+	res, err := c.AgentExec(ctx, guest, []string{"/bin/sh", "-c", script}, nil)
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("error joining the parts of %s in %s: %s", file, guest.Name, strings.TrimSpace(res.Stderr))
+	}
+	return nil
+}
+
+func (c *Cluster) agentWrite(ctx context.Context, guest Node, file string, content []byte) error {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	params := map[string]any{
+		"file":    file,
+		"content": base64.StdEncoding.EncodeToString(content),
+		"encode":  0,
+	}
+	if err := c.Client.Post(cctx, agentPath(guest, "file-write"), params, nil); err != nil {
+		return fmt.Errorf("error writing %s to %s: %w", path.Base(file), guest.Name, err)
+	}
+	return nil
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
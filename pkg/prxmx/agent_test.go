@@ -0,0 +1,112 @@
+package prxmx
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var agentGuest = Node{Name: "web", Type: GuestTypeVM, VMID: 100, Host: "pve1", Running: true}
+
+func TestCluster_AgentExec(t *testing.T) {
+	AgentPollInterval = 0
+	var params map[string]any
+	polls := 0
+	cluster := newFakeProxmox(t, map[string]http.HandlerFunc{
+		"/nodes/pve1/qemu/100/agent/exec": func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&params))
+			data(map[string]any{"pid": 42})(w, r)
+		},
+		"/nodes/pve1/qemu/100/agent/exec-status": func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "42", r.URL.Query().Get("pid"))
+			polls++
+			if polls < 3 {
+				data(map[string]any{"exited": 0})(w, r)
+				return
+			}
+			data(map[string]any{"exited": 1, "exitcode": 2, "out-data": "out", "err-data": "err", "out-truncated": true})(w, r)
+		},
+	})
+
+	res, err := cluster.AgentExec(context.Background(), agentGuest, []string{"ls", "/data"}, []byte("in"))
+	require.NoError(t, err)
+	assert.Equal(t, ExecResult{ExitCode: 2, Stdout: "out", Stderr: "err", Truncated: true}, res)
+	assert.Equal(t, []any{"ls", "/data"}, params["command"])
+	assert.Equal(t, "in", params["input-data"])
+	assert.Equal(t, 3, polls)
+
+	ct := agentGuest
+	ct.Type = GuestTypeLXC
+	_, err = cluster.AgentExec(context.Background(), ct, []string{"ls"}, nil)
+	assert.ErrorIs(t, err, ErrNoAgent)
+}
+
+func TestCluster_AgentFileRead(t *testing.T) {
+	cluster := newFakeProxmox(t, map[string]http.HandlerFunc{
+		"/nodes/pve1/qemu/100/agent/file-read": func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Query().Get("file") {
+			case "/etc/hosts file":
+				data(map[string]any{"content": "127.0.0.1 localhost\n"})(w, r)
+			default:
+				data(map[string]any{"content": "x", "truncated": true})(w, r)
+			}
+		},
+	})
+
+	content, err := cluster.AgentFileRead(context.Background(), agentGuest, "/etc/hosts file")
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1 localhost\n", string(content))
+
+	_, err = cluster.AgentFileRead(context.Background(), agentGuest, "/var/log/big")
+	assert.ErrorContains(t, err, "too big")
+}
+
+func TestCluster_AgentFileWrite(t *testing.T) {
+	AgentPollInterval = 0
+	var mu sync.Mutex
+	files := map[string][]byte{}
+	var script string
+	cluster := newFakeProxmox(t, map[string]http.HandlerFunc{
+		"/nodes/pve1/qemu/100/agent/file-write": func(w http.ResponseWriter, r *http.Request) {
+			var params map[string]any
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&params))
+			assert.EqualValues(t, 0, params["encode"])
+			content, err := base64.StdEncoding.DecodeString(params["content"].(string))
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(params["content"].(string)), AgentWriteLimit)
+			mu.Lock()
+			files[params["file"].(string)] = content
+			mu.Unlock()
+			data(nil)(w, r)
+		},
+		"/nodes/pve1/qemu/100/agent/exec": func(w http.ResponseWriter, r *http.Request) {
+			var params struct{ Command []string }
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&params))
+			script = params.Command[2]
+			data(map[string]any{"pid": 1})(w, r)
+		},
+		"/nodes/pve1/qemu/100/agent/exec-status": data(map[string]any{"exited": 1}),
+	})
+	ctx := context.Background()
+
+	require.NoError(t, cluster.AgentFileWrite(ctx, agentGuest, "/etc/motd", []byte{0, 1, 2}))
+	assert.Equal(t, []byte{0, 1, 2}, files["/etc/motd"])
+
+	big := []byte(strings.Repeat("a", 2*AgentWriteLimit))
+	require.NoError(t, cluster.AgentFileWrite(ctx, agentGuest, "/tmp/it's big", big))
+	joined := []byte{}
+	for i := 0; i < 3; i++ {
+		part, ok := files["/tmp/it's big.i2part."+string(rune('0'+i))]
+		require.True(t, ok, "part %d", i)
+		joined = append(joined, part...)
+	}
+	assert.Equal(t, big, joined)
+	assert.Contains(t, script, `> '/tmp/it'\''s big' && rm -f`)
+}
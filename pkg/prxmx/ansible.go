@@ -0,0 +1,162 @@
+package prxmx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"i2/pkg/store"
+	"i2/pkg/utils"
+)
+
+// AnsibleInventory is the output of an Ansible dynamic inventory script
+// called with --list
+type AnsibleInventory map[string]any
+
+// AnsibleGroup is a group of an Ansible dynamic inventory
+type AnsibleGroup struct {
+	Hosts    []string `json:"hosts,omitempty"`
+	Children []string `json:"children,omitempty"`
+}
+
+// AnsibleHosts maps the inventory host names to the guests. Guests are named
+// after the VM, guests sharing a name are named by their key instead.
+func AnsibleHosts(nodes []Node) map[string]Node {
+	count := map[string]int{}
+	for _, n := range nodes {
+		count[n.Name]++
+	}
+	hosts := map[string]Node{}
+	for _, n := range nodes {
+		name := n.Name
+		if count[name] > 1 {
+			name = n.Key()
+		}
+		hosts[name] = n
+	}
+	return hosts
+}
+
+// AnsibleHostVars returns the variables of a guest: ansible_host is the
+// local IP of the guest and ansible_user the SSH user of the config
+func AnsibleHostVars(n Node, sshUser string, docker bool) map[string]any {
+	vars := map[string]any{
+		"i2_cluster": n.Cluster,
+		"i2_vmid":    n.VMID,
+		"i2_node":    n.Host,
+		"i2_type":    n.Type,
+		"i2_tags":    n.Tags,
+		"i2_running": n.Running,
+		"i2_docker":  docker,
+	}
+	if ip := utils.GetLocalIP(n.IP); ip != "" {
+		vars["ansible_host"] = ip
+	}
+	if sshUser != "" {
+		vars["ansible_user"] = sshUser
+	}
+	return vars
+}
+
+// NewAnsibleInventory groups the guests by Proxmox node (node_<name>), by
+// tag (tag_<tag>) and by whether they run Docker (docker). Hostvars are
+// included in _meta so Ansible doesn't call --host for every guest. Guests
+// Ansible can't reach, without a local IP, and entries synced before guests
+// had a node are left out.
+func NewAnsibleInventory(nodes []Node, dockerHosts map[string]bool, sshUser string) AnsibleInventory {
+	hosts := AnsibleHosts(nodes)
+	groups := map[string][]string{}
+	hostvars := map[string]any{}
+	names := make([]string, 0, len(hosts))
+	for name, n := range hosts {
+		if n.Host == "" || utils.GetLocalIP(n.IP) == "" {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		n := hosts[name]
+		docker := dockerHosts[n.Key()]
+		hostvars[name] = AnsibleHostVars(n, sshUser, docker)
+		groups[ansibleGroupName("node", n.Host)] = append(groups[ansibleGroupName("node", n.Host)], name)
+		for _, tag := range n.Tags {
+			groups[ansibleGroupName("tag", tag)] = append(groups[ansibleGroupName("tag", tag)], name)
+		}
+		if docker {
+			groups["docker"] = append(groups["docker"], name)
+		}
+	}
+
+	inventory := AnsibleInventory{"_meta": map[string]any{"hostvars": hostvars}}
+	children := make([]string, 0, len(groups))
+	for group, members := range groups {
+		inventory[group] = AnsibleGroup{Hosts: members}
+		children = append(children, group)
+	}
+	sort.Strings(children)
+	inventory["all"] = AnsibleGroup{Hosts: names, Children: children}
+	return inventory
+}
+
+// ansibleGroupName returns a valid Ansible group name, only letters, digits
+// and underscores are allowed
+func ansibleGroupName(prefix, name string) string {
+	return prefix + "_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// DockerHosts returns the keys of the guests with containers in the
+// <bucket>-containers bucket
+func (i *Inventory) DockerHosts(ctx context.Context) (map[string]bool, error) {
+	keys, err := store.GetKeys(ctx, i.ContainersBucket, i.st.NatsConn)
+	if err != nil {
+		return nil, err
+	}
+	hosts := map[string]bool{}
+	for _, key := range keys {
+		b, err := store.GetKV(ctx, key, i.ContainersBucket, i.st.NatsConn)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", key, err)
+		}
+		containers := []json.RawMessage{}
+		if err := json.Unmarshal(b, &containers); err != nil {
+			return nil, fmt.Errorf("error decoding %s: %w", key, err)
+		}
+		hosts[key] = len(containers) > 0
+	}
+	return hosts, nil
+}
+
+// AnsibleInventory returns the Ansible dynamic inventory of the guests in
+// NATS
+func (i *Inventory) AnsibleInventory(ctx context.Context, sshUser string) (AnsibleInventory, error) {
+	nodes, err := i.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// guests are only in the containers bucket after a containers --all --live
+	docker, err := i.DockerHosts(ctx)
+	if err != nil {
+		docker = map[string]bool{}
+	}
+	return NewAnsibleInventory(nodes, docker, sshUser), nil
+}
+
+// HostVars returns the variables of a host, the output of --host
+func (inv AnsibleInventory) HostVars(name string) (map[string]any, bool) {
+	meta, ok := inv["_meta"].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	hostvars, _ := meta["hostvars"].(map[string]any)
+	vars, ok := hostvars[name].(map[string]any)
+	return vars, ok
+}
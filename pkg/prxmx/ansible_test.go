@@ -0,0 +1,51 @@
+package prxmx
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAnsibleInventory(t *testing.T) {
+	nodes := []Node{
+		{Cluster: "main", VMID: 100, Name: "web", Host: "pve1", Tags: []string{"prod", "k8s.io"}, IP: []string{"10.0.0.2", "192.168.1.10"}, Version: 1},
+		{Cluster: "main", VMID: 101, Name: "db", Host: "pve-2", Tags: []string{"prod"}, IP: []string{"192.168.1.11"}, Version: 1},
+		{Cluster: "lab", VMID: 100, Name: "db", Host: "lab1", Version: 1},
+		{Cluster: "lab", VMID: 101, Name: "old", IP: []string{"192.168.2.10"}},
+	}
+	docker := map[string]bool{"main.100": true, "main.101": false}
+
+	inventory := NewAnsibleInventory(nodes, docker, "ops")
+	b, err := json.Marshal(inventory)
+	require.NoError(t, err)
+	got := map[string]any{}
+	require.NoError(t, json.Unmarshal(b, &got))
+
+	hosts := func(group string) any {
+		return got[group].(map[string]any)["hosts"]
+	}
+	assert.Equal(t, []any{"web"}, hosts("node_pve1"))
+	assert.Equal(t, []any{"main.101"}, hosts("node_pve_2"))
+	assert.Equal(t, []any{"main.101", "web"}, hosts("tag_prod"))
+	assert.Equal(t, []any{"web"}, hosts("tag_k8s_io"))
+	assert.Equal(t, []any{"web"}, hosts("docker"))
+	assert.Equal(t, []any{"main.101", "web"}, hosts("all"))
+	assert.NotContains(t, got, "node_")
+
+	vars, ok := inventory.HostVars("web")
+	require.True(t, ok)
+	assert.Equal(t, "192.168.1.10", vars["ansible_host"])
+	assert.Equal(t, "ops", vars["ansible_user"])
+	assert.Equal(t, true, vars["i2_docker"])
+
+	// without an IP or a node the guests can't be reached
+	_, ok = inventory.HostVars("lab.100")
+	assert.False(t, ok)
+	_, ok = inventory.HostVars("old")
+	assert.False(t, ok)
+
+	_, ok = inventory.HostVars("db")
+	assert.False(t, ok)
+}
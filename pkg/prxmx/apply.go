@@ -0,0 +1,272 @@
+package prxmx
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Action is what apply does with a VM spec
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionNone   Action = "none"
+)
+
+// PlanItem is the outcome of comparing a VM spec with the cluster. Changes
+// are applied by Apply, destructive changes are only reported.
+type PlanItem struct {
+	Spec        VMSpec
+	Action      Action
+	Cluster     string
+	Node        string
+	VMID        uint64
+	TemplateID  uint64 `json:",omitempty"`
+	template    Node
+	Changes     []Change
+	Destructive []Change
+	Error       string `json:",omitempty"`
+}
+
+// Plan compares the specs with the VMs of the cluster. Specs that can't be
+// planned (ambiguous names, missing templates) are returned with their Error
+// set and are skipped by Apply.
+func (c *Cluster) Plan(ctx context.Context, specs []VMSpec) ([]PlanItem, error) {
+	guests, templates, err := c.getGuests(ctx)
+	if err != nil {
+		// a missing node could hide an existing VM and apply would clone it again
+		return nil, err
+	}
+	byName := map[string][]Node{}
+	for _, g := range guests {
+		node := c.guestNode(g)
+		if !node.IsContainer() {
+			byName[node.Name] = append(byName[node.Name], node)
+		}
+	}
+
+	plan := make([]PlanItem, 0, len(specs))
+	for _, spec := range specs {
+		item := PlanItem{Spec: spec, Cluster: c.Name, Action: ActionNone}
+		existing := byName[spec.Name]
+		var err error
+		switch {
+		case len(existing) > 1:
+			item.Error = fmt.Sprintf("%d VMs are called %s", len(existing), spec.Name)
+		case len(existing) == 1:
+			item.Node = existing[0].Host
+			item.VMID = existing[0].VMID
+			err = c.planUpdate(ctx, &item, existing[0])
+		default:
+			err = c.planCreate(ctx, &item, templates)
+		}
+		if err != nil {
+			item.Error = err.Error()
+		}
+		plan = append(plan, item)
+	}
+	return plan, nil
+}
+
+func (c *Cluster) planUpdate(ctx context.Context, item *PlanItem, vm Node) error {
+	config, err := c.vmConfig(ctx, vm.Host, vm.VMID)
+	if err != nil {
+		return err
+	}
+	if err := checkCloudInit(item.Spec, config); err != nil {
+		return err
+	}
+	item.Changes, item.Destructive = diffVM(item.Spec, config)
+	if item.Spec.Node != "" && item.Spec.Node != vm.Host {
+		item.Destructive = append(item.Destructive, Change{Field: "node", From: vm.Host, To: item.Spec.Node, Reason: "use i2 vms migrate"})
+	}
+	if len(item.Changes) > 0 {
+		item.Action = ActionUpdate
+	}
+	return nil
+}
+
+func (c *Cluster) planCreate(ctx context.Context, item *PlanItem, templates map[uint64]Node) error {
+	spec := item.Spec
+	if spec.Template == "" {
+		return fmt.Errorf("%s doesn't exist and has no template to clone", spec.Name)
+	}
+	ids := make([]uint64, 0, len(templates))
+	for id := range templates {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	matches := []string{}
+	for _, id := range ids {
+		template := templates[id]
+		if template.Name == spec.Template || strconv.FormatUint(id, 10) == spec.Template {
+			item.TemplateID = id
+			item.template = template
+			matches = append(matches, strconv.FormatUint(id, 10))
+		}
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("template %s not found", spec.Template)
+	}
+	if len(matches) > 1 {
+		return fmt.Errorf("%d templates are called %s (%s), use a VMID", len(matches), spec.Template, strings.Join(matches, ", "))
+	}
+	host := item.template.Host
+
+	config, err := c.vmConfig(ctx, host, item.TemplateID)
+	if err != nil {
+		return err
+	}
+	if err := checkCloudInit(spec, config); err != nil {
+		return err
+	}
+	item.Action = ActionCreate
+	item.Node = spec.Node
+	if item.Node == "" {
+		item.Node = host
+	}
+	// only the differences with the template are applied after the clone
+	item.Changes, item.Destructive = diffVM(spec, config)
+	return nil
+}
+
+func (c *Cluster) vmConfig(ctx context.Context, host string, vmid uint64) (map[string]any, error) {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	config := map[string]any{}
+	err := c.Client.Get(cctx, fmt.Sprintf("/nodes/%s/qemu/%d/config", host, vmid), &config)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the config of %d: %w", vmid, err)
+	}
+	return config, nil
+}
+
+// Apply creates and updates the VMs of a plan, items with an error or with
+// nothing to do are skipped. progress is called before every step. Apply
+// stops at the first failure.
+func (c *Cluster) Apply(ctx context.Context, plan []PlanItem, progress func(string)) error {
+	for _, item := range plan {
+		if item.Error != "" || item.Action == ActionNone {
+			continue
+		}
+		if item.Action == ActionCreate {
+			progress(fmt.Sprintf("Cloning %s from template %d", item.Spec.Name, item.TemplateID))
+			vmid, err := c.clone(ctx, item)
+			if err != nil {
+				return err
+			}
+			item.VMID = vmid
+		}
+		if len(item.Changes) > 0 {
+			progress(fmt.Sprintf("Configuring %s (%d changes)", item.Spec.Name, len(item.Changes)))
+			if err := c.applyChanges(ctx, item.Node, item.VMID, item.Changes); err != nil {
+				return fmt.Errorf("error configuring %s: %w", item.Spec.Name, err)
+			}
+		}
+		if item.Action == ActionCreate && item.Spec.Start {
+			progress(fmt.Sprintf("Starting %s", item.Spec.Name))
+			if err := c.startVM(ctx, item.Node, item.VMID); err != nil {
+				return fmt.Errorf("error starting %s: %w", item.Spec.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// clone clones the template of a plan item and waits for the clone task
+func (c *Cluster) clone(ctx context.Context, item PlanItem) (uint64, error) {
+	cctx, cancel := c.callContext(ctx)
+	var next any
+	err := c.Client.Get(cctx, "/cluster/nextid", &next)
+	cancel()
+	if err != nil {
+		return 0, fmt.Errorf("error getting a VMID: %w", err)
+	}
+	vmid, err := strconv.ParseUint(fmt.Sprint(next), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid VMID %v: %w", next, err)
+	}
+
+	host := item.template.Host
+	params := map[string]any{"newid": vmid, "name": item.Spec.Name}
+	if item.Spec.Full {
+		params["full"] = 1
+	}
+	if item.Node != host {
+		params["target"] = item.Node
+	}
+	var upid string
+	cctx, cancel = c.callContext(ctx)
+	err = c.Client.Post(cctx, fmt.Sprintf("/nodes/%s/qemu/%d/clone", host, item.TemplateID), params, &upid)
+	cancel()
+	if err != nil {
+		return 0, fmt.Errorf("error cloning %s: %w", item.Spec.Name, err)
+	}
+	if _, err := c.WaitTask(ctx, upid); err != nil {
+		return 0, fmt.Errorf("error cloning %s: %w", item.Spec.Name, err)
+	}
+	return vmid, nil
+}
+
+// applyChanges updates the VM config in one call and resizes the disks that grow
+func (c *Cluster) applyChanges(ctx context.Context, host string, vmid uint64, changes []Change) error {
+	params := map[string]any{}
+	for _, change := range changes {
+		if change.Resize {
+			continue
+		}
+		switch change.Field {
+		case "sshkeys":
+			// Proxmox wants the keys URL encoded, with %20 for spaces
+			params[change.Field] = strings.ReplaceAll(url.QueryEscape(change.To), "+", "%20")
+		default:
+			params[change.Field] = change.To
+		}
+	}
+	if len(params) > 0 {
+		cctx, cancel := c.callContext(ctx)
+		err := c.Client.Put(cctx, fmt.Sprintf("/nodes/%s/qemu/%d/config", host, vmid), params, nil)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, change := range changes {
+		if !change.Resize {
+			continue
+		}
+		var upid string
+		cctx, cancel := c.callContext(ctx)
+		err := c.Client.Put(cctx, fmt.Sprintf("/nodes/%s/qemu/%d/resize", host, vmid),
+			map[string]any{"disk": change.Field, "size": change.To}, &upid)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("error resizing %s: %w", change.Field, err)
+		}
+		// newer Proxmox versions resize in a task
+		if upid != "" {
+			if _, err := c.WaitTask(ctx, upid); err != nil {
+				return fmt.Errorf("error resizing %s: %w", change.Field, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Cluster) startVM(ctx context.Context, host string, vmid uint64) error {
+	var upid string
+	cctx, cancel := c.callContext(ctx)
+	err := c.Client.Post(cctx, fmt.Sprintf("/nodes/%s/qemu/%d/status/start", host, vmid), nil, &upid)
+	cancel()
+	if err != nil {
+		return err
+	}
+	_, err = c.WaitTask(ctx, upid)
+	return err
+}
@@ -0,0 +1,110 @@
+package prxmx
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCluster_PlanAndApply(t *testing.T) {
+	TaskPollInterval = 10 * time.Millisecond
+	var mu sync.Mutex
+	calls := map[string]map[string]any{}
+	record := func(name string, reply any) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			params := map[string]any{}
+			if err := json.NewDecoder(r.Body).Decode(&params); err != io.EOF {
+				require.NoError(t, err)
+			}
+			mu.Lock()
+			calls[name] = params
+			mu.Unlock()
+			data(reply)(w, r)
+		}
+	}
+
+	routes := fakeTask("OK")
+	routes["/nodes"] = data([]map[string]any{{"node": "pve1", "status": "online"}})
+	routes["/nodes/pve1/status"] = data(map[string]any{})
+	routes["/nodes/pve1/qemu"] = data([]map[string]any{
+		{"vmid": 100, "name": "web", "status": "running"},
+		{"vmid": 9000, "name": "debian-12", "status": "stopped", "template": 1},
+	})
+	routes["/nodes/pve1/lxc"] = data([]map[string]any{})
+	routes["/nodes/pve1/qemu/9000/config"] = data(map[string]any{"cores": 1, "memory": "2048"})
+	routes["/cluster/nextid"] = data("101")
+	routes["/nodes/pve1/qemu/9000/clone"] = record("clone", testUPID)
+	routes["/nodes/pve1/qemu/100/config"] = func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			record("web", nil)(w, r)
+			return
+		}
+		data(map[string]any{"cores": 2, "memory": "4096", "scsi0": "local-lvm:vm-100-disk-0,size=32G"})(w, r)
+	}
+	routes["/nodes/pve1/qemu/100/resize"] = record("resize", nil)
+	routes["/nodes/pve1/qemu/101/config"] = record("db", nil)
+	routes["/nodes/pve1/qemu/101/status/start"] = record("start", testUPID)
+	cluster := newFakeProxmox(t, routes)
+	ctx := context.Background()
+
+	plan, err := cluster.Plan(ctx, []VMSpec{
+		{Name: "web", Cores: 4, Memory: 4096, Disks: []DiskSpec{{Name: "scsi0", Storage: "local-lvm", Size: "40G"}}},
+		{Name: "db", Template: "debian-12", Cores: 2, Start: true},
+		{Name: "cache", Template: "alpine"},
+	})
+	require.NoError(t, err)
+	require.Len(t, plan, 3)
+
+	assert.Equal(t, ActionUpdate, plan[0].Action)
+	assert.Equal(t, []Change{
+		{Field: "cores", From: "2", To: "4"},
+		{Field: "scsi0", From: "32G", To: "40G", Resize: true},
+	}, plan[0].Changes)
+	assert.Equal(t, ActionCreate, plan[1].Action)
+	assert.Equal(t, uint64(9000), plan[1].TemplateID)
+	assert.Equal(t, []Change{{Field: "cores", From: "1", To: "2"}}, plan[1].Changes)
+	assert.Contains(t, plan[2].Error, "template alpine not found")
+
+	require.NoError(t, cluster.Apply(ctx, plan, func(string) {}))
+	assert.Equal(t, map[string]any{"cores": "4"}, calls["web"])
+	assert.Equal(t, map[string]any{"disk": "scsi0", "size": "40G"}, calls["resize"])
+	assert.Equal(t, map[string]any{"newid": float64(101), "name": "db"}, calls["clone"])
+	assert.Equal(t, map[string]any{"cores": "2"}, calls["db"])
+	assert.Contains(t, calls, "start")
+}
+
+func TestCluster_PlanTemplates(t *testing.T) {
+	routes := map[string]http.HandlerFunc{
+		"/nodes":             data([]map[string]any{{"node": "pve1", "status": "online"}}),
+		"/nodes/pve1/status": data(map[string]any{}),
+		"/nodes/pve1/qemu": data([]map[string]any{
+			{"vmid": 9001, "name": "debian-12", "status": "stopped", "template": 1},
+			{"vmid": 9000, "name": "debian-12", "status": "stopped", "template": 1},
+		}),
+		"/nodes/pve1/lxc":              data([]map[string]any{}),
+		"/nodes/pve1/qemu/9001/config": data(map[string]any{"cores": 1}),
+	}
+	cluster := newFakeProxmox(t, routes)
+
+	plan, err := cluster.Plan(context.Background(), []VMSpec{
+		{Name: "db", Template: "debian-12"},
+		{Name: "cache", Template: "9001"},
+		{Name: "ci", Template: "9001", CloudInit: &CloudInitSpec{User: "debian"}},
+		{Name: "web", Template: "9001", CloudInit: &CloudInitSpec{User: "debian", Storage: "local-lvm"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, plan, 4)
+	assert.Equal(t, "2 templates are called debian-12 (9000, 9001), use a VMID", plan[0].Error)
+	assert.Empty(t, plan[1].Error)
+	assert.Equal(t, uint64(9001), plan[1].TemplateID)
+	assert.Contains(t, plan[2].Error, "ci has no cloud-init drive, set cloudinit.storage")
+	assert.Empty(t, plan[3].Error)
+	assert.Contains(t, plan[3].Changes, Change{Field: "ide2", To: "local-lvm:cloudinit"})
+}
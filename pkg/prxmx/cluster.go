@@ -7,22 +7,61 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/luthermonson/go-proxmox"
 )
 
+const (
+	// DefaultTimeout bounds every Proxmox API call made while collecting the inventory
+	DefaultTimeout = 10 * time.Second
+	// DefaultWorkers is the number of concurrent Proxmox API calls
+	DefaultWorkers = 8
+	// DefaultClusterName is used to key the inventory when the config has no name
+	DefaultClusterName = "pve"
+)
+
 type Cluster struct {
-	ApiURL string
-	User   string
-	Pass   string
-	Client *proxmox.Client
+	Name    string
+	ApiURL  string
+	User    string
+	Pass    string
+	Client  *proxmox.Client
+	Timeout time.Duration
+	Workers int
 }
 
+// Guest types as reported by Proxmox
+const (
+	GuestTypeVM  = "qemu"
+	GuestTypeLXC = "lxc"
+)
+
+// NodeVersion is the version of the Node document stored in NATS. Entries
+// without a version were keyed by name and only carry Name, IP, Uptime,
+// Running and, sometimes, Type.
+const NodeVersion = 1
+
+// Node is a Proxmox guest, either a QEMU VM or an LXC container
 type Node struct {
-	Name    string
-	IP      []string
-	Uptime  Uptime
-	Running bool
+	Version  int
+	Cluster  string
+	VMID     uint64
+	Name     string
+	Type     string
+	Host     string
+	Tags     []string
+	CPUs     int
+	MaxMem   uint64
+	MaxDisk  uint64
+	IP       []string
+	IPv6     []string
+	MACs     []string
+	OS       string
+	Template string
+	Uptime   Uptime
+	Running  bool
+	Error    string `json:",omitempty"`
 }
 
 type Application struct {
@@ -42,7 +81,7 @@ func NewProxmoxClient(apiURL, user, pass string) *proxmox.Client {
 	return client
 }
 
-func NewCluster(apiURL, user, pass string) *Cluster {
+func NewCluster(apiURL, user, pass string, options ...func(*Cluster)) *Cluster {
 	httpClient := &http.Client{
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
@@ -52,94 +91,58 @@ func NewCluster(apiURL, user, pass string) *Cluster {
 		proxmox.WithAPIToken(user, pass),
 		proxmox.WithHTTPClient(httpClient),
 	)
-	return &Cluster{
-		ApiURL: apiURL,
-		User:   user,
-		Pass:   pass,
-		Client: client,
-	}
+	cluster := &Cluster{
+		Name:    DefaultClusterName,
+		ApiURL:  apiURL,
+		User:    user,
+		Pass:    pass,
+		Client:  client,
+		Timeout: DefaultTimeout,
+		Workers: DefaultWorkers,
+	}
+	for _, o := range options {
+		o(cluster)
+	}
+	return cluster
 }
 
-func (c *Cluster) GetClusterNodes() ([]string, error) {
-	nodes, err := c.Client.Nodes(context.Background())
-	if err != nil {
-		return nil, err
-	}
-	nodeNames := make([]string, 0, len(nodes))
-	for _, node := range nodes {
-		nodeNames = append(nodeNames, node.Node+" "+node.IP)
+// WithName sets the name used to key the cluster guests, empty keeps the default
+func WithName(name string) func(*Cluster) {
+	return func(c *Cluster) {
+		if name != "" {
+			c.Name = name
+		}
 	}
-	return nodeNames, nil
 }
 
-func (c *Cluster) getVirtualMachines() ([]*proxmox.VirtualMachine, error) {
-	nodes, err := c.Client.Nodes(context.Background())
-	if err != nil {
-		return nil, err
-	}
-
-	VMs := []*proxmox.VirtualMachine{}
-	ctx := context.Background()
-
-	for _, nodeStatus := range nodes {
-		node, err := c.Client.Node(ctx, nodeStatus.Node)
-		if err != nil {
-			return nil, err
-		}
-		vms, err := node.VirtualMachines(ctx)
-		if err != nil {
-			return nil, err
-		}
-		for _, vm := range vms {
-			VMs = append(VMs, vm)
+// WithTimeout sets the timeout of each Proxmox API call, zero keeps the default
+func WithTimeout(timeout time.Duration) func(*Cluster) {
+	return func(c *Cluster) {
+		if timeout > 0 {
+			c.Timeout = timeout
 		}
 	}
-	return VMs, nil
 }
 
-func (c *Cluster) GetVMs() ([]Node, error) {
-
-	VMs := []Node{}
-
-	allVms, err := c.getVirtualMachines()
-	if err != nil {
-		return nil, err
-	}
-
-	for _, vm := range allVms {
-		if vm.Template {
-			continue
+// WithWorkers sets how many Proxmox API calls can run concurrently
+func WithWorkers(workers int) func(*Cluster) {
+	return func(c *Cluster) {
+		if workers > 0 {
+			c.Workers = workers
 		}
-		node := Node{
-			Name:    vm.Name,
-			Uptime:  ParseUptime(vm.Uptime),
-			Running: vm.Status == "running",
-		}
-		if node.Running {
-			node.IP = getIPs(vm)
-		}
-		VMs = append(VMs, node)
-
 	}
-
-	return VMs, nil
 }
 
-func getIPs(vm *proxmox.VirtualMachine) []string {
-	ifaces, err := vm.AgentGetNetworkIFaces(context.Background())
+func (c *Cluster) GetClusterNodes() ([]string, error) {
+	nodes, err := c.Client.Nodes(context.Background())
 	if err != nil {
-		return nil
+		return nil, err
 	}
-
-	ips := make([]string, 0, len(ifaces))
-	for _, iface := range ifaces {
-		for _, ip := range iface.IPAddresses {
-			if ip.IPAddressType == "ipv4" {
-				ips = append(ips, ip.IPAddress)
-			}
-		}
+	nodeNames := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		nodeNames = append(nodeNames, node.Node+" "+node.IP)
 	}
-	return ips
+	return nodeNames, nil
 }
 
 type Uptime struct {
@@ -164,6 +167,33 @@ func ParseUptime(uptime uint64) Uptime {
 	}
 }
 
+// IsContainer reports whether the guest is an LXC container. Entries cached
+// before LXC support have no type and are always VMs.
+func (n *Node) IsContainer() bool {
+	return n.Type == GuestTypeLXC
+}
+
+// Key returns the NATS key of the guest: <cluster>.<vmid>. Legacy entries
+// are keyed by name.
+func (n *Node) Key() string {
+	if n.Version == 0 || n.VMID == 0 {
+		return n.Name
+	}
+	return fmt.Sprintf("%s.%d", n.Cluster, n.VMID)
+}
+
+// DecodeNode decodes a Node stored in NATS, upgrading older versions
+func DecodeNode(b []byte) (Node, error) {
+	node := Node{}
+	if err := json.Unmarshal(b, &node); err != nil {
+		return node, err
+	}
+	if node.Version == 0 && node.Type == "" {
+		node.Type = GuestTypeVM
+	}
+	return node, nil
+}
+
 func (c *Node) ToString() string {
 	if c.Running {
 		return fmt.Sprintf("%s - %s - %s ", c.Name, strings.Join(c.IP, ","), c.Uptime.ToString())
@@ -188,6 +218,7 @@ func (u *Uptime) ToStringShort() string {
 	return fmt.Sprintf("%d s", u.Seconds)
 }
 
+// ToBytes returns the JSON document stored in NATS
 func (n *Node) ToBytes() []byte {
 	bresults, err := json.Marshal(n)
 	if err != nil {
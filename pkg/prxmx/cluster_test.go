@@ -7,6 +7,8 @@ import (
 	"testing"
 
 	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var cluster *Cluster
@@ -85,3 +87,18 @@ func TestCluster_GetVMs(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeNode(t *testing.T) {
+	legacy := []byte(`{"Name":"web","IP":["192.168.1.10"],"Uptime":{"Raw":60},"Running":true}`)
+	node, err := DecodeNode(legacy)
+	require.NoError(t, err)
+	assert.Equal(t, 0, node.Version)
+	assert.Equal(t, GuestTypeVM, node.Type)
+	assert.Equal(t, "web", node.Key())
+
+	current := Node{Version: NodeVersion, Cluster: "pve", VMID: 101, Name: "web", Type: GuestTypeLXC}
+	node, err = DecodeNode(current.ToBytes())
+	require.NoError(t, err)
+	assert.Equal(t, current, node)
+	assert.Equal(t, "pve.101", node.Key())
+}
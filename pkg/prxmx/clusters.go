@@ -0,0 +1,227 @@
+package prxmx
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"i2/pkg/models"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+// Clusters are the Proxmox endpoints managed by i2. Guests, storages and
+// tasks are aggregated and tagged with the name of their cluster.
+type Clusters []*Cluster
+
+// ClusterNode is a node status tagged with its cluster
+type ClusterNode struct {
+	Cluster string `json:"cluster"`
+	proxmox.NodeStatus
+}
+
+// NewClusters returns the clusters of the config. Cluster names are used in
+// the NATS keys, so they have to be unique.
+func NewClusters(config *models.Config) (Clusters, error) {
+	clusters := Clusters{}
+	seen := map[string]bool{}
+	for _, p := range config.Proxmox.Endpoints() {
+		cluster := NewCluster(p.URL, p.User, p.Pass,
+			WithName(p.Name),
+			WithTimeout(config.Sync.Timeout),
+			WithWorkers(config.Sync.Workers),
+		)
+		if seen[cluster.Name] {
+			return nil, fmt.Errorf("duplicated Proxmox cluster name %q", cluster.Name)
+		}
+		seen[cluster.Name] = true
+		clusters = append(clusters, cluster)
+	}
+	if len(clusters) == 0 {
+		return nil, errors.New("no Proxmox cluster configured")
+	}
+	return clusters, nil
+}
+
+// Names returns the names of the clusters
+func (cs Clusters) Names() []string {
+	names := make([]string, 0, len(cs))
+	for _, c := range cs {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+// Select returns the cluster with the given name, or every cluster when the
+// name is empty
+func (cs Clusters) Select(name string) (Clusters, error) {
+	if name == "" {
+		return cs, nil
+	}
+	for _, c := range cs {
+		if c.Name == name {
+			return Clusters{c}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown Proxmox cluster %q, use one of: %s", name, strings.Join(cs.Names(), ", "))
+}
+
+// One returns the cluster with the given name, the name can only be empty
+// when there is a single cluster
+func (cs Clusters) One(name string) (*Cluster, error) {
+	if name == "" && len(cs) > 1 {
+		return nil, fmt.Errorf("choose a Proxmox cluster: %s", strings.Join(cs.Names(), ", "))
+	}
+	selected, err := cs.Select(name)
+	if err != nil {
+		return nil, err
+	}
+	return selected[0], nil
+}
+
+// each calls fn for every cluster concurrently and joins the errors, each
+// one prefixed with the cluster name
+func (cs Clusters) each(fn func(i int, c *Cluster) error) error {
+	errs := make([]error, len(cs))
+	runPool(len(cs), len(cs), func(i int) {
+		if err := fn(i, cs[i]); err != nil {
+			errs[i] = fmt.Errorf("%s: %w", cs[i].Name, err)
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// Nodes returns the nodes of every cluster
+func (cs Clusters) Nodes(ctx context.Context) ([]ClusterNode, error) {
+	perCluster := make([][]ClusterNode, len(cs))
+	err := cs.each(func(i int, c *Cluster) error {
+		cctx, cancel := c.callContext(ctx)
+		defer cancel()
+		nodes, err := c.Client.Nodes(cctx)
+		if err != nil {
+			return err
+		}
+		for _, n := range nodes {
+			perCluster[i] = append(perCluster[i], ClusterNode{Cluster: c.Name, NodeStatus: *n})
+		}
+		return nil
+	})
+	return flatten(perCluster), err
+}
+
+// GetVMs returns the guests of every cluster. Like Cluster.GetVMs, partial
+// results are returned with the error.
+func (cs Clusters) GetVMs() ([]Node, error) {
+	perCluster := make([][]Node, len(cs))
+	err := cs.each(func(i int, c *Cluster) (err error) {
+		perCluster[i], err = c.GetVMs()
+		return err
+	})
+	return flatten(perCluster), err
+}
+
+// GetStorage returns the storages of every cluster, partial results are
+// returned with the error
+func (cs Clusters) GetStorage() ([]Storage, error) {
+	perCluster := make([][]Storage, len(cs))
+	err := cs.each(func(i int, c *Cluster) (err error) {
+		perCluster[i], err = c.GetStorage()
+		return err
+	})
+	return flatten(perCluster), err
+}
+
+// Tasks returns the recent tasks of every cluster, newest first
+func (cs Clusters) Tasks(ctx context.Context) ([]TaskInfo, error) {
+	perCluster := make([][]TaskInfo, len(cs))
+	err := cs.each(func(i int, c *Cluster) (err error) {
+		perCluster[i], err = c.Tasks(ctx)
+		return err
+	})
+	tasks := flatten(perCluster)
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].StartTime.After(tasks[j].StartTime)
+	})
+	return tasks, err
+}
+
+// FindGuest returns the guest matching a name, a VMID or a <cluster>.<vmid>
+// key, and the cluster it belongs to
+func (cs Clusters) FindGuest(ctx context.Context, nameOrID string) (*Cluster, Node, error) {
+	candidates := cs
+	if name, _, found := strings.Cut(nameOrID, "."); found {
+		if selected, err := cs.Select(name); err == nil {
+			candidates = selected
+		}
+	}
+	if len(candidates) == 1 {
+		node, err := candidates[0].FindGuest(ctx, nameOrID)
+		return candidates[0], node, err
+	}
+
+	nodes := make([]Node, len(candidates))
+	errs := make([]error, len(candidates))
+	runPool(len(candidates), len(candidates), func(i int) {
+		nodes[i], errs[i] = candidates[i].FindGuest(ctx, nameOrID)
+	})
+	found := []int{}
+	for i, err := range errs {
+		if err == nil {
+			found = append(found, i)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, Node{}, errors.Join(errs...)
+	case 1:
+		return candidates[found[0]], nodes[found[0]], nil
+	}
+	keys := make([]string, 0, len(found))
+	for _, i := range found {
+		keys = append(keys, nodes[i].Key())
+	}
+	return nil, Node{}, fmt.Errorf("%s is ambiguous, use one of: %s", nameOrID, strings.Join(keys, ", "))
+}
+
+// ForNode returns the cluster a Proxmox node belongs to, node names found in
+// several clusters are ambiguous
+func (cs Clusters) ForNode(ctx context.Context, node string) (*Cluster, error) {
+	if len(cs) == 1 {
+		return cs[0], nil
+	}
+	nodes, err := cs.Nodes(ctx)
+	found := []string{}
+	for _, n := range nodes {
+		if n.Node == node {
+			found = append(found, n.Cluster)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, cmp.Or(err, fmt.Errorf("node %s not found in %s", node, strings.Join(cs.Names(), ", ")))
+	case 1:
+		cluster, _ := cs.Select(found[0])
+		return cluster[0], nil
+	}
+	return nil, fmt.Errorf("node %s is ambiguous, it's in the clusters %s, select one", node, strings.Join(found, ", "))
+}
+
+// ForTask returns the cluster running a task, found by the node in its UPID
+func (cs Clusters) ForTask(ctx context.Context, upid string) (*Cluster, error) {
+	task := proxmox.NewTask(proxmox.UPID(upid), nil)
+	if task == nil {
+		return nil, fmt.Errorf("invalid task id %q", upid)
+	}
+	return cs.ForNode(ctx, task.Node)
+}
+
+func flatten[T any](parts [][]T) []T {
+	all := []T{}
+	for _, part := range parts {
+		all = append(all, part...)
+	}
+	return all
+}
@@ -0,0 +1,91 @@
+package prxmx
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"i2/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClusters(t *testing.T) {
+	config := &models.Config{Proxmox: models.Proxmox{
+		URL: "https://pve1:8006/api2/json",
+		Clusters: []models.Proxmox{
+			{Name: "standalone", URL: "https://pve9:8006/api2/json"},
+		},
+	}}
+	clusters, err := NewClusters(config)
+	require.NoError(t, err)
+	assert.Equal(t, []string{DefaultClusterName, "standalone"}, clusters.Names())
+
+	selected, err := clusters.Select("standalone")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"standalone"}, selected.Names())
+	_, err = clusters.Select("nope")
+	assert.ErrorContains(t, err, "use one of: pve, standalone")
+
+	config.Proxmox.Clusters[0].Name = ""
+	_, err = NewClusters(config)
+	assert.ErrorContains(t, err, "duplicated")
+
+	_, err = NewClusters(&models.Config{})
+	assert.Error(t, err)
+}
+
+func TestClusters_Aggregate(t *testing.T) {
+	guests := func(node string, vms ...map[string]any) map[string]http.HandlerFunc {
+		return map[string]http.HandlerFunc{
+			"/nodes":                              data([]map[string]any{{"node": node, "status": "online"}}),
+			"/nodes/" + node + "/status":          data(map[string]any{}),
+			"/nodes/" + node + "/qemu":            data(vms),
+			"/nodes/" + node + "/lxc":             data([]map[string]any{}),
+			"/nodes/" + node + "/qemu/100/config": data(map[string]any{}),
+			"/nodes/" + node + "/qemu/101/config": data(map[string]any{}),
+		}
+	}
+	main := newFakeProxmox(t, guests("pve1",
+		map[string]any{"vmid": 100, "name": "web", "status": "stopped"},
+		map[string]any{"vmid": 101, "name": "db", "status": "stopped"},
+	), WithName("main"))
+	standalone := newFakeProxmox(t, guests("pve9",
+		map[string]any{"vmid": 100, "name": "web", "status": "stopped"},
+	), WithName("standalone"))
+	clusters := Clusters{main, standalone}
+	ctx := context.Background()
+
+	vms, err := clusters.GetVMs()
+	require.NoError(t, err)
+	keys := []string{}
+	for _, vm := range vms {
+		keys = append(keys, vm.Key())
+	}
+	assert.Equal(t, []string{"main.100", "main.101", "standalone.100"}, keys)
+
+	cluster, db, err := clusters.FindGuest(ctx, "db")
+	require.NoError(t, err)
+	assert.Equal(t, "main", cluster.Name)
+	assert.Equal(t, uint64(101), db.VMID)
+
+	_, _, err = clusters.FindGuest(ctx, "web")
+	assert.ErrorContains(t, err, "main.100, standalone.100")
+	cluster, web, err := clusters.FindGuest(ctx, "standalone.100")
+	require.NoError(t, err)
+	assert.Equal(t, "standalone", cluster.Name)
+	assert.Equal(t, "pve9", web.Host)
+
+	cluster, err = clusters.ForTask(ctx, "UPID:pve9:0000A1B2:0012C3D4:66E00000:qmstart:100:root@pam:")
+	require.NoError(t, err)
+	assert.Equal(t, "standalone", cluster.Name)
+	_, err = clusters.ForNode(ctx, "pve5")
+	assert.ErrorContains(t, err, "not found")
+
+	// node names aren't unique across clusters
+	other := newFakeProxmox(t, guests("pve9"), WithName("other"))
+	clusters = Clusters{main, standalone, other}
+	_, err = clusters.ForTask(ctx, "UPID:pve9:0000A1B2:0012C3D4:66E00000:qmstart:100:root@pam:")
+	assert.ErrorContains(t, err, "node pve9 is ambiguous, it's in the clusters standalone, other")
+}
@@ -0,0 +1,356 @@
+package prxmx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+// guest is either a QEMU VM or an LXC container found on a Proxmox node
+type guest struct {
+	vm *proxmox.VirtualMachine
+	ct *proxmox.Container
+}
+
+// runPool calls fn for every index in [0, n) using at most workers goroutines
+func runPool(n, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// callContext returns a context bounded by the cluster per-call timeout
+func (c *Cluster) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// getGuests lists the guests of every node concurrently. Nodes that fail are
+// reported in the returned error, the guests of the remaining nodes are
+// still returned. Templates are returned apart, keyed by VMID.
+func (c *Cluster) getGuests(ctx context.Context) ([]guest, map[uint64]Node, error) {
+	cctx, cancel := c.callContext(ctx)
+	nodes, err := c.Client.Nodes(cctx)
+	cancel()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	perNode := make([][]guest, len(nodes))
+	errs := make([]error, len(nodes))
+	runPool(len(nodes), c.Workers, func(i int) {
+		perNode[i], errs[i] = c.nodeGuests(ctx, nodes[i].Node)
+	})
+
+	guests := []guest{}
+	templates := map[uint64]Node{}
+	for _, gs := range perNode {
+		for _, g := range gs {
+			if g.vm != nil && g.vm.Template {
+				templates[uint64(g.vm.VMID)] = c.guestNode(g)
+				continue
+			}
+			guests = append(guests, g)
+		}
+	}
+	return guests, templates, errors.Join(errs...)
+}
+
+func (c *Cluster) nodeGuests(ctx context.Context, name string) ([]guest, error) {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+
+	node, err := c.Client.Node(cctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("node %s: %w", name, err)
+	}
+	vms, err := node.VirtualMachines(cctx)
+	if err != nil {
+		return nil, fmt.Errorf("node %s: %w", name, err)
+	}
+	cts, err := node.Containers(cctx)
+	if err != nil {
+		return nil, fmt.Errorf("node %s: %w", name, err)
+	}
+
+	guests := make([]guest, 0, len(vms)+len(cts))
+	for _, vm := range vms {
+		guests = append(guests, guest{vm: vm})
+	}
+	for _, ct := range cts {
+		guests = append(guests, guest{ct: ct})
+	}
+	return guests, nil
+}
+
+// GetVMs returns every guest in the cluster, both QEMU VMs and LXC containers.
+// Guests are inspected concurrently and every call is bounded by the cluster
+// timeout, so a hung guest agent only affects its own entry: the guest is
+// returned with its Error set and the error is also part of the returned
+// error. Callers should use the nodes even when the error is not nil.
+func (c *Cluster) GetVMs() ([]Node, error) {
+	ctx := context.Background()
+
+	guests, templates, err := c.getGuests(ctx)
+	if len(guests) == 0 && err != nil {
+		return nil, err
+	}
+
+	VMs := make([]Node, len(guests))
+	runPool(len(guests), c.Workers, func(i int) {
+		VMs[i] = c.toNode(ctx, guests[i], templates)
+	})
+
+	errs := []error{err}
+	for _, vm := range VMs {
+		if vm.Error != "" {
+			errs = append(errs, fmt.Errorf("%s: %s", vm.Name, vm.Error))
+		}
+	}
+	return VMs, errors.Join(errs...)
+}
+
+func (c *Cluster) toNode(ctx context.Context, g guest, templates map[uint64]Node) Node {
+	node := c.guestNode(g)
+	var errs []error
+	if node.Running {
+		if g.ct != nil {
+			errs = append(errs, c.containerNetwork(ctx, g.ct, &node))
+		} else {
+			errs = append(errs, c.vmAgentInfo(ctx, g.vm, &node))
+		}
+	}
+	errs = append(errs, c.guestConfig(ctx, &node, templates))
+
+	if err := errors.Join(errs...); err != nil {
+		node.Error = err.Error()
+	}
+	return node
+}
+
+// guestNode returns the guest fields available in the node listing, without
+// asking the guest agent or reading the guest config
+func (c *Cluster) guestNode(g guest) Node {
+	var node Node
+	if g.ct != nil {
+		node = Node{
+			Name:    g.ct.Name,
+			Type:    GuestTypeLXC,
+			VMID:    uint64(g.ct.VMID),
+			Host:    g.ct.Node,
+			Tags:    SplitTags(g.ct.Tags),
+			CPUs:    g.ct.CPUs,
+			MaxMem:  g.ct.MaxMem,
+			MaxDisk: g.ct.MaxDisk,
+			Uptime:  ParseUptime(g.ct.Uptime),
+			Running: g.ct.Status == "running",
+		}
+	} else {
+		node = Node{
+			Name:    g.vm.Name,
+			Type:    GuestTypeVM,
+			VMID:    uint64(g.vm.VMID),
+			Host:    g.vm.Node,
+			Tags:    SplitTags(g.vm.Tags),
+			CPUs:    g.vm.CPUs,
+			MaxMem:  g.vm.MaxMem,
+			MaxDisk: g.vm.MaxDisk,
+			Uptime:  ParseUptime(g.vm.Uptime),
+			Running: g.vm.Status == "running",
+		}
+	}
+	node.Version = NodeVersion
+	node.Cluster = c.Name
+	return node
+}
+
+// guestConfig reads the guest config to get the MAC addresses, the OS type
+// when the agent didn't report one and the template of linked clones
+func (c *Cluster) guestConfig(ctx context.Context, node *Node, templates map[uint64]Node) error {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+
+	config := map[string]any{}
+	err := c.Client.Get(cctx, fmt.Sprintf("/nodes/%s/%s/%d/config", node.Host, node.Type, node.VMID), &config)
+	if err != nil {
+		return err
+	}
+	node.MACs = configMACs(config)
+	if node.OS == "" {
+		node.OS, _ = config["ostype"].(string)
+	}
+	if base, ok := configBaseVMID(config); ok {
+		node.Template = templates[base].Name
+		if node.Template == "" {
+			node.Template = fmt.Sprintf("%d", base)
+		}
+	}
+	return nil
+}
+
+// vmAgentInfo asks the guest agent for the addresses and the OS of a VM. A
+// VM without a running agent has no addresses, the OS is best effort: old
+// agents don't support get-osinfo.
+func (c *Cluster) vmAgentInfo(ctx context.Context, vm *proxmox.VirtualMachine, node *Node) error {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	ifaces, err := vm.AgentGetNetworkIFaces(cctx)
+	if err != nil {
+		if agentUnavailable(err) {
+			return nil
+		}
+		return err
+	}
+	node.IP, node.IPv6 = agentIPs(ifaces)
+
+	octx, ocancel := c.callContext(ctx)
+	defer ocancel()
+	if info, err := vm.AgentOsInfo(octx); err == nil {
+		node.OS = info.PrettyName
+	}
+	return nil
+}
+
+// agentUnavailable reports whether a guest agent call failed because the
+// agent isn't configured or isn't running in the VM
+func agentUnavailable(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "guest agent is not running") ||
+		strings.Contains(msg, "no qemu guest agent configured") ||
+		strings.Contains(msg, "guest agent not available")
+}
+
+func (c *Cluster) containerNetwork(ctx context.Context, ct *proxmox.Container, node *Node) error {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	ifaces, err := ct.Interfaces(cctx)
+	if err != nil {
+		return err
+	}
+	node.IP, node.IPv6 = containerIPs(ifaces)
+	return nil
+}
+
+func agentIPs(ifaces []*proxmox.AgentNetworkIface) ([]string, []string) {
+	ipv4 := []string{}
+	ipv6 := []string{}
+	for _, iface := range ifaces {
+		for _, ip := range iface.IPAddresses {
+			switch ip.IPAddressType {
+			case "ipv4":
+				ipv4 = append(ipv4, ip.IPAddress)
+			case "ipv6":
+				ipv6 = append(ipv6, ip.IPAddress)
+			}
+		}
+	}
+	return ipv4, ipv6
+}
+
+// containerIPs extracts the addresses of the LXC interfaces, the API returns
+// them in CIDR notation (192.168.1.10/24)
+func containerIPs(ifaces proxmox.ContainerInterfaces) ([]string, []string) {
+	ipv4 := []string{}
+	ipv6 := []string{}
+	for _, iface := range ifaces {
+		if iface == nil || iface.Name == "lo" {
+			continue
+		}
+		if iface.Inet != "" {
+			ip, _, _ := strings.Cut(iface.Inet, "/")
+			ipv4 = append(ipv4, ip)
+		}
+		if iface.Inet6 != "" {
+			ip, _, _ := strings.Cut(iface.Inet6, "/")
+			ipv6 = append(ipv6, ip)
+		}
+	}
+	return ipv4, ipv6
+}
+
+// SplitTags splits the Proxmox tag list, tags are separated by ';' (or ','
+// and spaces in older versions)
+func SplitTags(tags string) []string {
+	return strings.FieldsFunc(tags, func(r rune) bool {
+		return r == ';' || r == ',' || r == ' '
+	})
+}
+
+// configMACs returns the MAC addresses of the netN entries of a guest config.
+// QEMU uses "virtio=BC:24:11:00:00:01,bridge=vmbr0" and LXC uses
+// "name=eth0,hwaddr=BC:24:11:00:00:01,bridge=vmbr0".
+func configMACs(config map[string]any) []string {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		if strings.HasPrefix(key, "net") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	macs := []string{}
+	for _, key := range keys {
+		value, ok := config[key].(string)
+		if !ok {
+			continue
+		}
+		for _, opt := range strings.Split(value, ",") {
+			_, v, found := strings.Cut(opt, "=")
+			if !found {
+				continue
+			}
+			if _, err := net.ParseMAC(v); err == nil {
+				macs = append(macs, v)
+				break
+			}
+		}
+	}
+	return macs
+}
+
+var baseVolume = regexp.MustCompile(`base-(\d+)-disk-\d+`)
+
+// configBaseVMID returns the template VMID of a linked clone: its volumes
+// reference the template base volume, "local-lvm:base-9000-disk-0/vm-101-disk-0"
+func configBaseVMID(config map[string]any) (uint64, bool) {
+	for _, value := range config {
+		v, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if m := baseVolume.FindStringSubmatch(v); m != nil {
+			id, err := strconv.ParseUint(m[1], 10, 64)
+			if err == nil {
+				return id, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,194 @@
+package prxmx
+
+import (
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunPool(t *testing.T) {
+	var running, maxRunning, calls int32
+	seen := make([]bool, 20)
+	runPool(len(seen), 3, func(i int) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		seen[i] = true
+		atomic.AddInt32(&calls, 1)
+		atomic.AddInt32(&running, -1)
+	})
+
+	assert.Equal(t, int32(20), calls)
+	assert.LessOrEqual(t, maxRunning, int32(3))
+	for i, ok := range seen {
+		assert.True(t, ok, "index %d was not processed", i)
+	}
+}
+
+func TestCluster_GetVMsPartial(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+
+	cluster := newFakeProxmox(t, map[string]http.HandlerFunc{
+		"/nodes":             data([]map[string]any{{"node": "pve1", "status": "online"}}),
+		"/nodes/pve1/status": data(map[string]any{}),
+		"/nodes/pve1/qemu": data([]map[string]any{
+			{"vmid": 100, "name": "ok", "status": "running", "tags": "docker;prod", "cpus": 2, "maxmem": 4096},
+			{"vmid": 101, "name": "hung", "status": "running"},
+			{"vmid": 102, "name": "tmpl", "status": "stopped", "template": 1},
+		}),
+		"/nodes/pve1/lxc": data([]map[string]any{
+			{"vmid": 200, "name": "ct", "status": "running"},
+		}),
+		"/nodes/pve1/qemu/100/agent/network-get-interfaces": data(map[string]any{
+			"result": []map[string]any{{
+				"name": "eth0",
+				"ip-addresses": []map[string]any{
+					{"ip-address-type": "ipv4", "ip-address": "192.168.1.10"},
+					{"ip-address-type": "ipv6", "ip-address": "fe80::1"},
+				},
+			}},
+		}),
+		"/nodes/pve1/qemu/100/agent/get-osinfo": data(map[string]any{
+			"result": map[string]any{"pretty-name": "Debian GNU/Linux 12 (bookworm)"},
+		}),
+		"/nodes/pve1/qemu/100/config": data(map[string]any{
+			"net0":  "virtio=BC:24:11:00:00:01,bridge=vmbr0",
+			"scsi0": "local-lvm:base-102-disk-0/vm-100-disk-0,size=32G",
+		}),
+		"/nodes/pve1/qemu/101/config": data(map[string]any{}),
+		"/nodes/pve1/lxc/200/config": data(map[string]any{
+			"ostype": "ubuntu",
+			"net0":   "name=eth0,bridge=vmbr0,hwaddr=BC:24:11:00:00:02,ip=dhcp",
+		}),
+		"/nodes/pve1/qemu/101/agent/network-get-interfaces": func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-hang:
+			case <-r.Context().Done():
+			}
+		},
+		"/nodes/pve1/lxc/200/interfaces": data([]map[string]any{
+			{"name": "eth0", "inet": "192.168.1.20/24"},
+		}),
+	}, WithTimeout(200*time.Millisecond))
+
+	start := time.Now()
+	vms, err := cluster.GetVMs()
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	require.Len(t, vms, 3)
+	byName := map[string]Node{}
+	for _, vm := range vms {
+		byName[vm.Name] = vm
+	}
+	ok := byName["ok"]
+	assert.Empty(t, ok.Error)
+	assert.Equal(t, "pve.100", ok.Key())
+	assert.Equal(t, "pve1", ok.Host)
+	assert.Equal(t, []string{"docker", "prod"}, ok.Tags)
+	assert.Equal(t, []string{"192.168.1.10"}, ok.IP)
+	assert.Equal(t, []string{"fe80::1"}, ok.IPv6)
+	assert.Equal(t, []string{"BC:24:11:00:00:01"}, ok.MACs)
+	assert.Equal(t, "Debian GNU/Linux 12 (bookworm)", ok.OS)
+	assert.Equal(t, "tmpl", ok.Template)
+	assert.NotEmpty(t, byName["hung"].Error)
+	assert.Equal(t, GuestTypeLXC, byName["ct"].Type)
+	assert.Equal(t, []string{"192.168.1.20"}, byName["ct"].IP)
+	assert.Equal(t, []string{"BC:24:11:00:00:02"}, byName["ct"].MACs)
+	assert.Equal(t, "ubuntu", byName["ct"].OS)
+}
+
+func TestCluster_GetVMsWithoutAgent(t *testing.T) {
+	cluster := newFakeProxmox(t, map[string]http.HandlerFunc{
+		"/nodes":             data([]map[string]any{{"node": "pve1", "status": "online"}}),
+		"/nodes/pve1/status": data(map[string]any{}),
+		"/nodes/pve1/lxc":    data([]map[string]any{}),
+		"/nodes/pve1/qemu": data([]map[string]any{
+			{"vmid": 100, "name": "noagent", "status": "running"},
+			{"vmid": 101, "name": "oldagent", "status": "running"},
+		}),
+		"/nodes/pve1/qemu/100/agent/network-get-interfaces": fail(http.StatusInternalServerError, "QEMU guest agent is not running"),
+		"/nodes/pve1/qemu/101/agent/network-get-interfaces": data(map[string]any{
+			"result": []map[string]any{{
+				"name":         "eth0",
+				"ip-addresses": []map[string]any{{"ip-address-type": "ipv4", "ip-address": "192.168.1.11"}},
+			}},
+		}),
+		"/nodes/pve1/qemu/101/agent/get-osinfo": fail(http.StatusInternalServerError, "The command guest-get-osinfo has not been found"),
+		"/nodes/pve1/qemu/100/config":           data(map[string]any{"ostype": "l26"}),
+		"/nodes/pve1/qemu/101/config":           data(map[string]any{"ostype": "l26"}),
+	})
+
+	vms, err := cluster.GetVMs()
+	require.NoError(t, err)
+	require.Len(t, vms, 2)
+	for _, vm := range vms {
+		assert.Empty(t, vm.Error)
+		assert.Equal(t, "l26", vm.OS)
+	}
+	byName := map[string]Node{vms[0].Name: vms[0], vms[1].Name: vms[1]}
+	assert.Empty(t, byName["noagent"].IP)
+	assert.Equal(t, []string{"192.168.1.11"}, byName["oldagent"].IP)
+}
+
+func TestContainerIPs(t *testing.T) {
+	tests := []struct {
+		name   string
+		ifaces proxmox.ContainerInterfaces
+		ipv4   []string
+		ipv6   []string
+	}{
+		{
+			name: "strips cidr and loopback",
+			ifaces: proxmox.ContainerInterfaces{
+				{Name: "lo", Inet: "127.0.0.1/8"},
+				{Name: "eth0", Inet: "192.168.1.20/24", Inet6: "fe80::1/64"},
+			},
+			ipv4: []string{"192.168.1.20"},
+			ipv6: []string{"fe80::1"},
+		},
+		{
+			name: "interface without ipv4",
+			ifaces: proxmox.ContainerInterfaces{
+				{Name: "eth0", Inet6: "fe80::1/64"},
+			},
+			ipv4: []string{},
+			ipv6: []string{"fe80::1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipv4, ipv6 := containerIPs(tt.ifaces)
+			if !reflect.DeepEqual(ipv4, tt.ipv4) || !reflect.DeepEqual(ipv6, tt.ipv6) {
+				t.Errorf("containerIPs() = %v %v, want %v %v", ipv4, ipv6, tt.ipv4, tt.ipv6)
+			}
+		})
+	}
+}
+
+func TestConfigBaseVMID(t *testing.T) {
+	id, ok := configBaseVMID(map[string]any{"scsi0": "local-lvm:base-9000-disk-0/vm-101-disk-0,size=32G"})
+	assert.True(t, ok)
+	assert.Equal(t, uint64(9000), id)
+
+	_, ok = configBaseVMID(map[string]any{"scsi0": "local-lvm:vm-101-disk-0,size=32G", "cores": 2})
+	assert.False(t, ok)
+}
+
+func TestSplitTags(t *testing.T) {
+	assert.Equal(t, []string{"docker", "prod"}, SplitTags("docker;prod"))
+	assert.Equal(t, []string{"a", "b"}, SplitTags("a, b"))
+	assert.Empty(t, SplitTags(""))
+}
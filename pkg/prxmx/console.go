@@ -0,0 +1,379 @@
+package prxmx
+
+import (
+	"bytes"
+	"context"
+	"crypto/des"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/luthermonson/go-proxmox"
+)
+
+// Console types: serial is the text console of termproxy, vnc the graphic
+// console of vncproxy
+const (
+	ConsoleSerial = "serial"
+	ConsoleVNC    = "vnc"
+)
+
+// ConsoleKeepAlive is how often a keep alive is sent on a serial console,
+// Proxmox closes idle consoles
+var ConsoleKeepAlive = 30 * time.Second
+
+// ConsoleTicket is the one time ticket of a console. The VNC password is only
+// set for VNC consoles and is only valid for the guest.
+type ConsoleTicket struct {
+	Type     string
+	Port     int
+	Ticket   string
+	User     string
+	Password string
+}
+
+type consoleProxy struct {
+	Port     proxmox.StringOrInt `json:"port"`
+	Ticket   string              `json:"ticket"`
+	User     string              `json:"user"`
+	Password string              `json:"password"`
+}
+
+// CheckConsoleType returns an error when kind is not a console type
+func CheckConsoleType(kind string) error {
+	if kind != ConsoleSerial && kind != ConsoleVNC {
+		return fmt.Errorf("invalid console type %q, use %s or %s", kind, ConsoleSerial, ConsoleVNC)
+	}
+	return nil
+}
+
+// ConsoleTicket asks Proxmox for a console ticket. Serial consoles of VMs
+// use serial0, the VM needs a serial port configured.
+func (c *Cluster) ConsoleTicket(ctx context.Context, guest Node, kind string) (ConsoleTicket, error) {
+	if err := CheckConsoleType(kind); err != nil {
+		return ConsoleTicket{}, err
+	}
+	if !guest.Running {
+		return ConsoleTicket{}, fmt.Errorf("%s is not running", guest.Name)
+	}
+	params := map[string]any{}
+	command := "termproxy"
+	switch {
+	case kind == ConsoleVNC:
+		command = "vncproxy"
+		params["websocket"] = 1
+		if !guest.IsContainer() {
+			params["generate-password"] = 1
+		}
+	case !guest.IsContainer():
+		params["serial"] = "serial0"
+	}
+
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	var proxy consoleProxy
+	err := c.Client.Post(cctx, fmt.Sprintf("/nodes/%s/%s/%d/%s", guest.Host, guest.Type, guest.VMID, command), params, &proxy)
+	if err != nil {
+		return ConsoleTicket{}, fmt.Errorf("error opening the console of %s: %w", guest.Name, err)
+	}
+	return ConsoleTicket{
+		Type:     kind,
+		Port:     int(proxy.Port),
+		Ticket:   proxy.Ticket,
+		User:     proxy.User,
+		Password: proxy.Password,
+	}, nil
+}
+
+// DialConsole opens the console websocket of a guest. Serial consoles are
+// returned logged in, ready for the termproxy messages built by ConsoleInput
+// and ConsoleResize. VNC consoles are raw RFB, LoginVNC logs them in.
+func (c *Cluster) DialConsole(ctx context.Context, guest Node, kind string) (*websocket.Conn, ConsoleTicket, error) {
+	ticket, err := c.ConsoleTicket(ctx, guest, kind)
+	if err != nil {
+		return nil, ticket, err
+	}
+
+	wsURL := strings.Replace(strings.Replace(c.ApiURL, "https://", "wss://", 1), "http://", "ws://", 1)
+	query := url.Values{"port": {fmt.Sprint(ticket.Port)}, "vncticket": {ticket.Ticket}}
+	wsURL = fmt.Sprintf("%s/nodes/%s/%s/%d/vncwebsocket?%s", wsURL, guest.Host, guest.Type, guest.VMID, query.Encode())
+
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: c.Timeout,
+		TLSClientConfig:  &tls.Config{InsecureSkipVerify: true},
+	}
+	header := http.Header{}
+	header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s=%s", c.User, c.Pass))
+	if kind == ConsoleVNC {
+		header.Set("Sec-WebSocket-Protocol", "binary")
+	}
+	conn, _, err := dialer.DialContext(ctx, wsURL, header)
+	if err != nil {
+		return nil, ticket, fmt.Errorf("error connecting to the console of %s: %w", guest.Name, err)
+	}
+	if kind == ConsoleVNC {
+		return conn, ticket, nil
+	}
+
+	// termproxy wants user:ticket before anything else and answers OK
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte(ticket.User+":"+ticket.Ticket+"\n")); err != nil {
+		conn.Close()
+		return nil, ticket, err
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(c.Timeout))
+	_, msg, err := conn.ReadMessage()
+	if err == nil && !strings.HasPrefix(string(msg), "OK") {
+		err = errors.New(strings.TrimSpace(string(msg)))
+	}
+	if err != nil {
+		conn.Close()
+		return nil, ticket, fmt.Errorf("error logging in the console of %s: %w", guest.Name, err)
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	return conn, ticket, nil
+}
+
+// ConsoleInput frames input for a serial console
+func ConsoleInput(data []byte) []byte {
+	return append([]byte(fmt.Sprintf("0:%d:", len(data))), data...)
+}
+
+// ConsoleResize frames a terminal size change for a serial console
+func ConsoleResize(cols, rows int) []byte {
+	return []byte(fmt.Sprintf("1:%d:%d:", cols, rows))
+}
+
+// ConsoleKeepAliveMessage keeps a serial console open
+var ConsoleKeepAliveMessage = []byte("2")
+
+// ProxyConsole copies the messages between a client websocket and a console
+// websocket until one of them is closed. Closing the console isn't an error.
+func ProxyConsole(client, console *websocket.Conn) error {
+	errs := make(chan error, 2)
+	pipe := func(dst, src *websocket.Conn) {
+		for {
+			kind, msg, err := src.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := dst.WriteMessage(kind, msg); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}
+	go pipe(console, client)
+	go pipe(client, console)
+
+	err := <-errs
+	closing := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	_ = client.WriteControl(websocket.CloseMessage, closing, time.Now().Add(time.Second))
+	_ = console.WriteControl(websocket.CloseMessage, closing, time.Now().Add(time.Second))
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+		return nil
+	}
+	return err
+}
+
+// ConsoleOriginAllowed reports whether a browser page may open a console:
+// its origin must be the public URL of the API or the host the request was
+// sent to. Requests without an Origin are refused, other clients have to
+// send one of them.
+func ConsoleOriginAllowed(r *http.Request, publicURL string) bool {
+	u, err := url.Parse(r.Header.Get("Origin"))
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	public, err := url.Parse(publicURL)
+	return publicURL != "" && err == nil && strings.EqualFold(public.Scheme, u.Scheme) && strings.EqualFold(public.Host, u.Host)
+}
+
+// LoginVNC authenticates a VNC console with the password of its ticket, the
+// VNC ticket for containers, and offers the client a handshake without
+// authentication, so the password never leaves i2. Both sides are then
+// ready for the ClientInit of the client.
+func LoginVNC(client, console *websocket.Conn, ticket ConsoleTicket) error {
+	password := ticket.Password
+	if password == "" {
+		password = ticket.Ticket
+	}
+	server := &wsStream{conn: console}
+	if err := vncServerLogin(server, password); err != nil {
+		return fmt.Errorf("error logging in the VNC console: %w", err)
+	}
+	viewer := &wsStream{conn: client}
+	if err := vncClientHandshake(viewer); err != nil {
+		return fmt.Errorf("error in the VNC handshake of the client: %w", err)
+	}
+	// bytes read past the handshakes belong to the other side
+	if len(server.pending) > 0 {
+		if _, err := viewer.Write(server.pending); err != nil {
+			return err
+		}
+	}
+	if len(viewer.pending) > 0 {
+		if _, err := server.Write(viewer.pending); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RFB security types
+const (
+	vncSecurityNone = 1
+	vncSecurityVNC  = 2
+)
+
+// vncServerLogin runs the RFB 3.8 handshake with a VNC server
+func vncServerLogin(rw io.ReadWriter, password string) error {
+	version := make([]byte, 12)
+	if _, err := io.ReadFull(rw, version); err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(version, []byte("RFB ")) {
+		return fmt.Errorf("not a VNC server: %q", version)
+	}
+	if _, err := rw.Write([]byte("RFB 003.008\n")); err != nil {
+		return err
+	}
+	count := []byte{0}
+	if _, err := io.ReadFull(rw, count); err != nil {
+		return err
+	}
+	if count[0] == 0 {
+		return vncReason(rw)
+	}
+	types := make([]byte, count[0])
+	if _, err := io.ReadFull(rw, types); err != nil {
+		return err
+	}
+	switch {
+	case bytes.IndexByte(types, vncSecurityVNC) >= 0:
+		if _, err := rw.Write([]byte{vncSecurityVNC}); err != nil {
+			return err
+		}
+		challenge := make([]byte, 16)
+		if _, err := io.ReadFull(rw, challenge); err != nil {
+			return err
+		}
+		if _, err := rw.Write(vncResponse(password, challenge)); err != nil {
+			return err
+		}
+	case bytes.IndexByte(types, vncSecurityNone) >= 0:
+		if _, err := rw.Write([]byte{vncSecurityNone}); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported VNC security types %v", types)
+	}
+	var result uint32
+	if err := binary.Read(rw, binary.BigEndian, &result); err != nil {
+		return err
+	}
+	if result != 0 {
+		return vncReason(rw)
+	}
+	return nil
+}
+
+// vncClientHandshake offers a VNC client the None security type, RFB 3.3
+// clients are told so directly
+func vncClientHandshake(rw io.ReadWriter) error {
+	if _, err := rw.Write([]byte("RFB 003.008\n")); err != nil {
+		return err
+	}
+	version := make([]byte, 12)
+	if _, err := io.ReadFull(rw, version); err != nil {
+		return err
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(string(version), "RFB %03d.%03d\n", &major, &minor); err != nil {
+		return fmt.Errorf("invalid RFB version %q", version)
+	}
+	if major == 3 && minor < 7 {
+		return binary.Write(rw, binary.BigEndian, uint32(vncSecurityNone))
+	}
+	if _, err := rw.Write([]byte{1, vncSecurityNone}); err != nil {
+		return err
+	}
+	choice := []byte{0}
+	if _, err := io.ReadFull(rw, choice); err != nil {
+		return err
+	}
+	if choice[0] != vncSecurityNone {
+		return fmt.Errorf("unsupported security type %d", choice[0])
+	}
+	if minor < 8 {
+		return nil
+	}
+	return binary.Write(rw, binary.BigEndian, uint32(0))
+}
+
+// vncReason reads the reason of a failed RFB handshake
+func vncReason(r io.Reader) error {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return err
+	}
+	reason := make([]byte, min(length, 1024))
+	if _, err := io.ReadFull(r, reason); err != nil {
+		return err
+	}
+	return errors.New(string(reason))
+}
+
+// vncResponse encrypts a VNC authentication challenge with DES, the key is
+// the password padded to 8 bytes with the bits of every byte reversed
+func vncResponse(password string, challenge []byte) []byte {
+	key := make([]byte, 8)
+	copy(key, password)
+	for i, b := range key {
+		key[i] = bits.Reverse8(b)
+	}
+	block, _ := des.NewCipher(key)
+	response := make([]byte, 16)
+	block.Encrypt(response[:8], challenge[:8])
+	block.Encrypt(response[8:], challenge[8:])
+	return response
+}
+
+// wsStream reads and writes a websocket of binary messages as a stream,
+// pending holds the end of the last message read
+type wsStream struct {
+	conn    *websocket.Conn
+	pending []byte
+}
+
+func (s *wsStream) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		_, msg, err := s.conn.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		s.pending = msg
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *wsStream) Write(p []byte) (int, error) {
+	if err := s.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,185 @@
+package prxmx
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// consoleRoutes is a running VM whose serial console answers every input
+// with "echo:<input>"
+func consoleRoutes(t *testing.T) map[string]http.HandlerFunc {
+	upgrader := websocket.Upgrader{}
+	return map[string]http.HandlerFunc{
+		"/nodes":             data([]map[string]any{{"node": "pve1", "status": "online"}}),
+		"/nodes/pve1/status": data(map[string]any{}),
+		"/nodes/pve1/lxc":    data([]map[string]any{}),
+		"/nodes/pve1/qemu": data([]map[string]any{
+			{"vmid": 100, "name": "web", "status": "running"},
+		}),
+		"/nodes/pve1/qemu/100/termproxy": func(w http.ResponseWriter, r *http.Request) {
+			params := map[string]any{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&params))
+			assert.Equal(t, "serial0", params["serial"])
+			data(map[string]any{"port": "5900", "ticket": "PVEVNC:ticket", "user": "root@pam"})(w, r)
+		},
+		"/nodes/pve1/qemu/100/vncwebsocket": func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "PVEAPIToken=root@pam!i2=secret", r.Header.Get("Authorization"))
+			assert.Equal(t, "5900", r.URL.Query().Get("port"))
+			assert.Equal(t, "PVEVNC:ticket", r.URL.Query().Get("vncticket"))
+			conn, err := upgrader.Upgrade(w, r, nil)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			_, login, err := conn.ReadMessage()
+			require.NoError(t, err)
+			if string(login) != "root@pam:PVEVNC:ticket\n" {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte("permission denied"))
+				return
+			}
+			require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("OK")))
+			for {
+				kind, msg, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				_ = conn.WriteMessage(kind, append([]byte("echo:"), msg...))
+			}
+		},
+	}
+}
+
+func TestConsoleFrames(t *testing.T) {
+	assert.Equal(t, "0:5:hello", string(ConsoleInput([]byte("hello"))))
+	assert.Equal(t, "0:2:é", string(ConsoleInput([]byte("é"))), "the length is in bytes")
+	assert.Equal(t, "1:80:24:", string(ConsoleResize(80, 24)))
+}
+
+func TestHandlerConsole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cluster := newFakeProxmox(t, consoleRoutes(t))
+	router := gin.New()
+	router.GET("/proxmox/vms/:name/console", Clusters{cluster}.handlerConsole(""))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	wsURL := strings.Replace(server.URL, "http://", "ws://", 1) + "/proxmox/vms/web/console"
+	origin := http.Header{"Origin": {server.URL}}
+
+	_, res, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode, "no Origin")
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, origin)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, ConsoleInput([]byte("ls\r"))))
+	_, msg, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "echo:0:3:ls\r", string(msg))
+
+	_, res, err = websocket.DefaultDialer.Dial(wsURL+"?type=rdp", origin)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	_, res, err = websocket.DefaultDialer.Dial(strings.Replace(wsURL, "/web/", "/db/", 1), origin)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestConsoleOriginAllowed(t *testing.T) {
+	request := func(origin string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "http://i2.lan:8080/proxmox/vms/web/console", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+	assert.False(t, ConsoleOriginAllowed(request(""), ""))
+	assert.True(t, ConsoleOriginAllowed(request("http://i2.lan:8080"), ""))
+	assert.True(t, ConsoleOriginAllowed(request("https://i2.example.com"), "https://i2.example.com/api"))
+	assert.False(t, ConsoleOriginAllowed(request("http://i2.example.com"), "https://i2.example.com"))
+	assert.False(t, ConsoleOriginAllowed(request("https://evil.example.com"), "https://i2.example.com"))
+}
+
+func TestHandlerConsoleVNC(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	routes := consoleRoutes(t)
+	upgrader := websocket.Upgrader{}
+	routes["/nodes/pve1/qemu/100/vncproxy"] = data(map[string]any{"port": "5901", "ticket": "PVEVNC:ticket", "user": "root@pam", "password": "otp"})
+	// a VNC server asking for the password otp, echoing what it receives
+	routes["/nodes/pve1/qemu/100/vncwebsocket"] = func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		rfb := &wsStream{conn: conn}
+		challenge := []byte("0123456789abcdef")
+		_, _ = rfb.Write([]byte("RFB 003.008\n"))
+		version := make([]byte, 12)
+		_, err = io.ReadFull(rfb, version)
+		assert.NoError(t, err)
+		_, _ = rfb.Write([]byte{1, vncSecurityVNC})
+		choice := []byte{0}
+		_, _ = io.ReadFull(rfb, choice)
+		_, _ = rfb.Write(challenge)
+		response := make([]byte, 16)
+		_, _ = io.ReadFull(rfb, response)
+		if !bytes.Equal(vncResponse("otp", challenge), response) {
+			_, _ = rfb.Write([]byte{0, 0, 0, 1, 0, 0, 0, 5, 'w', 'r', 'o', 'n', 'g'})
+			return
+		}
+		_, _ = rfb.Write([]byte{0, 0, 0, 0})
+		for {
+			kind, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			_ = conn.WriteMessage(kind, append([]byte("echo:"), msg...))
+		}
+	}
+	cluster := newFakeProxmox(t, routes)
+	router := gin.New()
+	router.GET("/proxmox/vms/:name/console", Clusters{cluster}.handlerConsole(""))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	wsURL := strings.Replace(server.URL, "http://", "ws://", 1) + "/proxmox/vms/web/console?type=vnc"
+
+	_, res, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"https://evil.example.com"}})
+	require.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	conn, res, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {server.URL}})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Empty(t, res.Header.Get("X-Vnc-Password"))
+	viewer := &wsStream{conn: conn}
+	version := make([]byte, 12)
+	_, err = io.ReadFull(viewer, version)
+	require.NoError(t, err)
+	assert.Equal(t, "RFB 003.008\n", string(version))
+	_, _ = viewer.Write([]byte("RFB 003.008\n"))
+	types := make([]byte, 2)
+	_, err = io.ReadFull(viewer, types)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, vncSecurityNone}, types)
+	_, _ = viewer.Write([]byte{vncSecurityNone})
+	result := make([]byte, 4)
+	_, err = io.ReadFull(viewer, result)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0}, result)
+
+	_, _ = viewer.Write([]byte{1})
+	_, msg, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "echo:\x01", string(msg))
+}
@@ -0,0 +1,44 @@
+package prxmx
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newFakeProxmox starts an HTTP server answering the given Proxmox API paths
+// (relative to /api2/json) and returns a cluster pointed at it
+func newFakeProxmox(t *testing.T, routes map[string]http.HandlerFunc, options ...func(*Cluster)) *Cluster {
+	t.Helper()
+	mux := http.NewServeMux()
+	for path, handler := range routes {
+		mux.HandleFunc("/api2/json"+path, handler)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return NewCluster(server.URL+"/api2/json", "root@pam!i2", "secret", options...)
+}
+
+// data replies with v wrapped in the Proxmox {"data": ...} envelope
+func data(v any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": v})
+	}
+}
+
+// fail replies with a Proxmox error, Proxmox puts the message in the status
+// line
+func fail(code int, message string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = fmt.Fprintf(buf, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", code, message)
+		_ = buf.Flush()
+	}
+}
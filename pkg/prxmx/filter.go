@@ -0,0 +1,61 @@
+package prxmx
+
+import (
+	"fmt"
+	"path"
+	"slices"
+)
+
+// Filter selects guests by tag, node, status and name. Empty fields match
+// every guest, a guest has to carry all the tags to match.
+type Filter struct {
+	Tags   []string
+	Node   string
+	Status string
+	Name   string
+}
+
+// Validate checks the status and the name glob of the filter
+func (f Filter) Validate() error {
+	switch f.Status {
+	case "", "running", "stopped":
+	default:
+		return fmt.Errorf("invalid status %q, use running or stopped", f.Status)
+	}
+	if _, err := path.Match(f.Name, ""); err != nil {
+		return fmt.Errorf("invalid name pattern %q: %w", f.Name, err)
+	}
+	return nil
+}
+
+// Match reports whether a guest passes the filter
+func (f Filter) Match(n Node) bool {
+	for _, tag := range f.Tags {
+		if !slices.Contains(n.Tags, tag) {
+			return false
+		}
+	}
+	if f.Node != "" && n.Host != f.Node {
+		return false
+	}
+	if f.Status == "running" && !n.Running || f.Status == "stopped" && n.Running {
+		return false
+	}
+	if f.Name != "" {
+		if ok, _ := path.Match(f.Name, n.Name); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns the guests passing the filter
+func (f Filter) Apply(nodes []Node) []Node {
+	matching := []Node{}
+	for _, n := range nodes {
+		if f.Match(n) {
+			matching = append(matching, n)
+		}
+	}
+	return matching
+}
@@ -0,0 +1,38 @@
+package prxmx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter_Match(t *testing.T) {
+	web := Node{Name: "web-1", Host: "pve1", Tags: []string{"docker", "prod"}, Running: true}
+	db := Node{Name: "db-1", Host: "pve2", Tags: []string{"prod"}}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []Node
+	}{
+		{"empty", Filter{}, []Node{web, db}},
+		{"tag", Filter{Tags: []string{"docker"}}, []Node{web}},
+		{"all tags", Filter{Tags: []string{"prod", "docker"}}, []Node{web}},
+		{"node", Filter{Node: "pve2"}, []Node{db}},
+		{"running", Filter{Status: "running"}, []Node{web}},
+		{"stopped", Filter{Status: "stopped"}, []Node{db}},
+		{"glob", Filter{Name: "db-*"}, []Node{db}},
+		{"none", Filter{Name: "web-*", Node: "pve2"}, []Node{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, tt.filter.Validate())
+			assert.Equal(t, tt.want, tt.filter.Apply([]Node{web, db}))
+		})
+	}
+}
+
+func TestFilter_Validate(t *testing.T) {
+	assert.Error(t, Filter{Status: "paused"}.Validate())
+	assert.Error(t, Filter{Name: "web-["}.Validate())
+}
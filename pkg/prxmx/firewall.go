@@ -0,0 +1,261 @@
+package prxmx
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+// FirewallScope is where firewall rules live: the cluster (the zero value), a
+// node, a guest or a security group
+type FirewallScope struct {
+	Node  string `json:",omitempty"`
+	Guest *Node  `json:",omitempty"`
+	Group string `json:",omitempty"`
+}
+
+func (s FirewallScope) String() string {
+	switch {
+	case s.Group != "":
+		return "group " + s.Group
+	case s.Guest != nil:
+		return "vm " + s.Guest.Name
+	case s.Node != "":
+		return "node " + s.Node
+	}
+	return "cluster"
+}
+
+// rulesPath is the path listing the rules of the scope, rules are deleted
+// with <rulesPath>/<pos>
+func (s FirewallScope) rulesPath() string {
+	switch {
+	case s.Group != "":
+		return "/cluster/firewall/groups/" + url.PathEscape(s.Group)
+	case s.Guest != nil:
+		return fmt.Sprintf("/nodes/%s/%s/%d/firewall/rules", s.Guest.Host, s.Guest.Type, s.Guest.VMID)
+	case s.Node != "":
+		return fmt.Sprintf("/nodes/%s/firewall/rules", s.Node)
+	}
+	return "/cluster/firewall/rules"
+}
+
+// FirewallRule is a Proxmox firewall rule. Type is in, out or group, Action
+// is ACCEPT, DROP or REJECT, or the security group name for group rules.
+// Source and Dest take addresses, CIDRs, aliases and IP sets (+name).
+type FirewallRule struct {
+	Pos      int    `json:"pos" yaml:"-"`
+	Type     string `json:"type" yaml:"type"`
+	Action   string `json:"action" yaml:"action"`
+	Enable   int    `json:"enable" yaml:"-"`
+	Disabled bool   `json:"-" yaml:"disabled,omitempty"`
+	Macro    string `json:"macro,omitempty" yaml:"macro,omitempty"`
+	Source   string `json:"source,omitempty" yaml:"source,omitempty"`
+	Dest     string `json:"dest,omitempty" yaml:"dest,omitempty"`
+	Proto    string `json:"proto,omitempty" yaml:"proto,omitempty"`
+	DPort    string `json:"dport,omitempty" yaml:"dport,omitempty"`
+	SPort    string `json:"sport,omitempty" yaml:"sport,omitempty"`
+	Iface    string `json:"iface,omitempty" yaml:"iface,omitempty"`
+	Log      string `json:"log,omitempty" yaml:"log,omitempty"`
+	Comment  string `json:"comment,omitempty" yaml:"comment,omitempty"`
+}
+
+// Validate normalizes the rule type and action and checks them
+func (r *FirewallRule) Validate() error {
+	r.Type = strings.ToLower(r.Type)
+	switch r.Type {
+	case "in", "out":
+		r.Action = strings.ToUpper(r.Action)
+		if r.Action != "ACCEPT" && r.Action != "DROP" && r.Action != "REJECT" {
+			return fmt.Errorf("invalid action %q, use ACCEPT, DROP or REJECT", r.Action)
+		}
+	case "group":
+		if r.Action == "" {
+			return fmt.Errorf("group rules need the security group as action")
+		}
+	default:
+		return fmt.Errorf("invalid rule type %q, use in, out or group", r.Type)
+	}
+	return nil
+}
+
+// String is the rule as shown by the Proxmox UI
+func (r FirewallRule) String() string {
+	parts := []string{r.Type, r.Action}
+	for _, field := range []struct{ name, value string }{
+		{"macro", r.Macro}, {"iface", r.Iface}, {"source", r.Source}, {"dest", r.Dest},
+		{"proto", r.Proto}, {"sport", r.SPort}, {"dport", r.DPort}, {"log", r.Log},
+	} {
+		if field.value != "" {
+			parts = append(parts, field.name+"="+field.value)
+		}
+	}
+	if r.Enable == 0 {
+		parts = append(parts, "(disabled)")
+	}
+	if r.Comment != "" {
+		parts = append(parts, "# "+r.Comment)
+	}
+	return strings.Join(parts, " ")
+}
+
+// same reports whether two rules match the same traffic the same way,
+// positions are ignored
+func (r FirewallRule) same(o FirewallRule) bool {
+	r.Pos, o.Pos = 0, 0
+	r.Disabled, o.Disabled = false, false
+	if r.Log == "nolog" {
+		r.Log = ""
+	}
+	if o.Log == "nolog" {
+		o.Log = ""
+	}
+	return r == o
+}
+
+// IPSet is a cluster IP set, referenced in rules as +<name>
+type IPSet struct {
+	Name    string       `json:"name"`
+	Comment string       `json:"comment,omitempty"`
+	Entries []IPSetEntry `json:"entries"`
+}
+
+// IPSetEntry is an address or a CIDR of an IP set
+type IPSetEntry struct {
+	CIDR    string            `json:"cidr"`
+	Comment string            `json:"comment,omitempty"`
+	NoMatch proxmox.IntOrBool `json:"nomatch,omitempty" swaggertype:"boolean"`
+}
+
+// SecurityGroup is a named list of rules, used in rules of type group
+type SecurityGroup struct {
+	Group   string         `json:"group"`
+	Comment string         `json:"comment,omitempty"`
+	Rules   []FirewallRule `json:"rules"`
+}
+
+// FirewallRules returns the rules of a scope ordered by position
+func (c *Cluster) FirewallRules(ctx context.Context, scope FirewallScope) ([]FirewallRule, error) {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	rules := []FirewallRule{}
+	if err := c.Client.Get(cctx, scope.rulesPath(), &rules); err != nil {
+		return nil, fmt.Errorf("error reading the firewall rules of %s: %w", scope, err)
+	}
+	return rules, nil
+}
+
+// AddFirewallRule inserts a rule at rule.Pos, 0 is the top of the list
+func (c *Cluster) AddFirewallRule(ctx context.Context, scope FirewallScope, rule FirewallRule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	if err := c.Client.Post(cctx, scope.rulesPath(), rule, nil); err != nil {
+		return fmt.Errorf("error adding a firewall rule to %s: %w", scope, err)
+	}
+	return nil
+}
+
+// DeleteFirewallRule deletes the rule at pos, the rules below move up
+func (c *Cluster) DeleteFirewallRule(ctx context.Context, scope FirewallScope, pos int) error {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	if err := c.Client.Delete(cctx, fmt.Sprintf("%s/%d", scope.rulesPath(), pos), nil); err != nil {
+		return fmt.Errorf("error deleting the firewall rule %d of %s: %w", pos, scope, err)
+	}
+	return nil
+}
+
+// IPSets returns the cluster IP sets with their entries
+func (c *Cluster) IPSets(ctx context.Context) ([]IPSet, error) {
+	cctx, cancel := c.callContext(ctx)
+	ipsets := []IPSet{}
+	err := c.Client.Get(cctx, "/cluster/firewall/ipset", &ipsets)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("error reading the IP sets: %w", err)
+	}
+	for i := range ipsets {
+		cctx, cancel := c.callContext(ctx)
+		ipsets[i].Entries = []IPSetEntry{}
+		err := c.Client.Get(cctx, "/cluster/firewall/ipset/"+url.PathEscape(ipsets[i].Name), &ipsets[i].Entries)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("error reading the IP set %s: %w", ipsets[i].Name, err)
+		}
+	}
+	return ipsets, nil
+}
+
+// CreateIPSet creates an empty cluster IP set
+func (c *Cluster) CreateIPSet(ctx context.Context, name, comment string) error {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	params := map[string]any{"name": name}
+	if comment != "" {
+		params["comment"] = comment
+	}
+	if err := c.Client.Post(cctx, "/cluster/firewall/ipset", params, nil); err != nil {
+		return fmt.Errorf("error creating the IP set %s: %w", name, err)
+	}
+	return nil
+}
+
+// AddIPSetEntry adds an address or a CIDR to an IP set
+func (c *Cluster) AddIPSetEntry(ctx context.Context, name, cidr string) error {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	err := c.Client.Post(cctx, "/cluster/firewall/ipset/"+url.PathEscape(name), map[string]any{"cidr": cidr}, nil)
+	if err != nil {
+		return fmt.Errorf("error adding %s to the IP set %s: %w", cidr, name, err)
+	}
+	return nil
+}
+
+// DeleteIPSetEntry removes an address or a CIDR from an IP set
+func (c *Cluster) DeleteIPSetEntry(ctx context.Context, name, cidr string) error {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	err := c.Client.Delete(cctx, fmt.Sprintf("/cluster/firewall/ipset/%s/%s", url.PathEscape(name), url.PathEscape(cidr)), nil)
+	if err != nil {
+		return fmt.Errorf("error deleting %s from the IP set %s: %w", cidr, name, err)
+	}
+	return nil
+}
+
+// SecurityGroups returns the security groups with their rules
+func (c *Cluster) SecurityGroups(ctx context.Context) ([]SecurityGroup, error) {
+	cctx, cancel := c.callContext(ctx)
+	groups := []SecurityGroup{}
+	err := c.Client.Get(cctx, "/cluster/firewall/groups", &groups)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("error reading the security groups: %w", err)
+	}
+	for i := range groups {
+		groups[i].Rules, err = c.FirewallRules(ctx, FirewallScope{Group: groups[i].Group})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// CreateSecurityGroup creates a security group without rules
+func (c *Cluster) CreateSecurityGroup(ctx context.Context, name, comment string) error {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	params := map[string]any{"group": name}
+	if comment != "" {
+		params["comment"] = comment
+	}
+	if err := c.Client.Post(cctx, "/cluster/firewall/groups", params, nil); err != nil {
+		return fmt.Errorf("error creating the security group %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+package prxmx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+
+	"i2/pkg/utils"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FirewallSpec is the content of a firewall.yaml file. Only the IP sets,
+// security groups and scopes listed are managed, an empty list of rules
+// removes every rule of its scope.
+type FirewallSpec struct {
+	Cluster string                    `yaml:"cluster,omitempty" json:"cluster,omitempty"`
+	IPSets  []IPSetSpec               `yaml:"ipsets,omitempty" json:"ipsets,omitempty"`
+	Groups  []SecurityGroupSpec       `yaml:"groups,omitempty" json:"groups,omitempty"`
+	Rules   []FirewallRule            `yaml:"rules,omitempty" json:"rules,omitempty"`
+	Nodes   map[string][]FirewallRule `yaml:"nodes,omitempty" json:"nodes,omitempty"`
+	VMs     map[string][]FirewallRule `yaml:"vms,omitempty" json:"vms,omitempty"`
+}
+
+// IPSetSpec is an IP set with fixed CIDRs and the address of every guest
+// with all the tags
+type IPSetSpec struct {
+	Name    string   `yaml:"name" json:"name"`
+	Comment string   `yaml:"comment,omitempty" json:"comment,omitempty"`
+	Tags    []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	CIDRs   []string `yaml:"cidrs,omitempty" json:"cidrs,omitempty"`
+}
+
+// SecurityGroupSpec is a security group and its rules
+type SecurityGroupSpec struct {
+	Name    string         `yaml:"name" json:"name"`
+	Comment string         `yaml:"comment,omitempty" json:"comment,omitempty"`
+	Rules   []FirewallRule `yaml:"rules" json:"rules"`
+}
+
+// IPSetChange is the entries added to and removed from an IP set
+type IPSetChange struct {
+	Name    string
+	Comment string   `json:",omitempty"`
+	Create  bool     `json:",omitempty"`
+	Add     []string `json:",omitempty"`
+	Delete  []string `json:",omitempty"`
+}
+
+// RulesChange replaces the rules of a scope
+type RulesChange struct {
+	Scope       FirewallScope
+	CreateGroup bool   `json:",omitempty"`
+	Comment     string `json:",omitempty"`
+	From        []FirewallRule
+	To          []FirewallRule
+}
+
+// FirewallPlan is what FirewallApply changes. Warnings are guests of a tagged
+// IP set left out because they have no address.
+type FirewallPlan struct {
+	Cluster  string
+	IPSets   []IPSetChange `json:",omitempty"`
+	Rules    []RulesChange `json:",omitempty"`
+	Warnings []string      `json:",omitempty"`
+}
+
+// Empty reports whether the plan has nothing to change
+func (p FirewallPlan) Empty() bool {
+	return len(p.IPSets) == 0 && len(p.Rules) == 0
+}
+
+// LoadFirewallSpec reads and validates a firewall spec in YAML
+func LoadFirewallSpec(r io.Reader) (FirewallSpec, error) {
+	var spec FirewallSpec
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&spec); err != nil {
+		return spec, fmt.Errorf("error decoding the firewall spec: %w", err)
+	}
+	return spec, spec.Validate()
+}
+
+// ReadFirewallFile reads the firewall spec of a YAML file
+func ReadFirewallFile(path string) (FirewallSpec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FirewallSpec{}, err
+	}
+	defer f.Close()
+	return LoadFirewallSpec(f)
+}
+
+// Validate checks the spec and normalizes its rules
+func (s *FirewallSpec) Validate() error {
+	check := func(scope string, rules []FirewallRule, groupRules bool) error {
+		for i := range rules {
+			if err := rules[i].Validate(); err != nil {
+				return fmt.Errorf("%s rule %d: %w", scope, i, err)
+			}
+			if groupRules && rules[i].Type == "group" {
+				return fmt.Errorf("%s rule %d: security groups can't include other groups", scope, i)
+			}
+			rules[i].Pos = 0
+			rules[i].Enable = 1
+			if rules[i].Disabled {
+				rules[i].Enable = 0
+			}
+		}
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for _, ipset := range s.IPSets {
+		if ipset.Name == "" || seen[ipset.Name] {
+			return fmt.Errorf("IP sets need a unique name, %q", ipset.Name)
+		}
+		seen[ipset.Name] = true
+	}
+	seen = map[string]bool{}
+	for _, group := range s.Groups {
+		if group.Name == "" || seen[group.Name] {
+			return fmt.Errorf("security groups need a unique name, %q", group.Name)
+		}
+		seen[group.Name] = true
+		if err := check("group "+group.Name, group.Rules, true); err != nil {
+			return err
+		}
+	}
+	if err := check("cluster", s.Rules, false); err != nil {
+		return err
+	}
+	for node, rules := range s.Nodes {
+		if err := check("node "+node, rules, false); err != nil {
+			return err
+		}
+	}
+	for vm, rules := range s.VMs {
+		if err := check("vm "+vm, rules, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FirewallPlan compares the spec with the firewall of the cluster. Guests
+// of tagged IP sets are found with GetVMs, the plan fails when the guests
+// can't all be inspected instead of dropping addresses.
+func (c *Cluster) FirewallPlan(ctx context.Context, spec FirewallSpec) (FirewallPlan, error) {
+	plan := FirewallPlan{Cluster: c.Name}
+
+	if len(spec.IPSets) > 0 {
+		if err := c.planIPSets(ctx, spec.IPSets, &plan); err != nil {
+			return plan, err
+		}
+	}
+
+	if len(spec.Groups) > 0 {
+		groups, err := c.SecurityGroups(ctx)
+		if err != nil {
+			return plan, err
+		}
+		for _, group := range spec.Groups {
+			i := slices.IndexFunc(groups, func(g SecurityGroup) bool { return g.Group == group.Name })
+			change := RulesChange{Scope: FirewallScope{Group: group.Name}, Comment: group.Comment, To: group.Rules}
+			if i < 0 {
+				change.CreateGroup = true
+			} else {
+				change.From = groups[i].Rules
+			}
+			if change.CreateGroup || !sameRules(change.From, change.To) {
+				plan.Rules = append(plan.Rules, change)
+			}
+		}
+	}
+
+	scopes := []FirewallScope{}
+	wanted := map[string][]FirewallRule{}
+	if spec.Rules != nil {
+		scopes = append(scopes, FirewallScope{})
+		wanted[FirewallScope{}.String()] = spec.Rules
+	}
+	for _, node := range sortedRuleKeys(spec.Nodes) {
+		scope := FirewallScope{Node: node}
+		scopes = append(scopes, scope)
+		wanted[scope.String()] = spec.Nodes[node]
+	}
+	for _, name := range sortedRuleKeys(spec.VMs) {
+		guest, err := c.FindGuest(ctx, name)
+		if err != nil {
+			return plan, err
+		}
+		scope := FirewallScope{Guest: &guest}
+		scopes = append(scopes, scope)
+		wanted[scope.String()] = spec.VMs[name]
+	}
+	for _, scope := range scopes {
+		current, err := c.FirewallRules(ctx, scope)
+		if err != nil {
+			return plan, err
+		}
+		if to := wanted[scope.String()]; !sameRules(current, to) {
+			plan.Rules = append(plan.Rules, RulesChange{Scope: scope, From: current, To: to})
+		}
+	}
+	return plan, nil
+}
+
+func (c *Cluster) planIPSets(ctx context.Context, specs []IPSetSpec, plan *FirewallPlan) error {
+	current, err := c.IPSets(ctx)
+	if err != nil {
+		return err
+	}
+	var guests []Node
+	if slices.ContainsFunc(specs, func(s IPSetSpec) bool { return len(s.Tags) > 0 }) {
+		// a guest missing from a partial result would lose its address
+		guests, err = c.GetVMs()
+		if err != nil {
+			return fmt.Errorf("the guests of tagged IP sets can't be listed: %w", err)
+		}
+	}
+
+	for _, spec := range specs {
+		wanted := slices.Clone(spec.CIDRs)
+		if len(spec.Tags) > 0 {
+			for _, guest := range (Filter{Tags: spec.Tags}).Apply(guests) {
+				ip := utils.GetLocalIP(guest.IP)
+				if ip == "" {
+					plan.Warnings = append(plan.Warnings, fmt.Sprintf("IP set %s: %s has no address", spec.Name, guest.Name))
+					continue
+				}
+				wanted = append(wanted, ip)
+			}
+		}
+		sort.Strings(wanted)
+		wanted = slices.Compact(wanted)
+
+		change := IPSetChange{Name: spec.Name, Comment: spec.Comment}
+		have := []string{}
+		if i := slices.IndexFunc(current, func(s IPSet) bool { return s.Name == spec.Name }); i < 0 {
+			change.Create = true
+		} else {
+			for _, entry := range current[i].Entries {
+				have = append(have, entry.CIDR)
+			}
+		}
+		for _, cidr := range wanted {
+			if !slices.Contains(have, cidr) {
+				change.Add = append(change.Add, cidr)
+			}
+		}
+		for _, cidr := range have {
+			if !slices.Contains(wanted, cidr) {
+				change.Delete = append(change.Delete, cidr)
+			}
+		}
+		if change.Create || len(change.Add) > 0 || len(change.Delete) > 0 {
+			plan.IPSets = append(plan.IPSets, change)
+		}
+	}
+	return nil
+}
+
+// FirewallApply applies a plan: IP sets first, then security groups, then
+// the rules that may reference them. New rules are inserted on top before the
+// old ones are deleted, so a scope is never left without rules. progress is
+// called before every step and FirewallApply stops at the first failure.
+func (c *Cluster) FirewallApply(ctx context.Context, plan FirewallPlan, progress func(string)) error {
+	for _, change := range plan.IPSets {
+		if change.Create {
+			progress("Creating IP set " + change.Name)
+			if err := c.CreateIPSet(ctx, change.Name, change.Comment); err != nil {
+				return err
+			}
+		}
+		for _, cidr := range change.Add {
+			progress(fmt.Sprintf("Adding %s to IP set %s", cidr, change.Name))
+			if err := c.AddIPSetEntry(ctx, change.Name, cidr); err != nil {
+				return err
+			}
+		}
+		for _, cidr := range change.Delete {
+			progress(fmt.Sprintf("Removing %s from IP set %s", cidr, change.Name))
+			if err := c.DeleteIPSetEntry(ctx, change.Name, cidr); err != nil {
+				return err
+			}
+		}
+	}
+
+	// groups have to exist before the rules using them
+	changes := slices.Clone(plan.Rules)
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Scope.Group != "" && changes[j].Scope.Group == ""
+	})
+	for _, change := range changes {
+		if change.CreateGroup {
+			progress("Creating security group " + change.Scope.Group)
+			if err := c.CreateSecurityGroup(ctx, change.Scope.Group, change.Comment); err != nil {
+				return err
+			}
+		}
+		progress(fmt.Sprintf("Replacing the %d rules of %s with %d rules", len(change.From), change.Scope, len(change.To)))
+		if err := c.replaceRules(ctx, change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cluster) replaceRules(ctx context.Context, change RulesChange) error {
+	for i, rule := range change.To {
+		rule.Pos = i
+		if err := c.AddFirewallRule(ctx, change.Scope, rule); err != nil {
+			return err
+		}
+	}
+	// the old rules moved below the new ones, delete them bottom up
+	for pos := len(change.To) + len(change.From) - 1; pos >= len(change.To); pos-- {
+		if err := c.DeleteFirewallRule(ctx, change.Scope, pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameRules compares two ordered rule lists
+func sameRules(a, b []FirewallRule) bool {
+	return slices.EqualFunc(a, b, FirewallRule.same)
+}
+
+// RuleDiff returns the rules only in from and the rules only in to, both
+// empty when the rules were only reordered
+func RuleDiff(from, to []FirewallRule) (removed, added []FirewallRule) {
+	for _, r := range from {
+		if !slices.ContainsFunc(to, r.same) {
+			removed = append(removed, r)
+		}
+	}
+	for _, r := range to {
+		if !slices.ContainsFunc(from, r.same) {
+			added = append(added, r)
+		}
+	}
+	return removed, added
+}
+
+func sortedRuleKeys(m map[string][]FirewallRule) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,152 @@
+package prxmx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadFirewallSpec(t *testing.T) {
+	spec, err := LoadFirewallSpec(strings.NewReader(`
+rules:
+  - {type: IN, action: accept, macro: SSH}
+  - {type: out, action: DROP, disabled: true}
+vms:
+  web: []
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []FirewallRule{
+		{Type: "in", Action: "ACCEPT", Macro: "SSH", Enable: 1},
+		{Type: "out", Action: "DROP", Disabled: true},
+	}, spec.Rules)
+	assert.NotNil(t, spec.VMs["web"], "an empty list clears the rules")
+	assert.Nil(t, spec.Nodes)
+
+	for _, invalid := range []string{
+		"rules: [{type: in, action: ALLOW}]",
+		"rules: [{type: forward, action: ACCEPT}]",
+		"groups: [{name: web, rules: [{type: group, action: other}]}]",
+		"ipsets: [{name: web}, {name: web}]",
+		"rules: [{type: in, action: ACCEPT, port: 22}]",
+	} {
+		_, err := LoadFirewallSpec(strings.NewReader(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCluster_FirewallPlanAndApply(t *testing.T) {
+	var mu sync.Mutex
+	calls := []string{}
+	record := func(reply any) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			params := map[string]any{}
+			if err := json.NewDecoder(r.Body).Decode(&params); err != io.EOF {
+				require.NoError(t, err)
+			}
+			call := r.Method + " " + strings.TrimPrefix(r.URL.EscapedPath(), "/api2/json")
+			if pos, ok := params["pos"]; ok {
+				call += fmt.Sprintf(" pos=%v action=%v", pos, params["action"])
+			}
+			if cidr, ok := params["cidr"]; ok {
+				call += fmt.Sprintf(" cidr=%v", cidr)
+			}
+			mu.Lock()
+			calls = append(calls, call)
+			mu.Unlock()
+			data(reply)(w, r)
+		}
+	}
+	get := func(v any, other http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				data(v)(w, r)
+				return
+			}
+			other(w, r)
+		}
+	}
+
+	routes := map[string]http.HandlerFunc{
+		"/nodes":             data([]map[string]any{{"node": "pve1", "status": "online"}}),
+		"/nodes/pve1/status": data(map[string]any{}),
+		"/nodes/pve1/qemu":   data([]map[string]any{}),
+		"/nodes/pve1/lxc": data([]map[string]any{
+			{"vmid": 200, "name": "web", "status": "running", "tags": "web"},
+			{"vmid": 201, "name": "web2", "status": "stopped", "tags": "web"},
+			{"vmid": 202, "name": "db", "status": "running", "tags": "db"},
+		}),
+		"/nodes/pve1/lxc/200/config":     data(map[string]any{}),
+		"/nodes/pve1/lxc/201/config":     data(map[string]any{}),
+		"/nodes/pve1/lxc/202/config":     data(map[string]any{}),
+		"/nodes/pve1/lxc/200/interfaces": data([]map[string]any{{"name": "eth0", "inet": "192.168.1.20/24"}}),
+		"/nodes/pve1/lxc/202/interfaces": data([]map[string]any{{"name": "eth0", "inet": "192.168.1.22/24"}}),
+		"/cluster/firewall/ipset":        data([]map[string]any{{"name": "web"}}),
+		"/cluster/firewall/ipset/web": get([]map[string]any{
+			{"cidr": "10.0.0.0/24"}, {"cidr": "192.168.1.99"},
+		}, record(nil)),
+		"/cluster/firewall/ipset/web/":  record(nil),
+		"/cluster/firewall/groups":      get([]map[string]any{}, record(nil)),
+		"/cluster/firewall/groups/www":  record(nil),
+		"/cluster/firewall/groups/www/": record(nil),
+		"/cluster/firewall/rules": data([]map[string]any{
+			{"pos": 0, "type": "in", "action": "ACCEPT", "macro": "SSH", "enable": 1},
+		}),
+		"/nodes/pve1/lxc/200/firewall/rules": get([]map[string]any{
+			{"pos": 0, "type": "in", "action": "ACCEPT", "proto": "tcp", "dport": "22", "enable": 1},
+			{"pos": 1, "type": "in", "action": "DROP", "enable": 1},
+		}, record(nil)),
+		"/nodes/pve1/lxc/200/firewall/rules/": record(nil),
+	}
+	cluster := newFakeProxmox(t, routes)
+	ctx := context.Background()
+
+	spec, err := LoadFirewallSpec(strings.NewReader(`
+ipsets:
+  - name: web
+    tags: [web]
+    cidrs: [10.0.0.0/24]
+groups:
+  - name: www
+    rules: [{type: in, action: ACCEPT, macro: HTTPS}]
+rules:
+  - {type: in, action: ACCEPT, macro: SSH}
+vms:
+  web:
+    - {type: group, action: www}
+    - {type: in, action: ACCEPT, source: +web, proto: tcp, dport: "8080"}
+`))
+	require.NoError(t, err)
+
+	plan, err := cluster.FirewallPlan(ctx, spec)
+	require.NoError(t, err)
+	assert.Equal(t, []IPSetChange{{Name: "web", Add: []string{"192.168.1.20"}, Delete: []string{"192.168.1.99"}}}, plan.IPSets)
+	assert.Equal(t, []string{"IP set web: web2 has no address"}, plan.Warnings)
+	require.Len(t, plan.Rules, 2, "the cluster rules are up to date")
+	assert.Equal(t, "group www", plan.Rules[0].Scope.String())
+	assert.True(t, plan.Rules[0].CreateGroup)
+	assert.Equal(t, "vm web", plan.Rules[1].Scope.String())
+
+	removed, added := RuleDiff(plan.Rules[1].From, plan.Rules[1].To)
+	assert.Len(t, removed, 2)
+	assert.Len(t, added, 2)
+
+	require.NoError(t, cluster.FirewallApply(ctx, plan, func(string) {}))
+	assert.Equal(t, []string{
+		"POST /cluster/firewall/ipset/web cidr=192.168.1.20",
+		"DELETE /cluster/firewall/ipset/web/192.168.1.99",
+		"POST /cluster/firewall/groups",
+		"POST /cluster/firewall/groups/www pos=0 action=ACCEPT",
+		"POST /nodes/pve1/lxc/200/firewall/rules pos=0 action=www",
+		"POST /nodes/pve1/lxc/200/firewall/rules pos=1 action=ACCEPT",
+		"DELETE /nodes/pve1/lxc/200/firewall/rules/3",
+		"DELETE /nodes/pve1/lxc/200/firewall/rules/2",
+	}, calls)
+}
@@ -1,24 +1,43 @@
 package prxmx
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"i2/pkg/models"
+	"i2/pkg/store"
+
+	"github.com/charmbracelet/log"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
 // GetClusterNodes godoc
 // @Summary Get cluster nodes
-// @Description Get cluster nodes
+// @Description Get the nodes of every cluster, tagged with their cluster name
 // @Tags proxmox
 // @Accept json
 // @Produce json
-// @Success 200 {object} interface{}
+// @Param cluster query string false "Cluster name, every cluster by default"
+// @Success 200 {array} interface{}
+// @Failure 400 {object} interface{}
 // @Failure 500 {object} interface{}
 // @Router /proxmox/nodes [get]
-func (cluster *Cluster) handlerGetClusterNodes(c *gin.Context) {
-	nodes, err := cluster.Client.Nodes(context.Background())
-	if err != nil {
+func (clusters Clusters) handlerGetClusterNodes(c *gin.Context) {
+	selected, ok := clusters.selected(c)
+	if !ok {
+		return
+	}
+	nodes, err := selected.Nodes(c.Request.Context())
+	if err != nil && len(nodes) == 0 {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -27,18 +46,790 @@ func (cluster *Cluster) handlerGetClusterNodes(c *gin.Context) {
 
 // GetVirtualMachines godoc
 // @Summary Get virtual machines
-// @Description Get virtual machines
+// @Description Get virtual machines and LXC containers. Guests that could not
+// @Description be fully inspected are returned with their error set.
+// @Description Every guest is tagged with its cluster name.
 // @Tags proxmox
 // @Accept json
 // @Produce json
+// @Param cluster query string false "Cluster name, every cluster by default"
+// @Param tag query []string false "Only guests with every tag" collectionFormat(multi)
+// @Param node query string false "Only guests on this Proxmox node"
+// @Param status query string false "running or stopped"
+// @Param name query string false "Name glob, web-*"
 // @Success 200 {array} []Node
+// @Failure 400 {object} interface{}
 // @Failure 500 {object} interface{}
 // @Router /proxmox/vms [get]
-func (cluster *Cluster) handlerGetVirtualMachines(c *gin.Context) {
-	nodes, err := cluster.GetVMs()
+func (clusters Clusters) handlerGetVirtualMachines(c *gin.Context) {
+	selected, ok := clusters.selected(c)
+	if !ok {
+		return
+	}
+	filter := Filter{
+		Tags:   c.QueryArray("tag"),
+		Node:   c.Query("node"),
+		Status: c.Query("status"),
+		Name:   c.Query("name"),
+	}
+	if err := filter.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	nodes, err := selected.GetVMs()
+	if err != nil && len(nodes) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, filter.Apply(nodes))
+}
+
+// GetTasks godoc
+// @Summary Get cluster tasks
+// @Description Get the recent tasks of the clusters, newest first
+// @Tags proxmox
+// @Accept json
+// @Produce json
+// @Param cluster query string false "Cluster name, every cluster by default"
+// @Success 200 {array} TaskInfo
+// @Failure 400 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /proxmox/tasks [get]
+func (clusters Clusters) handlerGetTasks(c *gin.Context) {
+	selected, ok := clusters.selected(c)
+	if !ok {
+		return
+	}
+	tasks, err := selected.Tasks(c.Request.Context())
+	if err != nil && len(tasks) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tasks)
+}
+
+// GetTask godoc
+// @Summary Get a task
+// @Description Get the status of a task
+// @Tags proxmox
+// @Accept json
+// @Produce json
+// @Param upid path string true "Task UPID"
+// @Param cluster query string false "Cluster name, needed when its node name is in several clusters"
+// @Success 200 {object} TaskInfo
+// @Failure 400 {object} interface{}
+// @Failure 404 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /proxmox/tasks/{upid} [get]
+func (clusters Clusters) handlerGetTask(c *gin.Context) {
+	selected, ok := clusters.selected(c)
+	if !ok {
+		return
+	}
+	cluster, err := selected.ForTask(c.Request.Context(), c.Param("upid"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	task, err := cluster.Task(c.Request.Context(), c.Param("upid"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, nodes)
+	c.JSON(http.StatusOK, task)
+}
+
+// StreamTaskLog godoc
+// @Summary Stream a task log
+// @Description Stream the log lines of a task as Server-Sent Events until it finishes.
+// @Description Every line is a "log" event, the last event is "status" with the
+// @Description final TaskInfo or "error" when the task failed or could not be followed.
+// @Tags proxmox
+// @Produce text/event-stream
+// @Param upid path string true "Task UPID"
+// @Param cluster query string false "Cluster name, needed when its node name is in several clusters"
+// @Success 200 {string} string
+// @Router /proxmox/tasks/{upid}/log [get]
+func (clusters Clusters) handlerStreamTaskLog(c *gin.Context) {
+	selected, ok := clusters.selected(c)
+	if !ok {
+		return
+	}
+	cluster, err := selected.ForTask(c.Request.Context(), c.Param("upid"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	streamTask(c, cluster, c.Param("upid"))
+}
+
+// streamTask follows a task and sends its log to the client as Server-Sent Events
+func streamTask(c *gin.Context, cluster *Cluster, upid string) {
+	// the task can outlive the server write timeout
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	ctx := c.Request.Context()
+	lines := make(chan string)
+	result := make(chan error, 1)
+	var info TaskInfo
+	go func() {
+		var err error
+		info, err = cluster.WatchTask(ctx, upid, lines)
+		result <- err
+		close(lines)
+	}()
+
+	c.Stream(func(w io.Writer) bool {
+		if line, ok := <-lines; ok {
+			c.SSEvent("log", line)
+			return true
+		}
+		if err := <-result; err != nil {
+			c.SSEvent("error", gin.H{"error": err.Error(), "task": info})
+			return false
+		}
+		c.SSEvent("status", info)
+		return false
+	})
+}
+
+// GetStorage godoc
+// @Summary Get storage
+// @Description Get every storage of every node with its usage and the ISOs,
+// @Description container templates and backups it contains. Storages that
+// @Description could not be inspected are returned with their error set.
+// @Tags proxmox
+// @Accept json
+// @Produce json
+// @Param cluster query string false "Cluster name, every cluster by default"
+// @Success 200 {array} Storage
+// @Failure 400 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /proxmox/storage [get]
+func (clusters Clusters) handlerGetStorage(c *gin.Context) {
+	selected, ok := clusters.selected(c)
+	if !ok {
+		return
+	}
+	storages, err := selected.GetStorage()
+	if err != nil && len(storages) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, storages)
+}
+
+// MigrateRequest is the body of a migration request
+type MigrateRequest struct {
+	Target string `json:"target" binding:"required"`
+	Online bool   `json:"online"`
+}
+
+// MigrateVM godoc
+// @Summary Migrate a guest
+// @Description Migrate a VM or LXC container to another node. The target node
+// @Description must have enough free memory and the guest disks must be on
+// @Description shared storage. The API follows the migration and records the
+// @Description new host of the guest in NATS once it's done, task is the route
+// @Description of the migration task.
+// @Tags proxmox
+// @Accept json
+// @Produce json
+// @Param name path string true "Guest name, VMID or <cluster>.<vmid>"
+// @Param cluster query string false "Cluster name, every cluster by default"
+// @Param request body MigrateRequest true "Migration"
+// @Success 202 {object} interface{}
+// @Failure 400 {object} interface{}
+// @Failure 404 {object} interface{}
+// @Failure 409 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /proxmox/vms/{name}/migrate [post]
+func (clusters Clusters) handlerMigrateVM(nats *models.Nats) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req MigrateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Error decoding request body: %v", err)})
+			return
+		}
+		selected, ok := clusters.selected(c)
+		if !ok {
+			return
+		}
+		cluster, guest, err := selected.FindGuest(c.Request.Context(), c.Param("name"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		upid, err := cluster.Migrate(c.Request.Context(), guest, req.Target, req.Online)
+		if errors.Is(err, ErrMigrationCheck) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// the migration outlives the request
+		go func() {
+			info, err := cluster.WaitMigration(context.Background(), nats, guest, req.Target, upid)
+			if err != nil {
+				log.Errorf("Migration of %s to %s: %v", guest.Name, req.Target, err)
+				return
+			}
+			log.Infof("Migrated %s to %s: %s", guest.Name, req.Target, info.ExitStatus)
+		}()
+		task := strings.TrimSuffix(c.FullPath(), "/vms/:name/migrate") + "/tasks/" + url.PathEscape(upid) + "?cluster=" + url.QueryEscape(cluster.Name)
+		c.JSON(http.StatusAccepted, gin.H{"upid": upid, "guest": guest.Key(), "target": req.Target, "task": task})
+	}
+}
+
+// Console godoc
+// @Summary Open a guest console
+// @Description Proxy the serial or VNC console of a running guest over a
+// @Description websocket, without exposing the Proxmox UI nor its credentials.
+// @Description Serial consoles are logged in, send termproxy messages:
+// @Description 0:<len>:<data> for input, 1:<cols>:<rows>: to resize and 2 to
+// @Description keep it open. VNC consoles are raw RFB without authentication,
+// @Description i2 logs in with the one time password. It needs the API token
+// @Description or a ticket from /auth/tickets in ?ticket=, and an Origin of
+// @Description the public URL of the API or its own host.
+// @Tags proxmox
+// @Param name path string true "Guest name, VMID or <cluster>.<vmid>"
+// @Param cluster query string false "Cluster name, every cluster by default"
+// @Param type query string false "serial (default) or vnc"
+// @Param ticket query string false "Ticket from /auth/tickets"
+// @Success 101 {string} string "Switching Protocols"
+// @Failure 400 {object} interface{}
+// @Failure 401 {object} interface{}
+// @Failure 403 {object} interface{}
+// @Failure 404 {object} interface{}
+// @Failure 502 {object} interface{}
+// @Router /proxmox/vms/{name}/console [get]
+func (clusters Clusters) handlerConsole(publicURL string) gin.HandlerFunc {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return ConsoleOriginAllowed(r, publicURL) },
+	}
+	return func(c *gin.Context) {
+		clusters.console(c, upgrader)
+	}
+}
+
+func (clusters Clusters) console(c *gin.Context, upgrader websocket.Upgrader) {
+	if !upgrader.CheckOrigin(c.Request) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "origin not allowed"})
+		return
+	}
+	kind := c.DefaultQuery("type", ConsoleSerial)
+	if err := CheckConsoleType(kind); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	selected, ok := clusters.selected(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	cluster, guest, err := selected.FindGuest(ctx, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	console, ticket, err := cluster.DialConsole(ctx, guest, kind)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	defer console.Close()
+
+	client, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader has already replied
+		return
+	}
+	defer client.Close()
+	if kind == ConsoleVNC {
+		if err := LoginVNC(client, console, ticket); err != nil {
+			log.Warnf("console of %s: %v", guest.Name, err)
+			return
+		}
+	}
+	if err := ProxyConsole(client, console); err != nil {
+		log.Debugf("console of %s closed: %v", guest.Name, err)
+	}
+}
+
+// selected returns the clusters chosen with the cluster query parameter,
+// it replies with a 400 when the cluster doesn't exist
+func (clusters Clusters) selected(c *gin.Context) (Clusters, bool) {
+	selected, err := clusters.Select(c.Query("cluster"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return selected, true
+}
+
+// AnsibleInventory godoc
+// @Summary Ansible dynamic inventory
+// @Description Get the guests stored in NATS as an Ansible dynamic inventory,
+// @Description grouped by node (node_<name>), tag (tag_<tag>) and docker. With
+// @Description host only the variables of that host are returned.
+// @Tags inventory
+// @Accept json
+// @Produce json
+// @Param host query string false "Host name, the output of --host"
+// @Success 200 {object} interface{}
+// @Failure 404 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /inventory/ansible [get]
+func handlerAnsibleInventory(config *models.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		st, err := store.NewStore(ctx, &config.Nats)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer st.Close()
+
+		inventory, err := NewInventory(st).AnsibleInventory(ctx, config.SSH.User)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		host := c.Query("host")
+		if host == "" {
+			c.JSON(http.StatusOK, inventory)
+			return
+		}
+		vars, ok := inventory.HostVars(host)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("host %s not found", host)})
+			return
+		}
+		c.JSON(http.StatusOK, vars)
+	}
+}
+
+// firewallScope returns the cluster and the scope chosen with the cluster,
+// node and vm query parameters, it replies with an error when they don't
+// exist
+func (clusters Clusters) firewallScope(c *gin.Context) (*Cluster, FirewallScope, bool) {
+	selected, ok := clusters.selected(c)
+	if !ok {
+		return nil, FirewallScope{}, false
+	}
+	ctx := c.Request.Context()
+	if vm := c.Query("vm"); vm != "" {
+		cluster, guest, err := selected.FindGuest(ctx, vm)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return nil, FirewallScope{}, false
+		}
+		return cluster, FirewallScope{Guest: &guest}, true
+	}
+	if node := c.Query("node"); node != "" {
+		cluster, err := selected.ForNode(ctx, node)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return nil, FirewallScope{}, false
+		}
+		return cluster, FirewallScope{Node: node}, true
+	}
+	cluster, err := selected.One("")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, FirewallScope{}, false
+	}
+	return cluster, FirewallScope{}, true
+}
+
+// GetFirewallRules godoc
+// @Summary Get firewall rules
+// @Description Get the firewall rules of the cluster, of a node or of a guest
+// @Tags firewall
+// @Accept json
+// @Produce json
+// @Param cluster query string false "Cluster name, required with several clusters"
+// @Param node query string false "Node name"
+// @Param vm query string false "Guest name, VMID or <cluster>.<vmid>"
+// @Success 200 {array} FirewallRule
+// @Failure 400 {object} interface{}
+// @Failure 404 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /proxmox/firewall/rules [get]
+func (clusters Clusters) handlerGetFirewallRules(c *gin.Context) {
+	cluster, scope, ok := clusters.firewallScope(c)
+	if !ok {
+		return
+	}
+	rules, err := cluster.FirewallRules(c.Request.Context(), scope)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// AddFirewallRule godoc
+// @Summary Add a firewall rule
+// @Description Insert a rule at pos, 0 is the top. Rules are enabled unless
+// @Description enable is 0.
+// @Tags firewall
+// @Accept json
+// @Produce json
+// @Param cluster query string false "Cluster name, required with several clusters"
+// @Param node query string false "Node name"
+// @Param vm query string false "Guest name, VMID or <cluster>.<vmid>"
+// @Param rule body FirewallRule true "Rule"
+// @Success 201 {object} FirewallRule
+// @Failure 400 {object} interface{}
+// @Failure 404 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /proxmox/firewall/rules [post]
+func (clusters Clusters) handlerAddFirewallRule(c *gin.Context) {
+	rule := FirewallRule{Enable: 1}
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Error decoding request body: %v", err)})
+		return
+	}
+	if err := rule.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cluster, scope, ok := clusters.firewallScope(c)
+	if !ok {
+		return
+	}
+	if err := cluster.AddFirewallRule(c.Request.Context(), scope, rule); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+// DeleteFirewallRule godoc
+// @Summary Delete a firewall rule
+// @Description Delete the rule at pos, the rules below move up
+// @Tags firewall
+// @Accept json
+// @Produce json
+// @Param pos path int true "Rule position"
+// @Param cluster query string false "Cluster name, required with several clusters"
+// @Param node query string false "Node name"
+// @Param vm query string false "Guest name, VMID or <cluster>.<vmid>"
+// @Success 204
+// @Failure 400 {object} interface{}
+// @Failure 404 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /proxmox/firewall/rules/{pos} [delete]
+func (clusters Clusters) handlerDeleteFirewallRule(c *gin.Context) {
+	pos, err := strconv.Atoi(c.Param("pos"))
+	if err != nil || pos < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid rule position %q", c.Param("pos"))})
+		return
+	}
+	cluster, scope, ok := clusters.firewallScope(c)
+	if !ok {
+		return
+	}
+	if err := cluster.DeleteFirewallRule(c.Request.Context(), scope, pos); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GetIPSets godoc
+// @Summary Get IP sets
+// @Description Get the cluster IP sets with their entries
+// @Tags firewall
+// @Accept json
+// @Produce json
+// @Param cluster query string false "Cluster name, required with several clusters"
+// @Success 200 {array} IPSet
+// @Failure 400 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /proxmox/firewall/ipsets [get]
+func (clusters Clusters) handlerGetIPSets(c *gin.Context) {
+	cluster, err := clusters.One(c.Query("cluster"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ipsets, err := cluster.IPSets(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ipsets)
+}
+
+// GetSecurityGroups godoc
+// @Summary Get security groups
+// @Description Get the security groups with their rules
+// @Tags firewall
+// @Accept json
+// @Produce json
+// @Param cluster query string false "Cluster name, required with several clusters"
+// @Success 200 {array} SecurityGroup
+// @Failure 400 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /proxmox/firewall/groups [get]
+func (clusters Clusters) handlerGetSecurityGroups(c *gin.Context) {
+	cluster, err := clusters.One(c.Query("cluster"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	groups, err := cluster.SecurityGroups(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+// ApplyFirewall godoc
+// @Summary Apply a firewall spec
+// @Description Reconcile the IP sets, security groups and rules of a
+// @Description firewall.yaml (YAML or JSON) and return the plan. With dry_run
+// @Description the plan is only returned.
+// @Tags firewall
+// @Accept json
+// @Produce json
+// @Param cluster query string false "Cluster name, overrides the cluster of the spec"
+// @Param dry_run query bool false "Only return the plan"
+// @Param spec body FirewallSpec true "Firewall spec"
+// @Success 200 {object} FirewallPlan
+// @Failure 400 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /proxmox/firewall/apply [post]
+func (clusters Clusters) handlerApplyFirewall(c *gin.Context) {
+	spec, err := LoadFirewallSpec(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cluster, err := clusters.One(cmp.Or(c.Query("cluster"), spec.Cluster))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	plan, err := cluster.FirewallPlan(ctx, spec)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("dry_run") != "true" {
+		if err := cluster.FirewallApply(ctx, plan, func(step string) { log.Info(step) }); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "plan": plan})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, plan)
+}
+
+// GetUsers godoc
+// @Summary Get users
+// @Description Get the Proxmox users with their API tokens
+// @Tags access
+// @Accept json
+// @Produce json
+// @Param cluster query string false "Cluster name, required with several clusters"
+// @Success 200 {array} User
+// @Failure 400 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /proxmox/users [get]
+func (clusters Clusters) handlerGetUsers(c *gin.Context) {
+	cluster, err := clusters.One(c.Query("cluster"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	users, err := cluster.Users(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+// CreateUser godoc
+// @Summary Create a user
+// @Description Create an enabled user, the password is only used by the pve realm
+// @Tags access
+// @Accept json
+// @Produce json
+// @Param cluster query string false "Cluster name, required with several clusters"
+// @Param user body UserSpec true "User"
+// @Success 201
+// @Failure 400 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /proxmox/users [post]
+func (clusters Clusters) handlerCreateUser(c *gin.Context) {
+	var spec UserSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Error decoding request body: %v", err)})
+		return
+	}
+	if err := spec.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cluster, err := clusters.One(c.Query("cluster"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := cluster.CreateUser(c.Request.Context(), spec); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusCreated)
+}
+
+// DeleteUser godoc
+// @Summary Delete a user
+// @Description Delete a user and its API tokens
+// @Tags access
+// @Accept json
+// @Produce json
+// @Param userid path string true "User, <name>@<realm>"
+// @Param cluster query string false "Cluster name, required with several clusters"
+// @Success 204
+// @Failure 400 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /proxmox/users/{userid} [delete]
+func (clusters Clusters) handlerDeleteUser(c *gin.Context) {
+	cluster, err := clusters.One(c.Query("cluster"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := cluster.DeleteUser(c.Request.Context(), c.Param("userid")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GetTokens godoc
+// @Summary Get API tokens
+// @Description Get the API tokens of a user
+// @Tags access
+// @Accept json
+// @Produce json
+// @Param userid path string true "User, <name>@<realm>"
+// @Param cluster query string false "Cluster name, required with several clusters"
+// @Success 200 {array} APIToken
+// @Failure 400 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /proxmox/users/{userid}/tokens [get]
+func (clusters Clusters) handlerGetTokens(c *gin.Context) {
+	cluster, err := clusters.One(c.Query("cluster"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tokens, err := cluster.Tokens(c.Request.Context(), c.Param("userid"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// CreateToken godoc
+// @Summary Issue an API token
+// @Description Issue an API token and grant its ACLs. Tokens use privilege
+// @Description separation unless privsep is false. The secret is only
+// @Description returned once.
+// @Tags access
+// @Accept json
+// @Produce json
+// @Param userid path string true "User, <name>@<realm>"
+// @Param cluster query string false "Cluster name, required with several clusters"
+// @Param token body TokenSpec true "Token"
+// @Success 201 {object} TokenSecret
+// @Failure 400 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /proxmox/users/{userid}/tokens [post]
+func (clusters Clusters) handlerCreateToken(c *gin.Context) {
+	spec := TokenSpec{Privsep: true}
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Error decoding request body: %v", err)})
+		return
+	}
+	if err := spec.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cluster, err := clusters.One(c.Query("cluster"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	secret, err := cluster.CreateToken(c.Request.Context(), c.Param("userid"), spec)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, secret)
+}
+
+// RotateToken godoc
+// @Summary Rotate an API token
+// @Description Revoke a token and issue it again with the same settings and
+// @Description ACLs, a temporary <tokenid>-rotate token checks the token can be
+// @Description issued first. The old secret stops working immediately.
+// @Tags access
+// @Accept json
+// @Produce json
+// @Param userid path string true "User, <name>@<realm>"
+// @Param tokenid path string true "Token id"
+// @Param cluster query string false "Cluster name, required with several clusters"
+// @Success 200 {object} TokenSecret
+// @Failure 400 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /proxmox/users/{userid}/tokens/{tokenid}/rotate [post]
+func (clusters Clusters) handlerRotateToken(c *gin.Context) {
+	cluster, err := clusters.One(c.Query("cluster"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	secret, err := cluster.RotateToken(c.Request.Context(), c.Param("userid"), c.Param("tokenid"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, secret)
+}
+
+// RevokeToken godoc
+// @Summary Revoke an API token
+// @Tags access
+// @Accept json
+// @Produce json
+// @Param userid path string true "User, <name>@<realm>"
+// @Param tokenid path string true "Token id"
+// @Param cluster query string false "Cluster name, required with several clusters"
+// @Success 204
+// @Failure 400 {object} interface{}
+// @Failure 500 {object} interface{}
+// @Router /proxmox/users/{userid}/tokens/{tokenid} [delete]
+func (clusters Clusters) handlerRevokeToken(c *gin.Context) {
+	cluster, err := clusters.One(c.Query("cluster"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := cluster.RevokeToken(c.Request.Context(), c.Param("userid"), c.Param("tokenid")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
 }
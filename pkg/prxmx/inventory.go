@@ -0,0 +1,285 @@
+package prxmx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"i2/pkg/store"
+)
+
+// Inventory stores the cluster guests and storages in NATS. Guests are keyed
+// by <cluster>.<vmid> in the <bucket>-vms bucket and the <bucket>-vms-names
+// bucket maps every guest name to the keys using it. Storages are keyed by
+// <cluster>.<node>.<storage> in the <bucket>-storage bucket. The containers
+// of every guest are stored by the containers command in <bucket>-containers,
+// the latest stats of those containers in <bucket>-stats.
+type Inventory struct {
+	st               *store.Store
+	Bucket           string
+	Index            string
+	StorageBucket    string
+	ContainersBucket string
+	StatsBucket      string
+}
+
+func NewInventory(st *store.Store) *Inventory {
+	return &Inventory{
+		st:               st,
+		Bucket:           st.Bucket + "-vms",
+		Index:            st.Bucket + "-vms-names",
+		StorageBucket:    st.Bucket + "-storage",
+		ContainersBucket: st.Bucket + "-containers",
+		StatsBucket:      st.Bucket + "-stats",
+	}
+}
+
+// Save stores the guests and updates the name index. Index entries pointing
+// to guests that still use the name are kept, so saving a subset of the
+// inventory doesn't drop the other guests with the same name. The previous
+// name of a renamed guest no longer points to it.
+func (i *Inventory) Save(ctx context.Context, nodes []Node) error {
+	names := map[string][]string{}
+	for _, node := range nodes {
+		if previous, err := i.GetByKey(ctx, node.Key()); err == nil && previous.Name != node.Name {
+			if _, ok := names[previous.Name]; !ok {
+				names[previous.Name] = []string{}
+			}
+		}
+		err := store.SetKV(ctx, node.Key(), i.Bucket, node.ToBytes(), i.st.NatsConn)
+		if err != nil {
+			return err
+		}
+		names[node.Name] = append(names[node.Name], node.Key())
+	}
+
+	for name, keys := range names {
+		for _, key := range i.indexKeys(ctx, name) {
+			if slices.Contains(keys, key) {
+				continue
+			}
+			if node, err := i.GetByKey(ctx, key); err == nil && node.Name == name {
+				keys = append(keys, key)
+			}
+		}
+		bkeys, err := json.Marshal(keys)
+		if err != nil {
+			return err
+		}
+		err = store.SetKV(ctx, name, i.Index, bkeys, i.st.NatsConn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetByKey returns the guest stored under key
+func (i *Inventory) GetByKey(ctx context.Context, key string) (Node, error) {
+	b, err := store.GetKV(ctx, key, i.Bucket, i.st.NatsConn)
+	if err != nil {
+		return Node{}, err
+	}
+	return DecodeNode(b)
+}
+
+// Find returns the guests matching a name or a key. Names are resolved with
+// the index first, skipping the guests renamed since the index was written,
+// entries cached before the index existed are keyed by name.
+func (i *Inventory) Find(ctx context.Context, nameOrKey string) ([]Node, error) {
+	nodes := []Node{}
+	for _, key := range i.indexKeys(ctx, nameOrKey) {
+		node, err := i.GetByKey(ctx, key)
+		if err != nil || node.Name != nameOrKey {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) > 0 {
+		return nodes, nil
+	}
+
+	node, err := i.GetByKey(ctx, nameOrKey)
+	if err != nil {
+		return nil, fmt.Errorf("guest %s not found: %w", nameOrKey, err)
+	}
+	return []Node{node}, nil
+}
+
+// Get returns the guest matching a name or a key, it fails when the name is
+// used by more than one guest
+func (i *Inventory) Get(ctx context.Context, nameOrKey string) (Node, error) {
+	nodes, err := i.Find(ctx, nameOrKey)
+	if err != nil {
+		return Node{}, err
+	}
+	if len(nodes) > 1 {
+		keys := make([]string, 0, len(nodes))
+		for _, node := range nodes {
+			keys = append(keys, node.Key())
+		}
+		return Node{}, fmt.Errorf("%s is ambiguous, use one of: %s", nameOrKey, strings.Join(keys, ", "))
+	}
+	return nodes[0], nil
+}
+
+// List returns every guest in the inventory. Legacy entries are skipped when
+// the guest has already been stored with its current key.
+func (i *Inventory) List(ctx context.Context) ([]Node, error) {
+	keys, err := store.GetKeys(ctx, i.Bucket, i.st.NatsConn)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []Node{}
+	current := map[string]bool{}
+	for _, key := range keys {
+		node, err := i.GetByKey(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", key, err)
+		}
+		if node.Version > 0 {
+			current[node.Name] = true
+		}
+		nodes = append(nodes, node)
+	}
+
+	return slices.DeleteFunc(nodes, func(n Node) bool {
+		return n.Version == 0 && current[n.Name]
+	}), nil
+}
+
+func (i *Inventory) indexKeys(ctx context.Context, name string) []string {
+	b, err := store.GetKV(ctx, name, i.Index, i.st.NatsConn)
+	if err != nil {
+		return nil
+	}
+	keys := []string{}
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil
+	}
+	return keys
+}
+
+// SaveStorage stores the storages of the cluster nodes
+func (i *Inventory) SaveStorage(ctx context.Context, storages []Storage) error {
+	for _, s := range storages {
+		bs, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		err = store.SetKV(ctx, s.Key(), i.StorageBucket, bs, i.st.NatsConn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListStorage returns every storage in the inventory
+func (i *Inventory) ListStorage(ctx context.Context) ([]Storage, error) {
+	keys, err := store.GetKeys(ctx, i.StorageBucket, i.st.NatsConn)
+	if err != nil {
+		return nil, err
+	}
+	storages := make([]Storage, 0, len(keys))
+	for _, key := range keys {
+		b, err := store.GetKV(ctx, key, i.StorageBucket, i.st.NatsConn)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", key, err)
+		}
+		s := Storage{}
+		if err := json.Unmarshal(b, &s); err != nil {
+			return nil, fmt.Errorf("error decoding %s: %w", key, err)
+		}
+		storages = append(storages, s)
+	}
+	return storages, nil
+}
+
+// SaveContainers stores the containers of a guest, as listed by Docker, in
+// the containers bucket
+func (i *Inventory) SaveContainers(ctx context.Context, vm Node, containers any) error {
+	b, err := json.Marshal(containers)
+	if err != nil {
+		return err
+	}
+	return store.SetKV(ctx, vm.Key(), i.ContainersBucket, b, i.st.NatsConn)
+}
+
+// Containers returns the containers stored for every guest, by guest key, as
+// SaveContainers encoded them
+func (i *Inventory) Containers(ctx context.Context) (map[string]json.RawMessage, error) {
+	return i.values(ctx, i.ContainersBucket)
+}
+
+// HostContainers returns the containers stored for a guest, as
+// SaveContainers encoded them
+func (i *Inventory) HostContainers(ctx context.Context, vm Node) (json.RawMessage, error) {
+	return store.GetKV(ctx, vm.Key(), i.ContainersBucket, i.st.NatsConn)
+}
+
+// SaveStats stores the latest stats of the containers of a guest in the
+// stats bucket
+func (i *Inventory) SaveStats(ctx context.Context, vm Node, stats any) error {
+	b, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return store.SetKV(ctx, vm.Key(), i.StatsBucket, b, i.st.NatsConn)
+}
+
+// Stats returns the stats stored for every guest, by guest key, as SaveStats
+// encoded them
+func (i *Inventory) Stats(ctx context.Context) (map[string]json.RawMessage, error) {
+	return i.values(ctx, i.StatsBucket)
+}
+
+// values returns every value of a bucket by key
+func (i *Inventory) values(ctx context.Context, bucket string) (map[string]json.RawMessage, error) {
+	keys, err := store.GetKeys(ctx, bucket, i.st.NatsConn)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]json.RawMessage, len(keys))
+	for _, key := range keys {
+		b, err := store.GetKV(ctx, key, bucket, i.st.NatsConn)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", key, err)
+		}
+		values[key] = b
+	}
+	return values, nil
+}
+
+// ContainerHosts returns the guests with a container whose label is set to
+// value, as stored in the containers bucket
+func (i *Inventory) ContainerHosts(ctx context.Context, label, value string) ([]Node, error) {
+	keys, err := store.GetKeys(ctx, i.ContainersBucket, i.st.NatsConn)
+	if err != nil {
+		return nil, err
+	}
+	type labelled struct{ Labels map[string]string }
+	nodes := []Node{}
+	for _, key := range keys {
+		b, err := store.GetKV(ctx, key, i.ContainersBucket, i.st.NatsConn)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", key, err)
+		}
+		containers := []labelled{}
+		if err := json.Unmarshal(b, &containers); err != nil {
+			return nil, fmt.Errorf("error decoding %s: %w", key, err)
+		}
+		if !slices.ContainsFunc(containers, func(c labelled) bool { return c.Labels[label] == value }) {
+			continue
+		}
+		node, err := i.GetByKey(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", key, err)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
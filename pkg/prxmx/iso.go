@@ -0,0 +1,75 @@
+package prxmx
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UploadISO uploads an ISO to a storage of a node and waits for Proxmox to
+// store it. The volume is named after the file, an existing one is replaced.
+// It returns the volume id, <storage>:iso/<file>.
+func (c *Cluster) UploadISO(ctx context.Context, node, storage, path string) (string, error) {
+	name := filepath.Base(path)
+	if !strings.HasSuffix(strings.ToLower(name), ".iso") {
+		return "", fmt.Errorf("%s: Proxmox only accepts .iso files", name)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// go-proxmox doesn't take a context for uploads
+	var upid string
+	uploadPath := fmt.Sprintf("/nodes/%s/storage/%s/upload", node, storage)
+	if err := c.Client.Upload(uploadPath, map[string]string{"content": "iso"}, f, &upid); err != nil {
+		return "", fmt.Errorf("error uploading %s to %s on %s: %w", name, storage, node, err)
+	}
+	if upid != "" {
+		if _, err := c.WaitTask(ctx, upid); err != nil {
+			return "", fmt.Errorf("error uploading %s to %s on %s: %w", name, storage, node, err)
+		}
+	}
+	return fmt.Sprintf("%s:iso/%s", storage, name), nil
+}
+
+// AttachISO inserts an ISO volume in a CD-ROM drive of a VM and returns the
+// drive. A drive already holding the volume is reused, otherwise the first
+// free IDE slot is used.
+func (c *Cluster) AttachISO(ctx context.Context, guest Node, volid string) (string, error) {
+	if guest.IsContainer() {
+		return "", fmt.Errorf("%s is a container, ISOs can only be attached to VMs", guest.Name)
+	}
+	configPath := fmt.Sprintf("/nodes/%s/qemu/%d/config", guest.Host, guest.VMID)
+	cctx, cancel := c.callContext(ctx)
+	config := map[string]any{}
+	err := c.Client.Get(cctx, configPath, &config)
+	cancel()
+	if err != nil {
+		return "", fmt.Errorf("error reading the config of %s: %w", guest.Name, err)
+	}
+
+	drive := ""
+	for _, key := range sortedKeys(config) {
+		if volume, _, _ := strings.Cut(configString(config, key), ","); isDiskKey(key) && volume == volid {
+			drive = key
+			break
+		}
+	}
+	if drive == "" {
+		drive = freeIDE(config)
+		if _, used := config[drive]; used {
+			return "", fmt.Errorf("%s has no free IDE slot", guest.Name)
+		}
+	}
+
+	cctx, cancel = c.callContext(ctx)
+	defer cancel()
+	if err := c.Client.Put(cctx, configPath, map[string]any{drive: volid + ",media=cdrom"}, nil); err != nil {
+		return "", fmt.Errorf("error attaching %s to %s: %w", volid, guest.Name, err)
+	}
+	return drive, nil
+}
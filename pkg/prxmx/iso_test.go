@@ -0,0 +1,79 @@
+package prxmx
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCluster_UploadISO(t *testing.T) {
+	TaskPollInterval = 10 * time.Millisecond
+	var content, filename string
+	var body []byte
+	routes := fakeTask("OK")
+	routes["/nodes/pve1/storage/local/upload"] = func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		content = r.FormValue("content")
+		file, header, err := r.FormFile("filename")
+		require.NoError(t, err)
+		filename = header.Filename
+		body, _ = io.ReadAll(file)
+		data(testUPID)(w, r)
+	}
+	cluster := newFakeProxmox(t, routes)
+
+	path := filepath.Join(t.TempDir(), "web-cidata.iso")
+	require.NoError(t, os.WriteFile(path, []byte("seed"), 0o644))
+	volid, err := cluster.UploadISO(context.Background(), "pve1", "local", path)
+	require.NoError(t, err)
+	assert.Equal(t, "local:iso/web-cidata.iso", volid)
+	assert.Equal(t, "iso", content)
+	assert.Equal(t, "web-cidata.iso", filename)
+	assert.Equal(t, "seed", string(body))
+
+	_, err = cluster.UploadISO(context.Background(), "pve1", "local", filepath.Join(t.TempDir(), "seed.img"))
+	assert.ErrorContains(t, err, ".iso")
+}
+
+func TestCluster_AttachISO(t *testing.T) {
+	var put map[string]any
+	config := map[string]any{"ide2": "local-lvm:vm-100-cloudinit,media=cdrom", "scsi0": "local-lvm:vm-100-disk-0,size=32G"}
+	routes := map[string]http.HandlerFunc{
+		"/nodes/pve1/qemu/100/config": func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPut {
+				put = map[string]any{}
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&put))
+			}
+			data(config)(w, r)
+		},
+	}
+	cluster := newFakeProxmox(t, routes)
+	guest := Node{Name: "web", Host: "pve1", Type: "qemu", VMID: 100}
+	ctx := context.Background()
+
+	drive, err := cluster.AttachISO(ctx, guest, "local:iso/web-cidata.iso")
+	require.NoError(t, err)
+	assert.Equal(t, "ide0", drive)
+	assert.Equal(t, map[string]any{"ide0": "local:iso/web-cidata.iso,media=cdrom"}, put)
+
+	// attaching the same ISO again reuses its drive
+	config["ide1"] = "local:iso/web-cidata.iso,media=cdrom"
+	drive, err = cluster.AttachISO(ctx, guest, "local:iso/web-cidata.iso")
+	require.NoError(t, err)
+	assert.Equal(t, "ide1", drive)
+
+	config["ide0"], config["ide3"] = "none,media=cdrom", "none,media=cdrom"
+	_, err = cluster.AttachISO(ctx, guest, "local:iso/other.iso")
+	assert.ErrorContains(t, err, "no free IDE slot")
+
+	_, err = cluster.AttachISO(ctx, Node{Name: "ct", Host: "pve1", Type: "lxc", VMID: 200}, "local:iso/web-cidata.iso")
+	assert.ErrorContains(t, err, "container")
+}
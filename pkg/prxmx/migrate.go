@@ -0,0 +1,324 @@
+package prxmx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"i2/pkg/models"
+	"i2/pkg/store"
+
+	"github.com/charmbracelet/log"
+)
+
+// ErrMigrationCheck is returned when a guest can't be migrated to a node
+var ErrMigrationCheck = errors.New("migration check failed")
+
+// Migration is a guest to be moved to another node
+type Migration struct {
+	Guest  Node
+	Target string
+}
+
+// FindGuest returns the guest matching a name, a VMID or a <cluster>.<vmid>
+// key. Only the fields of the node listing are set, the guest agent is not
+// queried.
+func (c *Cluster) FindGuest(ctx context.Context, nameOrID string) (Node, error) {
+	guests, _, err := c.getGuests(ctx)
+	if len(guests) == 0 && err != nil {
+		return Node{}, err
+	}
+	matches := []Node{}
+	for _, g := range guests {
+		node := c.guestNode(g)
+		id := strconv.FormatUint(node.VMID, 10)
+		if node.Name == nameOrID || id == nameOrID || node.Key() == nameOrID {
+			matches = append(matches, node)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		if err != nil {
+			return Node{}, fmt.Errorf("guest %s not found: %w", nameOrID, err)
+		}
+		return Node{}, fmt.Errorf("guest %s not found", nameOrID)
+	case 1:
+		return matches[0], nil
+	}
+	keys := make([]string, 0, len(matches))
+	for _, m := range matches {
+		keys = append(keys, m.Key())
+	}
+	return Node{}, fmt.Errorf("%s is ambiguous, use one of: %s", nameOrID, strings.Join(keys, ", "))
+}
+
+// CheckMigration verifies that a guest can be moved to the target node: the
+// target has to be online with enough free memory for a running guest, and
+// every disk of the guest has to be on a storage shared with the target. All
+// the problems found are returned wrapping ErrMigrationCheck.
+func (c *Cluster) CheckMigration(ctx context.Context, guest Node, target string, online bool) error {
+	if guest.Host == target {
+		return fmt.Errorf("%w: %s is already on %s", ErrMigrationCheck, guest.Name, target)
+	}
+	if guest.Running && !online {
+		return fmt.Errorf("%w: %s is running, migrate it online", ErrMigrationCheck, guest.Name)
+	}
+
+	cctx, cancel := c.callContext(ctx)
+	nodes, err := c.Client.Nodes(cctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, n := range nodes {
+		if n.Node == target {
+			found = true
+			if n.Status != "online" {
+				return fmt.Errorf("%w: node %s is %s", ErrMigrationCheck, target, n.Status)
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: node %s not found", ErrMigrationCheck, target)
+	}
+
+	problems := []string{}
+	if guest.Running {
+		cctx, cancel := c.callContext(ctx)
+		node, err := c.Client.Node(cctx, target)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if node.Memory.Free < guest.MaxMem {
+			problems = append(problems, fmt.Sprintf("node %s has %d bytes of free memory, %s needs %d",
+				target, node.Memory.Free, guest.Name, guest.MaxMem))
+		}
+	}
+
+	config := map[string]any{}
+	cctx, cancel = c.callContext(ctx)
+	err = c.Client.Get(cctx, fmt.Sprintf("/nodes/%s/%s/%d/config", guest.Host, guest.Type, guest.VMID), &config)
+	cancel()
+	if err != nil {
+		return err
+	}
+	storages, err := c.nodeStorage(ctx, target)
+	if err != nil {
+		return err
+	}
+	available := map[string]Storage{}
+	for _, s := range storages {
+		available[s.Name] = s
+	}
+	for _, name := range configStorages(config) {
+		s, ok := available[name]
+		switch {
+		case !ok || !s.Active:
+			problems = append(problems, fmt.Sprintf("storage %s is not available on %s", name, target))
+		case !s.Shared:
+			problems = append(problems, fmt.Sprintf("storage %s is local to %s, only guests on shared storage can be migrated", name, guest.Host))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrMigrationCheck, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Migrate checks and starts the migration of a guest to the target node and
+// returns the UPID of the migration task. Online migrations are live for VMs,
+// LXC containers can't be migrated live and are restarted on the target.
+func (c *Cluster) Migrate(ctx context.Context, guest Node, target string, online bool) (string, error) {
+	if err := c.CheckMigration(ctx, guest, target, online); err != nil {
+		return "", err
+	}
+
+	params := map[string]any{"target": target}
+	if online && guest.Running {
+		if guest.IsContainer() {
+			params["restart"] = 1
+		} else {
+			params["online"] = 1
+		}
+	}
+
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	var upid string
+	err := c.Client.Post(cctx, fmt.Sprintf("/nodes/%s/%s/%d/migrate", guest.Host, guest.Type, guest.VMID), params, &upid)
+	if err != nil {
+		return "", fmt.Errorf("error migrating %s to %s: %w", guest.Name, target, err)
+	}
+	return upid, nil
+}
+
+// FinishMigration follows the migration task of a guest until it stops,
+// sending its log lines to lines, and records the target as the host of the
+// guest in NATS. When NATS can't be reached the host is updated by the next
+// sync. lines is not closed.
+func (c *Cluster) FinishMigration(ctx context.Context, nats *models.Nats, guest Node, target, upid string, lines chan<- string) (TaskInfo, error) {
+	info, err := c.WatchTask(ctx, upid, lines)
+	if errors.Is(err, ErrTaskFailed) {
+		return info, err
+	}
+	if err != nil {
+		return info, fmt.Errorf("error watching the migration of %s: %w", guest.Name, err)
+	}
+
+	st, err := store.NewStore(ctx, nats)
+	if err != nil || st == nil {
+		log.Warnf("Error creating store, %s host not updated: %v", guest.Name, err)
+		return info, nil
+	}
+	defer st.Close()
+	inventory := NewInventory(st)
+	cached, err := inventory.GetByKey(ctx, guest.Key())
+	if err != nil {
+		// not synced yet, store what we know about the guest
+		cached = guest
+	}
+	cached.Host = target
+	if err := inventory.Save(ctx, []Node{cached}); err != nil {
+		return info, fmt.Errorf("error updating %s host in NATS: %w", guest.Name, err)
+	}
+	return info, nil
+}
+
+// WaitMigration is FinishMigration with the log lines discarded
+func (c *Cluster) WaitMigration(ctx context.Context, nats *models.Nats, guest Node, target, upid string) (TaskInfo, error) {
+	lines := make(chan string)
+	go func() {
+		for range lines {
+		}
+	}()
+	defer close(lines)
+	return c.FinishMigration(ctx, nats, guest, target, upid, lines)
+}
+
+// DrainPlan returns the migrations needed to move every running guest off a
+// node. Each guest goes to the online node with the most free memory left
+// that passes CheckMigration, guests that can't go anywhere are returned in
+// the error.
+func (c *Cluster) DrainPlan(ctx context.Context, host string) ([]Migration, error) {
+	guests, err := c.nodeGuests(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	running := []Node{}
+	for _, g := range guests {
+		node := c.guestNode(g)
+		if node.Running {
+			running = append(running, node)
+		}
+	}
+	// place the biggest guests first
+	sort.SliceStable(running, func(i, j int) bool {
+		return running[i].MaxMem > running[j].MaxMem
+	})
+
+	cctx, cancel := c.callContext(ctx)
+	nodes, err := c.Client.Nodes(cctx)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	free := map[string]uint64{}
+	for _, n := range nodes {
+		if n.Node == host || n.Status != "online" {
+			continue
+		}
+		cctx, cancel := c.callContext(ctx)
+		node, err := c.Client.Node(cctx, n.Node)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		free[n.Node] = node.Memory.Free
+	}
+
+	plan := []Migration{}
+	errs := []error{}
+	for _, guest := range running {
+		targets := make([]string, 0, len(free))
+		for name := range free {
+			targets = append(targets, name)
+		}
+		sort.Slice(targets, func(i, j int) bool {
+			if free[targets[i]] == free[targets[j]] {
+				return targets[i] < targets[j]
+			}
+			return free[targets[i]] > free[targets[j]]
+		})
+
+		var checkErr error
+		placed := false
+		for _, target := range targets {
+			if free[target] < guest.MaxMem {
+				continue
+			}
+			if checkErr = c.CheckMigration(ctx, guest, target, true); checkErr != nil {
+				continue
+			}
+			free[target] -= guest.MaxMem
+			plan = append(plan, Migration{Guest: guest, Target: target})
+			placed = true
+			break
+		}
+		if !placed {
+			if checkErr == nil {
+				checkErr = fmt.Errorf("%w: no node has enough free memory", ErrMigrationCheck)
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", guest.Name, checkErr))
+		}
+	}
+	return plan, errors.Join(errs...)
+}
+
+// configStorages returns the storages used by the disks of a guest config.
+// Empty and cloud-init drives are skipped, Proxmox regenerates cloud-init
+// drives on the target.
+func configStorages(config map[string]any) []string {
+	seen := map[string]bool{}
+	for key, value := range config {
+		if !isDiskKey(key) {
+			continue
+		}
+		v, ok := value.(string)
+		if !ok {
+			continue
+		}
+		volume, _, _ := strings.Cut(v, ",")
+		storage, name, found := strings.Cut(volume, ":")
+		if !found || strings.Contains(name, "cloudinit") {
+			continue
+		}
+		seen[storage] = true
+	}
+	storages := make([]string, 0, len(seen))
+	for s := range seen {
+		storages = append(storages, s)
+	}
+	sort.Strings(storages)
+	return storages
+}
+
+// isDiskKey reports whether a config key holds a volume: scsi0, virtio1,
+// efidisk0, unused0, rootfs, mp0...
+func isDiskKey(key string) bool {
+	if key == "rootfs" {
+		return true
+	}
+	for _, prefix := range []string{"scsi", "virtio", "sata", "ide", "efidisk", "tpmstate", "unused", "mp"} {
+		if n, ok := strings.CutPrefix(key, prefix); ok {
+			_, err := strconv.Atoi(n)
+			return err == nil
+		}
+	}
+	return false
+}
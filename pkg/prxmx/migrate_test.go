@@ -0,0 +1,172 @@
+package prxmx
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"i2/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// migrationRoutes is a two node cluster: web (100) only uses shared storage,
+// db (101) has a disk on local-lvm and pve2 has 4GiB of free memory
+func migrationRoutes() map[string]http.HandlerFunc {
+	const gib = 1 << 30
+	return map[string]http.HandlerFunc{
+		"/nodes": data([]map[string]any{
+			{"node": "pve1", "status": "online"},
+			{"node": "pve2", "status": "online"},
+			{"node": "pve3", "status": "offline"},
+		}),
+		"/nodes/pve1/status": data(map[string]any{"memory": map[string]any{"free": 1 * gib}}),
+		"/nodes/pve2/status": data(map[string]any{"memory": map[string]any{"free": 4 * gib}}),
+		"/nodes/pve1/qemu": data([]map[string]any{
+			{"vmid": 100, "name": "web", "status": "running", "maxmem": 2 * gib},
+			{"vmid": 101, "name": "db", "status": "running", "maxmem": 2 * gib},
+		}),
+		"/nodes/pve1/lxc":  data([]map[string]any{}),
+		"/nodes/pve2/qemu": data([]map[string]any{}),
+		"/nodes/pve2/lxc":  data([]map[string]any{}),
+		"/nodes/pve1/qemu/100/config": data(map[string]any{
+			"scsihw": "virtio-scsi-pci",
+			"scsi0":  "ceph:vm-100-disk-0,size=32G",
+			"ide2":   "local-lvm:vm-100-cloudinit,media=cdrom",
+			"ide0":   "none,media=cdrom",
+		}),
+		"/nodes/pve1/qemu/101/config": data(map[string]any{
+			"scsi0": "ceph:vm-101-disk-0,size=32G",
+			"scsi1": "local-lvm:vm-101-disk-1,size=100G",
+		}),
+		"/nodes/pve2/storage": data([]map[string]any{
+			{"storage": "ceph", "type": "rbd", "shared": 1, "active": 1},
+			{"storage": "local-lvm", "type": "lvmthin", "active": 1},
+		}),
+	}
+}
+
+func TestCluster_CheckMigration(t *testing.T) {
+	cluster := newFakeProxmox(t, migrationRoutes())
+	ctx := context.Background()
+
+	web, err := cluster.FindGuest(ctx, "web")
+	require.NoError(t, err)
+	db, err := cluster.FindGuest(ctx, "101")
+	require.NoError(t, err)
+
+	assert.NoError(t, cluster.CheckMigration(ctx, web, "pve2", true))
+
+	err = cluster.CheckMigration(ctx, web, "pve2", false)
+	assert.ErrorIs(t, err, ErrMigrationCheck, "running guests need an online migration")
+	err = cluster.CheckMigration(ctx, web, "pve1", true)
+	assert.ErrorIs(t, err, ErrMigrationCheck)
+	err = cluster.CheckMigration(ctx, web, "pve3", true)
+	assert.ErrorContains(t, err, "offline")
+	err = cluster.CheckMigration(ctx, db, "pve2", true)
+	assert.ErrorIs(t, err, ErrMigrationCheck)
+	assert.ErrorContains(t, err, "local-lvm is local")
+}
+
+func TestCluster_Migrate(t *testing.T) {
+	var params map[string]any
+	routes := migrationRoutes()
+	routes["/nodes/pve1/qemu/100/migrate"] = func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&params))
+		data(testUPID)(w, r)
+	}
+	cluster := newFakeProxmox(t, routes)
+	ctx := context.Background()
+
+	web, err := cluster.FindGuest(ctx, "web")
+	require.NoError(t, err)
+	upid, err := cluster.Migrate(ctx, web, "pve2", true)
+	require.NoError(t, err)
+	assert.Equal(t, testUPID, upid)
+	assert.Equal(t, "pve2", params["target"])
+	assert.EqualValues(t, 1, params["online"])
+}
+
+func TestCluster_FinishMigration(t *testing.T) {
+	TaskPollInterval = 10 * time.Millisecond
+	web := Node{Name: "web", VMID: 100, Host: "pve1"}
+	// NATS isn't reachable, the host is updated by the next sync
+	nats := &models.Nats{URL: "nats://127.0.0.1:1", Timeout: 1}
+
+	cluster := newFakeProxmox(t, fakeTask("OK"))
+	lines := make(chan string, 10)
+	info, err := cluster.FinishMigration(context.Background(), nats, web, "pve2", testUPID, lines)
+	require.NoError(t, err)
+	assert.Equal(t, "OK", info.ExitStatus)
+	assert.Len(t, lines, 3)
+
+	cluster = newFakeProxmox(t, fakeTask("migration aborted"))
+	_, err = cluster.WaitMigration(context.Background(), nats, web, "pve2", testUPID)
+	assert.ErrorIs(t, err, ErrTaskFailed)
+}
+
+func TestHandlerMigrateVM(t *testing.T) {
+	TaskPollInterval = 10 * time.Millisecond
+	gin.SetMode(gin.TestMode)
+
+	routes := migrationRoutes()
+	routes["/nodes/pve1/qemu/100/migrate"] = data(testUPID)
+	var polls atomic.Int32
+	for path, handler := range fakeTask("OK") {
+		routes[path] = func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(path, "/status") {
+				polls.Add(1)
+			}
+			handler(w, r)
+		}
+	}
+	cluster := newFakeProxmox(t, routes)
+	router := gin.New()
+	api := router.Group("/api/v1")
+	api.POST("/proxmox/vms/:name/migrate", Clusters{cluster}.handlerMigrateVM(&models.Nats{URL: "nats://127.0.0.1:1", Timeout: 1}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/proxmox/vms/web/migrate", strings.NewReader(`{"target": "pve2", "online": true}`))
+	router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, testUPID, body["upid"])
+	assert.Equal(t, "/api/v1/proxmox/tasks/"+url.PathEscape(testUPID)+"?cluster="+DefaultClusterName, body["task"])
+
+	// the API follows the task once the request is answered
+	assert.Eventually(t, func() bool { return polls.Load() >= 3 }, time.Second, 10*time.Millisecond)
+}
+
+func TestCluster_DrainPlan(t *testing.T) {
+	cluster := newFakeProxmox(t, migrationRoutes())
+
+	plan, err := cluster.DrainPlan(context.Background(), "pve1")
+	require.Len(t, plan, 1)
+	assert.Equal(t, "web", plan[0].Guest.Name)
+	assert.Equal(t, "pve2", plan[0].Target)
+	assert.ErrorContains(t, err, "db:")
+}
+
+func TestConfigStorages(t *testing.T) {
+	config := map[string]any{
+		"scsihw":   "virtio-scsi-pci",
+		"virtio0":  "ceph:vm-100-disk-0,size=32G",
+		"efidisk0": "local-lvm:vm-100-disk-1,efitype=4m",
+		"ide2":     "local:iso/debian-12.iso,media=cdrom",
+		"ide3":     "local-lvm:vm-100-cloudinit,media=cdrom",
+		"rootfs":   "nfs:subvol-100-disk-0,size=8G",
+		"mp0":      "/srv/data,mp=/data",
+		"net0":     "virtio=BC:24:11:00:00:01,bridge=vmbr0",
+	}
+	assert.Equal(t, []string{"ceph", "local", "local-lvm", "nfs"}, configStorages(config))
+}
@@ -3,11 +3,38 @@ package prxmx
 import (
 	"i2/pkg/models"
 
+	"github.com/charmbracelet/log"
 	"github.com/gin-gonic/gin"
 )
 
 func AddRoutes(api *gin.RouterGroup, config *models.Config) {
-	cluster := NewCluster(config.Proxmox.URL, config.Proxmox.User, config.Proxmox.Pass)
-	api.GET("/proxmox/nodes", cluster.handlerGetClusterNodes)
-	api.GET("/proxmox/vms", cluster.handlerGetVirtualMachines)
+	// the inventory is read from NATS, it doesn't need the Proxmox API
+	api.GET("/inventory/ansible", handlerAnsibleInventory(config))
+
+	clusters, err := NewClusters(config)
+	if err != nil {
+		log.Errorf("Proxmox routes disabled: %v", err)
+		return
+	}
+	api.GET("/proxmox/nodes", clusters.handlerGetClusterNodes)
+	api.GET("/proxmox/vms", clusters.handlerGetVirtualMachines)
+	api.POST("/proxmox/vms/:name/migrate", clusters.handlerMigrateVM(&config.Nats))
+	api.GET("/proxmox/vms/:name/console", clusters.handlerConsole(config.Api.PublicUrl))
+	api.GET("/proxmox/storage", clusters.handlerGetStorage)
+	api.GET("/proxmox/firewall/rules", clusters.handlerGetFirewallRules)
+	api.POST("/proxmox/firewall/rules", clusters.handlerAddFirewallRule)
+	api.DELETE("/proxmox/firewall/rules/:pos", clusters.handlerDeleteFirewallRule)
+	api.GET("/proxmox/firewall/ipsets", clusters.handlerGetIPSets)
+	api.GET("/proxmox/firewall/groups", clusters.handlerGetSecurityGroups)
+	api.POST("/proxmox/firewall/apply", clusters.handlerApplyFirewall)
+	api.GET("/proxmox/users", clusters.handlerGetUsers)
+	api.POST("/proxmox/users", clusters.handlerCreateUser)
+	api.DELETE("/proxmox/users/:userid", clusters.handlerDeleteUser)
+	api.GET("/proxmox/users/:userid/tokens", clusters.handlerGetTokens)
+	api.POST("/proxmox/users/:userid/tokens", clusters.handlerCreateToken)
+	api.POST("/proxmox/users/:userid/tokens/:tokenid/rotate", clusters.handlerRotateToken)
+	api.DELETE("/proxmox/users/:userid/tokens/:tokenid", clusters.handlerRevokeToken)
+	api.GET("/proxmox/tasks", clusters.handlerGetTasks)
+	api.GET("/proxmox/tasks/:upid", clusters.handlerGetTask)
+	api.GET("/proxmox/tasks/:upid/log", clusters.handlerStreamTaskLog)
 }
@@ -0,0 +1,355 @@
+package prxmx
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// VMSpecs is the content of a vms.yaml file
+type VMSpecs struct {
+	VMs []VMSpec `yaml:"vms"`
+}
+
+// VMSpec is the desired state of a VM. New VMs are cloned from Template on
+// Node, existing VMs are matched by name.
+type VMSpec struct {
+	Name      string         `yaml:"name"`
+	Cluster   string         `yaml:"cluster,omitempty"`
+	Node      string         `yaml:"node,omitempty"`
+	Template  string         `yaml:"template,omitempty"`
+	Full      bool           `yaml:"full,omitempty"`
+	Start     bool           `yaml:"start,omitempty"`
+	Cores     int            `yaml:"cores,omitempty"`
+	Memory    uint64         `yaml:"memory,omitempty"`
+	Disks     []DiskSpec     `yaml:"disks,omitempty"`
+	Network   []NetworkSpec  `yaml:"network,omitempty"`
+	Tags      []string       `yaml:"tags,omitempty"`
+	CloudInit *CloudInitSpec `yaml:"cloudinit,omitempty"`
+}
+
+// DiskSpec is a VM disk, Name is the bus and index: scsi0, virtio1...
+type DiskSpec struct {
+	Name    string `yaml:"name"`
+	Storage string `yaml:"storage"`
+	Size    string `yaml:"size"`
+}
+
+// NetworkSpec is a VM network interface, Name is the netN key
+type NetworkSpec struct {
+	Name   string `yaml:"name"`
+	Bridge string `yaml:"bridge"`
+	Model  string `yaml:"model,omitempty"`
+	VLAN   int    `yaml:"vlan,omitempty"`
+}
+
+// CloudInitSpec is the cloud-init configuration of a VM. IP is dhcp or an
+// address in CIDR notation.
+type CloudInitSpec struct {
+	Storage      string   `yaml:"storage,omitempty"`
+	User         string   `yaml:"user,omitempty"`
+	SSHKeys      []string `yaml:"ssh_keys,omitempty"`
+	IP           string   `yaml:"ip,omitempty"`
+	Gateway      string   `yaml:"gateway,omitempty"`
+	Nameserver   string   `yaml:"nameserver,omitempty"`
+	SearchDomain string   `yaml:"searchdomain,omitempty"`
+}
+
+// Change is a difference between a spec and a VM config. Field is the VM
+// config key, disks that grow are resized instead of reconfigured.
+type Change struct {
+	Field  string
+	From   string
+	To     string
+	Resize bool   `json:",omitempty"`
+	Reason string `json:",omitempty"`
+}
+
+func (c Change) String() string {
+	s := fmt.Sprintf("%s: %q -> %q", c.Field, c.From, c.To)
+	if c.Reason != "" {
+		s += " (" + c.Reason + ")"
+	}
+	return s
+}
+
+// LoadSpecs reads and validates the VM specs of a YAML document
+func LoadSpecs(r io.Reader) ([]VMSpec, error) {
+	var specs VMSpecs
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&specs); err != nil {
+		return nil, fmt.Errorf("error decoding the VM specs: %w", err)
+	}
+	seen := map[string]bool{}
+	for i, spec := range specs.VMs {
+		if spec.Name == "" {
+			return nil, fmt.Errorf("vm %d has no name", i)
+		}
+		key := spec.Cluster + "/" + spec.Name
+		if seen[key] {
+			return nil, fmt.Errorf("vm %s is defined twice", spec.Name)
+		}
+		seen[key] = true
+		for _, disk := range spec.Disks {
+			if !isDiskKey(disk.Name) {
+				return nil, fmt.Errorf("vm %s: invalid disk name %q", spec.Name, disk.Name)
+			}
+			if _, err := parseSize(disk.Size); err != nil {
+				return nil, fmt.Errorf("vm %s: disk %s: %w", spec.Name, disk.Name, err)
+			}
+		}
+		for _, net := range spec.Network {
+			if !strings.HasPrefix(net.Name, "net") || net.Bridge == "" {
+				return nil, fmt.Errorf("vm %s: network needs a netN name and a bridge", spec.Name)
+			}
+		}
+	}
+	return specs.VMs, nil
+}
+
+// ReadSpecFile reads the VM specs of a YAML file
+func ReadSpecFile(path string) ([]VMSpec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadSpecs(f)
+}
+
+// diffVM compares a spec with a VM config. Changes can be applied to the VM,
+// destructive changes (shrinking or moving disks, removing devices) are only
+// reported.
+func diffVM(spec VMSpec, config map[string]any) (changes, destructive []Change) {
+	add := func(field, from, to string) {
+		if from != to {
+			changes = append(changes, Change{Field: field, From: from, To: to})
+		}
+	}
+
+	if spec.Cores > 0 {
+		add("cores", configString(config, "cores"), strconv.Itoa(spec.Cores))
+	}
+	if spec.Memory > 0 {
+		add("memory", configString(config, "memory"), strconv.FormatUint(spec.Memory, 10))
+	}
+	if spec.Tags != nil {
+		current := SplitTags(configString(config, "tags"))
+		sort.Strings(current)
+		wanted := slices.Clone(spec.Tags)
+		sort.Strings(wanted)
+		add("tags", strings.Join(current, ";"), strings.Join(wanted, ";"))
+	}
+
+	for _, disk := range spec.Disks {
+		c, d := diffDisk(disk, configString(config, disk.Name))
+		changes = append(changes, c...)
+		destructive = append(destructive, d...)
+	}
+	if spec.Disks != nil {
+		for _, key := range sortedKeys(config) {
+			value := configString(config, key)
+			if !isDiskKey(key) || strings.Contains(value, "media=cdrom") ||
+				strings.HasPrefix(key, "unused") || strings.HasPrefix(key, "efidisk") || strings.HasPrefix(key, "tpmstate") {
+				continue
+			}
+			if !slices.ContainsFunc(spec.Disks, func(d DiskSpec) bool { return d.Name == key }) {
+				destructive = append(destructive, Change{Field: key, From: value, Reason: "disk not in the spec"})
+			}
+		}
+	}
+
+	for _, net := range spec.Network {
+		from := configString(config, net.Name)
+		add(net.Name, from, networkConfig(net, from))
+	}
+	if spec.Network != nil {
+		for _, key := range sortedKeys(config) {
+			if _, err := strconv.Atoi(strings.TrimPrefix(key, "net")); !strings.HasPrefix(key, "net") || err != nil {
+				continue
+			}
+			if !slices.ContainsFunc(spec.Network, func(n NetworkSpec) bool { return n.Name == key }) {
+				destructive = append(destructive, Change{Field: key, From: configString(config, key), Reason: "interface not in the spec"})
+			}
+		}
+	}
+
+	if ci := spec.CloudInit; ci != nil {
+		if drive := cloudInitDrive(config); drive == "" && cloudInitStorage(spec) != "" {
+			changes = append(changes, Change{Field: freeIDE(config), To: cloudInitStorage(spec) + ":cloudinit"})
+		}
+		if ci.User != "" {
+			add("ciuser", configString(config, "ciuser"), ci.User)
+		}
+		if ci.SSHKeys != nil {
+			current, _ := url.QueryUnescape(configString(config, "sshkeys"))
+			wanted := strings.Join(ci.SSHKeys, "\n")
+			if strings.TrimSpace(current) != wanted {
+				changes = append(changes, Change{Field: "sshkeys", From: current, To: wanted})
+			}
+		}
+		if ci.IP != "" {
+			ipconfig := "ip=" + ci.IP
+			if ci.Gateway != "" {
+				ipconfig += ",gw=" + ci.Gateway
+			}
+			add("ipconfig0", configString(config, "ipconfig0"), ipconfig)
+		}
+		if ci.Nameserver != "" {
+			add("nameserver", configString(config, "nameserver"), ci.Nameserver)
+		}
+		if ci.SearchDomain != "" {
+			add("searchdomain", configString(config, "searchdomain"), ci.SearchDomain)
+		}
+	}
+	return changes, destructive
+}
+
+// diffDisk compares a disk spec with the disk config value, "local-lvm:vm-100-disk-0,size=32G"
+func diffDisk(disk DiskSpec, value string) (changes, destructive []Change) {
+	wanted, _ := parseSize(disk.Size)
+	if value == "" {
+		gib := uint64(math.Ceil(float64(wanted) / (1 << 30)))
+		return []Change{{Field: disk.Name, To: fmt.Sprintf("%s:%d", disk.Storage, gib)}}, nil
+	}
+
+	volume, options, _ := strings.Cut(value, ",")
+	storage, _, _ := strings.Cut(volume, ":")
+	size := ""
+	for _, opt := range strings.Split(options, ",") {
+		if v, ok := strings.CutPrefix(opt, "size="); ok {
+			size = v
+		}
+	}
+	if disk.Storage != "" && storage != disk.Storage {
+		destructive = append(destructive, Change{Field: disk.Name, From: storage, To: disk.Storage, Reason: "the disk has to be moved"})
+	}
+	current, err := parseSize(size)
+	switch {
+	case err != nil:
+	case wanted > current:
+		changes = append(changes, Change{Field: disk.Name, From: size, To: disk.Size, Resize: true})
+	case wanted < current:
+		destructive = append(destructive, Change{Field: disk.Name, From: size, To: disk.Size, Reason: "disks can't shrink"})
+	}
+	return changes, destructive
+}
+
+// networkConfig returns the netN value for a spec, keeping the MAC address
+// and the options of the current value
+func networkConfig(net NetworkSpec, current string) string {
+	model := net.Model
+	if model == "" {
+		model = "virtio"
+	}
+	mac := ""
+	options := []string{}
+	for _, opt := range strings.Split(current, ",") {
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "":
+		case "virtio", "e1000", "e1000e", "rtl8139", "vmxnet3":
+			mac = value
+		case "bridge", "tag":
+		default:
+			options = append(options, opt)
+		}
+	}
+	first := model
+	if mac != "" {
+		first += "=" + mac
+	}
+	value := []string{first, "bridge=" + net.Bridge}
+	if net.VLAN > 0 {
+		value = append(value, "tag="+strconv.Itoa(net.VLAN))
+	}
+	return strings.Join(append(value, options...), ",")
+}
+
+// cloudInitStorage returns the storage of the cloud-init drive of a spec,
+// the one of its first disk by default
+func cloudInitStorage(spec VMSpec) string {
+	if spec.CloudInit.Storage != "" || len(spec.Disks) == 0 {
+		return spec.CloudInit.Storage
+	}
+	return spec.Disks[0].Storage
+}
+
+// checkCloudInit returns an error when a VM needs a cloud-init drive and its
+// spec has no storage to create it on
+func checkCloudInit(spec VMSpec, config map[string]any) error {
+	if spec.CloudInit == nil || cloudInitDrive(config) != "" || cloudInitStorage(spec) != "" {
+		return nil
+	}
+	return fmt.Errorf("%s has no cloud-init drive, set cloudinit.storage or the storage of its first disk", spec.Name)
+}
+
+func cloudInitDrive(config map[string]any) string {
+	for key := range config {
+		if isDiskKey(key) && strings.Contains(configString(config, key), "cloudinit") {
+			return key
+		}
+	}
+	return ""
+}
+
+func freeIDE(config map[string]any) string {
+	for _, key := range []string{"ide2", "ide0", "ide1", "ide3"} {
+		if _, used := config[key]; !used {
+			return key
+		}
+	}
+	return "ide2"
+}
+
+// configString returns a config value as a string, numbers are returned
+// without decimals
+func configString(config map[string]any, key string) string {
+	switch v := config[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func sortedKeys(config map[string]any) []string {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseSize parses a Proxmox size (512M, 32G, 1T), plain numbers are bytes
+func parseSize(size string) (uint64, error) {
+	units := map[byte]uint64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30, 'T': 1 << 40}
+	size = strings.TrimSpace(strings.ToUpper(size))
+	if size == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+	multiplier := uint64(1)
+	if m, ok := units[size[len(size)-1]]; ok {
+		multiplier = m
+		size = size[:len(size)-1]
+	}
+	n, err := strconv.ParseFloat(size, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return uint64(n * float64(multiplier)), nil
+}
@@ -0,0 +1,119 @@
+package prxmx
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSpecs(t *testing.T) {
+	specs, err := LoadSpecs(strings.NewReader(`
+vms:
+  - name: web
+    template: debian-12
+    cores: 2
+    memory: 4096
+    disks:
+      - {name: scsi0, storage: local-lvm, size: 32G}
+    network:
+      - {name: net0, bridge: vmbr0, vlan: 20}
+    tags: [docker]
+    cloudinit:
+      user: ivan
+      ip: dhcp
+`))
+	require.NoError(t, err)
+	require.Len(t, specs, 1)
+	assert.Equal(t, "web", specs[0].Name)
+	assert.Equal(t, uint64(4096), specs[0].Memory)
+	assert.Equal(t, 20, specs[0].Network[0].VLAN)
+	assert.Equal(t, "ivan", specs[0].CloudInit.User)
+
+	tests := []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{"unknown field", "vms:\n  - name: web\n    cpus: 2\n", "field cpus not found"},
+		{"no name", "vms:\n  - cores: 2\n", "has no name"},
+		{"twice", "vms:\n  - name: web\n  - name: web\n", "defined twice"},
+		{"disk name", "vms:\n  - name: web\n    disks: [{name: disk0, size: 1G}]\n", "invalid disk name"},
+		{"disk size", "vms:\n  - name: web\n    disks: [{name: scsi0, size: big}]\n", "invalid size"},
+		{"bridge", "vms:\n  - name: web\n    network: [{name: net0}]\n", "bridge"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadSpecs(strings.NewReader(tt.yaml))
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestDiffVM(t *testing.T) {
+	config := map[string]any{
+		"cores":     float64(2),
+		"memory":    "2048",
+		"tags":      "prod;docker",
+		"scsi0":     "local-lvm:vm-100-disk-0,size=32G",
+		"scsi1":     "local-lvm:vm-100-disk-1,size=100G",
+		"efidisk0":  "local-lvm:vm-100-disk-2,size=4M",
+		"ide2":      "local-lvm:vm-100-cloudinit,media=cdrom",
+		"net0":      "virtio=BC:24:11:00:00:01,bridge=vmbr0,firewall=1",
+		"net1":      "virtio=BC:24:11:00:00:02,bridge=vmbr1",
+		"ciuser":    "ivan",
+		"ipconfig0": "ip=dhcp",
+	}
+	spec := VMSpec{
+		Cores:  2,
+		Memory: 4096,
+		Tags:   []string{"docker", "prod"},
+		Disks: []DiskSpec{
+			{Name: "scsi0", Storage: "local-lvm", Size: "64G"},
+			{Name: "scsi2", Storage: "ceph", Size: "1.5G"},
+		},
+		Network:   []NetworkSpec{{Name: "net0", Bridge: "vmbr0", VLAN: 20}},
+		CloudInit: &CloudInitSpec{User: "ivan", IP: "dhcp", SSHKeys: []string{"ssh-ed25519 AAAA ivan"}},
+	}
+
+	changes, destructive := diffVM(spec, config)
+	assert.Equal(t, []Change{
+		{Field: "memory", From: "2048", To: "4096"},
+		{Field: "scsi0", From: "32G", To: "64G", Resize: true},
+		{Field: "scsi2", To: "ceph:2"},
+		{Field: "net0", From: "virtio=BC:24:11:00:00:01,bridge=vmbr0,firewall=1", To: "virtio=BC:24:11:00:00:01,bridge=vmbr0,tag=20,firewall=1"},
+		{Field: "sshkeys", To: "ssh-ed25519 AAAA ivan"},
+	}, changes)
+	assert.Equal(t, []Change{
+		{Field: "scsi1", From: "local-lvm:vm-100-disk-1,size=100G", Reason: "disk not in the spec"},
+		{Field: "net1", From: "virtio=BC:24:11:00:00:02,bridge=vmbr1", Reason: "interface not in the spec"},
+	}, destructive)
+
+	spec.Disks = []DiskSpec{{Name: "scsi0", Storage: "ceph", Size: "16G"}, {Name: "scsi1", Storage: "local-lvm", Size: "100G"}}
+	_, destructive = diffVM(spec, config)
+	assert.Equal(t, []Change{
+		{Field: "scsi0", From: "local-lvm", To: "ceph", Reason: "the disk has to be moved"},
+		{Field: "scsi0", From: "32G", To: "16G", Reason: "disks can't shrink"},
+		{Field: "net1", From: "virtio=BC:24:11:00:00:02,bridge=vmbr1", Reason: "interface not in the spec"},
+	}, destructive)
+}
+
+func TestDiffVMCloudInitDrive(t *testing.T) {
+	config := map[string]any{"ide2": "none,media=cdrom", "scsi0": "ceph:vm-100-disk-0,size=8G"}
+	spec := VMSpec{Disks: []DiskSpec{{Name: "scsi0", Storage: "ceph", Size: "8G"}}, CloudInit: &CloudInitSpec{}}
+	changes, destructive := diffVM(spec, config)
+	assert.Equal(t, []Change{{Field: "ide0", To: "ceph:cloudinit"}}, changes)
+	assert.Empty(t, destructive)
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]uint64{"1024": 1024, "4K": 4 << 10, "512M": 512 << 20, "32G": 32 << 30, "1T": 1 << 40, "1.5g": 3 << 29}
+	for in, want := range tests {
+		got, err := parseSize(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := parseSize("")
+	assert.Error(t, err)
+}
@@ -0,0 +1,190 @@
+package prxmx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// StorageWarningThreshold is the used fraction above which a storage is
+// reported as nearly full
+const StorageWarningThreshold = 0.85
+
+// Storage is a storage as seen by a Proxmox node. Shared storages are
+// listed once per node.
+type Storage struct {
+	Cluster   string
+	Node      string
+	Name      string
+	Type      string
+	Content   []string
+	Shared    bool
+	Active    bool
+	Enabled   bool
+	Total     uint64
+	Used      uint64
+	Avail     uint64
+	ISOs      []Volume
+	Templates []Volume
+	Backups   []Volume
+	Error     string `json:",omitempty"`
+}
+
+// Volume is a file stored in a Proxmox storage
+type Volume struct {
+	VolID   string
+	Name    string
+	Format  string
+	Size    uint64
+	VMID    uint64 `json:",omitempty"`
+	Created time.Time
+}
+
+type storageStatus struct {
+	Storage string `json:"storage"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	Shared  int    `json:"shared"`
+	Active  int    `json:"active"`
+	Enabled int    `json:"enabled"`
+	Total   uint64 `json:"total"`
+	Used    uint64 `json:"used"`
+	Avail   uint64 `json:"avail"`
+}
+
+type storageContent struct {
+	VolID   string `json:"volid"`
+	Content string `json:"content"`
+	Format  string `json:"format"`
+	Size    uint64 `json:"size"`
+	VMID    uint64 `json:"vmid"`
+	Ctime   int64  `json:"ctime"`
+}
+
+// Key returns the NATS key of the storage: <cluster>.<node>.<storage>
+func (s *Storage) Key() string {
+	return fmt.Sprintf("%s.%s.%s", s.Cluster, s.Node, s.Name)
+}
+
+// UsedFraction returns how full the storage is, from 0 to 1
+func (s *Storage) UsedFraction() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return float64(s.Used) / float64(s.Total)
+}
+
+// NearlyFull reports whether the storage is above StorageWarningThreshold
+func (s *Storage) NearlyFull() bool {
+	return s.UsedFraction() >= StorageWarningThreshold
+}
+
+// GetStorage returns every storage of every node with the ISOs, container
+// templates and backups they contain. Like GetVMs, nodes and storages are
+// inspected concurrently and partial results are returned with the error.
+func (c *Cluster) GetStorage() ([]Storage, error) {
+	ctx := context.Background()
+
+	cctx, cancel := c.callContext(ctx)
+	nodes, err := c.Client.Nodes(cctx)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	perNode := make([][]Storage, len(nodes))
+	errs := make([]error, len(nodes))
+	runPool(len(nodes), c.Workers, func(i int) {
+		perNode[i], errs[i] = c.nodeStorage(ctx, nodes[i].Node)
+	})
+	storages := []Storage{}
+	for _, s := range perNode {
+		storages = append(storages, s...)
+	}
+
+	runPool(len(storages), c.Workers, func(i int) {
+		if err := c.storageContent(ctx, &storages[i]); err != nil {
+			storages[i].Error = err.Error()
+		}
+	})
+	for _, s := range storages {
+		if s.Error != "" {
+			errs = append(errs, fmt.Errorf("%s: %s", s.Key(), s.Error))
+		}
+	}
+	return storages, errors.Join(errs...)
+}
+
+func (c *Cluster) nodeStorage(ctx context.Context, node string) ([]Storage, error) {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+
+	var statuses []storageStatus
+	if err := c.Client.Get(cctx, fmt.Sprintf("/nodes/%s/storage", node), &statuses); err != nil {
+		return nil, fmt.Errorf("node %s: %w", node, err)
+	}
+	storages := make([]Storage, 0, len(statuses))
+	for _, st := range statuses {
+		storages = append(storages, Storage{
+			Cluster: c.Name,
+			Node:    node,
+			Name:    st.Storage,
+			Type:    st.Type,
+			Content: strings.FieldsFunc(st.Content, func(r rune) bool { return r == ',' }),
+			Shared:  st.Shared == 1,
+			Active:  st.Active == 1,
+			Enabled: st.Enabled == 1,
+			Total:   st.Total,
+			Used:    st.Used,
+			Avail:   st.Avail,
+		})
+	}
+	return storages, nil
+}
+
+func (c *Cluster) storageContent(ctx context.Context, storage *Storage) error {
+	if !storage.Active {
+		return nil
+	}
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+
+	var contents []storageContent
+	err := c.Client.Get(cctx, fmt.Sprintf("/nodes/%s/storage/%s/content", storage.Node, storage.Name), &contents)
+	if err != nil {
+		return err
+	}
+	for _, content := range contents {
+		volume := Volume{
+			VolID:  content.VolID,
+			Name:   volumeName(content.VolID),
+			Format: content.Format,
+			Size:   content.Size,
+			VMID:   content.VMID,
+		}
+		if content.Ctime > 0 {
+			volume.Created = time.Unix(content.Ctime, 0)
+		}
+		switch content.Content {
+		case "iso":
+			storage.ISOs = append(storage.ISOs, volume)
+		case "vztmpl":
+			storage.Templates = append(storage.Templates, volume)
+		case "backup":
+			storage.Backups = append(storage.Backups, volume)
+		}
+	}
+	return nil
+}
+
+// volumeName returns the file name of a volume id: local:iso/debian-12.iso is debian-12.iso
+func volumeName(volID string) string {
+	_, name, found := strings.Cut(volID, ":")
+	if !found {
+		return volID
+	}
+	return path.Base(name)
+}
@@ -0,0 +1,58 @@
+package prxmx
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCluster_GetStorage(t *testing.T) {
+	cluster := newFakeProxmox(t, map[string]http.HandlerFunc{
+		"/nodes": data([]map[string]any{{"node": "pve1", "status": "online"}}),
+		"/nodes/pve1/storage": data([]map[string]any{
+			{"storage": "local", "type": "dir", "content": "iso,vztmpl,backup", "active": 1, "enabled": 1, "total": 100, "used": 40, "avail": 60},
+			{"storage": "local-lvm", "type": "lvmthin", "content": "images,rootdir", "active": 1, "enabled": 1, "total": 100, "used": 90, "avail": 10},
+			{"storage": "nas", "type": "nfs", "content": "backup", "shared": 1, "active": 0, "enabled": 1},
+		}),
+		"/nodes/pve1/storage/local/content": data([]map[string]any{
+			{"volid": "local:iso/debian-12.iso", "content": "iso", "format": "iso", "size": 10, "ctime": 1700000000},
+			{"volid": "local:vztmpl/ubuntu-24.04.tar.zst", "content": "vztmpl", "format": "tzst", "size": 5},
+			{"volid": "local:backup/vzdump-qemu-100.vma.zst", "content": "backup", "format": "vma.zst", "size": 20, "vmid": 100},
+		}),
+		"/nodes/pve1/storage/local-lvm/content": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "storage is locked", http.StatusInternalServerError)
+		},
+	}, WithName("lab"))
+
+	storages, err := cluster.GetStorage()
+	require.Error(t, err)
+	require.Len(t, storages, 3)
+
+	local := storages[0]
+	assert.Equal(t, "lab.pve1.local", local.Key())
+	assert.Equal(t, []string{"iso", "vztmpl", "backup"}, local.Content)
+	assert.False(t, local.NearlyFull())
+	require.Len(t, local.ISOs, 1)
+	assert.Equal(t, "debian-12.iso", local.ISOs[0].Name)
+	assert.Equal(t, int64(1700000000), local.ISOs[0].Created.Unix())
+	require.Len(t, local.Templates, 1)
+	require.Len(t, local.Backups, 1)
+	assert.Equal(t, uint64(100), local.Backups[0].VMID)
+
+	lvm := storages[1]
+	assert.True(t, lvm.NearlyFull())
+	assert.NotEmpty(t, lvm.Error)
+
+	nas := storages[2]
+	assert.True(t, nas.Shared)
+	assert.Empty(t, nas.Error, "inactive storages are not inspected")
+	assert.Zero(t, nas.UsedFraction())
+}
+
+func TestVolumeName(t *testing.T) {
+	assert.Equal(t, "debian-12.iso", volumeName("local:iso/debian-12.iso"))
+	assert.Equal(t, "vm-100-disk-0", volumeName("local-lvm:vm-100-disk-0"))
+	assert.Equal(t, "plain", volumeName("plain"))
+}
@@ -0,0 +1,180 @@
+package prxmx
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+// TaskPollInterval is how often a watched task is polled for status and logs
+var TaskPollInterval = 2 * time.Second
+
+// TaskInfo is the summary of a Proxmox task. Every mutating Proxmox call
+// returns the UPID of the task doing the work.
+type TaskInfo struct {
+	Cluster    string
+	UPID       string
+	Node       string
+	Type       string
+	ID         string
+	User       string
+	Status     string
+	ExitStatus string
+	Running    bool
+	StartTime  time.Time
+	EndTime    time.Time
+}
+
+// ErrTaskFailed is returned when a task finishes with an exit status other than OK
+var ErrTaskFailed = errors.New("task failed")
+
+func newTaskInfo(task *proxmox.Task) TaskInfo {
+	info := TaskInfo{
+		UPID:       string(task.UPID),
+		Node:       task.Node,
+		Type:       task.Type,
+		ID:         task.ID,
+		User:       task.User,
+		Status:     task.Status,
+		ExitStatus: task.ExitStatus,
+		Running:    task.EndTime.IsZero() && task.Status != "stopped",
+		StartTime:  task.StartTime,
+		EndTime:    task.EndTime,
+	}
+	// the node, type, id and user are part of the UPID
+	if parsed := proxmox.NewTask(task.UPID, nil); parsed != nil {
+		info.Node = cmp.Or(info.Node, parsed.Node)
+		info.Type = cmp.Or(info.Type, parsed.Type)
+		info.ID = cmp.Or(info.ID, parsed.ID)
+		info.User = cmp.Or(info.User, parsed.User)
+	}
+	// finished tasks in the cluster task list carry the exit status in Status
+	if !info.Running && info.ExitStatus == "" {
+		info.ExitStatus = task.Status
+	}
+	return info
+}
+
+// taskInfo returns the summary of a task of the cluster
+func (c *Cluster) taskInfo(task *proxmox.Task) TaskInfo {
+	info := newTaskInfo(task)
+	info.Cluster = c.Name
+	return info
+}
+
+// Tasks returns the recent tasks of the cluster, newest first
+func (c *Cluster) Tasks(ctx context.Context) ([]TaskInfo, error) {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+
+	var tasks proxmox.Tasks
+	if err := c.Client.Get(cctx, "/cluster/tasks", &tasks); err != nil {
+		return nil, err
+	}
+	infos := make([]TaskInfo, 0, len(tasks))
+	for _, task := range tasks {
+		infos = append(infos, c.taskInfo(task))
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].StartTime.After(infos[j].StartTime)
+	})
+	return infos, nil
+}
+
+// Task returns the current status of a task
+func (c *Cluster) Task(ctx context.Context, upid string) (TaskInfo, error) {
+	task := proxmox.NewTask(proxmox.UPID(upid), c.Client)
+	if task == nil {
+		return TaskInfo{}, fmt.Errorf("invalid task id %q", upid)
+	}
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	if err := task.Ping(cctx); err != nil {
+		return TaskInfo{}, err
+	}
+	return c.taskInfo(task), nil
+}
+
+// WatchTask follows a task until it stops, sending every new log line to
+// lines. It returns the final status of the task and an error wrapping
+// ErrTaskFailed when the task didn't finish OK. lines is not closed.
+func (c *Cluster) WatchTask(ctx context.Context, upid string, lines chan<- string) (TaskInfo, error) {
+	task := proxmox.NewTask(proxmox.UPID(upid), c.Client)
+	if task == nil {
+		return TaskInfo{}, fmt.Errorf("invalid task id %q", upid)
+	}
+
+	start := 0
+	for {
+		// ping before reading the log so the last lines are read once the task stops
+		cctx, cancel := c.callContext(ctx)
+		err := task.Ping(cctx)
+		cancel()
+		if err == nil {
+			start, err = c.sendTaskLog(ctx, task, start, lines)
+		}
+		if err != nil {
+			return c.taskInfo(task), err
+		}
+
+		if task.IsCompleted {
+			info := c.taskInfo(task)
+			if !task.IsSuccessful {
+				return info, fmt.Errorf("%w: %s %s", ErrTaskFailed, upid, task.ExitStatus)
+			}
+			return info, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return c.taskInfo(task), ctx.Err()
+		case <-time.After(TaskPollInterval):
+		}
+	}
+}
+
+// sendTaskLog sends the log lines of the task from start and returns the
+// index of the next line to read
+func (c *Cluster) sendTaskLog(ctx context.Context, task *proxmox.Task, start int, lines chan<- string) (int, error) {
+	const limit = 500
+	for {
+		cctx, cancel := c.callContext(ctx)
+		log, err := task.Log(cctx, start, limit)
+		cancel()
+		if err != nil {
+			return start, err
+		}
+		read := 0
+		for ; ; start++ {
+			line, ok := log[start]
+			if !ok {
+				break
+			}
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return start, ctx.Err()
+			}
+			read++
+		}
+		if read < limit {
+			return start, nil
+		}
+	}
+}
+
+// WaitTask waits for a task to finish, log lines are discarded
+func (c *Cluster) WaitTask(ctx context.Context, upid string) (TaskInfo, error) {
+	lines := make(chan string)
+	go func() {
+		for range lines {
+		}
+	}()
+	defer close(lines)
+	return c.WatchTask(ctx, upid, lines)
+}
@@ -0,0 +1,94 @@
+package prxmx
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testUPID = "UPID:pve1:0000A1B2:0012C3D4:66E00000:qmigrate:100:root@pam:"
+
+// fakeTask serves a task that runs for two polls, logging a line on each
+func fakeTask(exitStatus string) map[string]http.HandlerFunc {
+	var polls int32
+	logs := []string{"starting", "copying disk", "done"}
+	return map[string]http.HandlerFunc{
+		"/nodes/pve1/tasks/" + testUPID + "/status": func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&polls, 1) < 3 {
+				data(map[string]any{"upid": testUPID, "node": "pve1", "status": "running"})(w, r)
+				return
+			}
+			data(map[string]any{"upid": testUPID, "node": "pve1", "status": "stopped", "exitstatus": exitStatus})(w, r)
+		},
+		"/nodes/pve1/tasks/" + testUPID + "/log": func(w http.ResponseWriter, r *http.Request) {
+			visible := min(int(atomic.LoadInt32(&polls)), len(logs))
+			rows := []map[string]any{}
+			for i := 0; i < visible; i++ {
+				rows = append(rows, map[string]any{"n": i + 1, "t": logs[i]})
+			}
+			data(rows)(w, r)
+		},
+	}
+}
+
+func TestCluster_WatchTask(t *testing.T) {
+	TaskPollInterval = 10 * time.Millisecond
+
+	cluster := newFakeProxmox(t, fakeTask("OK"))
+	lines := make(chan string, 10)
+	info, err := cluster.WatchTask(context.Background(), testUPID, lines)
+	require.NoError(t, err)
+	close(lines)
+
+	got := []string{}
+	for line := range lines {
+		got = append(got, line)
+	}
+	assert.Equal(t, []string{"starting", "copying disk", "done"}, got)
+	assert.Equal(t, "qmigrate", info.Type)
+	assert.Equal(t, "OK", info.ExitStatus)
+	assert.False(t, info.Running)
+}
+
+func TestCluster_WaitTaskFailed(t *testing.T) {
+	TaskPollInterval = 10 * time.Millisecond
+
+	cluster := newFakeProxmox(t, fakeTask("migration aborted"))
+	info, err := cluster.WaitTask(context.Background(), testUPID)
+	require.ErrorIs(t, err, ErrTaskFailed)
+	assert.Equal(t, "migration aborted", info.ExitStatus)
+}
+
+func TestStreamTaskLog(t *testing.T) {
+	TaskPollInterval = 10 * time.Millisecond
+	gin.SetMode(gin.TestMode)
+
+	cluster := newFakeProxmox(t, fakeTask("OK"))
+	router := gin.New()
+	router.GET("/proxmox/tasks/:upid/log", Clusters{cluster}.handlerStreamTaskLog)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/proxmox/tasks/" + testUPID + "/log")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	events := []string{}
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		if event, ok := strings.CutPrefix(scanner.Text(), "event:"); ok {
+			events = append(events, event)
+		}
+	}
+	assert.Equal(t, []string{"log", "log", "log", "status"}, events)
+}
@@ -0,0 +1,347 @@
+package prxmx
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+// User is a Proxmox user, <name>@<realm>, with its API tokens
+type User struct {
+	UserID    string     `json:"userid"`
+	Enable    bool       `json:"enable"`
+	Expire    int64      `json:"expire,omitempty"`
+	Firstname string     `json:"firstname,omitempty"`
+	Lastname  string     `json:"lastname,omitempty"`
+	Email     string     `json:"email,omitempty"`
+	Comment   string     `json:"comment,omitempty"`
+	Groups    []string   `json:"groups,omitempty"`
+	Tokens    []APIToken `json:"tokens,omitempty"`
+}
+
+// APIToken is an API token of a user. With privilege separation the token
+// only has the permissions of its own ACLs, without it has the permissions
+// of the user.
+type APIToken struct {
+	TokenID string `json:"tokenid"`
+	Comment string `json:"comment,omitempty"`
+	Expire  int64  `json:"expire,omitempty"`
+	Privsep bool   `json:"privsep"`
+}
+
+// ACL grants a role on a path, / is everything, /vms/100 a guest and
+// /pool/<name> a pool
+type ACL struct {
+	Path      string `json:"path" yaml:"path"`
+	Role      string `json:"role" yaml:"role"`
+	Propagate bool   `json:"propagate" yaml:"propagate"`
+}
+
+// UserSpec is a user to create. The password is only needed for the pve
+// realm, the pam realm needs an existing system user.
+type UserSpec struct {
+	UserID   string   `json:"userid"`
+	Password string   `json:"password,omitempty"`
+	Email    string   `json:"email,omitempty"`
+	Comment  string   `json:"comment,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// TokenSpec is an API token to issue, Expire is a Unix time and 0 never
+// expires
+type TokenSpec struct {
+	ID      string `json:"id"`
+	Comment string `json:"comment,omitempty"`
+	Expire  int64  `json:"expire,omitempty"`
+	Privsep bool   `json:"privsep"`
+	ACLs    []ACL  `json:"acls,omitempty"`
+}
+
+// TokenSecret is an issued token. The secret is only returned once, the
+// Proxmox API takes it as <FullTokenID>=<Value>.
+type TokenSecret struct {
+	FullTokenID string `json:"full-tokenid"`
+	Value       string `json:"value"`
+}
+
+type userStatus struct {
+	UserID    string            `json:"userid"`
+	Enable    proxmox.IntOrBool `json:"enable"`
+	Expire    int64             `json:"expire"`
+	Firstname string            `json:"firstname"`
+	Lastname  string            `json:"lastname"`
+	Email     string            `json:"email"`
+	Comment   string            `json:"comment"`
+	Groups    any               `json:"groups"`
+	Tokens    []tokenStatus     `json:"tokens"`
+}
+
+type tokenStatus struct {
+	TokenID string            `json:"tokenid"`
+	Comment string            `json:"comment"`
+	Expire  int64             `json:"expire"`
+	Privsep proxmox.IntOrBool `json:"privsep"`
+}
+
+type aclEntry struct {
+	Path      string            `json:"path"`
+	RoleID    string            `json:"roleid"`
+	Type      string            `json:"type"`
+	UGID      string            `json:"ugid"`
+	Propagate proxmox.IntOrBool `json:"propagate"`
+}
+
+var tokenIDPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9._-]+$`)
+
+func (t tokenStatus) token() APIToken {
+	return APIToken{TokenID: t.TokenID, Comment: t.Comment, Expire: t.Expire, Privsep: bool(t.Privsep)}
+}
+
+// Validate checks the user id is <name>@<realm>
+func (s UserSpec) Validate() error {
+	name, realm, found := strings.Cut(s.UserID, "@")
+	if !found || name == "" || realm == "" {
+		return fmt.Errorf("invalid user %q, use <name>@<realm>, ops@pve", s.UserID)
+	}
+	return nil
+}
+
+// Validate checks the token id and the ACLs
+func (s TokenSpec) Validate() error {
+	if !tokenIDPattern.MatchString(s.ID) {
+		return fmt.Errorf("invalid token id %q, use letters, digits, '.', '-' and '_'", s.ID)
+	}
+	for _, acl := range s.ACLs {
+		if !strings.HasPrefix(acl.Path, "/") || acl.Role == "" {
+			return fmt.Errorf("invalid ACL %s %q, ACLs need an absolute path and a role", acl.Path, acl.Role)
+		}
+	}
+	if len(s.ACLs) > 0 && !s.Privsep {
+		return fmt.Errorf("tokens without privilege separation have the permissions of the user, ACLs need privsep")
+	}
+	return nil
+}
+
+// Users returns the users of the cluster with their tokens
+func (c *Cluster) Users(ctx context.Context) ([]User, error) {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	var statuses []userStatus
+	if err := c.Client.Get(cctx, "/access/users?full=1", &statuses); err != nil {
+		return nil, fmt.Errorf("error reading the users: %w", err)
+	}
+	users := make([]User, 0, len(statuses))
+	for _, st := range statuses {
+		user := User{
+			UserID:    st.UserID,
+			Enable:    bool(st.Enable),
+			Expire:    st.Expire,
+			Firstname: st.Firstname,
+			Lastname:  st.Lastname,
+			Email:     st.Email,
+			Comment:   st.Comment,
+			Groups:    listValue(st.Groups),
+			Tokens:    []APIToken{},
+		}
+		for _, t := range st.Tokens {
+			user.Tokens = append(user.Tokens, t.token())
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// CreateUser creates an enabled user
+func (c *Cluster) CreateUser(ctx context.Context, spec UserSpec) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	params := map[string]any{"userid": spec.UserID, "enable": 1}
+	for key, value := range map[string]string{
+		"password": spec.Password,
+		"email":    spec.Email,
+		"comment":  spec.Comment,
+		"groups":   strings.Join(spec.Groups, ","),
+	} {
+		if value != "" {
+			params[key] = value
+		}
+	}
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	if err := c.Client.Post(cctx, "/access/users", params, nil); err != nil {
+		return fmt.Errorf("error creating the user %s: %w", spec.UserID, err)
+	}
+	return nil
+}
+
+// DeleteUser deletes a user and its tokens
+func (c *Cluster) DeleteUser(ctx context.Context, userID string) error {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	if err := c.Client.Delete(cctx, "/access/users/"+url.PathEscape(userID), nil); err != nil {
+		return fmt.Errorf("error deleting the user %s: %w", userID, err)
+	}
+	return nil
+}
+
+// Tokens returns the API tokens of a user
+func (c *Cluster) Tokens(ctx context.Context, userID string) ([]APIToken, error) {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	var statuses []tokenStatus
+	if err := c.Client.Get(cctx, fmt.Sprintf("/access/users/%s/token", url.PathEscape(userID)), &statuses); err != nil {
+		return nil, fmt.Errorf("error reading the tokens of %s: %w", userID, err)
+	}
+	tokens := make([]APIToken, 0, len(statuses))
+	for _, st := range statuses {
+		tokens = append(tokens, st.token())
+	}
+	return tokens, nil
+}
+
+// TokenACLs returns the ACLs granted to a token, <user>!<token>
+func (c *Cluster) TokenACLs(ctx context.Context, fullTokenID string) ([]ACL, error) {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	var entries []aclEntry
+	if err := c.Client.Get(cctx, "/access/acl", &entries); err != nil {
+		return nil, fmt.Errorf("error reading the ACLs: %w", err)
+	}
+	acls := []ACL{}
+	for _, e := range entries {
+		if e.Type == "token" && e.UGID == fullTokenID {
+			acls = append(acls, ACL{Path: e.Path, Role: e.RoleID, Propagate: bool(e.Propagate)})
+		}
+	}
+	return acls, nil
+}
+
+// CreateToken issues an API token and grants its ACLs. A token whose ACLs
+// can't all be granted is revoked, so a failure never leaves a token behind.
+func (c *Cluster) CreateToken(ctx context.Context, userID string, spec TokenSpec) (TokenSecret, error) {
+	if err := spec.Validate(); err != nil {
+		return TokenSecret{}, err
+	}
+	params := map[string]any{"privsep": 0}
+	if spec.Privsep {
+		params["privsep"] = 1
+	}
+	if spec.Comment != "" {
+		params["comment"] = spec.Comment
+	}
+	if spec.Expire > 0 {
+		params["expire"] = spec.Expire
+	}
+
+	cctx, cancel := c.callContext(ctx)
+	var secret TokenSecret
+	err := c.Client.Post(cctx, tokenPath(userID, spec.ID), params, &secret)
+	cancel()
+	if err != nil {
+		return TokenSecret{}, fmt.Errorf("error creating the token %s of %s: %w", spec.ID, userID, err)
+	}
+
+	for _, acl := range spec.ACLs {
+		if err := c.grantToken(ctx, secret.FullTokenID, acl); err != nil {
+			if revokeErr := c.RevokeToken(ctx, userID, spec.ID); revokeErr != nil {
+				err = fmt.Errorf("%w, %w", err, revokeErr)
+			}
+			return TokenSecret{}, err
+		}
+	}
+	return secret, nil
+}
+
+func (c *Cluster) grantToken(ctx context.Context, fullTokenID string, acl ACL) error {
+	params := map[string]any{"path": acl.Path, "roles": acl.Role, "tokens": fullTokenID, "propagate": 0}
+	if acl.Propagate {
+		params["propagate"] = 1
+	}
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	if err := c.Client.Put(cctx, "/access/acl", params, nil); err != nil {
+		return fmt.Errorf("error granting %s on %s to %s: %w", acl.Role, acl.Path, fullTokenID, err)
+	}
+	return nil
+}
+
+// RotateToken replaces the secret of a token. Proxmox can't regenerate a
+// secret, the token is revoked and issued again with the same comment,
+// expiry, privilege separation and ACLs. A temporary token is issued first
+// with the same settings, so a token that can't be issued again, or whose
+// ACLs can't be granted, is left untouched. The old secret stops working
+// immediately.
+func (c *Cluster) RotateToken(ctx context.Context, userID, tokenID string) (TokenSecret, error) {
+	tokens, err := c.Tokens(ctx, userID)
+	if err != nil {
+		return TokenSecret{}, err
+	}
+	i := slices.IndexFunc(tokens, func(t APIToken) bool { return t.TokenID == tokenID })
+	if i < 0 {
+		return TokenSecret{}, fmt.Errorf("token %s of %s not found", tokenID, userID)
+	}
+	token := tokens[i]
+	spec := TokenSpec{ID: token.TokenID, Comment: token.Comment, Expire: token.Expire, Privsep: token.Privsep}
+	if spec.Privsep {
+		if spec.ACLs, err = c.TokenACLs(ctx, userID+"!"+tokenID); err != nil {
+			return TokenSecret{}, err
+		}
+	}
+
+	temporary := spec
+	temporary.ID = tokenID + rotateSuffix
+	if _, err := c.CreateToken(ctx, userID, temporary); err != nil {
+		return TokenSecret{}, fmt.Errorf("%s wasn't rotated: %w", tokenID, err)
+	}
+	if err := c.RevokeToken(ctx, userID, temporary.ID); err != nil {
+		return TokenSecret{}, fmt.Errorf("%s wasn't rotated: %w", tokenID, err)
+	}
+
+	if err := c.RevokeToken(ctx, userID, tokenID); err != nil {
+		return TokenSecret{}, err
+	}
+	secret, err := c.CreateToken(ctx, userID, spec)
+	if err != nil {
+		return TokenSecret{}, fmt.Errorf("the token %s of %s was revoked and couldn't be issued again, it no longer exists: %w", tokenID, userID, err)
+	}
+	return secret, nil
+}
+
+// rotateSuffix names the temporary token issued by RotateToken
+const rotateSuffix = "-rotate"
+
+// RevokeToken deletes a token, Proxmox drops its ACLs
+func (c *Cluster) RevokeToken(ctx context.Context, userID, tokenID string) error {
+	cctx, cancel := c.callContext(ctx)
+	defer cancel()
+	if err := c.Client.Delete(cctx, tokenPath(userID, tokenID), nil); err != nil {
+		return fmt.Errorf("error revoking the token %s of %s: %w", tokenID, userID, err)
+	}
+	return nil
+}
+
+func tokenPath(userID, tokenID string) string {
+	return fmt.Sprintf("/access/users/%s/token/%s", url.PathEscape(userID), url.PathEscape(tokenID))
+}
+
+// listValue reads a list Proxmox returns either as an array or as a comma
+// separated string
+func listValue(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.FieldsFunc(v, func(r rune) bool { return r == ',' })
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			list = append(list, fmt.Sprint(item))
+		}
+		return list
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package prxmx
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCluster_Users(t *testing.T) {
+	routes := map[string]http.HandlerFunc{
+		"/access/users": data([]map[string]any{
+			{"userid": "root@pam", "enable": 1, "groups": "admins,ops", "tokens": []map[string]any{
+				{"tokenid": "i2", "privsep": 0, "comment": "i2"},
+			}},
+			{"userid": "ops@pve", "enable": 0, "email": "ops@example.com"},
+		}),
+	}
+	cluster := newFakeProxmox(t, routes)
+
+	users, err := cluster.Users(context.Background())
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, User{
+		UserID: "root@pam", Enable: true, Groups: []string{"admins", "ops"},
+		Tokens: []APIToken{{TokenID: "i2", Comment: "i2"}},
+	}, users[0])
+	assert.False(t, users[1].Enable)
+	assert.Equal(t, "ops@example.com", users[1].Email)
+	assert.Empty(t, users[1].Tokens)
+}
+
+func TestTokenSpec_Validate(t *testing.T) {
+	assert.NoError(t, TokenSpec{ID: "ci-runner", Privsep: true, ACLs: []ACL{{Path: "/vms", Role: "PVEVMUser"}}}.Validate())
+	assert.Error(t, TokenSpec{ID: "1bad"}.Validate())
+	assert.Error(t, TokenSpec{ID: "ci", Privsep: true, ACLs: []ACL{{Path: "vms", Role: "PVEVMUser"}}}.Validate())
+	assert.Error(t, TokenSpec{ID: "ci", ACLs: []ACL{{Path: "/", Role: "PVEAuditor"}}}.Validate())
+	assert.Error(t, UserSpec{UserID: "ops"}.Validate())
+	assert.NoError(t, UserSpec{UserID: "ops@pve"}.Validate())
+}
+
+// fakeAccess answers the token and ACL calls of ops@pve and records them
+func fakeAccess(t *testing.T, aclStatus int) (map[string]http.HandlerFunc, func() []string) {
+	var mu sync.Mutex
+	calls := []string{}
+	record := func(w http.ResponseWriter, r *http.Request) map[string]any {
+		params := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != io.EOF {
+			assert.NoError(t, err)
+		}
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		return params
+	}
+	routes := map[string]http.HandlerFunc{
+		"/access/users/ops@pve/token": data([]map[string]any{
+			{"tokenid": "ci", "comment": "runner", "expire": 1893456000, "privsep": 1},
+		}),
+	}
+	for _, id := range []string{"ci", "ci-rotate"} {
+		routes["/access/users/ops@pve/token/"+id] = func(w http.ResponseWriter, r *http.Request) {
+			params := record(w, r)
+			if r.Method == http.MethodPost {
+				assert.Equal(t, map[string]any{"privsep": float64(1), "comment": "runner", "expire": float64(1893456000)}, params)
+				data(map[string]any{"full-tokenid": "ops@pve!" + id, "value": "new-secret"})(w, r)
+				return
+			}
+			data(nil)(w, r)
+		}
+	}
+	routes["/access/acl"] = func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			data([]map[string]any{
+				{"path": "/vms", "roleid": "PVEVMUser", "type": "token", "ugid": "ops@pve!ci", "propagate": 1},
+				{"path": "/", "roleid": "Administrator", "type": "user", "ugid": "ops@pve", "propagate": 1},
+			})(w, r)
+			return
+		}
+		params := record(w, r)
+		assert.Equal(t, "/vms", params["path"])
+		assert.Equal(t, "PVEVMUser", params["roles"])
+		assert.Equal(t, float64(1), params["propagate"])
+		assert.Contains(t, []any{"ops@pve!ci", "ops@pve!ci-rotate"}, params["tokens"])
+		w.WriteHeader(aclStatus)
+		data(nil)(w, r)
+	}
+	return routes, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+}
+
+func TestCluster_CreateToken(t *testing.T) {
+	routes, calls := fakeAccess(t, http.StatusOK)
+	cluster := newFakeProxmox(t, routes)
+	spec := TokenSpec{ID: "ci", Comment: "runner", Expire: 1893456000, Privsep: true,
+		ACLs: []ACL{{Path: "/vms", Role: "PVEVMUser", Propagate: true}}}
+
+	secret, err := cluster.CreateToken(context.Background(), "ops@pve", spec)
+	require.NoError(t, err)
+	assert.Equal(t, TokenSecret{FullTokenID: "ops@pve!ci", Value: "new-secret"}, secret)
+	assert.Equal(t, []string{
+		"POST /api2/json/access/users/ops@pve/token/ci",
+		"PUT /api2/json/access/acl",
+	}, calls())
+}
+
+func TestCluster_CreateTokenRevokesOnACLFailure(t *testing.T) {
+	routes, calls := fakeAccess(t, http.StatusForbidden)
+	cluster := newFakeProxmox(t, routes)
+	spec := TokenSpec{ID: "ci", Comment: "runner", Expire: 1893456000, Privsep: true,
+		ACLs: []ACL{{Path: "/vms", Role: "PVEVMUser", Propagate: true}}}
+
+	_, err := cluster.CreateToken(context.Background(), "ops@pve", spec)
+	require.Error(t, err)
+	assert.Equal(t, []string{
+		"POST /api2/json/access/users/ops@pve/token/ci",
+		"PUT /api2/json/access/acl",
+		"DELETE /api2/json/access/users/ops@pve/token/ci",
+	}, calls())
+}
+
+func TestCluster_RotateToken(t *testing.T) {
+	routes, calls := fakeAccess(t, http.StatusOK)
+	cluster := newFakeProxmox(t, routes)
+
+	secret, err := cluster.RotateToken(context.Background(), "ops@pve", "ci")
+	require.NoError(t, err)
+	assert.Equal(t, "new-secret", secret.Value)
+	assert.Equal(t, []string{
+		"POST /api2/json/access/users/ops@pve/token/ci-rotate",
+		"PUT /api2/json/access/acl",
+		"DELETE /api2/json/access/users/ops@pve/token/ci-rotate",
+		"DELETE /api2/json/access/users/ops@pve/token/ci",
+		"POST /api2/json/access/users/ops@pve/token/ci",
+		"PUT /api2/json/access/acl",
+	}, calls())
+
+	_, err = cluster.RotateToken(context.Background(), "ops@pve", "missing")
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestCluster_RotateTokenKeepsTokenOnFailure(t *testing.T) {
+	routes, calls := fakeAccess(t, http.StatusForbidden)
+	cluster := newFakeProxmox(t, routes)
+
+	_, err := cluster.RotateToken(context.Background(), "ops@pve", "ci")
+	assert.ErrorContains(t, err, "ci wasn't rotated")
+	assert.Equal(t, []string{
+		"POST /api2/json/access/users/ops@pve/token/ci-rotate",
+		"PUT /api2/json/access/acl",
+		"DELETE /api2/json/access/users/ops@pve/token/ci-rotate",
+	}, calls())
+}
@@ -0,0 +1,25 @@
+package remote
+
+import (
+	"fmt"
+	"strings"
+
+	"i2/pkg/dfiles"
+)
+
+// UploadFiles copies files to a directory of a host, created when missing,
+// and returns its absolute path. A relative dir is in the home directory of
+// user, it must not need quoting.
+func UploadFiles(user, host, privateKeyPath, dir string, files []string) (string, error) {
+	out, err := ExecuteSSHCommand(user, host, fmt.Sprintf("mkdir -p %s && cd %s && pwd", dir, dir), privateKeyPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s on %s: %w", dir, host, err)
+	}
+	abs := strings.TrimSpace(out)
+	for _, file := range files {
+		if err := dfiles.SCPTransfer(file, user, host, abs, privateKeyPath); err != nil {
+			return "", fmt.Errorf("failed to upload %s to %s: %w", file, host, err)
+		}
+	}
+	return abs, nil
+}
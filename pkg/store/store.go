@@ -127,3 +127,11 @@ func GetKeys(ctx context.Context, bucket string, nc *nats.Conn) ([]string, error
 
 	return keys, nil
 }
+
+// Publish sends a message on a NATS subject
+func Publish(subject string, value []byte, nc *nats.Conn) error {
+	if nc == nil {
+		return fmt.Errorf("nats connection is nil")
+	}
+	return nc.Publish(subject, value)
+}